@@ -2,6 +2,7 @@ package aat
 
 import (
 	"fmt"
+	"runtime"
 	"sync"
 	"testing"
 
@@ -76,3 +77,145 @@ func connectSubscriber(fn client.EventHandler) *client.Client {
 	}
 	return subscriber
 }
+
+// BenchmarkPubSubPatterns publishes to a topic that matches one of many
+// prefix or wildcard subscriptions, such as subscriptions per device.  The
+// time to publish should not grow with the number of subscriptions.
+func BenchmarkPubSubPatterns(b *testing.B) {
+	for _, match := range []string{wamp.MatchPrefix, wamp.MatchWildcard} {
+		for n := 10; n <= 10000; n *= 10 {
+			b.Run(fmt.Sprintf("%s%05d", match, n), func(b *testing.B) {
+				benchPubSubPatterns(match, n, b)
+			})
+		}
+	}
+}
+
+// benchPattern returns the nth URI pattern of the match policy, and a URI
+// that matches only that pattern.
+func benchPattern(match string, n int) (string, string) {
+	uri := fmt.Sprintf("nexus.bench.device%05d.sensor.value", n)
+	if match == wamp.MatchWildcard {
+		return fmt.Sprintf("nexus.bench.device%05d..value", n), uri
+	}
+	return fmt.Sprintf("nexus.bench.device%05d.", n), uri
+}
+
+func benchPubSubPatterns(match string, patternCount int, b *testing.B) {
+	var allDone sync.WaitGroup
+	eventHandler := func(ev *wamp.Event) {
+		allDone.Done()
+	}
+
+	subscriber, err := connectClient()
+	if err != nil {
+		panic("Failed to connect client: " + err.Error())
+	}
+	options := wamp.Dict{wamp.OptMatch: match}
+	for i := 0; i < patternCount; i++ {
+		pattern, _ := benchPattern(match, i)
+		if err = subscriber.Subscribe(pattern, eventHandler, options); err != nil {
+			panic("subscribe error: " + err.Error())
+		}
+	}
+	_, topic := benchPattern(match, patternCount/2)
+
+	publisher, err := connectClient()
+	if err != nil {
+		panic("Failed to connect client: " + err.Error())
+	}
+
+	args := wamp.List{"hello world"}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		allDone.Add(benchMsgCount)
+		for j := 0; j < benchMsgCount; j++ {
+			err = publisher.Publish(topic, nil, args, nil)
+			if err != nil {
+				panic("Error waiting for published response: " + err.Error())
+			}
+		}
+		allDone.Wait()
+	}
+
+	b.StopTimer()
+
+	publisher.Close()
+	subscriber.Close()
+}
+
+// BenchmarkPubSubParallel publishes from many publishers in parallel, each to
+// its own topic with its own subscriber.  Run with -cpu=1,2,4,8 to see how
+// throughput scales with routing shards.
+func BenchmarkPubSubParallel(b *testing.B) {
+	for _, realm := range []string{testRealm, testShardedRealm} {
+		b.Run(realm, func(b *testing.B) {
+			benchPubSubParallel(realm, b)
+		})
+	}
+}
+
+// pubSubPair is a publisher and a subscriber to the publisher's topic.
+type pubSubPair struct {
+	publisher  *client.Client
+	subscriber *client.Client
+	topic      string
+	received   chan struct{}
+}
+
+func benchPubSubParallel(realm string, b *testing.B) {
+	cfg := client.Config{
+		Realm:           realm,
+		ResponseTimeout: clientResponseTimeout,
+	}
+	pairs := make(chan *pubSubPair, runtime.GOMAXPROCS(0))
+	for i := 0; i < cap(pairs); i++ {
+		pair := &pubSubPair{
+			topic:    fmt.Sprintf("nexus.bench.parallel.%d", i),
+			received: make(chan struct{}, benchMsgCount),
+		}
+		var err error
+		if pair.subscriber, err = connectClientCfg(cfg); err != nil {
+			panic("Failed to connect client: " + err.Error())
+		}
+		err = pair.subscriber.Subscribe(pair.topic, func(ev *wamp.Event) {
+			pair.received <- struct{}{}
+		}, nil)
+		if err != nil {
+			panic("subscribe error: " + err.Error())
+		}
+		if pair.publisher, err = connectClientCfg(cfg); err != nil {
+			panic("Failed to connect client: " + err.Error())
+		}
+		pairs <- pair
+	}
+
+	args := wamp.List{"hello world"}
+
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		pair := <-pairs
+		defer func() { pairs <- pair }()
+		for pb.Next() {
+			for j := 0; j < benchMsgCount; j++ {
+				if err := pair.publisher.Publish(pair.topic, nil, args, nil); err != nil {
+					panic("Error waiting for published response: " + err.Error())
+				}
+			}
+			for j := 0; j < benchMsgCount; j++ {
+				<-pair.received
+			}
+		}
+	})
+
+	b.StopTimer()
+
+	for i := 0; i < cap(pairs); i++ {
+		pair := <-pairs
+		pair.publisher.Close()
+		pair.subscriber.Close()
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"math/rand"
+	"runtime"
 	"testing"
 
 	"github.com/gammazero/nexus/v3/client"
@@ -141,6 +142,55 @@ func BenchmarkRPCProgress(b *testing.B) {
 	server.Close()
 }
 
+// BenchmarkRpcPatterns calls a procedure that matches one of many prefix or
+// wildcard registrations.  The time to call should not grow with the number
+// of registrations.
+func BenchmarkRpcPatterns(b *testing.B) {
+	for _, match := range []string{wamp.MatchPrefix, wamp.MatchWildcard} {
+		for n := 10; n <= 10000; n *= 10 {
+			b.Run(fmt.Sprintf("%s%05d", match, n), func(b *testing.B) {
+				benchmarkRpcPatterns(match, n, b)
+			})
+		}
+	}
+}
+
+func benchmarkRpcPatterns(match string, patternCount int, b *testing.B) {
+	server, err := connectClient()
+	if err != nil {
+		panic("Failed to connect client: " + err.Error())
+	}
+	options := wamp.Dict{wamp.OptMatch: match}
+	for i := 0; i < patternCount; i++ {
+		pattern, _ := benchPattern(match, i)
+		if err = server.Register(pattern, identity, options); err != nil {
+			panic("Failed to register procedure: " + err.Error())
+		}
+	}
+	_, procedure := benchPattern(match, patternCount/2)
+
+	client, err := connectClient()
+	if err != nil {
+		panic("Failed to connect client: " + err.Error())
+	}
+
+	ctx := context.Background()
+	callArgs := wamp.List{"hello world"}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err = client.Call(ctx, procedure, nil, callArgs, nil, nil); err != nil {
+			panic(err)
+		}
+	}
+
+	b.StopTimer()
+
+	client.Close()
+	server.Close()
+}
+
 func benchmarkRpc(b *testing.B, action client.InvocationHandler, callArgs wamp.List, verify func(*wamp.Result)) {
 	server, err := connectClient()
 	if err != nil {
@@ -198,3 +248,66 @@ func randomString(n int) string {
 	}
 	return string(b)
 }
+
+// BenchmarkRpcParallel calls from many callers in parallel, each to its own
+// procedure with its own callee.  Run with -cpu=1,2,4,8 to see how throughput
+// scales with routing shards.
+func BenchmarkRpcParallel(b *testing.B) {
+	for _, realm := range []string{testRealm, testShardedRealm} {
+		b.Run(realm, func(b *testing.B) {
+			benchmarkRpcParallel(realm, b)
+		})
+	}
+}
+
+// rpcPair is a caller and a callee of the caller's procedure.
+type rpcPair struct {
+	caller    *client.Client
+	callee    *client.Client
+	procedure string
+}
+
+func benchmarkRpcParallel(realm string, b *testing.B) {
+	cfg := client.Config{
+		Realm:           realm,
+		ResponseTimeout: clientResponseTimeout,
+	}
+	pairs := make(chan *rpcPair, runtime.GOMAXPROCS(0))
+	for i := 0; i < cap(pairs); i++ {
+		pair := &rpcPair{procedure: fmt.Sprintf("nexus.bench.parallel.%d", i)}
+		var err error
+		if pair.callee, err = connectClientCfg(cfg); err != nil {
+			panic("Failed to connect client: " + err.Error())
+		}
+		if err = pair.callee.Register(pair.procedure, identity, nil); err != nil {
+			panic("Failed to register procedure: " + err.Error())
+		}
+		if pair.caller, err = connectClientCfg(cfg); err != nil {
+			panic("Failed to connect client: " + err.Error())
+		}
+		pairs <- pair
+	}
+
+	ctx := context.Background()
+	callArgs := wamp.List{"hello world"}
+
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		pair := <-pairs
+		defer func() { pairs <- pair }()
+		for pb.Next() {
+			if _, err := pair.caller.Call(ctx, pair.procedure, nil, callArgs, nil, nil); err != nil {
+				panic(err)
+			}
+		}
+	})
+
+	b.StopTimer()
+
+	for i := 0; i < cap(pairs); i++ {
+		pair := <-pairs
+		pair.caller.Close()
+		pair.callee.Close()
+	}
+}
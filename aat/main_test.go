@@ -11,6 +11,7 @@ import (
 	"net/http/cookiejar"
 	"os"
 	"path"
+	"runtime"
 	"testing"
 	"time"
 
@@ -24,8 +25,9 @@ import (
 )
 
 const (
-	testRealm     = "nexus.test.realm"
-	testAuthRealm = "nexus.test.auth"
+	testRealm        = "nexus.test.realm"
+	testAuthRealm    = "nexus.test.auth"
+	testShardedRealm = "nexus.test.sharded"
 
 	tcpAddr  = "127.0.0.1:8282"
 	unixAddr = "/tmp/nexustest_sock"
@@ -51,7 +53,7 @@ var (
 	// available for "" or "unix".
 	scheme string
 
-	// serType is set to "json" or "msgpack".  Ignored if sockType is "".
+	// serType is set to "json", "msgpack", "cbor", or "ubjson".  Ignored if sockType is "".
 	serType string
 
 	// compress enables compression on both client and server config
@@ -85,12 +87,12 @@ func TestMain(m *testing.M) {
 	flag.StringVar(&scheme, "scheme", "",
 		"-scheme=[http, https, ws, wss, tcp, tcps, unix] or none for local (in-process)")
 	flag.StringVar(&serType, "serialize", "",
-		"-serialize[json, msgpack, cbor] default is json")
+		"-serialize[json, msgpack, cbor, ubjson] default is json")
 	flag.BoolVar(&compress, "compress", false, "enable compression")
 	flag.IntVar(&outQueueSize, "qsize", 0, "server's per-client outbound queue size")
 	flag.Parse()
 
-	if serType != "" && serType != "json" && serType != "msgpack" && serType != "cbor" {
+	if serType != "" && serType != "json" && serType != "msgpack" && serType != "cbor" && serType != "ubjson" {
 		fmt.Fprintln(os.Stderr, "invalid serialize value")
 		flag.Usage()
 		os.Exit(1)
@@ -144,6 +146,11 @@ func TestMain(m *testing.M) {
 				EnableMetaKill:   true,
 				EnableMetaModify: true,
 			},
+			{
+				URI:           wamp.URI(testShardedRealm),
+				AnonymousAuth: true,
+				RoutingShards: runtime.NumCPU(),
+			},
 		},
 		//Debug: true,
 	}
@@ -272,6 +279,8 @@ func connectClientCfg(cfg client.Config) (*client.Client, error) {
 		cfg.Serialization = serialize.MSGPACK
 	case "cbor":
 		cfg.Serialization = serialize.CBOR
+	case "ubjson":
+		cfg.Serialization = serialize.UBJSON
 	}
 	cfg.Logger = cliLogger
 
@@ -25,11 +25,17 @@ const (
 	JSON    = serialize.JSON
 	MSGPACK = serialize.MSGPACK
 	CBOR    = serialize.CBOR
+	UBJSON  = serialize.UBJSON
 )
 
 // A Client routes messages to/from a WAMP router.
 type Client struct {
 	sess *wamp.Session
+	// sessDone is closed when the connection of sess to the router is lost.
+	sessDone chan struct{}
+	// reconnected is closed when the client reconnects.  It is nil unless the
+	// client is reconnecting.
+	reconnected chan struct{}
 
 	responseTimeout time.Duration
 	awaitingReply   map[wamp.ID]chan wamp.Message
@@ -37,14 +43,39 @@ type Client struct {
 
 	eventHandlers map[wamp.ID]EventHandler
 	topicSubID    map[string]wamp.ID
+	topicOptions  map[string]wamp.Dict
 
 	invHandlers    map[wamp.ID]InvocationHandler
 	nameProcID     map[string]wamp.ID
+	procOptions    map[string]wamp.Dict
 	invHandlerKill map[wamp.ID]context.CancelFunc
-	progGate       map[context.Context]wamp.ID
+	progGate       map[context.Context]progressDest
+
+	// Handlers of subscriptions and registrations that are waiting for the
+	// router's reply, by request ID.  The run() goroutine installs the
+	// handler when it receives the reply, so that events and invocations
+	// that follow the reply are handled.
+	subscribing map[wamp.ID]EventHandler
+	registering map[wamp.ID]InvocationHandler
+
+	// Handlers of subscriptions and registrations that are not yet restored
+	// after reconnecting, by topic and procedure.
+	unrestoredSubs map[string]EventHandler
+	unrestoredRegs map[string]InvocationHandler
 
 	activeInvHandlers sync.WaitGroup
 
+	// Acknowledgments of events received by SubscribeAck subscriptions,
+	// waiting to be sent by the ackEvents goroutine.
+	acks           chan eventAck
+	startAckEvents sync.Once
+
+	// mu protects the session and the maps above.
+	mu sync.Mutex
+
+	reconnect *reconnecter
+	restoring sync.WaitGroup
+
 	log   stdlog.StdLog
 	debug bool
 
@@ -69,6 +100,14 @@ type InvokeResult struct {
 // the invocation was canceled.
 var InvocationCanceled = InvokeResult{Err: wamp.ErrCanceled}
 
+// progressDest is where to send progressive results for an invocation: the
+// request ID of the invocation, and the session it was received on.
+type progressDest struct {
+	req  wamp.ID
+	sess *wamp.Session
+	done <-chan struct{}
+}
+
 // NewClient takes a connected Peer, joins the realm specified in cfg, and if
 // successful, returns a new client.
 //
@@ -80,6 +119,18 @@ func NewClient(p wamp.Peer, cfg Config) (*Client, error) {
 		cfg.ResponseTimeout = defaultResponseTimeout
 	}
 
+	sess, err := newSession(p, cfg)
+	if err != nil {
+		return nil, err
+	}
+	c := newClient(sess, cfg)
+	go c.run() // start the core goroutine
+	return c, nil
+}
+
+// newSession joins the realm specified in cfg over the peer, and returns the
+// session.  The peer is closed if the realm cannot be joined.
+func newSession(p wamp.Peer, cfg Config) (*wamp.Session, error) {
 	welcome, err := joinRealm(p, cfg)
 	if err != nil {
 		p.Close()
@@ -92,56 +143,126 @@ func NewClient(p wamp.Peer, cfg Config) (*Client, error) {
 		p.Close()
 		return nil, ErrRouterNoRoles
 	}
+	return sess, nil
+}
 
+// newClient creates a client for a session.  The caller starts the client's
+// run() goroutine.
+func newClient(sess *wamp.Session, cfg Config) *Client {
 	c := &Client{
-		sess: sess,
+		sess:     sess,
+		sessDone: make(chan struct{}),
 
 		responseTimeout: cfg.ResponseTimeout,
 		awaitingReply:   map[wamp.ID]chan wamp.Message{},
 
 		eventHandlers: map[wamp.ID]EventHandler{},
 		topicSubID:    map[string]wamp.ID{},
+		topicOptions:  map[string]wamp.Dict{},
 
 		invHandlers:    map[wamp.ID]InvocationHandler{},
 		nameProcID:     map[string]wamp.ID{},
+		procOptions:    map[string]wamp.Dict{},
 		invHandlerKill: map[wamp.ID]context.CancelFunc{},
-		progGate:       map[context.Context]wamp.ID{},
+		progGate:       map[context.Context]progressDest{},
+
+		subscribing: map[wamp.ID]EventHandler{},
+		registering: map[wamp.ID]InvocationHandler{},
+
+		unrestoredSubs: map[string]EventHandler{},
+		unrestoredRegs: map[string]InvocationHandler{},
 
 		log:        cfg.Logger,
 		debug:      cfg.Debug,
 		cancelMode: wamp.CancelModeKillNoWait,
 		idGen:      new(wamp.SyncIDGen),
+
+		acks: make(chan eventAck, ackQueueSize),
 	}
 	c.ctx, c.cancel = context.WithCancel(context.Background())
-	go c.run() // start the core goroutine
-	return c, nil
+	return c
 }
 
 // Done returns a channel that signals when the client is no longer connected
-// to a router and has shutdown.
+// to a router and has shutdown.  A client that reconnects is shutdown only
+// when it is closed or gives up reconnecting.
 func (c *Client) Done() <-chan struct{} { return c.ctx.Done() }
 
 // Connected returns true if the client is still connected to (receiving from)
 // the router.
-func (c *Client) Connected() bool { return c.ctx.Err() == nil }
+func (c *Client) Connected() bool {
+	_, _, err := c.session()
+	return err == nil
+}
 
 // ID returns the client's session ID which is assigned after attaching to a
-// router and joining a realm.
-func (c *Client) ID() wamp.ID { return c.sess.ID }
+// router and joining a realm.  The ID changes when the client reconnects.
+func (c *Client) ID() wamp.ID {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sess.ID
+}
 
 // Logger returns the clients logger that was provided by Config when the
 // client was created, or the stdout logger if one was not provided in Config.
 func (c *Client) Logger() stdlog.StdLog { return c.log }
 
 // RealmDetails returns the realm information received in the WELCOME message.
-func (c *Client) RealmDetails() wamp.Dict { return c.sess.Details }
+func (c *Client) RealmDetails() wamp.Dict {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sess.Details
+}
 
 // HasFeature returns true if the session has the specified feature for the
 // specified role.
 func (c *Client) HasFeature(role, feature string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	return c.sess.HasFeature(role, feature)
 }
 
+// session returns the current session, and the channel that is closed when
+// the connection of the session is lost.  Returns ErrNotConn if the client is
+// not connected.
+func (c *Client) session() (*wamp.Session, <-chan struct{}, error) {
+	c.mu.Lock()
+	sess, done := c.sess, c.sessDone
+	c.mu.Unlock()
+	select {
+	case <-done:
+		return nil, nil, ErrNotConn
+	default:
+	}
+	return sess, done, nil
+}
+
+// awaitSession is the same as session, except that if the client is
+// reconnecting and is configured to queue calls, then it waits for the client
+// to reconnect or for ctx to be done.
+func (c *Client) awaitSession(ctx context.Context) (*wamp.Session, <-chan struct{}, error) {
+	for {
+		c.mu.Lock()
+		sess, done, reconnected := c.sess, c.sessDone, c.reconnected
+		c.mu.Unlock()
+		select {
+		case <-done:
+		default:
+			return sess, done, nil
+		}
+		if reconnected == nil || !c.reconnect.cfg.Reconnect.QueueCalls {
+			return nil, nil, ErrNotConn
+		}
+		select {
+		case <-reconnected:
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case <-c.Done():
+			return nil, nil, ErrNotConn
+		}
+	}
+}
+
 // EventHandler is a function that handles a publish event.
 type EventHandler func(event *wamp.Event)
 
@@ -159,39 +280,58 @@ type EventHandler func(event *wamp.Event)
 //
 // NOTE: Use consts defined in wamp/options.go instead of raw strings.
 func (c *Client) Subscribe(topic string, fn EventHandler, options wamp.Dict) error {
-	if !c.Connected() {
-		return ErrNotConn
+	sess, done, err := c.session()
+	if err != nil {
+		return err
 	}
 
 	if options == nil {
 		options = wamp.Dict{}
 	}
+	subID, err := c.subscribe(sess, done, topic, fn, options)
+	if err != nil {
+		return err
+	}
+
+	// The event handler was registered when the reply was received.
+	c.mu.Lock()
+	c.topicSubID[topic] = subID
+	c.topicOptions[topic] = options
+	c.mu.Unlock()
+	return nil
+}
+
+// subscribe sends SUBSCRIBE to the router over the session, and returns the
+// subscription ID from the SUBSCRIBED reply.  The event handler is registered
+// for the subscription ID when the reply is received.
+func (c *Client) subscribe(sess *wamp.Session, done <-chan struct{}, topic string, fn EventHandler, options wamp.Dict) (wamp.ID, error) {
 	id := c.idGen.Next()
+	c.mu.Lock()
+	c.subscribing[id] = fn
+	c.mu.Unlock()
 	c.expectReply(id)
-	c.sess.Send(&wamp.Subscribe{
+	sess.Send(&wamp.Subscribe{
 		Request: id,
 		Options: options,
 		Topic:   wamp.URI(topic),
 	})
 
 	// Wait to receive SUBSCRIBED message.
-	msg, err := c.waitForReply(id)
+	msg, err := c.waitForReply(id, done)
+	c.mu.Lock()
+	delete(c.subscribing, id)
+	c.mu.Unlock()
 	if err != nil {
-		return err
+		return 0, err
 	}
 	switch msg := msg.(type) {
 	case *wamp.Subscribed:
-		// Register the event handler for this subscription.
-		c.sess.Lock()
-		c.eventHandlers[msg.Subscription] = fn
-		c.topicSubID[topic] = msg.Subscription
-		c.sess.Unlock()
-		return nil
+		return msg.Subscription, nil
 	case *wamp.Error:
-		return fmt.Errorf("subscribing to topic '%v': %s", topic,
+		return 0, fmt.Errorf("subscribing to topic '%v': %s", topic,
 			wampErrorString(msg))
 	default:
-		return unexpectedMsgError(msg, wamp.SUBSCRIBED)
+		return 0, unexpectedMsgError(msg, wamp.SUBSCRIBED)
 	}
 }
 
@@ -207,22 +347,166 @@ func (c *Client) SubscribeChan(topic string, events chan<- *wamp.Event, options
 	return c.Subscribe(topic, handler, options)
 }
 
+// AckEventHandler is a function that handles an event delivered with
+// at-least-once delivery.  Returning nil acknowledges the event.  Returning an
+// error negatively acknowledges the event, so that the router redelivers it.
+type AckEventHandler func(event *wamp.Event) error
+
+// SubscribeAck subscribes the client to the specified topic or topic pattern
+// with at-least-once delivery.
+//
+// The router keeps each event until the event is acknowledged, and redelivers
+// an event that is not acknowledged within a timeout, up to a retry limit.  A
+// redelivered event has the "redelivered" detail set to true.  The specified
+// AckEventHandler is called for each event, and the event is acknowledged or
+// negatively acknowledged according to the handler's return value.
+//
+// Events are acknowledged by a goroutine that the client starts for its
+// SubscribeAck subscriptions, in the order they were handled.  If too many
+// acknowledgments are waiting to be sent, then an event is not acknowledged,
+// and the router redelivers it.
+//
+// Options are the same as for Subscribe.
+func (c *Client) SubscribeAck(topic string, fn AckEventHandler, options wamp.Dict) error {
+	opts := make(wamp.Dict, len(options)+1)
+	for k, v := range options {
+		opts[k] = v
+	}
+	opts[wamp.OptDelivery] = wamp.DeliveryAtLeastOnce
+
+	handler := func(event *wamp.Event) {
+		err := fn(event)
+		seq, ok := wamp.AsInt64(event.Details[wamp.DetailDeliverySeq])
+		if !ok {
+			return
+		}
+		procedure := wamp.MetaProcSubAck
+		if err != nil {
+			if c.debug {
+				c.log.Println("Event handler error, requesting redelivery:", err)
+			}
+			procedure = wamp.MetaProcSubNack
+		}
+		// Acknowledge in the ackEvents goroutine, since waiting for the
+		// result here would block the handling of the result.
+		select {
+		case c.acks <- eventAck{procedure, event.Subscription, seq}:
+		default:
+			c.log.Println("Too many acknowledgments waiting, not acknowledging event",
+				seq, "for subscription", event.Subscription)
+		}
+	}
+	c.startAckEvents.Do(func() {
+		go c.ackEvents()
+	})
+	return c.Subscribe(topic, handler, opts)
+}
+
+// GapHandler is a function that is called when events were missed.  It is
+// given the event received after the missed events, and the number of events
+// missed.  A missed count of 0 means that an unknown number of events may
+// have been missed, because the sequence restarted.
+type GapHandler func(event *wamp.Event, missed uint64)
+
+// DetectGaps returns an EventHandler that calls fn for each event, and calls
+// onGap when the event's sequence number shows that events were missed, for
+// example because they were dropped by the router.
+//
+// The subscription must request sequence numbers by setting the "sequence"
+// option to "topic" or "subscription", to have events numbered per topic or
+// per subscription.  Events without a sequence number are passed to fn without
+// checking for gaps.  The returned EventHandler may be used for multiple
+// subscriptions.
+//
+// The router may restart a topic's sequence at 1, such as when it stops
+// tracking the sequence of a topic that has not been published to recently.
+// An event numbered 1 after a higher number is treated as a restart, and
+// onGap is called with a missed count of 0.
+func DetectGaps(fn EventHandler, onGap GapHandler) EventHandler {
+	type seqKey struct {
+		subID wamp.ID
+		topic wamp.URI
+	}
+	var mu sync.Mutex
+	last := map[seqKey]uint64{}
+
+	return func(event *wamp.Event) {
+		key := seqKey{subID: event.Subscription}
+		seq, ok := wamp.AsInt64(event.Details[wamp.DetailSubscriptionSeq])
+		if !ok {
+			// The topic is only present for pattern-based subscriptions, which
+			// is sufficient since an exact subscription has one topic.
+			seq, ok = wamp.AsInt64(event.Details[wamp.DetailTopicSeq])
+			key.topic, _ = wamp.AsURI(event.Details["topic"])
+		}
+		if ok && seq > 0 {
+			mu.Lock()
+			prev := last[key]
+			restart := seq == 1 && prev != 0
+			if uint64(seq) > prev || restart {
+				last[key] = uint64(seq)
+			}
+			mu.Unlock()
+			if restart {
+				onGap(event, 0)
+			} else if uint64(seq) > prev+1 {
+				onGap(event, uint64(seq)-prev-1)
+			}
+		}
+		fn(event)
+	}
+}
+
+// ackQueueSize is the number of event acknowledgments that can wait to be
+// sent.
+const ackQueueSize = 256
+
+// eventAck is the acknowledgment or negative acknowledgment of an event.
+type eventAck struct {
+	procedure wamp.URI
+	subID     wamp.ID
+	seq       int64
+}
+
+// ackEvents sends event acknowledgments until the client is done.
+func (c *Client) ackEvents() {
+	for {
+		select {
+		case ack := <-c.acks:
+			c.ackEvent(ack.procedure, ack.subID, ack.seq)
+		case <-c.Done():
+			return
+		}
+	}
+}
+
+// ackEvent calls the procedure that acknowledges or negatively acknowledges
+// an event.
+func (c *Client) ackEvent(procedure wamp.URI, subID wamp.ID, seq int64) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.responseTimeout)
+	defer cancel()
+	_, err := c.Call(ctx, string(procedure), nil, wamp.List{subID, seq}, nil, nil)
+	if err != nil && c.Connected() {
+		c.log.Println("Failed to acknowledge event:", err)
+	}
+}
+
 // SubscriptionID returns the subscription ID for the specified topic.  If the
 // client does not have an active subscription to the topic, then returns false
 // for second boolean return value.
 func (c *Client) SubscriptionID(topic string) (subID wamp.ID, ok bool) {
-	c.sess.Lock()
+	c.mu.Lock()
 	subID, ok = c.topicSubID[topic]
-	c.sess.Unlock()
+	c.mu.Unlock()
 	return
 }
 
 // Unsubscribe removes the registered EventHandler from the topic.
 func (c *Client) Unsubscribe(topic string) error {
-	c.sess.Lock()
+	c.mu.Lock()
 	subID, ok := c.topicSubID[topic]
 	if !ok {
-		c.sess.Unlock()
+		c.mu.Unlock()
 		return ErrNotSubscribed
 	}
 	// Delete the subscription anyway, regardless of whether or not the the
@@ -230,22 +514,35 @@ func (c *Client) Unsubscribe(topic string) error {
 	// Unsubscribe() then it has no interest in receiving any more events for
 	// the topic, and may expect any.
 	delete(c.topicSubID, topic)
+	delete(c.topicOptions, topic)
 	delete(c.eventHandlers, subID)
-	c.sess.Unlock()
+	delete(c.unrestoredSubs, topic)
+	c.mu.Unlock()
+	if subID == 0 {
+		// Not yet restored after reconnecting.  The subscription is removed
+		// from the router when it is restored.
+		return nil
+	}
 
-	if !c.Connected() {
-		return ErrNotConn
+	sess, done, err := c.session()
+	if err != nil {
+		return err
 	}
+	return c.unsubscribe(sess, done, topic, subID)
+}
 
+// unsubscribe sends UNSUBSCRIBE to the router over the session, and waits for
+// the UNSUBSCRIBED reply.
+func (c *Client) unsubscribe(sess *wamp.Session, done <-chan struct{}, topic string, subID wamp.ID) error {
 	id := c.idGen.Next()
 	c.expectReply(id)
-	c.sess.Send(&wamp.Unsubscribe{
+	sess.Send(&wamp.Unsubscribe{
 		Request:      id,
 		Subscription: subID,
 	})
 
 	// Wait to receive UNSUBSCRIBED message.
-	msg, err := c.waitForReply(id)
+	msg, err := c.waitForReply(id, done)
 	if err != nil {
 		return err
 	}
@@ -294,8 +591,9 @@ func (c *Client) Unsubscribe(topic string) error {
 //
 // NOTE: Use consts defined in wamp/options.go instead of raw strings.
 func (c *Client) Publish(topic string, options wamp.Dict, args wamp.List, kwargs wamp.Dict) error {
-	if !c.Connected() {
-		return ErrNotConn
+	sess, done, err := c.session()
+	if err != nil {
+		return err
 	}
 
 	id := c.idGen.Next()
@@ -311,7 +609,7 @@ func (c *Client) Publish(topic string, options wamp.Dict, args wamp.List, kwargs
 		}
 	}
 
-	c.sess.Send(&wamp.Publish{
+	sess.Send(&wamp.Publish{
 		Request:     id,
 		Options:     options,
 		Topic:       wamp.URI(topic),
@@ -324,7 +622,7 @@ func (c *Client) Publish(topic string, options wamp.Dict, args wamp.List, kwargs
 	}
 
 	// Wait to receive PUBLISHED message.
-	msg, err := c.waitForReply(id)
+	msg, err := c.waitForReply(id, done)
 	if err != nil {
 		return err
 	}
@@ -370,61 +668,80 @@ type InvocationHandler func(context.Context, *wamp.Invocation) InvokeResult
 //
 // NOTE: Use consts defined in wamp/options.go instead of raw strings.
 func (c *Client) Register(procedure string, fn InvocationHandler, options wamp.Dict) error {
-	if !c.Connected() {
-		return ErrNotConn
+	sess, done, err := c.session()
+	if err != nil {
+		return err
 	}
-	id := c.idGen.Next()
-	c.expectReply(id)
 	if options == nil {
 		options = wamp.Dict{}
 	}
-	c.sess.Send(&wamp.Register{
+	regID, err := c.register(sess, done, procedure, fn, options)
+	if err != nil {
+		return err
+	}
+
+	// The invocation handler was registered when the reply was received.
+	c.mu.Lock()
+	c.nameProcID[procedure] = regID
+	c.procOptions[procedure] = options
+	c.mu.Unlock()
+	return nil
+}
+
+// register sends REGISTER to the router over the session, and returns the
+// registration ID from the REGISTERED reply.  The invocation handler is
+// registered for the registration ID when the reply is received.
+func (c *Client) register(sess *wamp.Session, done <-chan struct{}, procedure string, fn InvocationHandler, options wamp.Dict) (wamp.ID, error) {
+	id := c.idGen.Next()
+	c.mu.Lock()
+	c.registering[id] = fn
+	c.mu.Unlock()
+	c.expectReply(id)
+	sess.Send(&wamp.Register{
 		Request:   id,
 		Options:   options,
 		Procedure: wamp.URI(procedure),
 	})
 
 	// Wait to receive REGISTERED message.
-	msg, err := c.waitForReply(id)
+	msg, err := c.waitForReply(id, done)
+	c.mu.Lock()
+	delete(c.registering, id)
+	c.mu.Unlock()
 	if err != nil {
-		return err
+		return 0, err
 	}
 	switch msg := msg.(type) {
 	case *wamp.Registered:
-		// Register the event handler for this registration.
-		c.sess.Lock()
-		c.invHandlers[msg.Registration] = fn
-		c.nameProcID[procedure] = msg.Registration
-		c.sess.Unlock()
 		if c.debug {
 			c.log.Println("Registered", procedure, "as registration",
 				msg.Registration)
 		}
+		return msg.Registration, nil
 	case *wamp.Error:
-		return fmt.Errorf("registering procedure '%v': %s", procedure,
+		return 0, fmt.Errorf("registering procedure '%v': %s", procedure,
 			wampErrorString(msg))
 	default:
-		return unexpectedMsgError(msg, wamp.REGISTERED)
+		return 0, unexpectedMsgError(msg, wamp.REGISTERED)
 	}
-	return nil
 }
 
 // RegistrationID returns the registration ID for the specified procedure.  If
 // the client is not registered for the procedure, then returns false for
 // second boolean return value.
 func (c *Client) RegistrationID(procedure string) (regID wamp.ID, ok bool) {
-	c.sess.Lock()
+	c.mu.Lock()
 	regID, ok = c.nameProcID[procedure]
-	c.sess.Unlock()
+	c.mu.Unlock()
 	return
 }
 
 // Unregister removes the registration of a procedure from the router.
 func (c *Client) Unregister(procedure string) error {
-	c.sess.Lock()
+	c.mu.Lock()
 	procID, ok := c.nameProcID[procedure]
 	if !ok {
-		c.sess.Unlock()
+		c.mu.Unlock()
 		return ErrNotRegistered
 	}
 	// Delete the registration anyway, regardless of whether or not the the
@@ -432,22 +749,35 @@ func (c *Client) Unregister(procedure string) error {
 	// Unregister() then it has no interest in receiving any more invocations
 	// for the procedure, and may not expect any.
 	delete(c.nameProcID, procedure)
+	delete(c.procOptions, procedure)
 	delete(c.invHandlers, procID)
-	c.sess.Unlock()
+	delete(c.unrestoredRegs, procedure)
+	c.mu.Unlock()
+	if procID == 0 {
+		// Not yet restored after reconnecting.  The registration is removed
+		// from the router when it is restored.
+		return nil
+	}
 
-	if !c.Connected() {
-		return ErrNotConn
+	sess, done, err := c.session()
+	if err != nil {
+		return err
 	}
+	return c.unregister(sess, done, procedure, procID)
+}
 
+// unregister sends UNREGISTER to the router over the session, and waits for
+// the UNREGISTERED reply.
+func (c *Client) unregister(sess *wamp.Session, done <-chan struct{}, procedure string, procID wamp.ID) error {
 	id := c.idGen.Next()
 	c.expectReply(id)
-	c.sess.Send(&wamp.Unregister{
+	sess.Send(&wamp.Unregister{
 		Request:      id,
 		Registration: procID,
 	})
 
 	// Wait to receive UNREGISTERED message.
-	msg, err := c.waitForReply(id)
+	msg, err := c.waitForReply(id, done)
 	if err != nil {
 		return err
 	}
@@ -527,9 +857,16 @@ type ProgressHandler func(*wamp.Result)
 // IMPORTANT: If the context has a timeout, then the amount of time needs to be
 // sufficient for the caller to receive all progressive results as well as the
 // final result.
+//
+// Calls While Disconnected
+//
+// If the client reconnects, and ReconnectConfig.QueueCalls is set, then a call
+// made while the client is disconnected waits for the client to reconnect, or
+// for the context to be done.  Otherwise, Call returns ErrNotConn.
 func (c *Client) Call(ctx context.Context, procedure string, options wamp.Dict, args wamp.List, kwargs wamp.Dict, progcb ProgressHandler) (*wamp.Result, error) {
-	if !c.Connected() {
-		return nil, ErrNotConn
+	sess, done, err := c.awaitSession(ctx)
+	if err != nil {
+		return nil, err
 	}
 
 	if options == nil {
@@ -555,7 +892,7 @@ func (c *Client) Call(ctx context.Context, procedure string, options wamp.Dict,
 
 	id := c.idGen.Next()
 	c.expectReply(id)
-	c.sess.Send(&wamp.Call{
+	sess.Send(&wamp.Call{
 		Request:     id,
 		Procedure:   wamp.URI(procedure),
 		Options:     options,
@@ -564,7 +901,7 @@ func (c *Client) Call(ctx context.Context, procedure string, options wamp.Dict,
 	})
 
 	// Wait to receive RESULT message.
-	msg, err := c.waitForReplyWithCancel(ctx, id, procedure, progChan)
+	msg, err := c.waitForReplyWithCancel(ctx, sess, done, id, procedure, progChan)
 
 	// Finish handling any remaining progressive results before returning the
 	// final result.
@@ -640,15 +977,20 @@ func (rpce RPCError) Error() string {
 // Close causes the client to leave the realm it has joined, and closes the
 // connection to the router.
 func (c *Client) Close() error {
-	c.sess.Lock()
+	c.mu.Lock()
 	if c.closed {
-		c.sess.Unlock()
+		c.mu.Unlock()
 		return ErrAlreadyClosed
 	}
 	c.closed = true
-	c.sess.Unlock()
+	c.mu.Unlock()
 
-	if c.Connected() {
+	// Stop reconnecting, if the client is disconnected.
+	if c.reconnect != nil {
+		c.reconnect.cancel()
+	}
+
+	if sess, done, err := c.session(); err == nil {
 		// Leave the realm and stop receiving messages.
 
 		// Make an effort to say goodbye, but do not wait a long time to send.
@@ -656,7 +998,7 @@ func (c *Client) Close() error {
 		defer cancel()
 
 		var stopped bool
-		if c.sess.SendCtx(sendCtx, &wamp.Goodbye{
+		if sess.SendCtx(sendCtx, &wamp.Goodbye{
 			Details: wamp.Dict{},
 			Reason:  wamp.CloseRealm,
 		}) == nil {
@@ -664,21 +1006,24 @@ func (c *Client) Close() error {
 			// run() to exit.  Wait for run() to exit, but only wait for
 			// whatever time remains on the context.
 			select {
-			case <-c.Done():
+			case <-done:
 				stopped = true
 			case <-sendCtx.Done():
 			}
 		}
 
 		if !stopped {
-			c.sess.EndRecv(nil) // force run() to exit
-			<-c.Done()
+			sess.EndRecv(nil) // force run() to exit
 		}
 	}
+	<-c.Done()
 
 	// When for any running invocation handlers to finish.
 	c.activeInvHandlers.Wait()
-	c.sess.Close()
+	c.mu.Lock()
+	sess := c.sess
+	c.mu.Unlock()
+	sess.Close()
 
 	return nil
 }
@@ -706,30 +1051,28 @@ func (c *Client) SendProgress(ctx context.Context, args wamp.List, kwArgs wamp.D
 	// Lookup the request ID using ctx.  If there is no request ID, this means
 	// that the caller is not accepting progressive results, or that the
 	// invocation handler has been closed because the call was canceled.
-	var req wamp.ID
+	var dest progressDest
 	var ok bool
-	c.sess.Lock()
-	req, ok = c.progGate[ctx]
-	c.sess.Unlock()
+	c.mu.Lock()
+	dest, ok = c.progGate[ctx]
+	c.mu.Unlock()
 
 	if !ok {
 		// progGate value may have been removed if session was disconnected.
-		select {
-		case <-c.Done():
+		if !c.Connected() {
 			return ErrNotConn
-		default:
 		}
 		// Caller is not accepting progressive results or call canceled.
 		return ErrCallerNoProg
 	}
-	if c.sess.SendCtx(ctx, &wamp.Yield{
-		Request:     req,
+	if dest.sess.SendCtx(ctx, &wamp.Yield{
+		Request:     dest.req,
 		Options:     wamp.Dict{wamp.OptProgress: true},
 		Arguments:   args,
 		ArgumentsKw: kwArgs,
 	}) != nil {
 		select {
-		case <-c.Done():
+		case <-dest.done:
 			return ErrNotConn
 		default:
 		}
@@ -905,22 +1248,23 @@ func unexpectedMsgError(msg wamp.Message, expected wamp.MessageType) error {
 
 func (c *Client) expectReply(id wamp.ID) {
 	wait := make(chan wamp.Message)
-	c.sess.Lock()
+	c.mu.Lock()
 	c.awaitingReply[id] = wait
-	c.sess.Unlock()
+	c.mu.Unlock()
 }
 
-// waitForReply waits for an expected reply from the router.
+// waitForReply waits for an expected reply from the router, until the done
+// channel of the session that the request was sent on is closed.
 //
 // IMPORTANT: Must not block on anything requiring run() goroutine, since the
 // run() goroutine may be blocked waiting for a reply to be read from the
 // awaiting reply channel.
-func (c *Client) waitForReply(id wamp.ID) (wamp.Message, error) {
+func (c *Client) waitForReply(id wamp.ID, done <-chan struct{}) (wamp.Message, error) {
 	var wait chan wamp.Message
 	var ok bool
-	c.sess.Lock()
+	c.mu.Lock()
 	wait, ok = c.awaitingReply[id]
-	c.sess.Unlock()
+	c.mu.Unlock()
 	if !ok {
 		return nil, fmt.Errorf("not expecting reply for ID: %v", id)
 	}
@@ -937,12 +1281,13 @@ func (c *Client) waitForReply(id wamp.ID) (wamp.Message, error) {
 		}
 	case <-timer.C:
 		err = ErrReplyTimeout
-	case <-c.Done():
+	case <-done:
+		timer.Stop()
 		err = ErrNotConn
 	}
-	c.sess.Lock()
+	c.mu.Lock()
 	delete(c.awaitingReply, id)
-	c.sess.Unlock()
+	c.mu.Unlock()
 
 	return msg, err
 }
@@ -953,12 +1298,12 @@ func (c *Client) waitForReply(id wamp.ID) (wamp.Message, error) {
 // IMPORTANT: Must not block on anything requiring run() goroutine, since the
 // run() goroutine may be blocked waiting for a reply to be read from the
 // awaiting reply channel.
-func (c *Client) waitForReplyWithCancel(ctx context.Context, id wamp.ID, procedure string, progChan chan<- *wamp.Result) (wamp.Message, error) {
+func (c *Client) waitForReplyWithCancel(ctx context.Context, sess *wamp.Session, done <-chan struct{}, id wamp.ID, procedure string, progChan chan<- *wamp.Result) (wamp.Message, error) {
 	var wait chan wamp.Message
 	var ok bool
-	c.sess.Lock()
+	c.mu.Lock()
 	wait, ok = c.awaitingReply[id]
-	c.sess.Unlock()
+	c.mu.Unlock()
 	if !ok {
 		return nil, fmt.Errorf("not expecting reply for ID: %v", id)
 	}
@@ -989,7 +1334,7 @@ CollectResults:
 			c.log.Printf("Call to %q canceled by caller (mode=%s): %s",
 				procedure, c.cancelMode, err)
 		}
-		c.sess.Send(&wamp.Cancel{
+		sess.Send(&wamp.Cancel{
 			Request: id,
 			Options: wamp.SetOption(nil, wamp.OptMode, c.cancelMode),
 		})
@@ -1011,28 +1356,61 @@ CollectResults:
 		case <-timer.C:
 			// Did not get expected response to cancel
 			err = ErrReplyTimeout
+		case <-done:
+			timer.Stop()
 		}
-	case <-c.Done():
+	case <-done:
 		err = ErrNotConn
 	}
 	// All done with this call, so not waiting for more replies.
-	c.sess.Lock()
+	c.mu.Lock()
 	delete(c.awaitingReply, id)
-	c.sess.Unlock()
+	c.mu.Unlock()
 
 	return msg, err
 }
 
 // run is the core client goroutine.  This handles messages received from the
-// router and serializes access to all mutable state.
+// router and serializes access to all mutable state.  If the client is
+// configured to reconnect, then this also reconnects the client when its
+// connection to the router is lost.
 func (c *Client) run() {
 	defer c.cancel()
-	if c.debug {
-		defer c.log.Println("Client", c.sess, "closed")
+	defer c.restoring.Wait()
+
+	for {
+		// Only run() replaces the session, so it is safe to read here.
+		sess := c.sess
+		c.runSession(sess)
+		if c.debug {
+			c.log.Println("Client", sess, "closed")
+		}
+
+		c.mu.Lock()
+		close(c.sessDone)
+		closed := c.closed
+		if c.reconnect != nil && !closed {
+			c.reconnected = make(chan struct{})
+		}
+		c.mu.Unlock()
+
+		if c.reconnect == nil || closed {
+			return
+		}
+		// Wait for any restore from a previous reconnect to stop, so that it
+		// does not restore onto the next session.
+		c.restoring.Wait()
+		if !c.runReconnect() {
+			return
+		}
 	}
+}
 
-	recv := c.sess.Recv()
-	recvDone := c.sess.RecvDone()
+// runSession handles messages received from the router over the session,
+// until the connection is lost or the router says GOODBYE.
+func (c *Client) runSession(sess *wamp.Session) {
+	recv := sess.Recv()
+	recvDone := sess.RecvDone()
 	for {
 		select {
 		case msg, ok := <-recv:
@@ -1069,8 +1447,18 @@ func (c *Client) runReceiveFromRouter(msg wamp.Message) bool {
 		c.runHandleInterrupt(msg)
 
 	case *wamp.Registered:
+		c.mu.Lock()
+		if fn, ok := c.registering[msg.Request]; ok {
+			c.invHandlers[msg.Registration] = fn
+		}
+		c.mu.Unlock()
 		c.runSignalReply(msg, msg.Request)
 	case *wamp.Subscribed:
+		c.mu.Lock()
+		if fn, ok := c.subscribing[msg.Request]; ok {
+			c.eventHandlers[msg.Subscription] = fn
+		}
+		c.mu.Unlock()
 		c.runSignalReply(msg, msg.Request)
 	case *wamp.Unsubscribed:
 		c.runSignalReply(msg, msg.Request)
@@ -1100,9 +1488,9 @@ func (c *Client) runReceiveFromRouter(msg wamp.Message) bool {
 // as the messages are received in.  This could not be guaranteed if executing
 // concurrently in separate goroutines.
 func (c *Client) runHandleEvent(msg *wamp.Event) {
-	c.sess.Lock()
+	c.mu.Lock()
 	handler, ok := c.eventHandlers[msg.Subscription]
-	c.sess.Unlock()
+	c.mu.Unlock()
 	if !ok {
 		c.log.Println("No handler registered for subscription:",
 			msg.Subscription)
@@ -1118,10 +1506,11 @@ func (c *Client) runHandleInvocation(msg *wamp.Invocation) {
 	progResOK, _ := msg.Details[wamp.OptReceiveProgress].(bool)
 	reqID := msg.Request
 
-	c.sess.Lock()
+	c.mu.Lock()
+	sess, done := c.sess, c.sessDone
 	handler, ok := c.invHandlers[msg.Registration]
 	if !ok {
-		c.sess.Unlock()
+		c.mu.Unlock()
 		errMsg := fmt.Sprintf("client has no handler for registration %v",
 			msg.Registration)
 		// The dealer has a procedure registered to this client, but this
@@ -1129,7 +1518,7 @@ func (c *Client) runHandleInvocation(msg *wamp.Invocation) {
 		// as ErrNoSuchProcedure, since the dealer has a procedure registered.
 		// It is reported as ErrInvalidArgument to denote that the client has a
 		// problem with the registration ID argument.
-		c.sess.Send(&wamp.Error{
+		sess.Send(&wamp.Error{
 			Type:      wamp.INVOCATION,
 			Request:   reqID,
 			Details:   wamp.Dict{},
@@ -1156,9 +1545,9 @@ func (c *Client) runHandleInvocation(msg *wamp.Invocation) {
 	// If caller is accepting progressive results, create map entry to allow
 	// progress to be sent.
 	if progResOK {
-		c.progGate[ctx] = reqID
+		c.progGate[ctx] = progressDest{req: reqID, sess: sess, done: done}
 	}
-	c.sess.Unlock()
+	c.mu.Unlock()
 
 	// Start a goroutine to run the user-defined invocation handler.
 	go func() {
@@ -1177,10 +1566,10 @@ func (c *Client) runHandleInvocation(msg *wamp.Invocation) {
 
 		// Remove the kill switch when done processing invocation.
 		defer func() {
-			c.sess.Lock()
+			c.mu.Lock()
 			delete(c.progGate, ctx)
 			delete(c.invHandlerKill, reqID)
-			c.sess.Unlock()
+			c.mu.Unlock()
 			c.activeInvHandlers.Done()
 		}()
 
@@ -1193,8 +1582,8 @@ func (c *Client) runHandleInvocation(msg *wamp.Invocation) {
 			if result.Err == wamp.ErrCanceled {
 				c.log.Println("INVOCATION", reqID, "canceled by callee")
 			}
-		case <-c.Done():
-			c.log.Print("Client stopping, invocation handler canceled")
+		case <-done:
+			c.log.Print("Client disconnected, invocation handler canceled")
 			// Return without sending response to server.  This will also
 			// cancel the context.
 			return
@@ -1212,7 +1601,7 @@ func (c *Client) runHandleInvocation(msg *wamp.Invocation) {
 		}
 
 		if result.Err != "" {
-			c.sess.SendCtx(c.ctx, &wamp.Error{
+			sess.SendCtx(c.ctx, &wamp.Error{
 				Type:        wamp.INVOCATION,
 				Request:     reqID,
 				Details:     wamp.Dict{},
@@ -1222,7 +1611,7 @@ func (c *Client) runHandleInvocation(msg *wamp.Invocation) {
 			})
 			return
 		}
-		c.sess.SendCtx(c.ctx, &wamp.Yield{
+		sess.SendCtx(c.ctx, &wamp.Yield{
 			Request:     reqID,
 			Options:     wamp.Dict{},
 			Arguments:   result.Args,
@@ -1235,9 +1624,9 @@ func (c *Client) runHandleInvocation(msg *wamp.Invocation) {
 // requesting that a pending call be canceled.
 func (c *Client) runHandleInterrupt(msg *wamp.Interrupt) {
 	logMsg := "Received INTERRUPT for INVOCATION"
-	c.sess.Lock()
+	c.mu.Lock()
 	cancel, ok := c.invHandlerKill[msg.Request]
-	c.sess.Unlock()
+	c.mu.Unlock()
 	if !ok {
 		c.log.Println(logMsg, msg.Request, "that no longer exists")
 		return
@@ -1253,9 +1642,9 @@ func (c *Client) runHandleInterrupt(msg *wamp.Interrupt) {
 func (c *Client) runSignalReply(msg wamp.Message, requestID wamp.ID) {
 	var w chan wamp.Message
 	var ok bool
-	c.sess.Lock()
+	c.mu.Lock()
 	w, ok = c.awaitingReply[requestID]
-	c.sess.Unlock()
+	c.mu.Unlock()
 	if !ok {
 		c.log.Println("Received", msg.MessageType(), requestID,
 			"that client is no longer waiting for")
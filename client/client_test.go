@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"math/rand"
 	"os"
 	"strings"
 	"testing"
@@ -315,6 +316,100 @@ func TestSubscribe(t *testing.T) {
 	r.Close()
 }
 
+func TestSubscribeAck(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	r, err := getTestRouter(newTestRealmConfig(testRealm, func(rc *router.RealmConfig) {
+		rc.EventAckTimeoutMsec = 100
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	sub, err := newTestClient(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	pub, err := newTestClient(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pub.Close()
+
+	// Fail the first delivery, which is then redelivered and acknowledged.
+	events := make(chan *wamp.Event, 4)
+	handler := func(event *wamp.Event) error {
+		events <- event
+		if redelivered, _ := event.Details[wamp.DetailRedelivered].(bool); !redelivered {
+			return errors.New("not ready")
+		}
+		return nil
+	}
+	if err = sub.SubscribeAck(testTopic, handler, nil); err != nil {
+		t.Fatal("subscribe error:", err)
+	}
+	if err = pub.Publish(testTopic, nil, wamp.List{"hello"}, nil); err != nil {
+		t.Fatal("publish error:", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case event := <-events:
+			if seq, _ := wamp.AsInt64(event.Details[wamp.DetailDeliverySeq]); seq != 1 {
+				t.Fatal("Expected delivery seq 1, got", seq)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("did not get event delivery", i+1)
+		}
+	}
+
+	// Acknowledged event is not redelivered.
+	select {
+	case <-events:
+		t.Fatal("Acknowledged event was redelivered")
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+
+func TestDetectGaps(t *testing.T) {
+	var received int
+	var gaps []uint64
+	handler := DetectGaps(func(event *wamp.Event) {
+		received++
+	}, func(event *wamp.Event, missed uint64) {
+		gaps = append(gaps, missed)
+	})
+
+	subEvent := func(seq int) *wamp.Event {
+		return &wamp.Event{
+			Subscription: 1,
+			Details:      wamp.Dict{wamp.DetailSubscriptionSeq: seq},
+		}
+	}
+	topicEvent := func(topic string, seq int) *wamp.Event {
+		return &wamp.Event{
+			Subscription: 2,
+			Details:      wamp.Dict{"topic": topic, wamp.DetailTopicSeq: seq},
+		}
+	}
+	for _, event := range []*wamp.Event{
+		subEvent(1), subEvent(2), subEvent(5),
+		topicEvent("a", 1), topicEvent("b", 1), topicEvent("a", 3),
+		{Subscription: 3, Details: wamp.Dict{}},
+		// The sequence restarts, and continues from the restart.
+		subEvent(1), subEvent(2), subEvent(4),
+	} {
+		handler(event)
+	}
+	if received != 10 {
+		t.Fatal("Expected 10 events handled, got", received)
+	}
+	if len(gaps) != 4 || gaps[0] != 2 || gaps[1] != 1 || gaps[2] != 0 || gaps[3] != 1 {
+		t.Fatal("Wrong gaps detected:", gaps)
+	}
+}
+
 func TestRemoteProcedureCall(t *testing.T) {
 	defer leaktest.Check(t)()
 
@@ -1175,3 +1270,353 @@ func TestEventContentSafety(t *testing.T) {
 		}
 	}
 }
+
+// startReconnectRouter starts a router with a websocket server.  If setup is
+// not nil, it is called before the server starts accepting clients.
+func startReconnectRouter(t *testing.T, setup func(router.Router)) (router.Router, io.Closer) {
+	r, err := getTestRouter(newTestRealmConfig(testRealm))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if setup != nil {
+		setup(r)
+	}
+	closer, err := router.NewWebsocketServer(r).ListenAndServe(testAddress)
+	if err != nil {
+		r.Close()
+		t.Fatal(err)
+	}
+	return r, closer
+}
+
+func newReconnectConfig(events chan<- string) *ReconnectConfig {
+	return &ReconnectConfig{
+		MinBackoff: 10 * time.Millisecond,
+		MaxBackoff: 50 * time.Millisecond,
+		Jitter:     0.5,
+		OnConnected: func(*Client) {
+			events <- "connected"
+		},
+		OnDisconnected: func(*Client) {
+			events <- "disconnected"
+		},
+		OnRejoined: func(*Client) {
+			events <- "rejoined"
+		},
+	}
+}
+
+func expectLifecycle(t *testing.T, events <-chan string, expect string) {
+	select {
+	case ev := <-events:
+		if ev != expect {
+			t.Fatalf("Expected %s callback, got %s", expect, ev)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for %s callback", expect)
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	r, closer := startReconnectRouter(t, nil)
+
+	lifecycle := make(chan string, 4)
+	cfg := newTestClientConfig(testRealm, func(cfg *Config) {
+		cfg.Reconnect = newReconnectConfig(lifecycle)
+	})
+	client, err := ConnectNet(context.Background(), fmt.Sprintf("ws://%s/ws", testAddress), *cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	expectLifecycle(t, lifecycle, "connected")
+
+	events := make(chan *wamp.Event, 1)
+	if err = client.Subscribe(testTopic, func(ev *wamp.Event) { events <- ev }, nil); err != nil {
+		t.Fatal(err)
+	}
+	echo := func(ctx context.Context, inv *wamp.Invocation) InvokeResult {
+		return InvokeResult{Args: inv.Arguments}
+	}
+	if err = client.Register("test.echo", echo, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err = client.Register("test.dropped", echo, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err = client.Unregister("test.dropped"); err != nil {
+		t.Fatal(err)
+	}
+	oldID := client.ID()
+
+	// Restart the router.
+	closer.Close()
+	r.Close()
+	expectLifecycle(t, lifecycle, "disconnected")
+	if client.Connected() {
+		t.Fatal("Client should not be connected")
+	}
+	select {
+	case <-client.Done():
+		t.Fatal("Client should not be done while reconnecting")
+	default:
+	}
+	// Without QueueCalls, calls fail while disconnected.
+	if _, err = client.Call(context.Background(), "test.echo", nil, nil, nil, nil); err != ErrNotConn {
+		t.Fatal("Expected ErrNotConn, got", err)
+	}
+
+	r, closer = startReconnectRouter(t, nil)
+	defer r.Close()
+	defer closer.Close()
+	expectLifecycle(t, lifecycle, "connected")
+	expectLifecycle(t, lifecycle, "rejoined")
+
+	if !client.Connected() {
+		t.Fatal("Client should be connected")
+	}
+	if client.ID() == oldID {
+		t.Fatal("Expected new session ID after reconnecting")
+	}
+	if _, ok := client.RegistrationID("test.dropped"); ok {
+		t.Fatal("Unregistered procedure was restored")
+	}
+
+	// Check that the subscription and registration were restored.
+	other, err := newTestClient(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if err = other.Publish(testTopic, nil, wamp.List{"hello"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case ev := <-events:
+		if len(ev.Arguments) != 1 || ev.Arguments[0] != "hello" {
+			t.Fatal("Wrong event arguments:", ev.Arguments)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Did not receive event after reconnecting")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	result, err := other.Call(ctx, "test.echo", nil, wamp.List{"ping"}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Arguments) != 1 || result.Arguments[0] != "ping" {
+		t.Fatal("Wrong call result:", result.Arguments)
+	}
+}
+
+func TestReconnectRestoresAll(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	r, closer := startReconnectRouter(t, nil)
+
+	lifecycle := make(chan string, 4)
+	cfg := newTestClientConfig(testRealm, func(cfg *Config) {
+		cfg.Reconnect = newReconnectConfig(lifecycle)
+	})
+	client, err := ConnectNet(context.Background(), fmt.Sprintf("ws://%s/ws", testAddress), *cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	expectLifecycle(t, lifecycle, "connected")
+
+	// The router issues IDs from 1 after restarting, so the new IDs of some
+	// subscriptions and registrations are the old IDs of others.
+	names := []string{"test.a", "test.b", "test.c"}
+	events := make(chan string, len(names))
+	for _, name := range names {
+		name := name
+		handler := func(ev *wamp.Event) { events <- name }
+		if err = client.Subscribe(name, handler, nil); err != nil {
+			t.Fatal(err)
+		}
+		handle := func(ctx context.Context, inv *wamp.Invocation) InvokeResult {
+			return InvokeResult{Args: wamp.List{name}}
+		}
+		if err = client.Register(name, handle, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	closer.Close()
+	r.Close()
+	expectLifecycle(t, lifecycle, "disconnected")
+	r, closer = startReconnectRouter(t, nil)
+	defer r.Close()
+	defer closer.Close()
+	expectLifecycle(t, lifecycle, "connected")
+	expectLifecycle(t, lifecycle, "rejoined")
+
+	other, err := newTestClient(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	for _, name := range names {
+		if err = other.Publish(name, nil, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case got := <-events:
+			if got != name {
+				t.Fatalf("Event for %s handled by handler for %s", name, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Did not receive event for", name)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		result, err := other.Call(ctx, name, nil, nil, nil, nil)
+		cancel()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(result.Arguments) != 1 || result.Arguments[0] != name {
+			t.Fatalf("Call to %s handled by handler for %v", name, result.Arguments)
+		}
+	}
+}
+
+func TestReconnectQueueCalls(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	r, closer := startReconnectRouter(t, nil)
+
+	lifecycle := make(chan string, 4)
+	cfg := newTestClientConfig(testRealm, func(cfg *Config) {
+		cfg.Reconnect = newReconnectConfig(lifecycle)
+		cfg.Reconnect.QueueCalls = true
+	})
+	client, err := ConnectNet(context.Background(), fmt.Sprintf("ws://%s/ws", testAddress), *cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	expectLifecycle(t, lifecycle, "connected")
+
+	closer.Close()
+	r.Close()
+	expectLifecycle(t, lifecycle, "disconnected")
+
+	// A queued call ends when its context is done.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	_, err = client.Call(ctx, "test.echo", nil, nil, nil, nil)
+	cancel()
+	if err != context.DeadlineExceeded {
+		t.Fatal("Expected context deadline exceeded, got", err)
+	}
+
+	// A queued call is made after reconnecting.
+	results := make(chan *wamp.Result, 1)
+	errs := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		result, err := client.Call(ctx, "test.echo", nil, wamp.List{"queued"}, nil, nil)
+		if err != nil {
+			errs <- err
+			return
+		}
+		results <- result
+	}()
+
+	var callee *Client
+	r, closer = startReconnectRouter(t, func(r router.Router) {
+		if callee, err = newTestClient(r); err != nil {
+			t.Fatal(err)
+		}
+		echo := func(ctx context.Context, inv *wamp.Invocation) InvokeResult {
+			return InvokeResult{Args: inv.Arguments}
+		}
+		if err = callee.Register("test.echo", echo, nil); err != nil {
+			t.Fatal(err)
+		}
+	})
+	defer r.Close()
+	defer closer.Close()
+	defer callee.Close()
+	expectLifecycle(t, lifecycle, "connected")
+	expectLifecycle(t, lifecycle, "rejoined")
+
+	select {
+	case result := <-results:
+		if len(result.Arguments) != 1 || result.Arguments[0] != "queued" {
+			t.Fatal("Wrong call result:", result.Arguments)
+		}
+	case err = <-errs:
+		t.Fatal("Queued call failed:", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Queued call did not complete")
+	}
+}
+
+func TestReconnectFailover(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	r, closer := startReconnectRouter(t, nil)
+	defer r.Close()
+	defer closer.Close()
+
+	lifecycle := make(chan string, 4)
+	cfg := newTestClientConfig(testRealm, func(cfg *Config) {
+		cfg.Reconnect = newReconnectConfig(lifecycle)
+		cfg.Reconnect.FailoverURLs = []string{fmt.Sprintf("ws://%s/ws", testAddress)}
+		cfg.Reconnect.MaxAttempts = 2
+	})
+	// Nothing is listening on the first URL.
+	client, err := ConnectNet(context.Background(), "tcp://127.0.0.1:8998", *cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectLifecycle(t, lifecycle, "connected")
+	if err = client.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Give up after MaxAttempts.
+	cfg.Reconnect.FailoverURLs = nil
+	if _, err = ConnectNet(context.Background(), "tcp://127.0.0.1:8998", *cfg); err == nil {
+		t.Fatal("Expected error connecting")
+	}
+}
+
+func TestReconnectBackoff(t *testing.T) {
+	cfg := Config{
+		Reconnect: &ReconnectConfig{
+			MinBackoff: 100 * time.Millisecond,
+			MaxBackoff: time.Second,
+		},
+	}
+	r := &reconnecter{cfg: cfg}
+	for attempt, expect := range []time.Duration{
+		1: 100 * time.Millisecond,
+		2: 200 * time.Millisecond,
+		3: 400 * time.Millisecond,
+		4: 800 * time.Millisecond,
+		5: time.Second,
+		6: time.Second,
+	} {
+		if attempt == 0 {
+			continue
+		}
+		if d := r.backoff(attempt); d != expect {
+			t.Fatalf("Expected backoff %s for attempt %d, got %s", expect, attempt, d)
+		}
+	}
+
+	cfg.Reconnect.Jitter = 0.5
+	r = &reconnecter{cfg: cfg, rand: rand.New(rand.NewSource(1))}
+	for i := 0; i < 100; i++ {
+		if d := r.backoff(4); d < 400*time.Millisecond || d > 800*time.Millisecond {
+			t.Fatal("Backoff with jitter out of range:", d)
+		}
+	}
+}
@@ -47,7 +47,7 @@ type Config struct {
 	// waiting for a response from the router.  A value of 0 uses the default.
 	ResponseTimeout time.Duration
 
-	// Set to JSON or MSGPACK.  Default (zero-value) is JSON.
+	// Set to JSON, MSGPACK, CBOR, or UBJSON.  Default (zero-value) is JSON.
 	Serialization serialize.Serialization
 
 	// Provide a tls.Config to connect the client using TLS.  The zero
@@ -69,4 +69,8 @@ type Config struct {
 
 	// Websocket transport configuration.
 	WsCfg transport.WebsocketConfig
+
+	// Reconnect, if not nil, makes a client created by ConnectNet reconnect
+	// to the router when its connection is lost.  See ReconnectConfig.
+	Reconnect *ReconnectConfig
 }
@@ -10,4 +10,9 @@ const (
 
 	// Time client will wait for expected router response if not specified.
 	defaultResponseTimeout = 5 * time.Second
+
+	// Reconnect defaults, if not specified in ReconnectConfig.
+	defaultMinBackoff  = time.Second
+	defaultMaxBackoff  = 30 * time.Second
+	defaultDialTimeout = 10 * time.Second
 )
@@ -33,11 +33,26 @@ import (
 // For Unix socket clients, the routerURL has the form "unix://path".  The path
 // portion specifies a path on the local file system where the Unix socket is
 // created.  TLS is not used for unix sockets.
+//
+// If cfg.Reconnect is set, then the client reconnects to the router, or to one
+// of the failover routers, when its connection is lost.  See ReconnectConfig.
 func ConnectNet(ctx context.Context, routerURL string, cfg Config) (*Client, error) {
 	if cfg.Logger == nil {
 		cfg.Logger = log.New(os.Stderr, "", 0)
 	}
+	if cfg.Reconnect != nil {
+		return connectReconnecting(ctx, routerURL, cfg)
+	}
 
+	p, err := dialRouter(ctx, routerURL, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(p, cfg)
+}
+
+// dialRouter connects a peer to the router at routerURL.
+func dialRouter(ctx context.Context, routerURL string, cfg Config) (wamp.Peer, error) {
 	u, err := url.Parse(routerURL)
 	if err != nil {
 		return nil, err
@@ -79,7 +94,7 @@ func ConnectNet(ctx context.Context, routerURL string, cfg Config) (*Client, err
 	if err != nil {
 		return nil, err
 	}
-	return NewClient(p, cfg)
+	return p, nil
 }
 
 // CookieURL takes a websocket URL string and outputs a url.URL that can be
@@ -0,0 +1,352 @@
+package client
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// ReconnectConfig configures a client to reconnect to a router when its
+// connection is lost, for example because the router restarted.  This is used
+// by clients created by ConnectNet.
+//
+// When the connection is lost, the client tries to connect to the router URL
+// given to ConnectNet and then to each of the FailoverURLs, in order.  If it
+// cannot connect to any of them, it waits for a backoff delay and tries them
+// all again.  The delay starts at MinBackoff and doubles after each round of
+// failed attempts, up to MaxBackoff.  Connecting the client the first time is
+// retried in the same way, until the context given to ConnectNet is done.
+//
+// After reconnecting, the client joins the realm again, and re-establishes all
+// of its subscriptions and registrations, with the same handlers and options.
+// The client has a new session ID, and new subscription and registration IDs.
+// A subscription or registration that the router refuses is logged and
+// dropped.  Events published and calls made while the client is disconnected
+// are not received.
+//
+// Calls, publications, and other requests that are waiting for a reply when
+// the connection is lost fail with ErrNotConn, since the client cannot know
+// whether the router handled them.  Invocation handlers that are running when
+// the connection is lost have their context canceled, and their results are
+// discarded.
+//
+// The client's Done channel is closed only when the client is closed, or when
+// it gives up trying to reconnect after MaxAttempts.
+type ReconnectConfig struct {
+	// FailoverURLs are router URLs that the client connects to when it cannot
+	// connect to the router URL given to ConnectNet.
+	FailoverURLs []string
+
+	// MinBackoff is the delay before the first retry after failing to connect
+	// to any router.  A value of 0 uses the default.
+	MinBackoff time.Duration
+	// MaxBackoff is the longest delay between retries.  A value of 0 uses the
+	// default.
+	MaxBackoff time.Duration
+	// Jitter is the fraction, from 0 to 1, of each backoff delay by which the
+	// delay is randomly shortened.  This keeps many clients that lost their
+	// connections at the same time from all reconnecting at the same time.
+	// A value of 0 means no jitter.
+	Jitter float64
+
+	// DialTimeout limits the time to connect to each router.  A value of 0
+	// uses the default.
+	DialTimeout time.Duration
+
+	// MaxAttempts is the number of times to try connecting to the routers
+	// before giving up and closing the client.  A value of 0 means never give
+	// up.
+	MaxAttempts int
+
+	// QueueCalls makes Call wait for the client to reconnect, or for the
+	// call's context to be done, when called while the client is
+	// disconnected.  Otherwise, Call fails with ErrNotConn while the client is
+	// disconnected.  Other requests always fail with ErrNotConn.
+	QueueCalls bool
+
+	// OnConnected, if not nil, is called each time the client joins the
+	// realm, including the first time.  When reconnecting, this is called
+	// before subscriptions and registrations are re-established.
+	OnConnected func(*Client)
+	// OnDisconnected, if not nil, is called each time the client loses its
+	// connection to the router, before trying to reconnect.  It must not wait
+	// for the client to reconnect.
+	OnDisconnected func(*Client)
+	// OnRejoined, if not nil, is called after the client has reconnected and
+	// re-established its subscriptions and registrations.
+	OnRejoined func(*Client)
+}
+
+// reconnecter connects a client to one of a list of routers, retrying with
+// backoff.
+type reconnecter struct {
+	cfg  Config
+	urls []string
+	rand *rand.Rand
+
+	// ctx is canceled when the client is closed, to stop reconnecting.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// connectReconnecting creates a client that reconnects when its connection to
+// the router is lost.
+func connectReconnecting(ctx context.Context, routerURL string, cfg Config) (*Client, error) {
+	rc := *cfg.Reconnect
+	if rc.MinBackoff == 0 {
+		rc.MinBackoff = defaultMinBackoff
+	}
+	if rc.MaxBackoff == 0 {
+		rc.MaxBackoff = defaultMaxBackoff
+	}
+	if rc.MaxBackoff < rc.MinBackoff {
+		rc.MaxBackoff = rc.MinBackoff
+	}
+	if rc.DialTimeout == 0 {
+		rc.DialTimeout = defaultDialTimeout
+	}
+	cfg.Reconnect = &rc
+	if cfg.ResponseTimeout == 0 {
+		cfg.ResponseTimeout = defaultResponseTimeout
+	}
+
+	r := &reconnecter{
+		cfg:  cfg,
+		urls: append([]string{routerURL}, rc.FailoverURLs...),
+		rand: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	sess, err := r.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+
+	c := newClient(sess, cfg)
+	c.reconnect = r
+	go c.run()
+
+	if rc.OnConnected != nil {
+		rc.OnConnected(c)
+	}
+	return c, nil
+}
+
+// connect connects to one of the routers and joins the realm.  The router URLs
+// are tried in order, and then tried again after a backoff delay, until
+// connecting succeeds, the maximum number of attempts is reached, or ctx is
+// done.
+func (r *reconnecter) connect(ctx context.Context) (*wamp.Session, error) {
+	var err error
+	for attempt := 0; r.cfg.Reconnect.MaxAttempts == 0 || attempt < r.cfg.Reconnect.MaxAttempts; attempt++ {
+		if attempt != 0 {
+			timer := time.NewTimer(r.backoff(attempt))
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return nil, ctx.Err()
+			}
+		}
+		for _, routerURL := range r.urls {
+			var sess *wamp.Session
+			if sess, err = r.join(ctx, routerURL); err == nil {
+				return sess, nil
+			}
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			r.cfg.Logger.Printf("Failed to connect to %s: %s", routerURL, err)
+		}
+	}
+	return nil, err
+}
+
+// join connects to the router at routerURL and joins the realm.
+func (r *reconnecter) join(ctx context.Context, routerURL string) (*wamp.Session, error) {
+	dialCtx, cancel := context.WithTimeout(ctx, r.cfg.Reconnect.DialTimeout)
+	defer cancel()
+	p, err := dialRouter(dialCtx, routerURL, r.cfg)
+	if err != nil {
+		return nil, err
+	}
+	return newSession(p, r.cfg)
+}
+
+// backoff returns the delay before the specified attempt, with jitter.
+func (r *reconnecter) backoff(attempt int) time.Duration {
+	rc := r.cfg.Reconnect
+	delay := rc.MinBackoff
+	for i := 1; i < attempt && delay < rc.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > rc.MaxBackoff {
+		delay = rc.MaxBackoff
+	}
+	if rc.Jitter > 0 {
+		delay -= time.Duration(rc.Jitter * r.rand.Float64() * float64(delay))
+	}
+	return delay
+}
+
+// subscription is a subscription to re-establish after reconnecting.
+type subscription struct {
+	topic   string
+	handler EventHandler
+	options wamp.Dict
+}
+
+// registration is a registration to re-establish after reconnecting.
+type registration struct {
+	procedure string
+	handler   InvocationHandler
+	options   wamp.Dict
+}
+
+// runReconnect reconnects the client after its connection is lost.  Returns
+// false if the client was closed or could not reconnect.
+func (c *Client) runReconnect() bool {
+	r := c.reconnect
+	if r.cfg.Reconnect.OnDisconnected != nil {
+		r.cfg.Reconnect.OnDisconnected(c)
+	}
+	c.log.Println("Connection to router lost, reconnecting")
+
+	sess, err := r.connect(r.ctx)
+	if err != nil {
+		if r.ctx.Err() == nil {
+			c.log.Println("Giving up reconnecting:", err)
+		}
+		return false
+	}
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		sess.Close()
+		return false
+	}
+	oldSess := c.sess
+	c.sess = sess
+	c.sessDone = make(chan struct{})
+	done := c.sessDone
+	close(c.reconnected)
+	c.reconnected = nil
+
+	// The IDs from the old session are forgotten before any new IDs are
+	// received, since the router may issue the same IDs again, for other
+	// subscriptions and registrations.  The ID of a subscription or
+	// registration is 0 until it is restored, and its handler is kept by
+	// topic or procedure until then.
+	subs := make([]subscription, 0, len(c.topicSubID))
+	for topic, subID := range c.topicSubID {
+		handler := c.eventHandlers[subID]
+		if subID == 0 {
+			handler = c.unrestoredSubs[topic]
+		}
+		subs = append(subs, subscription{
+			topic:   topic,
+			handler: handler,
+			options: c.topicOptions[topic],
+		})
+		c.topicSubID[topic] = 0
+		c.unrestoredSubs[topic] = handler
+	}
+	c.eventHandlers = map[wamp.ID]EventHandler{}
+	regs := make([]registration, 0, len(c.nameProcID))
+	for procedure, regID := range c.nameProcID {
+		handler := c.invHandlers[regID]
+		if regID == 0 {
+			handler = c.unrestoredRegs[procedure]
+		}
+		regs = append(regs, registration{
+			procedure: procedure,
+			handler:   handler,
+			options:   c.procOptions[procedure],
+		})
+		c.nameProcID[procedure] = 0
+		c.unrestoredRegs[procedure] = handler
+	}
+	c.invHandlers = map[wamp.ID]InvocationHandler{}
+	c.mu.Unlock()
+	oldSess.Close()
+	c.log.Println("Reconnected to router as session", sess)
+
+	// Restore in a separate goroutine, since restoring requires the run()
+	// goroutine to receive replies from the router.
+	c.restoring.Add(1)
+	go c.restore(sess, done, subs, regs)
+	return true
+}
+
+// restore re-establishes subscriptions and registrations on a new session.
+// Subscriptions and registrations that cannot be restored because the
+// connection was lost again are left for the next reconnect.
+func (c *Client) restore(sess *wamp.Session, done <-chan struct{}, subs []subscription, regs []registration) {
+	defer c.restoring.Done()
+	rc := c.reconnect.cfg.Reconnect
+	if rc.OnConnected != nil {
+		rc.OnConnected(c)
+	}
+
+	for _, sub := range subs {
+		subID, err := c.subscribe(sess, done, sub.topic, sub.handler, sub.options)
+		if err == ErrNotConn {
+			return
+		}
+		c.mu.Lock()
+		current, ok := c.topicSubID[sub.topic]
+		restored := ok && current == 0
+		if restored {
+			delete(c.unrestoredSubs, sub.topic)
+			if err == nil {
+				c.topicSubID[sub.topic] = subID
+			} else {
+				delete(c.topicSubID, sub.topic)
+				delete(c.topicOptions, sub.topic)
+			}
+		} else if err == nil && current != subID {
+			delete(c.eventHandlers, subID)
+		}
+		c.mu.Unlock()
+		if err != nil {
+			c.log.Println("Failed to restore subscription:", err)
+		} else if !restored && current != subID {
+			// Unsubscribed while restoring.
+			c.unsubscribe(sess, done, sub.topic, subID)
+		}
+	}
+
+	for _, reg := range regs {
+		regID, err := c.register(sess, done, reg.procedure, reg.handler, reg.options)
+		if err == ErrNotConn {
+			return
+		}
+		c.mu.Lock()
+		current, ok := c.nameProcID[reg.procedure]
+		restored := ok && current == 0
+		if restored {
+			delete(c.unrestoredRegs, reg.procedure)
+			if err == nil {
+				c.nameProcID[reg.procedure] = regID
+			} else {
+				delete(c.nameProcID, reg.procedure)
+				delete(c.procOptions, reg.procedure)
+			}
+		} else if err == nil && current != regID {
+			delete(c.invHandlers, regID)
+		}
+		c.mu.Unlock()
+		if err != nil {
+			c.log.Println("Failed to restore registration:", err)
+		} else if !restored && current != regID {
+			// Unregistered while restoring.
+			c.unregister(sess, done, reg.procedure, regID)
+		}
+	}
+
+	if rc.OnRejoined != nil {
+		rc.OnRejoined(c)
+	}
+}
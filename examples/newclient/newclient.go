@@ -45,7 +45,7 @@ func NewClient(logger *log.Logger) (*client.Client, error) {
 		fmt.Sprintf("router port. (default %d, %d, %d, %d for scheme ws, wss, tcp, tcps)", defaultWsPort, defaultWssPort, defaultTcpPort, defaultTcpsPort))
 	flag.StringVar(&realm, "realm", defaultRealm, "realm name")
 	flag.StringVar(&scheme, "scheme", "ws", "[ws, wss, tcp, tcps, unix]")
-	flag.StringVar(&serType, "serialize", "json", "\"json\" or \"msgpack\"")
+	flag.StringVar(&serType, "serialize", "json", "\"json\", \"msgpack\", \"cbor\", or \"ubjson\"")
 	flag.BoolVar(&skipVerify, "skipverify", false,
 		"accept any certificate presented by the server")
 	flag.StringVar(&caFile, "trust", "",
@@ -65,10 +65,12 @@ func NewClient(logger *log.Logger) (*client.Client, error) {
 		serialization = client.MSGPACK
 	case "cbor":
 		serialization = client.CBOR
+	case "ubjson":
+		serialization = client.UBJSON
 
 	default:
 		return nil, errors.New(
-			"invalid serialization, muse be one of: json, msgpack, cbor")
+			"invalid serialization, muse be one of: json, msgpack, cbor, ubjson")
 	}
 
 	if addr == "" {
@@ -30,6 +30,15 @@ type Config struct {
 		AllowOrigins []string `json:"allow_origins"`
 		// Limit on number of pending messages to send to each client.
 		OutQueueSize int `json:"out_queue_size"`
+		// Milliseconds to wait for more messages to add to a batch, for
+		// clients using a batched subprotocol.  Set to 0 to send a batch as
+		// soon as no more messages are waiting.
+		BatchWindowMsec int `json:"batch_window_msec"`
+		// Size, in bytes, at which a batch is sent.  Set to 0 to use the
+		// default of 16K.
+		BatchMaxSize int `json:"batch_max_size"`
+		// PROXY protocol headers from load balancers.
+		ProxyProtocol ProxyProtocolConfig `json:"proxy_protocol"`
 	}
 
 	// RawSocket configuration parameters.
@@ -47,15 +56,61 @@ type Config struct {
 		KeyFile  string `json:"key_file"`
 		// Limit on number of pending messages to send to each client.
 		OutQueueSize int `json:"out_queue_size"`
+		// PROXY protocol headers from load balancers, on TCP connections.
+		ProxyProtocol ProxyProtocolConfig `json:"proxy_protocol"`
+	}
+
+	// Combined listener configuration parameters.  The combined listener
+	// accepts both websocket and rawsocket connections on one TCP port, and
+	// handles them using the websocket and rawsocket settings above.
+	Combined struct {
+		// String form of address (example, "192.0.2.1:25", "[2001:db8::1]:80")
+		Address string `json:"address"`
+		// Files containing a certificate and matching private key.  If set,
+		// then connections that begin with a TLS handshake use TLS.
+		CertFile string `json:"cert_file"`
+		KeyFile  string `json:"key_file"`
+		// Reject connections that do not use TLS.
+		RequireTLS bool `json:"require_tls"`
+		// PROXY protocol headers from load balancers.
+		ProxyProtocol ProxyProtocolConfig `json:"proxy_protocol"`
 	}
 
 	// File to write log data to.  If not specified, log to stdout.
 	LogPath string `json:"log_path"`
+	// Time in seconds to wait, when shutting down, for in-progress calls to
+	// complete.  Set to 0 to use the default of 10 seconds.
+	ShutdownGracePeriod time.Duration `json:"shutdown_grace_period"`
 	// Router configuration parameters.
 	// See https://godoc.org/github.com/gammazero/nexus#RouterConfig
 	Router router.Config
 }
 
+// ProxyProtocolConfig configures accepting PROXY protocol headers, which tell
+// the server the address of a client that connects through a load balancer.
+type ProxyProtocolConfig struct {
+	// Accept PROXY protocol headers.
+	Enable bool `json:"enable"`
+	// Reject connections that do not have a PROXY protocol header.
+	Required bool `json:"required"`
+	// Networks, in CIDR notation, from which headers are accepted.  If empty,
+	// headers are accepted from any address.  This must not be empty unless
+	// headers are required.
+	TrustedCIDRs []string `json:"trusted_cidrs"`
+}
+
+// proxyProtocol returns the router configuration for the PROXY protocol, or
+// nil if it is not enabled.
+func (c *ProxyProtocolConfig) proxyProtocol() *router.ProxyProtocol {
+	if !c.Enable {
+		return nil
+	}
+	return &router.ProxyProtocol{
+		Required:     c.Required,
+		TrustedCIDRs: c.TrustedCIDRs,
+	}
+}
+
 func LoadConfig(path string) *Config {
 	file, err := ioutil.ReadFile(path)
 	if err != nil {
@@ -74,5 +129,8 @@ func LoadConfig(path string) *Config {
 	if config.RawSocket.TCPKeepAliveInterval != 0 {
 		config.RawSocket.TCPKeepAliveInterval *= time.Second
 	}
+	if config.ShutdownGracePeriod != 0 {
+		config.ShutdownGracePeriod *= time.Second
+	}
 	return &config
 }
@@ -5,6 +5,7 @@ Stand-alone nexus router service.
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"io"
@@ -12,12 +13,21 @@ import (
 	"os"
 	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/gammazero/nexus/v3/router"
 	"github.com/gammazero/nexus/v3/wamp"
 )
 
+const (
+	// Default time to wait for in-progress calls to complete at shutdown.
+	defaultShutdownGracePeriod = 10 * time.Second
+
+	// Time allowed for router to close after the shutdown grace period.
+	closeTimeout = 5 * time.Second
+)
+
 func main() {
 	var (
 		cfgFile, realm            string
@@ -105,40 +115,15 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Create and run servers.
+	// Create servers.  The websocket and rawsocket servers are configured
+	// even if they do not have their own listeners, since they also handle
+	// connections accepted by the combined listener.
+	wss := newWebsocketServer(r, conf, logger)
+	rss := newRawSocketServer(r, conf, logger)
+
+	// Run servers.
 	var closers []io.Closer
 	if conf.WebSocket.Address != "" {
-		// Create a new websocket server with the router.
-		wss := router.NewWebsocketServer(r)
-		if conf.WebSocket.EnableCompression {
-			wss.Upgrader.EnableCompression = true
-			logger.Printf("Compression enabled")
-		}
-		if conf.WebSocket.EnableTrackingCookie {
-			wss.EnableTrackingCookie = true
-			logger.Printf("Tracking cookie enabled - not currently used")
-		}
-		if conf.WebSocket.EnableRequestCapture {
-			wss.EnableRequestCapture = true
-			logger.Printf("Request capture enabled - not currently used")
-		}
-		if conf.WebSocket.KeepAlive != 0 {
-			wss.KeepAlive = conf.WebSocket.KeepAlive
-			logger.Printf("Websocket heartbeat interval: %s", wss.KeepAlive)
-		}
-		if len(conf.WebSocket.AllowOrigins) != 0 {
-			e := wss.AllowOrigins(conf.WebSocket.AllowOrigins)
-			if e != nil {
-				logger.Print(e)
-				os.Exit(1)
-			}
-			logger.Println("Allowing origins matching:",
-				strings.Join(conf.WebSocket.AllowOrigins, "|"))
-		}
-		if conf.WebSocket.OutQueueSize != 0 {
-			wss.OutQueueSize = conf.WebSocket.OutQueueSize
-			logger.Printf("Websocket outbound queue size: %d", wss.OutQueueSize)
-		}
 		var closer io.Closer
 		var sockDesc string
 		if conf.WebSocket.CertFile != "" && conf.WebSocket.KeyFile != "" {
@@ -158,68 +143,89 @@ func main() {
 		logger.Printf("Listening for %s connections on ws://%s/", sockDesc,
 			conf.WebSocket.Address)
 	}
-	if conf.RawSocket.TCPAddress != "" || conf.RawSocket.UnixAddress != "" {
-		// Create a new rawsocket server with the router.
-		rss := router.NewRawSocketServer(r)
-		rss.RecvLimit = conf.RawSocket.MaxMsgLen
-		if conf.RawSocket.OutQueueSize != 0 {
-			rss.OutQueueSize = conf.RawSocket.OutQueueSize
-			logger.Printf("raw socket outbound queue size: %d", rss.OutQueueSize)
+	if conf.RawSocket.TCPAddress != "" {
+		var closer io.Closer
+		var sockDesc string
+		if conf.RawSocket.CertFile != "" && conf.RawSocket.KeyFile != "" {
+			// Run TLS rawsocket TCP server.
+			closer, err = rss.ListenAndServeTLS("tcp",
+				conf.RawSocket.TCPAddress, nil, conf.RawSocket.CertFile,
+				conf.RawSocket.KeyFile)
+			sockDesc = "TLS socket"
+		} else {
+			// Run rawsocket TCP server.
+			closer, err = rss.ListenAndServe("tcp", conf.RawSocket.TCPAddress)
+			sockDesc = "socket"
+		}
+		if err != nil {
+			logger.Print("Cannot start TCP server: ", err)
+			os.Exit(1)
+		}
+		closers = append(closers, closer)
+		logger.Println("Listening for TCP", sockDesc, "connections on",
+			conf.RawSocket.TCPAddress)
+	}
+	if conf.RawSocket.UnixAddress != "" {
+		// Run rawsocket Unix server.
+		closer, err := rss.ListenAndServe("unix", conf.RawSocket.UnixAddress)
+		if err != nil {
+			logger.Print("Cannot start unix socket server: ", err)
+			os.Exit(1)
 		}
-		if conf.RawSocket.TCPAddress != "" {
-			if conf.RawSocket.TCPKeepAliveInterval != 0 {
-				rss.KeepAlive = conf.RawSocket.TCPKeepAliveInterval
-				logger.Printf("tcp keep-alive interval: %s", rss.KeepAlive)
-			}
-
-			var closer io.Closer
-			var sockDesc string
-			if conf.RawSocket.CertFile != "" && conf.RawSocket.KeyFile != "" {
-				// Run TLS rawsocket TCP server.
-				closer, err = rss.ListenAndServeTLS("tcp",
-					conf.RawSocket.TCPAddress, nil, conf.RawSocket.CertFile,
-					conf.RawSocket.KeyFile)
-				sockDesc = "TLS socket"
-			} else {
-				// Run rawsocket TCP server.
-				closer, err = rss.ListenAndServe("tcp", conf.RawSocket.TCPAddress)
-				sockDesc = "socket"
-			}
-			if err != nil {
-				logger.Print("Cannot start TCP server: ", err)
-				os.Exit(1)
-			}
-			closers = append(closers, closer)
-			logger.Println("Listening for TCP", sockDesc, "connections on",
-				conf.RawSocket.TCPAddress)
+		closers = append(closers, closer)
+		logger.Println("Listening for Unix socket connections on",
+			conf.RawSocket.UnixAddress)
+	}
+	if conf.Combined.Address != "" {
+		// Run server that accepts websocket and rawsocket connections on the
+		// same port.
+		ss := router.NewSniffServer(wss, rss)
+		ss.RequireTLS = conf.Combined.RequireTLS
+		if pp := conf.Combined.ProxyProtocol.proxyProtocol(); pp != nil {
+			ss.ProxyProtocol = pp
 		}
-		if conf.RawSocket.UnixAddress != "" {
-			// Run rawsocket Unix server.
-			closer, err := rss.ListenAndServe("unix", conf.RawSocket.UnixAddress)
-			if err != nil {
-				logger.Print("Cannot start unix socket server: ", err)
-				os.Exit(1)
+		var closer io.Closer
+		var sockDesc string
+		if conf.Combined.CertFile != "" && conf.Combined.KeyFile != "" {
+			closer, err = ss.ListenAndServeTLS("tcp", conf.Combined.Address,
+				nil, conf.Combined.CertFile, conf.Combined.KeyFile)
+			sockDesc = "TLS and plain"
+			if ss.RequireTLS {
+				sockDesc = "TLS"
 			}
-			closers = append(closers, closer)
-			logger.Println("Listening for Unix socket connections on",
-				conf.RawSocket.UnixAddress)
+		} else {
+			closer, err = ss.ListenAndServe("tcp", conf.Combined.Address)
+			sockDesc = "plain"
 		}
+		if err != nil {
+			logger.Print("Cannot start combined server: ", err)
+			os.Exit(1)
+		}
+		closers = append(closers, closer)
+		logger.Println("Listening for", sockDesc,
+			"websocket and rawsocket connections on", conf.Combined.Address)
 	}
 	if len(closers) == 0 {
 		logger.Print("No servers configured")
 		os.Exit(1)
 	}
 
-	// Shutdown server if SIGINT (CTRL-c) received.
+	// Shutdown server if SIGINT (CTRL-c) or SIGTERM received.
 	shutdown := make(chan os.Signal, 1)
-	signal.Notify(shutdown, os.Interrupt)
+	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
 	<-shutdown
 
-	// If process does not exit in a few seconds, exit with error.
+	gracePeriod := conf.ShutdownGracePeriod
+	if gracePeriod == 0 {
+		gracePeriod = defaultShutdownGracePeriod
+	}
+
+	// If process does not exit after the grace period and time to close, exit
+	// with error.
 	exitChan := make(chan struct{})
 	go func() {
 		select {
-		case <-time.After(5 * time.Second):
+		case <-time.After(gracePeriod + closeTimeout):
 			logger.Print("Router took too long to stop")
 			os.Exit(1)
 		case <-exitChan:
@@ -230,10 +236,82 @@ func main() {
 	for i := range closers {
 		closers[i].Close()
 	}
-	r.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), gracePeriod)
+	if err := r.Shutdown(ctx); err != nil {
+		logger.Print("Router shutdown did not wait for all calls: ", err)
+	}
+	cancel()
 	close(exitChan)
 }
 
+// newWebsocketServer creates a websocket server configured by the websocket
+// section of the config.
+func newWebsocketServer(r router.Router, conf *Config, logger *log.Logger) *router.WebsocketServer {
+	wss := router.NewWebsocketServer(r)
+	if conf.WebSocket.EnableCompression {
+		wss.Upgrader.EnableCompression = true
+		logger.Printf("Compression enabled")
+	}
+	if conf.WebSocket.EnableTrackingCookie {
+		wss.EnableTrackingCookie = true
+		logger.Printf("Tracking cookie enabled - not currently used")
+	}
+	if conf.WebSocket.EnableRequestCapture {
+		wss.EnableRequestCapture = true
+		logger.Printf("Request capture enabled - not currently used")
+	}
+	if conf.WebSocket.KeepAlive != 0 {
+		wss.KeepAlive = conf.WebSocket.KeepAlive
+		logger.Printf("Websocket heartbeat interval: %s", wss.KeepAlive)
+	}
+	if len(conf.WebSocket.AllowOrigins) != 0 {
+		e := wss.AllowOrigins(conf.WebSocket.AllowOrigins)
+		if e != nil {
+			logger.Print(e)
+			os.Exit(1)
+		}
+		logger.Println("Allowing origins matching:",
+			strings.Join(conf.WebSocket.AllowOrigins, "|"))
+	}
+	if conf.WebSocket.OutQueueSize != 0 {
+		wss.OutQueueSize = conf.WebSocket.OutQueueSize
+		logger.Printf("Websocket outbound queue size: %d", wss.OutQueueSize)
+	}
+	if conf.WebSocket.BatchWindowMsec != 0 {
+		wss.BatchWindow = time.Duration(conf.WebSocket.BatchWindowMsec) * time.Millisecond
+		logger.Printf("Websocket batch window: %s", wss.BatchWindow)
+	}
+	if conf.WebSocket.BatchMaxSize != 0 {
+		wss.BatchMaxSize = conf.WebSocket.BatchMaxSize
+		logger.Printf("Websocket batch size limit: %d", wss.BatchMaxSize)
+	}
+	if pp := conf.WebSocket.ProxyProtocol.proxyProtocol(); pp != nil {
+		wss.ProxyProtocol = pp
+		logger.Printf("Websocket PROXY protocol enabled (required=%t)", pp.Required)
+	}
+	return wss
+}
+
+// newRawSocketServer creates a rawsocket server configured by the rawsocket
+// section of the config.
+func newRawSocketServer(r router.Router, conf *Config, logger *log.Logger) *router.RawSocketServer {
+	rss := router.NewRawSocketServer(r)
+	rss.RecvLimit = conf.RawSocket.MaxMsgLen
+	if conf.RawSocket.OutQueueSize != 0 {
+		rss.OutQueueSize = conf.RawSocket.OutQueueSize
+		logger.Printf("raw socket outbound queue size: %d", rss.OutQueueSize)
+	}
+	if conf.RawSocket.TCPKeepAliveInterval != 0 {
+		rss.KeepAlive = conf.RawSocket.TCPKeepAliveInterval
+		logger.Printf("tcp keep-alive interval: %s", rss.KeepAlive)
+	}
+	if pp := conf.RawSocket.ProxyProtocol.proxyProtocol(); pp != nil {
+		rss.ProxyProtocol = pp
+		logger.Printf("raw socket PROXY protocol enabled (required=%t)", pp.Required)
+	}
+	return rss
+}
+
 func printFlags(flagNames ...string) {
 	for i := range flagNames {
 		f := flag.Lookup(flagNames[i])
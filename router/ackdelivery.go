@@ -0,0 +1,235 @@
+package router
+
+import (
+	"sort"
+	"time"
+
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+const (
+	defaultEventAckTimeout      = 5 * time.Second
+	defaultEventMaxRedeliveries = 3
+
+	// Minimum interval at which unacknowledged events are checked for
+	// redelivery.
+	minRedeliverInterval = 10 * time.Millisecond
+)
+
+// ackKey identifies a subscriber's at-least-once subscription by the session
+// ID of the subscriber and the subscription ID.
+type ackKey struct {
+	sessID wamp.ID
+	subID  wamp.ID
+}
+
+// ackState holds the events sent for a subscriber's at-least-once subscription
+// that have not been acknowledged.
+type ackState struct {
+	subscriber *wamp.Session
+	// Sequence number of the last event sent.
+	seq     uint64
+	pending map[uint64]*pendingEvent
+}
+
+// pendingEvent is an event waiting to be acknowledged.
+type pendingEvent struct {
+	event        *wamp.Event
+	redeliveries int
+	deadline     time.Time
+}
+
+// syncAckSubscribe enables at-least-once delivery for the subscriber's
+// subscription, and starts the redelivery of unacknowledged events if not
+// already started.
+func (b *broker) syncAckSubscribe(subscriber *wamp.Session, sub *subscription) {
+	key := ackKey{subscriber.ID, sub.id}
+	if _, ok := b.ackSubs[key]; ok {
+		return
+	}
+	b.ackSubs[key] = &ackState{
+		subscriber: subscriber,
+		pending:    map[uint64]*pendingEvent{},
+	}
+
+	if b.redeliverStop == nil && !b.redeliverClosed {
+		b.redeliverStop = make(chan struct{})
+		b.redeliverDone = make(chan struct{})
+		go b.runRedeliver(b.redeliverStop, b.redeliverDone)
+	}
+}
+
+// syncSendAckEvent assigns the event the next sequence number of the
+// subscription, keeps it until acknowledged, and sends it to the subscriber.
+func (b *broker) syncSendAckEvent(subscriber *wamp.Session, st *ackState, event *wamp.Event) {
+	st.seq++
+	event.Details[wamp.DetailDeliverySeq] = st.seq
+	st.pending[st.seq] = &pendingEvent{
+		event:    event,
+		deadline: time.Now().Add(b.eventAckTimeout),
+	}
+	// Local clients get a copy, so that they cannot modify the kept event.
+	if subscriber.Peer.IsLocal() {
+		event = copyEvent(event, false)
+	}
+	b.trySend(subscriber, event)
+}
+
+// syncRedeliverEvent sends an unacknowledged event again, or discards it if
+// it has already been redelivered the maximum number of times.
+func (b *broker) syncRedeliverEvent(subscriber *wamp.Session, st *ackState, seq uint64, pe *pendingEvent) {
+	if pe.redeliveries >= b.eventMaxRedeliveries {
+		delete(st.pending, seq)
+		b.log.Printf("Discarded unacknowledged event %d for session %s after %d redeliveries",
+			seq, subscriber, pe.redeliveries)
+		return
+	}
+	pe.redeliveries++
+	pe.deadline = time.Now().Add(b.eventAckTimeout)
+	b.trySend(subscriber, copyEvent(pe.event, true))
+}
+
+// syncRedeliver redelivers, in order, each event that has not been
+// acknowledged within the acknowledgement timeout.
+func (b *broker) syncRedeliver() {
+	now := time.Now()
+	var due []uint64
+	for _, st := range b.ackSubs {
+		if len(st.pending) == 0 {
+			continue
+		}
+		due = due[:0]
+		for seq, pe := range st.pending {
+			if !now.Before(pe.deadline) {
+				due = append(due, seq)
+			}
+		}
+		sort.Slice(due, func(i, j int) bool { return due[i] < due[j] })
+		for _, seq := range due {
+			b.syncRedeliverEvent(st.subscriber, st, seq, st.pending[seq])
+		}
+	}
+}
+
+// runRedeliver periodically checks for events to redeliver, until stopped.
+func (b *broker) runRedeliver(stop, done chan struct{}) {
+	defer close(done)
+	interval := b.eventAckTimeout / 4
+	if interval < minRedeliverInterval {
+		interval = minRedeliverInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+		select {
+		case b.actionChan <- b.syncRedeliver:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// syncStopRedeliver stops the redelivery of events, and prevents it from
+// starting.  Returns a channel that is closed when redelivery has stopped, or
+// nil if redelivery was not started.
+func (b *broker) syncStopRedeliver() <-chan struct{} {
+	b.redeliverClosed = true
+	if b.redeliverStop == nil {
+		return nil
+	}
+	close(b.redeliverStop)
+	b.redeliverStop = nil
+	return b.redeliverDone
+}
+
+// copyEvent returns a copy of the event with its own details and arguments.
+// If redelivered is true, then the copy is marked as redelivered.
+func copyEvent(event *wamp.Event, redelivered bool) *wamp.Event {
+	ev := &wamp.Event{
+		Publication:  event.Publication,
+		Subscription: event.Subscription,
+		Details:      make(wamp.Dict, len(event.Details)+1),
+	}
+	for k, v := range event.Details {
+		ev.Details[k] = v
+	}
+	if redelivered {
+		ev.Details[wamp.DetailRedelivered] = true
+	}
+	if len(event.Arguments) != 0 {
+		ev.Arguments = make(wamp.List, len(event.Arguments))
+		copy(ev.Arguments, event.Arguments)
+	}
+	if len(event.ArgumentsKw) != 0 {
+		ev.ArgumentsKw = make(wamp.Dict, len(event.ArgumentsKw))
+		for k, v := range event.ArgumentsKw {
+			ev.ArgumentsKw[k] = v
+		}
+	}
+	return ev
+}
+
+// subAck acknowledges an event delivered with at-least-once delivery.
+//
+// The arguments are the subscription ID and the delivery sequence number of
+// the event.
+func (b *broker) subAck(msg *wamp.Invocation) wamp.Message {
+	return b.ackEvent(msg, false)
+}
+
+// subNack negatively acknowledges an event delivered with at-least-once
+// delivery, causing the event to be redelivered immediately unless it has
+// already been redelivered the maximum number of times.
+//
+// The arguments are the subscription ID and the delivery sequence number of
+// the event.
+func (b *broker) subNack(msg *wamp.Invocation) wamp.Message {
+	return b.ackEvent(msg, true)
+}
+
+func (b *broker) ackEvent(msg *wamp.Invocation, nack bool) wamp.Message {
+	caller, ok := wamp.AsID(msg.Details["caller"])
+	if !ok || len(msg.Arguments) < 2 {
+		return makeError(msg.Request, wamp.ErrInvalidArgument)
+	}
+	subID, ok := wamp.AsID(msg.Arguments[0])
+	if !ok {
+		return makeError(msg.Request, wamp.ErrInvalidArgument)
+	}
+	seq, ok := wamp.AsInt64(msg.Arguments[1])
+	if !ok || seq <= 0 {
+		return makeError(msg.Request, wamp.ErrInvalidArgument)
+	}
+
+	var found bool
+	sync := make(chan struct{})
+	b.actionChan <- func() {
+		defer close(sync)
+		st, ok := b.ackSubs[ackKey{caller, subID}]
+		if !ok {
+			return
+		}
+		found = true
+		// An event that is not pending was already acknowledged or
+		// discarded.
+		pe, ok := st.pending[uint64(seq)]
+		if !ok {
+			return
+		}
+		if nack {
+			b.syncRedeliverEvent(st.subscriber, st, uint64(seq), pe)
+		} else {
+			delete(st.pending, uint64(seq))
+		}
+	}
+	<-sync
+	if !found {
+		return makeError(msg.Request, wamp.ErrNoSuchSubscription)
+	}
+	return &wamp.Yield{Request: msg.Request}
+}
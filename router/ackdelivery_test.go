@@ -0,0 +1,98 @@
+package router
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+const ackTopic = wamp.URI("ack.topic")
+
+// recvAckEvent receives an event and checks its delivery sequence number and
+// whether it was redelivered.
+func recvAckEvent(t *testing.T, sub *wamp.Session, seq int64, redelivered bool) {
+	msg, err := wamp.RecvTimeout(sub, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	event, ok := msg.(*wamp.Event)
+	if !ok {
+		t.Fatal("Expected EVENT, got:", msg.MessageType())
+	}
+	if s, _ := wamp.AsInt64(event.Details[wamp.DetailDeliverySeq]); s != seq {
+		t.Fatal("Expected delivery seq", seq, "got", s)
+	}
+	if r, _ := event.Details[wamp.DetailRedelivered].(bool); r != redelivered {
+		t.Fatal("Expected redelivered", redelivered, "got", r)
+	}
+}
+
+func TestAtLeastOnceDelivery(t *testing.T) {
+	r := newTestRouterWithRealm(t, &RealmConfig{
+		EventAckTimeoutMsec:  50,
+		EventMaxRedeliveries: 2,
+	})
+	defer r.Close()
+
+	sub, err := testClient(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub.Send(&wamp.Subscribe{
+		Request: wamp.GlobalID(),
+		Topic:   ackTopic,
+		Options: wamp.Dict{wamp.OptDelivery: wamp.DeliveryAtLeastOnce},
+	})
+	msg, err := wamp.RecvTimeout(sub, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	subscribed, ok := msg.(*wamp.Subscribed)
+	if !ok {
+		t.Fatal("Expected SUBSCRIBED, got:", msg.MessageType())
+	}
+
+	pub, err := testClient(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publish := func() {
+		pub.Send(&wamp.Publish{Request: wamp.GlobalID(), Topic: ackTopic})
+	}
+
+	// Unacknowledged event is redelivered up to the limit.
+	publish()
+	recvAckEvent(t, sub, 1, false)
+	recvAckEvent(t, sub, 1, true)
+	recvAckEvent(t, sub, 1, true)
+	if msg, err = wamp.RecvTimeout(sub, 200*time.Millisecond); err == nil {
+		t.Fatal("Expected no more redeliveries, got:", msg.MessageType())
+	}
+
+	// Acknowledged event is not redelivered.
+	publish()
+	recvAckEvent(t, sub, 2, false)
+	sub.Send(&wamp.Call{
+		Request:   wamp.GlobalID(),
+		Procedure: wamp.MetaProcSubAck,
+		Arguments: wamp.List{subscribed.Subscription, 2},
+	})
+	if msg, err = wamp.RecvTimeout(sub, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok = msg.(*wamp.Result); !ok {
+		t.Fatal("Expected RESULT, got:", msg.MessageType())
+	}
+	if msg, err = wamp.RecvTimeout(sub, 200*time.Millisecond); err == nil {
+		t.Fatal("Expected no redelivery, got:", msg.MessageType())
+	}
+
+	// Invalid delivery option is rejected.
+	sub.Send(&wamp.Subscribe{
+		Request: wamp.GlobalID(),
+		Topic:   ackTopic,
+		Options: wamp.Dict{wamp.OptDelivery: "bogus"},
+	})
+	expectErrorURI(t, sub, wamp.ErrInvalidArgument)
+}
@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"errors"
+	"os/user"
+	"strconv"
+
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// PeerCredIdentity is the identity given to clients whose peer credentials
+// match a PeerCredAuth mapping.
+type PeerCredIdentity struct {
+	// AuthID of the client.  If empty, then the name of the user that runs
+	// the client process is used, or the user ID if the user has no name.
+	AuthID string `json:"authid"`
+	// AuthRole of the client.
+	AuthRole string `json:"authrole"`
+}
+
+// PeerCredAuth implements Authenticator interface.  It authenticates clients
+// connected to a Unix socket by the user and group IDs of the client process,
+// which the router reads from the socket.  This lets local system services
+// authenticate without any secrets.
+//
+// The client's user ID is looked up in Users, and if not found, the client's
+// primary group ID is looked up in Groups.  A client that matches neither, or
+// that is not connected over a Unix socket, fails authentication.
+//
+// To use peer credential authentication, supply an instance of PeerCredAuth
+// to the RealmConfig, and have clients request the "peercred" authmethod:
+//
+//     RealmConfigs: []*router.RealmConfig{
+//         {
+//             PeerCredAuth: &auth.PeerCredAuth{
+//                 Users: map[uint32]auth.PeerCredIdentity{
+//                     0: {AuthID: "root", AuthRole: "admin"},
+//                 },
+//                 Groups: map[uint32]auth.PeerCredIdentity{
+//                     1001: {AuthRole: "service"},
+//                 },
+//             },
+//             ...
+//         },
+type PeerCredAuth struct {
+	// Users maps a user ID to the identity of clients run by that user.
+	Users map[uint32]PeerCredIdentity `json:"users"`
+	// Groups maps a group ID to the identity of clients run with that
+	// primary group.
+	Groups map[uint32]PeerCredIdentity `json:"groups"`
+}
+
+// AuthMethod returns description of authentication method.
+func (a *PeerCredAuth) AuthMethod() string {
+	return "peercred"
+}
+
+// Authenticate a client using the peer credentials in the transport details.
+// This does not send a challenge, and provides the authid and authrole for
+// the WELCOME message.
+func (a *PeerCredAuth) Authenticate(sid wamp.ID, details wamp.Dict, client wamp.Peer) (*wamp.Welcome, error) {
+	cred, err := wamp.DictValue(details, []string{"transport", "peer_cred"})
+	if err != nil {
+		return nil, errors.New("no peer credentials")
+	}
+	credDict, _ := wamp.AsDict(cred)
+	uid, ok := wamp.AsInt64(credDict["uid"])
+	if !ok {
+		return nil, errors.New("no peer uid")
+	}
+	gid, ok := wamp.AsInt64(credDict["gid"])
+	if !ok {
+		return nil, errors.New("no peer gid")
+	}
+
+	ident, ok := a.Users[uint32(uid)]
+	if !ok {
+		if ident, ok = a.Groups[uint32(gid)]; !ok {
+			return nil, errors.New("peer credentials not authorized")
+		}
+	}
+
+	authid := ident.AuthID
+	if authid == "" {
+		authid = strconv.FormatInt(uid, 10)
+		if u, err := user.LookupId(authid); err == nil {
+			authid = u.Username
+		}
+	}
+
+	// Create welcome details containing auth info.
+	return &wamp.Welcome{
+		Details: wamp.Dict{
+			"authid":       authid,
+			"authrole":     ident.AuthRole,
+			"authprovider": "static",
+			"authmethod":   a.AuthMethod(),
+		},
+	}, nil
+}
@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+func TestPeerCredAuth(t *testing.T) {
+	pcAuth := PeerCredAuth{
+		Users: map[uint32]PeerCredIdentity{
+			1000: {AuthID: "alice", AuthRole: "admin"},
+		},
+		Groups: map[uint32]PeerCredIdentity{
+			2000: {AuthID: "services", AuthRole: "service"},
+		},
+	}
+
+	credDetails := func(uid, gid int64) wamp.Dict {
+		return wamp.Dict{
+			"authmethods": []string{"peercred"},
+			"transport": wamp.Dict{
+				"peer_cred": wamp.Dict{"uid": uid, "gid": gid, "pid": int64(1)},
+			},
+		}
+	}
+
+	// User match takes precedence over group match.
+	welcome, err := pcAuth.Authenticate(wamp.ID(101), credDetails(1000, 2000), nil)
+	if err != nil {
+		t.Fatal("authenticate failed: ", err.Error())
+	}
+	if s, _ := wamp.AsString(welcome.Details["authid"]); s != "alice" {
+		t.Fatal("incorrect authid in welcome details:", s)
+	}
+	if s, _ := wamp.AsString(welcome.Details["authrole"]); s != "admin" {
+		t.Fatal("incorrect authrole in welcome details:", s)
+	}
+	if s, _ := wamp.AsString(welcome.Details["authmethod"]); s != "peercred" {
+		t.Fatal("invalid authmethod in welcome details")
+	}
+
+	welcome, err = pcAuth.Authenticate(wamp.ID(102), credDetails(1001, 2000), nil)
+	if err != nil {
+		t.Fatal("authenticate failed: ", err.Error())
+	}
+	if s, _ := wamp.AsString(welcome.Details["authrole"]); s != "service" {
+		t.Fatal("incorrect authrole in welcome details:", s)
+	}
+
+	// No matching user or group.
+	if _, err = pcAuth.Authenticate(wamp.ID(103), credDetails(1001, 2001), nil); err == nil {
+		t.Fatal("expected error for unknown user and group")
+	}
+
+	// No peer credentials.
+	details := wamp.Dict{"authmethods": []string{"peercred"}}
+	if _, err = pcAuth.Authenticate(wamp.ID(104), details, nil); err == nil {
+		t.Fatal("expected error without peer credentials")
+	}
+}
@@ -10,12 +10,8 @@ type Authorizer interface {
 	// returned if there is a failure to determine authorization.  This error
 	// is included in the ERROR response to the client.
 	//
-	// Since the Authorizer accesses both the sending session and the message
-	// through a pointer, the authorizer can alter the content of both the
-	// sending session and the message.  This allows the authorizer to also
-	// work as an interceptor of messages that can change their content and/or
-	// change the sending session based on the intercepted message.  This
-	// functionality may be used to set values in the session upon encountering
-	// certain messages sent by that session.
+	// The Authorizer is run as the first of a realm's inbound interceptors.
+	// To alter messages or session details based on the messages sent by a
+	// session, use an Interceptor instead.
 	Authorize(*wamp.Session, wamp.Message) (bool, error)
 }
@@ -0,0 +1,526 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gammazero/nexus/v3/transport"
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+const (
+	// bridgeAuthID is the authid of the session that a bridge uses in each
+	// bridged realm.
+	bridgeAuthID = "nexus.bridge"
+
+	// optBridgeCaller is the CALL option that a bridge session uses to give
+	// the identity of the caller whose call the bridge is forwarding.  It is
+	// ignored in calls from any other session.
+	optBridgeCaller = "bridge_caller"
+
+	// Size of the router-to-bridge queue of a bridge session.
+	bridgeQueueSize = 1024
+
+	// Time to wait for the router to reply when setting up a bridge.
+	bridgeSetupTimeout = 5 * time.Second
+)
+
+// Bridge makes topics and procedures of one realm available in another realm
+// of the same router.  Events published to a bridged topic in the From realm
+// are republished in the To realm, and calls to a bridged procedure in the To
+// realm are forwarded to the procedure's registration in the From realm.
+//
+// Events and calls that a bridge forwards are never forwarded by another
+// bridge, which prevents forwarding loops when bridges connect realms in both
+// directions.
+type Bridge struct {
+	// From is the URI of the realm whose topics and procedures are bridged.
+	From wamp.URI `json:"from"`
+	// To is the URI of the realm where bridged topics are republished and
+	// bridged procedures can be called.
+	To wamp.URI `json:"to"`
+	// Topics are the topics whose events are republished.
+	Topics []*BridgeURI `json:"topics"`
+	// Procedures are the procedures whose calls are forwarded.
+	Procedures []*BridgeURI `json:"procedures"`
+}
+
+// BridgeURI selects bridged topics or procedures, and maps their URIs in the
+// From realm to URIs in the To realm.
+type BridgeURI struct {
+	// URI is the topic or procedure URI in the From realm.
+	URI wamp.URI `json:"uri"`
+	// Match is the matching policy for URI: "exact" or "prefix".  Default is
+	// "exact".
+	Match string `json:"match"`
+	// MapTo is the URI in the To realm.  When Match is "prefix", MapTo
+	// replaces the matched prefix.  Default is the same URI as in the From
+	// realm.
+	MapTo wamp.URI `json:"map_to"`
+}
+
+// checkBridge returns an error if the bridge configuration is not valid.
+func checkBridge(bridge *Bridge) error {
+	if bridge.From == "" || bridge.To == "" {
+		return errors.New("bridge requires from and to realms")
+	}
+	if bridge.From == bridge.To {
+		return fmt.Errorf("cannot bridge realm %v to itself", bridge.From)
+	}
+	for _, bu := range append(bridge.Topics, bridge.Procedures...) {
+		switch bu.Match {
+		case "", wamp.MatchExact, wamp.MatchPrefix:
+		default:
+			return fmt.Errorf("invalid match policy %q for bridged URI %v",
+				bu.Match, bu.URI)
+		}
+		if !bu.URI.ValidURI(false, bu.Match) {
+			return fmt.Errorf("invalid bridged URI %v", bu.URI)
+		}
+		if bu.MapTo != "" && !bu.MapTo.ValidURI(false, bu.Match) {
+			return fmt.Errorf("invalid bridged URI mapping %v", bu.MapTo)
+		}
+	}
+	return nil
+}
+
+// match returns the match option for subscribing or registering the URI.
+func (bu *BridgeURI) match() string {
+	if bu.Match == "" {
+		return wamp.MatchExact
+	}
+	return bu.Match
+}
+
+// to returns the URI in the To realm that the URI in the From realm maps to.
+func (bu *BridgeURI) to(uri wamp.URI) wamp.URI {
+	if bu.MapTo == "" {
+		return uri
+	}
+	if bu.Match != wamp.MatchPrefix {
+		return bu.MapTo
+	}
+	return bu.MapTo + wamp.URI(strings.TrimPrefix(string(uri), string(bu.URI)))
+}
+
+// from returns the URI in the From realm that the URI in the To realm maps
+// from.
+func (bu *BridgeURI) from(uri wamp.URI) wamp.URI {
+	if bu.MapTo == "" {
+		return uri
+	}
+	if bu.Match != wamp.MatchPrefix {
+		return bu.URI
+	}
+	return bu.URI + wamp.URI(strings.TrimPrefix(string(uri), string(bu.MapTo)))
+}
+
+// bridgeSession is the session that bridges use in a realm.  All bridges
+// to or from the realm share the same session.
+type bridgeSession struct {
+	realm *realm
+	sess  *wamp.Session
+	// Client side of the session.
+	peer  wamp.Peer
+	idGen wamp.SyncIDGen
+
+	// Canceled when the session ends.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu     sync.Mutex
+	closed bool
+	// Request ID -> channel waiting for reply.
+	pending map[wamp.ID]chan wamp.Message
+	// Subscription ID -> event handler.
+	events map[wamp.ID]func(*wamp.Event)
+	// Registration ID -> invocation handler.
+	invocations map[wamp.ID]func(*wamp.Invocation)
+	// Invocation request ID -> interrupt handler.
+	interrupts map[wamp.ID]func(*wamp.Interrupt)
+
+	wg *sync.WaitGroup
+}
+
+// startBridges creates the sessions used by the bridges and sets up the
+// bridged topics and procedures.
+func (r *router) startBridges(bridges []*Bridge) error {
+	if len(bridges) == 0 {
+		return nil
+	}
+	for _, bridge := range bridges {
+		if err := checkBridge(bridge); err != nil {
+			return err
+		}
+	}
+
+	realms := map[wamp.URI]*realm{}
+	sync := make(chan struct{})
+	r.actionChan <- func() {
+		for uri, realm := range r.realms {
+			realms[uri] = realm
+		}
+		close(sync)
+	}
+	<-sync
+
+	sessions := map[wamp.URI]*bridgeSession{}
+	bridgeSessionFor := func(uri wamp.URI) (*bridgeSession, error) {
+		if bs, ok := sessions[uri]; ok {
+			return bs, nil
+		}
+		realm, ok := realms[uri]
+		if !ok {
+			return nil, fmt.Errorf("cannot bridge realm %v: no such realm", uri)
+		}
+		bs, err := r.newBridgeSession(realm)
+		if err != nil {
+			return nil, fmt.Errorf("cannot bridge realm %v: %s", uri, err)
+		}
+		sessions[uri] = bs
+		return bs, nil
+	}
+
+	for _, bridge := range bridges {
+		from, err := bridgeSessionFor(bridge.From)
+		if err != nil {
+			return err
+		}
+		to, err := bridgeSessionFor(bridge.To)
+		if err != nil {
+			return err
+		}
+		for _, bu := range bridge.Topics {
+			if err = from.bridgeTopic(bu, to); err != nil {
+				return fmt.Errorf("cannot bridge topic %v from realm %v: %s",
+					bu.URI, bridge.From, err)
+			}
+		}
+		for _, bu := range bridge.Procedures {
+			if err = to.bridgeProcedure(bu, from); err != nil {
+				return fmt.Errorf("cannot bridge procedure %v to realm %v: %s",
+					bu.URI, bridge.To, err)
+			}
+		}
+		r.log.Printf("Bridged realm %v to realm %v", bridge.From, bridge.To)
+	}
+	return nil
+}
+
+// newBridgeSession joins a bridge session to the realm.
+func (r *router) newBridgeSession(realm *realm) (*bridgeSession, error) {
+	cli, rtr := transport.LinkedPeersQSize(bridgeQueueSize)
+	features := func(names ...string) wamp.Dict {
+		f := wamp.Dict{}
+		for _, name := range names {
+			f[name] = true
+		}
+		return wamp.Dict{"features": f}
+	}
+	roles := wamp.Dict{
+		wamp.RolePublisher:  wamp.Dict{},
+		wamp.RoleSubscriber: features(wamp.FeaturePatternSub),
+		wamp.RoleCaller:     features(wamp.FeatureCallCanceling, wamp.FeatureCallTimeout, wamp.FeatureCallerIdent),
+		wamp.RoleCallee:     features(wamp.FeatureCallCanceling, wamp.FeatureCallTimeout, wamp.FeatureCallerIdent, wamp.FeaturePatternBasedReg),
+	}
+	sid := wamp.GlobalID()
+	details := wamp.Dict{
+		"session":    sid,
+		"authid":     bridgeAuthID,
+		"authrole":   "trusted",
+		"authmethod": "",
+	}
+	sess := wamp.NewSession(rtr, sid, details, wamp.Dict{"roles": roles})
+
+	bs := &bridgeSession{
+		realm:       realm,
+		sess:        sess,
+		peer:        cli,
+		pending:     map[wamp.ID]chan wamp.Message{},
+		events:      map[wamp.ID]func(*wamp.Event){},
+		invocations: map[wamp.ID]func(*wamp.Invocation){},
+		interrupts:  map[wamp.ID]func(*wamp.Interrupt){},
+		wg:          &r.waitBridges,
+	}
+	bs.ctx, bs.cancel = context.WithCancel(context.Background())
+
+	if err := realm.handleSession(sess); err != nil {
+		bs.cancel()
+		return nil, err
+	}
+	realm.dealer.setBridge(sess)
+
+	bs.wg.Add(1)
+	go bs.run()
+	return bs, nil
+}
+
+// run dispatches the messages that the router sends to the bridge session,
+// until the session ends.
+func (bs *bridgeSession) run() {
+	defer bs.wg.Done()
+	for msg := range bs.peer.Recv() {
+		switch msg := msg.(type) {
+		case *wamp.Event:
+			bs.mu.Lock()
+			handler := bs.events[msg.Subscription]
+			bs.mu.Unlock()
+			if handler != nil {
+				handler(msg)
+			}
+		case *wamp.Invocation:
+			bs.mu.Lock()
+			handler := bs.invocations[msg.Registration]
+			bs.mu.Unlock()
+			if handler == nil {
+				bs.send(&wamp.Error{
+					Type:    wamp.INVOCATION,
+					Request: msg.Request,
+					Details: wamp.Dict{},
+					Error:   wamp.ErrNoSuchRegistration,
+				})
+				continue
+			}
+			bs.wg.Add(1)
+			go func() {
+				defer bs.wg.Done()
+				handler(msg)
+			}()
+		case *wamp.Interrupt:
+			bs.mu.Lock()
+			handler := bs.interrupts[msg.Request]
+			bs.mu.Unlock()
+			if handler != nil {
+				handler(msg)
+			}
+		case *wamp.Subscribed:
+			bs.reply(msg.Request, msg)
+		case *wamp.Registered:
+			bs.reply(msg.Request, msg)
+		case *wamp.Result:
+			bs.reply(msg.Request, msg)
+		case *wamp.Error:
+			bs.reply(msg.Request, msg)
+		}
+		// GOODBYE is not answered, since the router closes the session after
+		// sending GOODBYE.
+	}
+	bs.mu.Lock()
+	bs.closed = true
+	bs.mu.Unlock()
+	bs.cancel()
+}
+
+// send sends a message to the router, unless the session has ended.
+func (bs *bridgeSession) send(msg wamp.Message) error {
+	return bs.peer.SendCtx(bs.ctx, msg)
+}
+
+// reply gives a reply to the request waiting for it.
+func (bs *bridgeSession) reply(reqID wamp.ID, msg wamp.Message) {
+	bs.mu.Lock()
+	ch, ok := bs.pending[reqID]
+	delete(bs.pending, reqID)
+	bs.mu.Unlock()
+	if ok {
+		ch <- msg
+	}
+}
+
+// request sends a message to the router and waits for the reply.
+func (bs *bridgeSession) request(ctx context.Context, reqID wamp.ID, msg wamp.Message) (wamp.Message, error) {
+	ch := make(chan wamp.Message, 1)
+	bs.mu.Lock()
+	if bs.closed {
+		bs.mu.Unlock()
+		return nil, errors.New("bridge session closed")
+	}
+	bs.pending[reqID] = ch
+	bs.mu.Unlock()
+
+	err := bs.send(msg)
+	if err == nil {
+		select {
+		case reply := <-ch:
+			return reply, nil
+		case <-bs.ctx.Done():
+			err = errors.New("bridge session closed")
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+	bs.mu.Lock()
+	delete(bs.pending, reqID)
+	bs.mu.Unlock()
+	return nil, err
+}
+
+// setup sends a SUBSCRIBE or REGISTER message and returns the reply.
+func (bs *bridgeSession) setup(reqID wamp.ID, msg wamp.Message) (wamp.Message, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), bridgeSetupTimeout)
+	defer cancel()
+	reply, err := bs.request(ctx, reqID, msg)
+	if err != nil {
+		return nil, err
+	}
+	if e, ok := reply.(*wamp.Error); ok {
+		if len(e.Arguments) != 0 {
+			return nil, fmt.Errorf("%s: %v", e.Error, e.Arguments[0])
+		}
+		return nil, errors.New(string(e.Error))
+	}
+	return reply, nil
+}
+
+// bridgeTopic subscribes to the topic in this session's realm, and
+// republishes the topic's events in the realm of the other session.
+func (bs *bridgeSession) bridgeTopic(bu *BridgeURI, to *bridgeSession) error {
+	reqID := bs.idGen.Next()
+	reply, err := bs.setup(reqID, &wamp.Subscribe{
+		Request: reqID,
+		Topic:   bu.URI,
+		Options: wamp.Dict{wamp.OptMatch: bu.match()},
+	})
+	if err != nil {
+		return err
+	}
+	subscribed, ok := reply.(*wamp.Subscribed)
+	if !ok {
+		return fmt.Errorf("unexpected %s reply to SUBSCRIBE", reply.MessageType())
+	}
+
+	bs.mu.Lock()
+	bs.events[subscribed.Subscription] = func(event *wamp.Event) {
+		topic := bu.URI
+		if t, ok := wamp.AsURI(event.Details[detailTopic]); ok {
+			topic = t
+		}
+		// The bridge session does not receive its own publications, so
+		// republished events are not bridged again.
+		to.send(&wamp.Publish{
+			Request:     to.idGen.Next(),
+			Options:     wamp.Dict{},
+			Topic:       bu.to(topic),
+			Arguments:   event.Arguments,
+			ArgumentsKw: event.ArgumentsKw,
+		})
+	}
+	bs.mu.Unlock()
+	return nil
+}
+
+// bridgeProcedure registers the procedure in this session's realm, and
+// forwards calls to the procedure to the realm of the other session.
+func (bs *bridgeSession) bridgeProcedure(bu *BridgeURI, from *bridgeSession) error {
+	procedure := bu.to(bu.URI)
+	reqID := bs.idGen.Next()
+	reply, err := bs.setup(reqID, &wamp.Register{
+		Request:   reqID,
+		Procedure: procedure,
+		Options:   wamp.Dict{wamp.OptMatch: bu.match()},
+	})
+	if err != nil {
+		return err
+	}
+	registered, ok := reply.(*wamp.Registered)
+	if !ok {
+		return fmt.Errorf("unexpected %s reply to REGISTER", reply.MessageType())
+	}
+
+	bs.mu.Lock()
+	bs.invocations[registered.Registration] = func(inv *wamp.Invocation) {
+		called := procedure
+		if p, ok := wamp.AsURI(inv.Details[wamp.OptProcedure]); ok {
+			called = p
+		}
+		bs.forwardCall(inv, from, bu.from(called))
+	}
+	bs.mu.Unlock()
+	return nil
+}
+
+// forwardCall calls the procedure in the realm of the other session, and
+// returns the result or error of that call as the result or error of the
+// invocation.
+func (bs *bridgeSession) forwardCall(inv *wamp.Invocation, from *bridgeSession, procedure wamp.URI) {
+	options := wamp.Dict{}
+	if timeout, _ := wamp.AsInt64(inv.Details[wamp.OptTimeout]); timeout > 0 {
+		options[wamp.OptTimeout] = timeout
+	}
+	// If the caller disclosed its identity, then disclose the same identity
+	// to the callee in the other realm.
+	if caller, ok := inv.Details[wamp.RoleCaller]; ok {
+		identity := wamp.Dict{wamp.RoleCaller: caller}
+		for _, f := range []string{"authid", "authrole"} {
+			key := fmt.Sprintf("%s_%s", wamp.RoleCaller, f)
+			if val, ok := inv.Details[key]; ok {
+				identity[key] = val
+			}
+		}
+		options[wamp.OptDiscloseMe] = true
+		options[optBridgeCaller] = identity
+	}
+
+	callID := from.idGen.Next()
+	call := &wamp.Call{
+		Request:     callID,
+		Options:     options,
+		Procedure:   procedure,
+		Arguments:   inv.Arguments,
+		ArgumentsKw: inv.ArgumentsKw,
+	}
+
+	// Cancel the forwarded call if the invocation is interrupted.
+	bs.mu.Lock()
+	bs.interrupts[inv.Request] = func(interrupt *wamp.Interrupt) {
+		mode, _ := wamp.AsString(interrupt.Options[wamp.OptMode])
+		if mode == "" {
+			mode = wamp.CancelModeKill
+		}
+		from.send(&wamp.Cancel{
+			Request: callID,
+			Options: wamp.Dict{wamp.OptMode: mode},
+		})
+	}
+	bs.mu.Unlock()
+
+	reply, err := from.request(bs.ctx, callID, call)
+
+	bs.mu.Lock()
+	delete(bs.interrupts, inv.Request)
+	bs.mu.Unlock()
+
+	switch reply := reply.(type) {
+	case *wamp.Result:
+		bs.send(&wamp.Yield{
+			Request:     inv.Request,
+			Options:     wamp.Dict{},
+			Arguments:   reply.Arguments,
+			ArgumentsKw: reply.ArgumentsKw,
+		})
+	case *wamp.Error:
+		bs.send(&wamp.Error{
+			Type:        wamp.INVOCATION,
+			Request:     inv.Request,
+			Details:     wamp.Dict{},
+			Error:       reply.Error,
+			Arguments:   reply.Arguments,
+			ArgumentsKw: reply.ArgumentsKw,
+		})
+	default:
+		if err == nil {
+			err = fmt.Errorf("unexpected %s reply to CALL", reply.MessageType())
+		}
+		bs.send(&wamp.Error{
+			Type:      wamp.INVOCATION,
+			Request:   inv.Request,
+			Details:   wamp.Dict{},
+			Error:     wamp.ErrCanceled,
+			Arguments: wamp.List{"bridged call failed: " + err.Error()},
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package router
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fortytw2/leaktest"
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+const (
+	bridgeRealmA = wamp.URI("bridge.realm.a")
+	bridgeRealmB = wamp.URI("bridge.realm.b")
+)
+
+// recvMsg receives a message and fails if it is not of the expected type.
+func recvMsg(t *testing.T, sess *wamp.Session, msgType wamp.MessageType) wamp.Message {
+	msg, err := wamp.RecvTimeout(sess, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.MessageType() != msgType {
+		t.Fatalf("Expected %s, got: %s %+v", msgType, msg.MessageType(), msg)
+	}
+	return msg
+}
+
+func TestBridge(t *testing.T) {
+	defer leaktest.Check(t)()
+	config := &Config{
+		RealmConfigs: []*RealmConfig{
+			{URI: bridgeRealmA, AnonymousAuth: true, AllowDisclose: true},
+			{URI: bridgeRealmB, AnonymousAuth: true, AllowDisclose: true},
+		},
+		Bridges: []*Bridge{
+			{
+				From: bridgeRealmA,
+				To:   bridgeRealmB,
+				Topics: []*BridgeURI{
+					{URI: "sensor", Match: wamp.MatchPrefix, MapTo: "remote.sensor"},
+				},
+				Procedures: []*BridgeURI{
+					{URI: "svc.add", MapTo: "remote.add"},
+					{URI: "loop.proc"},
+				},
+			},
+			{
+				From: bridgeRealmB,
+				To:   bridgeRealmA,
+				Topics: []*BridgeURI{
+					{URI: "remote.sensor", Match: wamp.MatchPrefix, MapTo: "sensor"},
+				},
+				Procedures: []*BridgeURI{
+					{URI: "loop.proc"},
+				},
+			},
+		},
+		Debug: debug,
+	}
+	r, err := NewRouter(config, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	clientA, err := testClientInRealm(r, bridgeRealmA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientB, err := testClientInRealm(r, bridgeRealmB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Events published in realm A are republished in realm B with the mapped
+	// topic, and are not bridged back into realm A.
+	for _, sub := range []struct {
+		sess  *wamp.Session
+		topic wamp.URI
+	}{
+		{clientA, "sensor.temp"},
+		{clientB, "remote.sensor.temp"},
+	} {
+		sub.sess.Send(&wamp.Subscribe{Request: wamp.GlobalID(), Topic: sub.topic, Options: wamp.Dict{}})
+		recvMsg(t, sub.sess, wamp.SUBSCRIBED)
+	}
+	pub, err := testClientInRealm(r, bridgeRealmA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub.Send(&wamp.Publish{
+		Request:   wamp.GlobalID(),
+		Topic:     "sensor.temp",
+		Arguments: wamp.List{21},
+	})
+	event := recvMsg(t, clientB, wamp.EVENT).(*wamp.Event)
+	if len(event.Arguments) != 1 || event.Arguments[0] != 21 {
+		t.Fatal("Wrong bridged event arguments:", event.Arguments)
+	}
+	recvMsg(t, clientA, wamp.EVENT)
+	if msg, err := wamp.RecvTimeout(clientA, 200*time.Millisecond); err == nil {
+		t.Fatal("Bridged event was bridged back:", msg.MessageType())
+	}
+
+	// Calls in realm B are forwarded to the registration in realm A, with the
+	// caller's identity disclosed.
+	clientA.Send(&wamp.Register{
+		Request:   wamp.GlobalID(),
+		Procedure: "svc.add",
+		Options:   wamp.Dict{wamp.OptDiscloseCaller: true},
+	})
+	recvMsg(t, clientA, wamp.REGISTERED)
+
+	callID := wamp.GlobalID()
+	clientB.Send(&wamp.Call{
+		Request:   callID,
+		Procedure: "remote.add",
+		Options:   wamp.Dict{wamp.OptDiscloseMe: true},
+		Arguments: wamp.List{2, 3},
+	})
+	inv := recvMsg(t, clientA, wamp.INVOCATION).(*wamp.Invocation)
+	if inv.Details["caller"] != clientB.ID {
+		t.Fatal("Wrong disclosed caller:", inv.Details["caller"], "expected", clientB.ID)
+	}
+	if authid, _ := wamp.AsString(inv.Details["caller_authid"]); authid != "user1" {
+		t.Fatal("Wrong disclosed caller authid:", authid)
+	}
+	clientA.Send(&wamp.Yield{Request: inv.Request, Arguments: wamp.List{5}})
+	result := recvMsg(t, clientB, wamp.RESULT).(*wamp.Result)
+	if result.Request != callID || len(result.Arguments) != 1 || result.Arguments[0] != 5 {
+		t.Fatal("Wrong bridged call result:", result)
+	}
+
+	// Errors are returned to the caller in realm B.
+	clientB.Send(&wamp.Call{Request: wamp.GlobalID(), Procedure: "remote.add"})
+	inv = recvMsg(t, clientA, wamp.INVOCATION).(*wamp.Invocation)
+	if _, ok := inv.Details["caller"]; ok {
+		t.Fatal("Caller identity of bridge was disclosed")
+	}
+	clientA.Send(&wamp.Error{
+		Type:      wamp.INVOCATION,
+		Request:   inv.Request,
+		Details:   wamp.Dict{},
+		Error:     "app.error.bad_add",
+		Arguments: wamp.List{"too few arguments"},
+	})
+	errMsg := recvMsg(t, clientB, wamp.ERROR).(*wamp.Error)
+	if errMsg.Error != "app.error.bad_add" || len(errMsg.Arguments) != 1 || errMsg.Arguments[0] != "too few arguments" {
+		t.Fatal("Wrong bridged call error:", errMsg)
+	}
+
+	// A procedure bridged in both directions is not called in a loop.
+	clientB.Send(&wamp.Call{Request: wamp.GlobalID(), Procedure: "loop.proc"})
+	expectErrorURI(t, clientB, wamp.ErrNoSuchProcedure)
+}
+
+func TestBridgeConfig(t *testing.T) {
+	defer leaktest.Check(t)()
+	realms := []*RealmConfig{
+		{URI: bridgeRealmA, AnonymousAuth: true},
+		{URI: bridgeRealmB, AnonymousAuth: true},
+	}
+	for _, bridge := range []*Bridge{
+		{From: bridgeRealmA, To: bridgeRealmA},
+		{From: bridgeRealmA, To: "bridge.realm.none"},
+		{From: bridgeRealmA, To: bridgeRealmB, Topics: []*BridgeURI{{URI: "a..b"}}},
+		{From: bridgeRealmA, To: bridgeRealmB, Procedures: []*BridgeURI{{URI: "a.b", Match: wamp.MatchWildcard}}},
+	} {
+		config := &Config{RealmConfigs: realms, Bridges: []*Bridge{bridge}}
+		if _, err := NewRouter(config, logger); err == nil {
+			t.Fatalf("Expected error for invalid bridge %+v", bridge)
+		}
+	}
+}
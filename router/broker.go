@@ -2,8 +2,13 @@ package router
 
 import (
 	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/gammazero/nexus/v3/router/durable"
 	"github.com/gammazero/nexus/v3/stdlog"
+	"github.com/gammazero/nexus/v3/transport/serialize"
 	"github.com/gammazero/nexus/v3/wamp"
 )
 
@@ -30,6 +35,10 @@ type subscription struct {
 	match       string   // match policy
 	created     string   // when subscription was created
 	subscribers map[*wamp.Session]struct{}
+	// Subscribers whose subscription is durable.
+	durable map[*wamp.Session]*durableSub
+	// Sequence numbers of subscribers that requested them.
+	sequences map[*wamp.Session]*eventSequence
 }
 
 type broker struct {
@@ -37,6 +46,9 @@ type broker struct {
 	topicSubscription    map[wamp.URI]*subscription
 	pfxTopicSubscription map[wamp.URI]*subscription
 	wcTopicSubscription  map[wamp.URI]*subscription
+	// Index the prefix and wildcard topics for matching published topics.
+	pfxTopicTrie prefixTrie
+	wcTopicTrie  wildcardTrie
 
 	// subscription ID -> subscription
 	subscriptions map[wamp.ID]*subscription
@@ -47,7 +59,17 @@ type broker struct {
 	actionChan chan func()
 
 	// Generate subscription IDs.
-	idGen *wamp.IDGen
+	idGen shardIDGen
+
+	// Number of pattern subscriptions and pattern durable subscriptions.
+	// This is read outside the broker goroutine, to skip publishing to a
+	// shard that has no pattern subscriptions.
+	patternRoutes int32
+
+	// When routing is sharded, subscription meta events are queued, and then
+	// sent to the shards that have subscriptions to the meta topic.
+	queueMeta  bool
+	metaEvents []*subMetaEvent
 
 	strictURI     bool
 	allowDisclose bool
@@ -55,6 +77,44 @@ type broker struct {
 	log           stdlog.StdLog
 	debug         bool
 	filterFactory FilterFactory
+
+	// Interceptors called for each message sent to a session.
+	outbound interceptorChain
+	// Validates publication payloads.
+	validator *payloadValidator
+	// Rewrites topic URIs.
+	rewriter *uriRewriter
+
+	// Maximum subscriptions per session, 0 for no limit.
+	maxSubscriptions int
+
+	// Durable subscriptions by queue name, and the store for their events.
+	// Durable subscriptions are not allowed if durableStore is nil.
+	// The store may be shared by other brokers, so queue names begin with
+	// the realm URI, and the store is closed by its owner.
+	durables         map[string]*durableSub
+	durableStore     durable.Store
+	durableRealm     wamp.URI
+	durableMaxEvents int
+	durableMaxAge    time.Duration
+	durableReplays   sync.WaitGroup
+	durableClosed    bool
+	// Removal of durable subscriptions that have had no subscribed session
+	// for longer than durableMaxAge.
+	durableExpireStop chan struct{}
+	durableExpireDone chan struct{}
+
+	// At-least-once subscriptions, and the redelivery of events that are not
+	// acknowledged.
+	ackSubs              map[ackKey]*ackState
+	eventAckTimeout      time.Duration
+	eventMaxRedeliveries int
+	redeliverStop        chan struct{}
+	redeliverDone        chan struct{}
+	redeliverClosed      bool
+
+	// Closed when the broker has stopped.
+	done chan struct{}
 }
 
 // newBroker returns a new default broker implementation instance.
@@ -72,20 +132,28 @@ func newBroker(logger stdlog.StdLog, strictURI, allowDisclose, debug bool, publi
 
 		subscriptions:   map[wamp.ID]*subscription{},
 		sessionSubIDSet: map[*wamp.Session]map[wamp.ID]struct{}{},
+		durables:        map[string]*durableSub{},
+		ackSubs:         map[ackKey]*ackState{},
 
 		// The action handler should be nearly always runable, since it is the
 		// critical section that does the only routing.  So, and unbuffered
 		// channel is appropriate.
 		actionChan: make(chan func()),
 
-		idGen: new(wamp.IDGen),
-
 		strictURI:     strictURI,
 		allowDisclose: allowDisclose,
 
 		log:           logger,
 		debug:         debug,
 		filterFactory: publishFilter,
+
+		durableMaxEvents: defaultDurableMaxEvents,
+		durableMaxAge:    defaultDurableMaxAge,
+
+		eventAckTimeout:      defaultEventAckTimeout,
+		eventMaxRedeliveries: defaultEventMaxRedeliveries,
+
+		done: make(chan struct{}),
 	}
 	go b.run()
 	return b
@@ -107,6 +175,36 @@ func (b *broker) role() wamp.Dict {
 // The Subscriber can detect the delivery of that same event on multiple
 // subscriptions via EVENT.PUBLISHED.Publication, which will be identical.
 func (b *broker) publish(pub *wamp.Session, msg *wamp.Publish) {
+	p := b.checkPublish(pub, msg)
+	if p == nil {
+		return
+	}
+
+	b.actionChan <- func() {
+		b.syncPublish(p)
+	}
+
+	// Send PUBLISHED message if acknowledge is present and true.
+	if p.ack {
+		b.trySend(pub, &wamp.Published{Request: msg.Request, Publication: p.id})
+	}
+}
+
+// publication is a publication that has been checked and is ready to route.
+type publication struct {
+	pub        *wamp.Session
+	msg        *wamp.Publish
+	id         wamp.ID
+	origTopic  wamp.URI
+	excludePub bool
+	disclose   bool
+	ack        bool
+	filter     PublishFilter
+}
+
+// checkPublish validates and rewrites the publication.  Returns nil if the
+// publication is rejected, after sending any error to the publisher.
+func (b *broker) checkPublish(pub *wamp.Session, msg *wamp.Publish) *publication {
 	if pub == nil || msg == nil {
 		panic("broker.Publish with nil session or message")
 	}
@@ -118,7 +216,7 @@ func (b *broker) publish(pub *wamp.Session, msg *wamp.Publish) {
 
 	if !msg.Topic.ValidURI(b.strictURI, "") {
 		if !pubAck {
-			return
+			return nil
 		}
 		errMsg := fmt.Sprintf(
 			"publish with invalid topic URI %v (URI strict checking %v)",
@@ -131,7 +229,33 @@ func (b *broker) publish(pub *wamp.Session, msg *wamp.Publish) {
 			Arguments: wamp.List{errMsg},
 			Details:   wamp.Dict{},
 		})
-		return
+		return nil
+	}
+
+	// Rewrite the topic, if there is a rule for it, without modifying the
+	// publisher's message.
+	var origTopic wamp.URI
+	if topic, ok := b.rewriter.rewrite(msg.Topic); ok {
+		origTopic = msg.Topic
+		rewritten := *msg
+		rewritten.Topic = topic
+		msg = &rewritten
+	}
+
+	// Validate payload against any schema for the topic.
+	if err := b.validator.validateArgs(msg.Topic, msg.Arguments, msg.ArgumentsKw); err != nil {
+		b.log.Printf("Rejected %s to %s from session %s: %s",
+			msg.MessageType(), msg.Topic, pub, err)
+		if pubAck {
+			b.trySend(pub, &wamp.Error{
+				Type:      msg.MessageType(),
+				Request:   msg.Request,
+				Error:     wamp.ErrInvalidArgument,
+				Arguments: wamp.List{err.Error()},
+				Details:   wamp.Dict{},
+			})
+		}
+		return nil
 	}
 
 	excludePub := true
@@ -165,22 +289,21 @@ func (b *broker) publish(pub *wamp.Session, msg *wamp.Publish) {
 			}
 			// When the publisher requested disclosure, but it isn't
 			// allowed, don't continue to publish the message.
-			return
+			return nil
 		}
 		disclose = true
 	}
-	pubID := wamp.GlobalID()
-
-	// Get blacklists and whitelists, if any, from publish message.
-	filter := b.filterFactory(msg)
-
-	b.actionChan <- func() {
-		b.syncPublish(pub, msg, pubID, excludePub, disclose, filter)
-	}
 
-	// Send PUBLISHED message if acknowledge is present and true.
-	if pubAck {
-		b.trySend(pub, &wamp.Published{Request: msg.Request, Publication: pubID})
+	return &publication{
+		pub:        pub,
+		msg:        msg,
+		id:         wamp.GlobalID(),
+		origTopic:  origTopic,
+		excludePub: excludePub,
+		disclose:   disclose,
+		ack:        pubAck,
+		// Get blacklists and whitelists, if any, from publish message.
+		filter: b.filterFactory(msg),
 	}
 }
 
@@ -195,6 +318,29 @@ func (b *broker) publish(pub *wamp.Session, msg *wamp.Publish) {
 // Broker and the Subscriber support pattern-based subscriptions, this matching
 // can happen by prefix-matching policy or wildcard-matching policy.
 func (b *broker) subscribe(sub *wamp.Session, msg *wamp.Subscribe) {
+	req := b.checkSubscribe(sub, msg)
+	if req == nil {
+		return
+	}
+	b.actionChan <- func() {
+		b.syncSubscribeRequest(sub, req, 0)
+	}
+}
+
+// subscribeRequest is a subscription request that has been checked and is
+// ready to route.
+type subscribeRequest struct {
+	msg         *wamp.Subscribe
+	match       string
+	durableName string
+	authid      string
+	delivery    string
+	seqMode     string
+}
+
+// checkSubscribe validates and rewrites the subscription request.  Returns nil
+// if the request is rejected, after sending an error to the subscriber.
+func (b *broker) checkSubscribe(sub *wamp.Session, msg *wamp.Subscribe) *subscribeRequest {
 	if sub == nil || msg == nil {
 		panic("broker.Subscribe with nil session or message")
 	}
@@ -214,12 +360,92 @@ func (b *broker) subscribe(sub *wamp.Session, msg *wamp.Subscribe) {
 			Arguments: wamp.List{errMsg},
 			Details:   wamp.Dict{},
 		})
-		return
+		return nil
 	}
 
-	b.actionChan <- func() {
-		b.syncSubscribe(sub, msg, match)
+	// Rewrite the topic, if there is a rule for it, without modifying the
+	// subscriber's message.
+	if topic, ok := b.rewriter.rewrite(msg.Topic); ok {
+		rewritten := *msg
+		rewritten.Topic = topic
+		msg = &rewritten
+	}
+
+	// A durable subscription is identified by its name and the subscriber's
+	// authid.
+	durableName, _ := wamp.AsString(msg.Options[wamp.OptDurable])
+	var authid string
+	if durableName != "" {
+		if b.durableStore == nil {
+			b.trySend(sub, &wamp.Error{
+				Type:      msg.MessageType(),
+				Request:   msg.Request,
+				Error:     wamp.ErrOptionNotAllowed,
+				Arguments: wamp.List{"durable subscriptions not enabled"},
+				Details:   wamp.Dict{},
+			})
+			return nil
+		}
+		sub.Lock()
+		authid, _ = wamp.AsString(sub.Details["authid"])
+		sub.Unlock()
+	}
+
+	// Events for an at-least-once subscription are redelivered until
+	// acknowledged.
+	delivery, _ := wamp.AsString(msg.Options[wamp.OptDelivery])
+	if delivery != "" && delivery != wamp.DeliveryAtLeastOnce {
+		b.trySend(sub, &wamp.Error{
+			Type:      msg.MessageType(),
+			Request:   msg.Request,
+			Error:     wamp.ErrInvalidArgument,
+			Arguments: wamp.List{fmt.Sprintf("invalid delivery option %q", delivery)},
+			Details:   wamp.Dict{},
+		})
+		return nil
+	}
+
+	// Events are numbered per topic or per subscription, if requested.
+	seqMode, _ := wamp.AsString(msg.Options[wamp.OptSequence])
+	if seqMode != "" && seqMode != wamp.SequenceTopic && seqMode != wamp.SequenceSubscription {
+		b.trySend(sub, &wamp.Error{
+			Type:      msg.MessageType(),
+			Request:   msg.Request,
+			Error:     wamp.ErrInvalidArgument,
+			Arguments: wamp.List{fmt.Sprintf("invalid sequence option %q", seqMode)},
+			Details:   wamp.Dict{},
+		})
+		return nil
+	}
+
+	return &subscribeRequest{
+		msg:         msg,
+		match:       match,
+		durableName: durableName,
+		authid:      authid,
+		delivery:    delivery,
+		seqMode:     seqMode,
+	}
+}
+
+// syncSubscribeRequest subscribes the subscriber as requested.  otherSubs is
+// the number of the subscriber's subscriptions in other shards.  Returns the
+// subscription, or nil if the subscription was not allowed.
+func (b *broker) syncSubscribeRequest(sub *wamp.Session, req *subscribeRequest, otherSubs int) *subscription {
+	s := b.syncSubscribe(sub, req.msg, req.match, otherSubs)
+	if s == nil {
+		return nil
+	}
+	if req.seqMode != "" {
+		b.syncSetSequence(sub, s, req.seqMode)
+	}
+	if req.durableName != "" {
+		b.syncDurableSubscribe(sub, s, req.authid, req.durableName)
 	}
+	if req.delivery == wamp.DeliveryAtLeastOnce {
+		b.syncAckSubscribe(sub, s)
+	}
+	return s
 }
 
 // unsubscribe removes the requested subscription.
@@ -245,12 +471,37 @@ func (b *broker) removeSession(sess *wamp.Session) {
 	}
 }
 
+// stopRedeliver stops the redelivery of durable and at-least-once events, and
+// waits for it to stop.  This is done before the realm closes its sessions,
+// so that no more events are sent to them.
+func (b *broker) stopRedeliver() {
+	var expireDone, redeliverDone <-chan struct{}
+	sync := make(chan struct{})
+	b.actionChan <- func() {
+		expireDone = b.syncCloseDurable()
+		redeliverDone = b.syncStopRedeliver()
+		close(sync)
+	}
+	<-sync
+	b.durableReplays.Wait()
+	if expireDone != nil {
+		<-expireDone
+	}
+	if redeliverDone != nil {
+		<-redeliverDone
+	}
+}
+
 // Close stops the broker, letting already queued actions finish.
 func (b *broker) close() {
+	// Stop the redelivery of durable and at-least-once events, which uses the
+	// action channel.
+	b.stopRedeliver()
 	close(b.actionChan)
 }
 
 func (b *broker) run() {
+	defer close(b.done)
 	for action := range b.actionChan {
 		action()
 	}
@@ -259,25 +510,29 @@ func (b *broker) run() {
 	}
 }
 
-func (b *broker) syncPublish(pub *wamp.Session, msg *wamp.Publish, pubID wamp.ID, excludePub, disclose bool, filter PublishFilter) {
+func (b *broker) syncPublish(p *publication) {
+	pub, msg, pubID, origTopic := p.pub, p.msg, p.id, p.origTopic
+	excludePub, disclose, filter := p.excludePub, p.disclose, p.filter
+
 	// Publish to subscribers with exact match.
 	if sub, ok := b.topicSubscription[msg.Topic]; ok {
-		b.syncPubEvent(pub, msg, pubID, sub, excludePub, false, disclose, filter)
+		b.syncPubEvent(pub, msg, pubID, origTopic, sub, excludePub, false, disclose, filter)
 	}
 
 	// Publish to subscribers with prefix match.
-	for pfxTopic, sub := range b.pfxTopicSubscription {
-		if msg.Topic.PrefixMatch(pfxTopic) {
-			b.syncPubEvent(pub, msg, pubID, sub, excludePub, true, disclose, filter)
-		}
-	}
+	b.pfxTopicTrie.match(msg.Topic, func(pfxTopic wamp.URI) {
+		sub := b.pfxTopicSubscription[pfxTopic]
+		b.syncPubEvent(pub, msg, pubID, origTopic, sub, excludePub, true, disclose, filter)
+	})
 
 	// Publish to subscribers with wildcard match.
-	for wcTopic, sub := range b.wcTopicSubscription {
-		if msg.Topic.WildcardMatch(wcTopic) {
-			b.syncPubEvent(pub, msg, pubID, sub, excludePub, true, disclose, filter)
-		}
-	}
+	b.wcTopicTrie.match(msg.Topic, func(wcTopic wamp.URI) {
+		sub := b.wcTopicSubscription[wcTopic]
+		b.syncPubEvent(pub, msg, pubID, origTopic, sub, excludePub, true, disclose, filter)
+	})
+
+	// Store and send events for durable subscriptions.
+	b.syncPubDurable(pub, msg, pubID, origTopic, excludePub, disclose, filter)
 }
 
 func newSubscription(id wamp.ID, subscriber *wamp.Session, topic wamp.URI, match string) *subscription {
@@ -290,30 +545,62 @@ func newSubscription(id wamp.ID, subscriber *wamp.Session, topic wamp.URI, match
 	}
 }
 
-func (b *broker) syncSubscribe(subscriber *wamp.Session, msg *wamp.Subscribe, match string) {
+// syncSubscribe subscribes the subscriber to the topic.  Returns the
+// subscription, or nil if the subscription was not allowed.
+func (b *broker) syncSubscribe(subscriber *wamp.Session, msg *wamp.Subscribe, match string, otherSubs int) *subscription {
 	var sub *subscription
 	var existingSub bool
 
 	switch match {
 	case wamp.MatchPrefix:
-		// Subscribe to any topic that matches by the given prefix URI
 		sub, existingSub = b.pfxTopicSubscription[msg.Topic]
+	case wamp.MatchWildcard:
+		sub, existingSub = b.wcTopicSubscription[msg.Topic]
+	default:
+		sub, existingSub = b.topicSubscription[msg.Topic]
+	}
+
+	// Check subscription limit, unless already subscribed.
+	if b.maxSubscriptions > 0 && otherSubs+len(b.sessionSubIDSet[subscriber]) >= b.maxSubscriptions {
+		var already bool
+		if existingSub {
+			_, already = sub.subscribers[subscriber]
+		}
+		if !already {
+			b.log.Println("SUBSCRIBE to", msg.Topic,
+				"exceeds subscription limit for subscriber", subscriber)
+			b.trySend(subscriber, &wamp.Error{
+				Type:      msg.MessageType(),
+				Request:   msg.Request,
+				Details:   wamp.Dict{},
+				Error:     wamp.ErrSubscriptionLimitExceeded,
+				Arguments: wamp.List{fmt.Sprint("session has maximum of ", b.maxSubscriptions, " subscriptions")},
+			})
+			return nil
+		}
+	}
+
+	switch match {
+	case wamp.MatchPrefix:
+		// Subscribe to any topic that matches by the given prefix URI
 		if !existingSub {
 			// Create a new prefix subscription.
 			sub = newSubscription(b.idGen.Next(), subscriber, msg.Topic, match)
 			b.pfxTopicSubscription[msg.Topic] = sub
+			b.pfxTopicTrie.add(msg.Topic)
+			b.syncCountPatternRoutes()
 		}
 	case wamp.MatchWildcard:
 		// Subscribe to any topic that matches by the given wildcard URI.
-		sub, existingSub = b.wcTopicSubscription[msg.Topic]
 		if !existingSub {
 			// Create a new wildcard subscription.
 			sub = newSubscription(b.idGen.Next(), subscriber, msg.Topic, match)
 			b.wcTopicSubscription[msg.Topic] = sub
+			b.wcTopicTrie.add(msg.Topic)
+			b.syncCountPatternRoutes()
 		}
 	default:
 		// Subscribe to the topic that exactly matches the given URI.
-		sub, existingSub = b.topicSubscription[msg.Topic]
 		if !existingSub {
 			// Create a new subscription.
 			sub = newSubscription(b.idGen.Next(), subscriber, msg.Topic, match)
@@ -331,7 +618,7 @@ func (b *broker) syncSubscribe(subscriber *wamp.Session, msg *wamp.Subscribe, ma
 				Request:      msg.Request,
 				Subscription: sub.id,
 			})
-			return
+			return sub
 		}
 		// Add subscriber to existing subscription.
 		sub.subscribers[subscriber] = struct{}{}
@@ -355,6 +642,7 @@ func (b *broker) syncSubscribe(subscriber *wamp.Session, msg *wamp.Subscribe, ma
 
 	// Publish WAMP on_subscribe meta event.
 	b.syncPubSubMeta(wamp.MetaEventSubOnSubscribe, subscriber.ID, sub.id)
+	return sub
 }
 
 // syncDeleteSubscription removes the the ID->subscription mapping and removes
@@ -367,8 +655,12 @@ func (b *broker) syncDelSubscription(sub *subscription) {
 	switch sub.match {
 	case wamp.MatchPrefix:
 		delete(b.pfxTopicSubscription, sub.topic)
+		b.pfxTopicTrie.remove(sub.topic)
+		b.syncCountPatternRoutes()
 	case wamp.MatchWildcard:
 		delete(b.wcTopicSubscription, sub.topic)
+		b.wcTopicTrie.remove(sub.topic)
+		b.syncCountPatternRoutes()
 	default:
 		delete(b.topicSubscription, sub.topic)
 	}
@@ -389,8 +681,15 @@ func (b *broker) syncUnsubscribe(subscriber *wamp.Session, msg *wamp.Unsubscribe
 		return
 	}
 
+	// Unsubscribing ends a durable subscription and discards its events.
+	if d := sub.durable[subscriber]; d != nil {
+		b.syncDeleteDurable(d)
+	}
+	delete(b.ackSubs, ackKey{subscriber.ID, subID})
+
 	// Remove subscribed session from subscription.
 	delete(sub.subscribers, subscriber)
+	delete(sub.sequences, subscriber)
 
 	// If no more subscribers on this subscription, delete subscription and
 	// send on_delete meta event.
@@ -444,8 +743,16 @@ func (b *broker) syncRemoveSession(subscriber *wamp.Session) {
 		if !ok {
 			continue
 		}
+		// Events continue to be stored for a durable subscription, until
+		// it has had no subscribed session for longer than the maximum
+		// event age.
+		if d := sub.durable[subscriber]; d != nil {
+			b.syncDetachDurable(d)
+		}
+		delete(b.ackSubs, ackKey{subscriber.ID, subID})
 		// Remove subscribed session from subscription.
 		delete(sub.subscribers, subscriber)
+		delete(sub.sequences, subscriber)
 
 		// If no more subscribers on this subscription.
 		if len(sub.subscribers) == 0 {
@@ -459,12 +766,28 @@ func (b *broker) syncRemoveSession(subscriber *wamp.Session) {
 
 // syncPubEvent sends an event to all subscribers that are not excluded from
 // receiving the event.
-func (b *broker) syncPubEvent(pub *wamp.Session, msg *wamp.Publish, pubID wamp.ID, sub *subscription, excludePublisher, sendTopic, disclose bool, filter PublishFilter) {
+//
+// Subscribers that receive the same event share one SharedMessage, so that
+// the event is serialized once per serializer instead of once per
+// subscriber.  The event is only shared with subscribers whose peers accept
+// shared messages, as reported by serialize.AcceptsSharedMessage.  Others,
+// including local subscribers that receive their own copy of the arguments,
+// get a plain event.  The event is also not shared with subscribers that
+// receive events numbered or tracked for them, or if there are outbound
+// interceptors, since these may change the event for each subscriber.
+func (b *broker) syncPubEvent(pub *wamp.Session, msg *wamp.Publish, pubID wamp.ID, origTopic wamp.URI, sub *subscription, excludePublisher, sendTopic, disclose bool, filter PublishFilter) {
+	// Shared events, without and with the publisher disclosed.
+	var shared [2]*serialize.SharedMessage
+
 	for subscriber, _ := range sub.subscribers {
 		// Do not send event to publisher.
 		if subscriber == pub && excludePublisher {
 			continue
 		}
+		// Events for durable subscriptions are sent by syncPubDurable.
+		if _, ok := sub.durable[subscriber]; ok {
+			continue
+		}
 
 		// Check if receiver is restricted.
 		if filter != nil {
@@ -483,21 +806,28 @@ func (b *broker) syncPubEvent(pub *wamp.Session, msg *wamp.Publish, pubID wamp.I
 
 		// TODO: Handle publication trust levels
 
-		event := &wamp.Event{
-			Publication:  pubID,
-			Subscription: sub.id,
-			Arguments:    msg.Arguments,
-			ArgumentsKw:  msg.ArgumentsKw,
-			Details:      wamp.Dict{},
-		}
-		// If a subscription was established with a pattern-based matching
-		// policy, a Broker MUST supply the original PUBLISH.Topic as provided
-		// by the Publisher in EVENT.Details.topic|uri.
-		if sendTopic {
-			event.Details[detailTopic] = msg.Topic
+		discloseTo := disclose && subscriber.HasFeature(wamp.RoleSubscriber, wamp.FeaturePubIdent)
+		seq := sub.sequences[subscriber]
+		st := b.ackSubs[ackKey{subscriber.ID, sub.id}]
+
+		if len(b.outbound) == 0 && seq == nil && st == nil && serialize.AcceptsSharedMessage(subscriber.Peer) {
+			var i int
+			if discloseTo {
+				i = 1
+			}
+			if shared[i] == nil {
+				event := newEvent(pub, msg, pubID, origTopic, sub.id, sendTopic, discloseTo)
+				shared[i] = serialize.NewSharedMessage(event)
+			}
+			b.trySend(subscriber, shared[i])
+			continue
 		}
-		if disclose && subscriber.HasFeature(wamp.RoleSubscriber, wamp.FeaturePubIdent) {
-			disclosePublisher(pub, event.Details)
+
+		event := newEvent(pub, msg, pubID, origTopic, sub.id, sendTopic, discloseTo)
+		// Number the event, so that the subscriber can detect events that
+		// were not delivered.
+		if seq != nil {
+			seq.stamp(event.Details, msg.Topic)
 		}
 
 		if subscriber.Peer.IsLocal() {
@@ -514,10 +844,40 @@ func (b *broker) syncPubEvent(pub *wamp.Session, msg *wamp.Publish, pubID wamp.I
 			}
 		}
 
+		if st != nil {
+			b.syncSendAckEvent(subscriber, st, event)
+			continue
+		}
 		b.trySend(subscriber, event)
 	}
 }
 
+// newEvent creates the event for a subscription, from a publication.
+func newEvent(pub *wamp.Session, msg *wamp.Publish, pubID wamp.ID, origTopic wamp.URI, subID wamp.ID, sendTopic, disclose bool) *wamp.Event {
+	event := &wamp.Event{
+		Publication:  pubID,
+		Subscription: subID,
+		Arguments:    msg.Arguments,
+		ArgumentsKw:  msg.ArgumentsKw,
+		Details:      wamp.Dict{},
+	}
+	// If a subscription was established with a pattern-based matching
+	// policy, a Broker MUST supply the original PUBLISH.Topic as provided
+	// by the Publisher in EVENT.Details.topic|uri.
+	if sendTopic {
+		event.Details[detailTopic] = msg.Topic
+	}
+	// If the topic was rewritten, then supply the topic as provided by the
+	// publisher.
+	if origTopic != "" {
+		event.Details[wamp.DetailOriginalTopic] = origTopic
+	}
+	if disclose {
+		disclosePublisher(pub, event.Details)
+	}
+	return event
+}
+
 // syncPubMeta publishes the subscription meta event, using the supplied
 // function, to the matching subscribers.
 func (b *broker) syncPubMeta(metaTopic wamp.URI, sendMeta func(metaSub *subscription, sendTopic bool)) {
@@ -526,58 +886,36 @@ func (b *broker) syncPubMeta(metaTopic wamp.URI, sendMeta func(metaSub *subscrip
 		sendMeta(metaSub, false)
 	}
 	// Publish to subscribers with prefix match.
-	for pfxTopic, metaSub := range b.pfxTopicSubscription {
-		if metaTopic.PrefixMatch(pfxTopic) {
-			sendMeta(metaSub, true)
-		}
-	}
+	b.pfxTopicTrie.match(metaTopic, func(pfxTopic wamp.URI) {
+		sendMeta(b.pfxTopicSubscription[pfxTopic], true)
+	})
 	// Publish to subscribers with wildcard match.
-	for wcTopic, metaSub := range b.wcTopicSubscription {
-		if metaTopic.WildcardMatch(wcTopic) {
-			sendMeta(metaSub, true)
-		}
-	}
+	b.wcTopicTrie.match(metaTopic, func(wcTopic wamp.URI) {
+		sendMeta(b.wcTopicSubscription[wcTopic], true)
+	})
+}
+
+// subMetaEvent is a subscription meta event.
+type subMetaEvent struct {
+	topic wamp.URI
+	// Session causing the meta event, which is not sent the event.
+	sessID wamp.ID
+	pubID  wamp.ID
+	// Returns the arguments of the event.  Each local subscriber is sent its
+	// own arguments, since local clients could modify contents.
+	args func() wamp.List
 }
 
 // syncPubSubMeta publishes a subscription meta event when a subscription is
 // added, removed, or deleted.
 func (b *broker) syncPubSubMeta(metaTopic wamp.URI, subSessID, subID wamp.ID) {
-	pubID := wamp.GlobalID() // create here so that it is same for all events
-	b.syncPubMeta(metaTopic, func(metaSub *subscription, sendTopic bool) {
-		makeEvent := func() *wamp.Event {
-			evt := &wamp.Event{
-				Publication:  pubID,
-				Subscription: metaSub.id,
-				Details:      wamp.Dict{},
-				Arguments:    wamp.List{subSessID, subID},
-			}
-			if sendTopic {
-				evt.Details[detailTopic] = metaTopic
-			}
-			return evt
-		}
-		var event *wamp.Event
-
-		for subscriber := range metaSub.subscribers {
-			// Do not send the meta event to the session that is causing the
-			// meta event to be generated.  This prevents useless events that
-			// could lead to race conditions on the client.
-			if subscriber.ID == subSessID {
-				continue
-			}
-
-			// Need to send separate event message to each local subscriber,
-			// since local clients could modify contents.
-			if subscriber.Peer.IsLocal() {
-				b.trySend(subscriber, makeEvent())
-				continue
-			}
-
-			if event == nil {
-				event = makeEvent()
-			}
-			b.trySend(subscriber, event)
-		}
+	b.syncPubMetaEvent(&subMetaEvent{
+		topic:  metaTopic,
+		sessID: subSessID,
+		pubID:  wamp.GlobalID(),
+		args: func() wamp.List {
+			return wamp.List{subSessID, subID}
+		},
 	})
 }
 
@@ -586,25 +924,49 @@ func (b *broker) syncPubSubMeta(metaTopic wamp.URI, subSessID, subID wamp.ID) {
 // Fired when a subscription is created through a subscription request for a
 // topic which was previously without subscribers.
 func (b *broker) syncPubSubCreateMeta(topic wamp.URI, subSessID wamp.ID, sub *subscription) {
-	pubID := wamp.GlobalID() // create here so that it is same for all events
-	b.syncPubMeta(wamp.MetaEventSubOnCreate, func(metaSub *subscription, sendTopic bool) {
+	subID, created, match := sub.id, sub.created, sub.match
+	b.syncPubMetaEvent(&subMetaEvent{
+		topic:  wamp.MetaEventSubOnCreate,
+		sessID: subSessID,
+		pubID:  wamp.GlobalID(),
+		args: func() wamp.List {
+			return wamp.List{
+				subSessID,
+				wamp.Dict{
+					"id":          subID,
+					"created":     created,
+					"uri":         topic,
+					wamp.OptMatch: match,
+				},
+			}
+		},
+	})
+}
+
+// syncPubMetaEvent sends the meta event to the subscribers of the meta topic.
+// If routing is sharded, then the meta event is queued instead, to be sent by
+// the shards that have subscriptions to the meta topic.
+func (b *broker) syncPubMetaEvent(ev *subMetaEvent) {
+	if b.queueMeta {
+		b.metaEvents = append(b.metaEvents, ev)
+		return
+	}
+	b.syncSendMetaEvent(ev)
+}
+
+// syncSendMetaEvent sends the meta event to the subscribers of the meta
+// topic.
+func (b *broker) syncSendMetaEvent(ev *subMetaEvent) {
+	b.syncPubMeta(ev.topic, func(metaSub *subscription, sendTopic bool) {
 		makeEvent := func() *wamp.Event {
 			evt := &wamp.Event{
-				Publication:  pubID,
+				Publication:  ev.pubID,
 				Subscription: metaSub.id,
 				Details:      wamp.Dict{},
-				Arguments: wamp.List{
-					subSessID,
-					wamp.Dict{
-						"id":          sub.id,
-						"created":     sub.created,
-						"uri":         sub.topic,
-						wamp.OptMatch: sub.match,
-					},
-				},
+				Arguments:    ev.args(),
 			}
 			if sendTopic {
-				evt.Details[detailTopic] = wamp.MetaEventSubOnCreate
+				evt.Details[detailTopic] = ev.topic
 			}
 			return evt
 		}
@@ -614,11 +976,11 @@ func (b *broker) syncPubSubCreateMeta(topic wamp.URI, subSessID wamp.ID, sub *su
 			// Do not send the meta event to the session that is causing the
 			// meta event to be generated.  This prevents useless events that
 			// could lead to race conditions on the client.
-			if subscriber.ID == subSessID {
+			if subscriber.ID == ev.sessID {
 				continue
 			}
 
-			// Need to send separate event message to each locsl subscriber,
+			// Need to send separate event message to each local subscriber,
 			// since local clients could modify contents.
 			if subscriber.Peer.IsLocal() {
 				b.trySend(subscriber, makeEvent())
@@ -633,7 +995,36 @@ func (b *broker) syncPubSubCreateMeta(topic wamp.URI, subSessID wamp.ID, sub *su
 	})
 }
 
+// syncTakeMetaEvents returns the queued meta events, and empties the queue.
+func (b *broker) syncTakeMetaEvents() []*subMetaEvent {
+	events := b.metaEvents
+	b.metaEvents = nil
+	return events
+}
+
+// syncCountPatternRoutes updates the number of pattern subscriptions and
+// pattern durable subscriptions.
+func (b *broker) syncCountPatternRoutes() {
+	n := len(b.pfxTopicSubscription) + len(b.wcTopicSubscription)
+	for _, d := range b.durables {
+		if d.match == wamp.MatchPrefix || d.match == wamp.MatchWildcard {
+			n++
+		}
+	}
+	atomic.StoreInt32(&b.patternRoutes, int32(n))
+}
+
+// hasPatternRoutes returns true if the broker has any pattern subscriptions
+// or pattern durable subscriptions.
+func (b *broker) hasPatternRoutes() bool {
+	return atomic.LoadInt32(&b.patternRoutes) != 0
+}
+
 func (b *broker) trySend(sess *wamp.Session, msg wamp.Message) bool {
+	if msg = b.outbound.interceptOutbound(sess, msg); msg == nil {
+		// Dropped by interceptor.
+		return true
+	}
 	if err := sess.TrySend(msg); err != nil {
 		b.log.Printf("!!! Dropped %s to session %s: %s", msg.MessageType(), sess, err)
 		return false
@@ -659,7 +1050,21 @@ func disclosePublisher(pub *wamp.Session, details wamp.Dict) {
 
 // subList retrieves subscription IDs listed according to match policies.
 func (b *broker) subList(msg *wamp.Invocation) wamp.Message {
-	var exactSubs, pfxSubs, wcSubs []wamp.ID
+	exactSubs, pfxSubs, wcSubs := b.listSubIDs(nil, nil, nil)
+	dict := wamp.Dict{
+		wamp.MatchExact:    exactSubs,
+		wamp.MatchPrefix:   pfxSubs,
+		wamp.MatchWildcard: wcSubs,
+	}
+	return &wamp.Yield{
+		Request:   msg.Request,
+		Arguments: wamp.List{dict},
+	}
+}
+
+// listSubIDs appends the IDs of the broker's subscriptions, by match policy,
+// to the given lists.
+func (b *broker) listSubIDs(exactSubs, pfxSubs, wcSubs []wamp.ID) ([]wamp.ID, []wamp.ID, []wamp.ID) {
 	sync := make(chan struct{})
 	b.actionChan <- func() {
 		for subID, sub := range b.subscriptions {
@@ -675,15 +1080,7 @@ func (b *broker) subList(msg *wamp.Invocation) wamp.Message {
 		close(sync)
 	}
 	<-sync
-	dict := wamp.Dict{
-		wamp.MatchExact:    exactSubs,
-		wamp.MatchPrefix:   pfxSubs,
-		wamp.MatchWildcard: wcSubs,
-	}
-	return &wamp.Yield{
-		Request:   msg.Request,
-		Arguments: wamp.List{dict},
-	}
+	return exactSubs, pfxSubs, wcSubs
 }
 
 // subLookup obtains the subscription (if any) managing a topic, according
@@ -692,6 +1089,7 @@ func (b *broker) subLookup(msg *wamp.Invocation) wamp.Message {
 	var subID wamp.ID
 	if len(msg.Arguments) != 0 {
 		if topic, ok := wamp.AsURI(msg.Arguments[0]); ok {
+			topic = b.rewriter.rewriteURI(topic)
 			var match string
 			if len(msg.Arguments) > 1 {
 				if opts, ok := wamp.AsDict(msg.Arguments[1]); ok {
@@ -730,24 +1128,7 @@ func (b *broker) subMatch(msg *wamp.Invocation) wamp.Message {
 	var subIDs []wamp.ID
 	if len(msg.Arguments) != 0 {
 		if topic, ok := wamp.AsURI(msg.Arguments[0]); ok {
-			sync := make(chan struct{})
-			b.actionChan <- func() {
-				if sub, ok := b.topicSubscription[topic]; ok {
-					subIDs = append(subIDs, sub.id)
-				}
-				for pfxTopic, sub := range b.pfxTopicSubscription {
-					if topic.PrefixMatch(pfxTopic) {
-						subIDs = append(subIDs, sub.id)
-					}
-				}
-				for wcTopic, sub := range b.wcTopicSubscription {
-					if topic.WildcardMatch(wcTopic) {
-						subIDs = append(subIDs, sub.id)
-					}
-				}
-				close(sync)
-			}
-			<-sync
+			subIDs = b.matchSubIDs(subIDs, b.rewriter.rewriteURI(topic))
 		}
 	}
 	return &wamp.Yield{
@@ -756,6 +1137,26 @@ func (b *broker) subMatch(msg *wamp.Invocation) wamp.Message {
 	}
 }
 
+// matchSubIDs appends the IDs of the broker's subscriptions that match the
+// topic to the given list.
+func (b *broker) matchSubIDs(subIDs []wamp.ID, topic wamp.URI) []wamp.ID {
+	sync := make(chan struct{})
+	b.actionChan <- func() {
+		if sub, ok := b.topicSubscription[topic]; ok {
+			subIDs = append(subIDs, sub.id)
+		}
+		b.pfxTopicTrie.match(topic, func(pfxTopic wamp.URI) {
+			subIDs = append(subIDs, b.pfxTopicSubscription[pfxTopic].id)
+		})
+		b.wcTopicTrie.match(topic, func(wcTopic wamp.URI) {
+			subIDs = append(subIDs, b.wcTopicSubscription[wcTopic].id)
+		})
+		close(sync)
+	}
+	<-sync
+	return subIDs
+}
+
 // subGet retrieves information on a particular subscription.
 func (b *broker) subGet(msg *wamp.Invocation) wamp.Message {
 	var dict wamp.Dict
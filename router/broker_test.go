@@ -5,6 +5,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/gammazero/nexus/v3/transport/serialize"
 	"github.com/gammazero/nexus/v3/wamp"
 )
 
@@ -36,6 +37,22 @@ func (p *testPeer) Close()                    { return }
 
 func (p *testPeer) IsLocal() bool { return true }
 
+// remoteTestPeer is a testPeer that is not local, like the peer of a session
+// connected over a network transport.
+type remoteTestPeer struct {
+	*testPeer
+}
+
+func (p remoteTestPeer) IsLocal() bool { return false }
+
+// sharingTestPeer is a remoteTestPeer that accepts shared messages, like the
+// peer of a session connected over websocket or rawsocket.
+type sharingTestPeer struct {
+	remoteTestPeer
+}
+
+func (p sharingTestPeer) AcceptsSharedMessage() bool { return true }
+
 func TestBasicSubscribe(t *testing.T) {
 	// Test subscribing to a topic.
 	broker := newBroker(logger, false, true, debug, nil)
@@ -693,3 +710,89 @@ func TestPublisherIdentification(t *testing.T) {
 		t.Fatal("incorrect publisher ID disclosed")
 	}
 }
+
+func TestSharedEvent(t *testing.T) {
+	broker := newBroker(logger, false, true, debug, nil)
+	testTopic := wamp.URI("nexus.test.topic")
+	pubIdentDetails := wamp.Dict{
+		"roles": wamp.Dict{
+			"subscriber": wamp.Dict{
+				"features": wamp.Dict{
+					"publisher_identification": true,
+				},
+			},
+		},
+	}
+
+	// Two subscribers that accept shared messages, one that also supports
+	// publisher identification, a local subscriber, and a remote subscriber
+	// that does not accept shared messages.
+	sessions := []*wamp.Session{
+		wamp.NewSession(sharingTestPeer{remoteTestPeer{newTestPeer()}}, wamp.GlobalID(), nil, nil),
+		wamp.NewSession(sharingTestPeer{remoteTestPeer{newTestPeer()}}, wamp.GlobalID(), nil, nil),
+		wamp.NewSession(sharingTestPeer{remoteTestPeer{newTestPeer()}}, wamp.GlobalID(), nil, pubIdentDetails),
+		wamp.NewSession(newTestPeer(), wamp.GlobalID(), nil, nil),
+		wamp.NewSession(remoteTestPeer{newTestPeer()}, wamp.GlobalID(), nil, nil),
+	}
+	for _, sess := range sessions {
+		broker.subscribe(sess, &wamp.Subscribe{Request: wamp.GlobalID(), Topic: testTopic})
+		rsp := <-sess.Recv()
+		if _, ok := rsp.(*wamp.Subscribed); !ok {
+			t.Fatal("expected", wamp.SUBSCRIBED, "got:", rsp.MessageType())
+		}
+	}
+
+	pubSess := wamp.NewSession(newTestPeer(), wamp.GlobalID(), nil, nil)
+	broker.publish(pubSess, &wamp.Publish{
+		Request:   wamp.GlobalID(),
+		Topic:     testTopic,
+		Options:   wamp.Dict{"disclose_me": true},
+		Arguments: wamp.List{"hello world"},
+	})
+
+	msgs := make([]wamp.Message, len(sessions))
+	for i, sess := range sessions {
+		select {
+		case msgs[i] = <-sess.Recv():
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for event")
+		}
+	}
+
+	// Subscribers that receive the same event share it.
+	shared, ok := msgs[0].(*serialize.SharedMessage)
+	if !ok {
+		t.Fatal("expected shared event, got", msgs[0])
+	}
+	if msgs[1] != shared {
+		t.Fatal("expected remote subscribers to share event")
+	}
+	evt, ok := shared.Message.(*wamp.Event)
+	if !ok {
+		t.Fatal("expected", wamp.EVENT, "got:", shared.MessageType())
+	}
+	if _, ok = evt.Details["publisher"]; ok {
+		t.Fatal("publisher disclosed to subscriber without publisher identification")
+	}
+
+	// The subscriber that supports publisher identification gets a different
+	// event.
+	disclosed, ok := msgs[2].(*serialize.SharedMessage)
+	if !ok || disclosed == shared {
+		t.Fatal("expected separate shared event with publisher disclosed")
+	}
+	evt = disclosed.Message.(*wamp.Event)
+	if pub, _ := evt.Details["publisher"].(wamp.ID); pub != pubSess.ID {
+		t.Fatal("incorrect publisher ID disclosed")
+	}
+
+	// The local subscriber gets its own event.
+	if _, ok = msgs[3].(*wamp.Event); !ok {
+		t.Fatal("expected unshared event for local subscriber, got", msgs[3])
+	}
+
+	// A peer that does not accept shared messages gets a plain event.
+	if _, ok = msgs[4].(*wamp.Event); !ok {
+		t.Fatal("expected unshared event for peer not accepting shared messages, got", msgs[4])
+	}
+}
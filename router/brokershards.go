@@ -0,0 +1,412 @@
+package router
+
+import (
+	"sync"
+
+	"github.com/gammazero/nexus/v3/router/durable"
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// brokerShards routes the publications and subscriptions of a realm using one
+// or more broker shards.  With a single shard, all messages are handled by
+// that broker as if it were not sharded.
+type brokerShards struct {
+	// All shards.  When there is more than one shard, the last is the
+	// pattern shard.
+	shards []*broker
+	// Shards for subscriptions with exact matching, selected by topic.
+	exact []*broker
+	// Shard for subscriptions with pattern matching.
+	pattern *broker
+
+	maxSubscriptions int
+	// Held while checking the subscription limit and subscribing, so that
+	// concurrent subscribes for a session cannot exceed the limit.
+	limitLock sync.Mutex
+
+	// Publications waiting to be routed, by publisher.  Each publisher with
+	// waiting publications has a goroutine that routes them in order.
+	publishers   map[*wamp.Session]*publishQueue
+	publishersMu sync.Mutex
+	publishing   sync.WaitGroup
+
+	// Store created for the realm, which is closed when the shards have
+	// stopped.  A store supplied in the realm configuration is not closed
+	// with the realm, since it may be used by other realms.
+	ownStore durable.Store
+}
+
+// newBrokerShards creates n shards for subscriptions with exact matching,
+// calling newShard to create each, and a separate shard for subscriptions
+// with pattern matching if n is greater than one.
+func newBrokerShards(n int, newShard func() *broker) *brokerShards {
+	if n <= 1 {
+		b := newShard()
+		return &brokerShards{
+			shards:           []*broker{b},
+			exact:            []*broker{b},
+			pattern:          b,
+			maxSubscriptions: b.maxSubscriptions,
+		}
+	}
+	shards := make([]*broker, n+1)
+	for i := range shards {
+		b := newShard()
+		b.idGen = shardIDGen{shard: int64(i), shards: int64(len(shards))}
+		b.queueMeta = true
+		shards[i] = b
+	}
+	return &brokerShards{
+		shards:           shards,
+		exact:            shards[:n],
+		pattern:          shards[n],
+		maxSubscriptions: shards[0].maxSubscriptions,
+		publishers:       map[*wamp.Session]*publishQueue{},
+	}
+}
+
+// publishQueueSize is the number of publications from a publisher that can
+// wait to be routed before the publisher waits.
+const publishQueueSize = 64
+
+// publishQueue holds the publications of a publisher that are waiting to be
+// routed.
+type publishQueue struct {
+	pubs chan *publication
+	// Number of publications given to the queue and not yet routed.  Guarded
+	// by brokerShards.publishersMu.
+	pending int
+}
+
+// role returns the role information for the "broker" role.
+func (s *brokerShards) role() wamp.Dict {
+	return brokerRole
+}
+
+// topicShard returns the shard for subscriptions to the topic with the match
+// policy.
+func (s *brokerShards) topicShard(topic wamp.URI, match string) *broker {
+	if match == wamp.MatchPrefix || match == wamp.MatchWildcard {
+		return s.pattern
+	}
+	return s.exact[shardOfURI(topic, len(s.exact))]
+}
+
+// patternShardAfter returns the pattern shard if it must be visited after
+// the given exact shard to route a topic, or nil if not.
+func (s *brokerShards) patternShardAfter(b *broker) *broker {
+	if s.pattern == b || !s.pattern.hasPatternRoutes() {
+		return nil
+	}
+	return s.pattern
+}
+
+// idShard returns the shard that generated the subscription ID in the first
+// argument of the meta procedure call, or the pattern shard if there is no ID.
+func (s *brokerShards) idShard(msg *wamp.Invocation) *broker {
+	if len(msg.Arguments) != 0 {
+		if id, ok := wamp.AsID(msg.Arguments[0]); ok {
+			return s.shards[shardOfID(id, len(s.shards))]
+		}
+	}
+	return s.pattern
+}
+
+// publish sends an event to the subscribers of the topic.  The publication is
+// handed to the publisher's queue, so that the publisher does not wait for
+// it to be routed.
+func (s *brokerShards) publish(pub *wamp.Session, msg *wamp.Publish) {
+	if len(s.shards) == 1 {
+		s.pattern.publish(pub, msg)
+		return
+	}
+	p := s.pattern.checkPublish(pub, msg)
+	if p == nil {
+		return
+	}
+
+	// Send PUBLISHED message if acknowledge is present and true.  As without
+	// sharding, this is sent when the publication is accepted for routing,
+	// without waiting for the event to be delivered.
+	if p.ack {
+		b := s.topicShard(p.msg.Topic, wamp.MatchExact)
+		b.trySend(pub, &wamp.Published{Request: msg.Request, Publication: p.id})
+	}
+
+	s.publishersMu.Lock()
+	q, ok := s.publishers[pub]
+	if !ok {
+		q = &publishQueue{pubs: make(chan *publication, publishQueueSize)}
+		s.publishers[pub] = q
+		s.publishing.Add(1)
+		go s.runPublishQueue(pub, q)
+	}
+	q.pending++
+	s.publishersMu.Unlock()
+	q.pubs <- p
+}
+
+// runPublishQueue routes the publications in the publisher's queue, in the
+// order they were published, until the queue is empty.
+func (s *brokerShards) runPublishQueue(pub *wamp.Session, q *publishQueue) {
+	defer s.publishing.Done()
+	for p := range q.pubs {
+		s.routePublication(p)
+		s.publishersMu.Lock()
+		q.pending--
+		if q.pending == 0 {
+			// The next publication starts a new queue, which cannot route
+			// it before the publications in this queue, since all have
+			// been routed.
+			delete(s.publishers, pub)
+			s.publishersMu.Unlock()
+			return
+		}
+		s.publishersMu.Unlock()
+	}
+}
+
+// routePublication routes the publication by the shard of the topic and then
+// by the pattern shard, waiting for each, so that events from the publisher
+// stay in order.
+func (s *brokerShards) routePublication(p *publication) {
+	b := s.topicShard(p.msg.Topic, wamp.MatchExact)
+	runSync(b.actionChan, func() {
+		b.syncPublish(p)
+	})
+	if pb := s.patternShardAfter(b); pb != nil {
+		runSync(pb.actionChan, func() {
+			pb.syncPublish(p)
+		})
+	}
+}
+
+// subscribe subscribes the client to the topic, in the shard of the topic.
+func (s *brokerShards) subscribe(sub *wamp.Session, msg *wamp.Subscribe) {
+	if len(s.shards) == 1 {
+		s.pattern.subscribe(sub, msg)
+		return
+	}
+	req := s.pattern.checkSubscribe(sub, msg)
+	if req == nil {
+		return
+	}
+	b := s.topicShard(req.msg.Topic, req.match)
+
+	// The subscription limit applies to the subscriptions in all shards.
+	var otherSubs int
+	if s.maxSubscriptions > 0 {
+		s.limitLock.Lock()
+		for _, other := range s.shards {
+			if other != b {
+				otherSubs += other.subscriptionCount(sub)
+			}
+		}
+	}
+
+	var subscribed bool
+	var metaEvents []*subMetaEvent
+	runSync(b.actionChan, func() {
+		subscribed = b.syncSubscribeRequest(sub, req, otherSubs) != nil
+		metaEvents = b.syncTakeMetaEvents()
+	})
+	if s.maxSubscriptions > 0 {
+		s.limitLock.Unlock()
+	}
+
+	// A durable subscription moves to another shard if subscribed to a topic
+	// in another shard.
+	if subscribed && req.durableName != "" {
+		queue := durableQueue(b.durableRealm, req.authid, req.durableName)
+		for _, other := range s.shards {
+			if other != b {
+				runSync(other.actionChan, func() {
+					other.syncForgetDurable(queue)
+				})
+			}
+		}
+	}
+	s.sendMetaEvents(metaEvents)
+}
+
+// unsubscribe removes the requested subscription, in the shard that has the
+// subscription.
+func (s *brokerShards) unsubscribe(sub *wamp.Session, msg *wamp.Unsubscribe) {
+	if len(s.shards) == 1 {
+		s.pattern.unsubscribe(sub, msg)
+		return
+	}
+	b := s.shards[shardOfID(msg.Subscription, len(s.shards))]
+	var metaEvents []*subMetaEvent
+	runSync(b.actionChan, func() {
+		b.syncUnsubscribe(sub, msg)
+		metaEvents = b.syncTakeMetaEvents()
+	})
+	s.sendMetaEvents(metaEvents)
+}
+
+// removeSession removes all subscriptions of the subscriber from all shards.
+func (s *brokerShards) removeSession(sess *wamp.Session) {
+	if len(s.shards) == 1 {
+		s.pattern.removeSession(sess)
+		return
+	}
+	if sess == nil {
+		return
+	}
+	var metaEvents []*subMetaEvent
+	for _, b := range s.shards {
+		runSync(b.actionChan, func() {
+			b.syncRemoveSession(sess)
+			metaEvents = append(metaEvents, b.syncTakeMetaEvents()...)
+		})
+	}
+	s.sendMetaEvents(metaEvents)
+}
+
+// sendMetaEvents sends subscription meta events to the subscribers of the
+// meta topics, in the shards that have the subscriptions.
+func (s *brokerShards) sendMetaEvents(metaEvents []*subMetaEvent) {
+	for _, ev := range metaEvents {
+		b := s.topicShard(ev.topic, wamp.MatchExact)
+		runSync(b.actionChan, func() {
+			b.syncSendMetaEvent(ev)
+		})
+		if pb := s.patternShardAfter(b); pb != nil {
+			runSync(pb.actionChan, func() {
+				pb.syncSendMetaEvent(ev)
+			})
+		}
+	}
+}
+
+// stopRedeliver stops the redelivery of events in all shards.
+func (s *brokerShards) stopRedeliver() {
+	for _, b := range s.shards {
+		b.stopRedeliver()
+	}
+}
+
+// close stops all shards, after routing the publications waiting in
+// publisher queues, and then closes the store created for the realm.
+func (s *brokerShards) close() {
+	s.publishing.Wait()
+	for _, b := range s.shards {
+		b.close()
+	}
+	if s.ownStore != nil {
+		for _, b := range s.shards {
+			<-b.done
+		}
+		if err := s.ownStore.Close(); err != nil {
+			s.pattern.log.Println("Error closing durable subscription store:", err)
+		}
+	}
+}
+
+// subscriptionCount returns the number of subscriptions of the session.
+func (s *brokerShards) subscriptionCount(sess *wamp.Session) int {
+	var count int
+	for _, b := range s.shards {
+		count += b.subscriptionCount(sess)
+	}
+	return count
+}
+
+// ----- Subscription Meta Procedure Handlers -----
+
+// subList retrieves subscription IDs listed according to match policies.
+func (s *brokerShards) subList(msg *wamp.Invocation) wamp.Message {
+	var exactSubs, pfxSubs, wcSubs []wamp.ID
+	for _, b := range s.shards {
+		exactSubs, pfxSubs, wcSubs = b.listSubIDs(exactSubs, pfxSubs, wcSubs)
+	}
+	dict := wamp.Dict{
+		wamp.MatchExact:    exactSubs,
+		wamp.MatchPrefix:   pfxSubs,
+		wamp.MatchWildcard: wcSubs,
+	}
+	return &wamp.Yield{
+		Request:   msg.Request,
+		Arguments: wamp.List{dict},
+	}
+}
+
+// subLookup obtains the subscription (if any) managing a topic, according
+// to some match policy.
+func (s *brokerShards) subLookup(msg *wamp.Invocation) wamp.Message {
+	b := s.pattern
+	if len(msg.Arguments) != 0 {
+		if topic, ok := wamp.AsURI(msg.Arguments[0]); ok {
+			var match string
+			if len(msg.Arguments) > 1 {
+				if opts, ok := wamp.AsDict(msg.Arguments[1]); ok {
+					match, _ = wamp.AsString(opts[wamp.OptMatch])
+				}
+			}
+			b = s.topicShard(b.rewriter.rewriteURI(topic), match)
+		}
+	}
+	return b.subLookup(msg)
+}
+
+// subMatch retrieves a list of IDs of subscriptions matching a topic URI,
+// irrespective of match policy.
+func (s *brokerShards) subMatch(msg *wamp.Invocation) wamp.Message {
+	var subIDs []wamp.ID
+	if len(msg.Arguments) != 0 {
+		if topic, ok := wamp.AsURI(msg.Arguments[0]); ok {
+			topic = s.pattern.rewriter.rewriteURI(topic)
+			b := s.topicShard(topic, wamp.MatchExact)
+			subIDs = b.matchSubIDs(subIDs, topic)
+			if pb := s.patternShardAfter(b); pb != nil {
+				subIDs = pb.matchSubIDs(subIDs, topic)
+			}
+		}
+	}
+	return &wamp.Yield{
+		Request:   msg.Request,
+		Arguments: wamp.List{subIDs},
+	}
+}
+
+// subGet retrieves information on a particular subscription.
+func (s *brokerShards) subGet(msg *wamp.Invocation) wamp.Message {
+	return s.idShard(msg).subGet(msg)
+}
+
+// subListSubscribers retrieves a list of session IDs for sessions currently
+// attached to the subscription.
+func (s *brokerShards) subListSubscribers(msg *wamp.Invocation) wamp.Message {
+	return s.idShard(msg).subListSubscribers(msg)
+}
+
+// subCountSubscribers obtains the number of sessions currently attached to the
+// subscription.
+func (s *brokerShards) subCountSubscribers(msg *wamp.Invocation) wamp.Message {
+	return s.idShard(msg).subCountSubscribers(msg)
+}
+
+// subAckDurable acknowledges the events of the caller's durable subscription,
+// in the shard that has the durable subscription.
+func (s *brokerShards) subAckDurable(msg *wamp.Invocation) wamp.Message {
+	var reply wamp.Message
+	for _, b := range s.shards {
+		reply = b.subAckDurable(msg)
+		if errMsg, ok := reply.(*wamp.Error); !ok || errMsg.Error != wamp.ErrNoSuchSubscription {
+			break
+		}
+	}
+	return reply
+}
+
+// subAck acknowledges an event delivered with at-least-once delivery.
+func (s *brokerShards) subAck(msg *wamp.Invocation) wamp.Message {
+	return s.idShard(msg).subAck(msg)
+}
+
+// subNack negatively acknowledges an event delivered with at-least-once
+// delivery.
+func (s *brokerShards) subNack(msg *wamp.Invocation) wamp.Message {
+	return s.idShard(msg).subNack(msg)
+}
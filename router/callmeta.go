@@ -0,0 +1,123 @@
+package router
+
+import (
+	"fmt"
+
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// callInfo returns the information on a call that is waiting for a result.
+// The call is identified by the ID of its invocation.
+func callInfo(invocationID wamp.ID, invk *invocation) wamp.Dict {
+	return wamp.Dict{
+		"id":             invocationID,
+		"caller":         invk.callID.session,
+		"caller_request": invk.callID.request,
+		"callee":         invk.callee.ID,
+		"procedure":      invk.procedure,
+		"registration":   invk.registration,
+		"started":        wamp.ISO8601(invk.started),
+		wamp.OptTimeout:  invk.timeout,
+		"canceled":       invk.canceled,
+	}
+}
+
+// callList is a call meta procedure that retrieves information on all calls
+// currently waiting for a result.  Calls to meta procedures are not included.
+func (d *dealer) callList(msg *wamp.Invocation) wamp.Message {
+	return &wamp.Yield{
+		Request:   msg.Request,
+		Arguments: wamp.List{d.listCalls(wamp.List{})},
+	}
+}
+
+// listCalls appends the information on the dealer's calls that are waiting
+// for a result to the given list.
+func (d *dealer) listCalls(calls wamp.List) wamp.List {
+	sync := make(chan struct{})
+	d.actionChan <- func() {
+		for id, invk := range d.invocations {
+			if invk.callee.ID == metaID {
+				continue
+			}
+			calls = append(calls, callInfo(id, invk))
+		}
+		close(sync)
+	}
+	<-sync
+	return calls
+}
+
+// callGet is a call meta procedure that retrieves information on a call
+// waiting for a result.  The argument is the call ID reported by
+// wamp.call.list.
+func (d *dealer) callGet(msg *wamp.Invocation) wamp.Message {
+	var dict wamp.Dict
+	if len(msg.Arguments) != 0 {
+		if id, ok := wamp.AsID(msg.Arguments[0]); ok {
+			sync := make(chan struct{})
+			d.actionChan <- func() {
+				if invk, ok := d.invocations[id]; ok && invk.callee.ID != metaID {
+					dict = callInfo(id, invk)
+				}
+				close(sync)
+			}
+			<-sync
+		}
+	}
+	if dict == nil {
+		return makeError(msg.Request, wamp.ErrNoSuchCall)
+	}
+	return &wamp.Yield{
+		Request:   msg.Request,
+		Arguments: wamp.List{dict},
+	}
+}
+
+// callCancel is a call meta procedure that cancels a call waiting for a
+// result, as if the caller canceled the call.  The argument is the call ID
+// reported by wamp.call.list.  The cancel mode is given by the "mode" keyword
+// argument: "skip", "kill", or "killnowait" (default).
+func (d *dealer) callCancel(msg *wamp.Invocation) wamp.Message {
+	if len(msg.Arguments) == 0 {
+		return makeError(msg.Request, wamp.ErrNoSuchCall)
+	}
+	id, ok := wamp.AsID(msg.Arguments[0])
+	if !ok {
+		return makeError(msg.Request, wamp.ErrNoSuchCall)
+	}
+	mode, _ := wamp.AsString(msg.ArgumentsKw[wamp.OptMode])
+	switch mode {
+	case wamp.CancelModeKillNoWait, wamp.CancelModeKill, wamp.CancelModeSkip:
+	case "":
+		mode = wamp.CancelModeKillNoWait
+	default:
+		errMsg := makeError(msg.Request, wamp.ErrInvalidArgument)
+		errMsg.Arguments = wamp.List{fmt.Sprint("invalid cancel mode ", mode)}
+		return errMsg
+	}
+	canceler, _ := wamp.AsID(msg.Details["caller"])
+
+	var found bool
+	sync := make(chan struct{})
+	d.actionChan <- func() {
+		defer close(sync)
+		invk, ok := d.invocations[id]
+		if !ok || invk.callee.ID == metaID {
+			return
+		}
+		caller, ok := d.calls[invk.callID]
+		if !ok {
+			return
+		}
+		found = true
+		errArgs := wamp.List{fmt.Sprint("call canceled by session ", canceler)}
+		d.syncCancel(caller, &wamp.Cancel{Request: invk.callID.request}, mode,
+			wamp.ErrCanceled, errArgs)
+	}
+	<-sync
+	if !found {
+		return makeError(msg.Request, wamp.ErrNoSuchCall)
+	}
+	return &wamp.Yield{Request: msg.Request}
+}
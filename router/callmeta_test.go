@@ -0,0 +1,94 @@
+package router
+
+import (
+	"testing"
+
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// callMeta calls a call meta procedure and returns the result.
+func callMeta(t *testing.T, sess *wamp.Session, procedure wamp.URI, args wamp.List, kwArgs wamp.Dict) *wamp.Result {
+	sess.Send(&wamp.Call{
+		Request:     wamp.GlobalID(),
+		Procedure:   procedure,
+		Arguments:   args,
+		ArgumentsKw: kwArgs,
+	})
+	return recvMsg(t, sess, wamp.RESULT).(*wamp.Result)
+}
+
+func TestCallMeta(t *testing.T) {
+	const stuckProc = wamp.URI("stuck.proc")
+	r := newTestRouterWithRealm(t, &RealmConfig{EnableMetaCancel: true})
+	defer r.Close()
+
+	callee, err := testClient(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	callee.Send(&wamp.Register{Request: wamp.GlobalID(), Procedure: stuckProc})
+	registered := recvMsg(t, callee, wamp.REGISTERED).(*wamp.Registered)
+
+	caller, err := testClient(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	callID := wamp.GlobalID()
+	caller.Send(&wamp.Call{Request: callID, Procedure: stuckProc})
+	recvMsg(t, callee, wamp.INVOCATION)
+
+	admin, err := testClient(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Outstanding call is listed, without the call to the meta procedure.
+	result := callMeta(t, admin, wamp.MetaProcCallList, nil, nil)
+	calls, _ := wamp.AsList(result.Arguments[0])
+	if len(calls) != 1 {
+		t.Fatal("Expected 1 call, got", len(calls))
+	}
+	info, _ := wamp.AsDict(calls[0])
+	if info["caller"] != caller.ID || info["caller_request"] != callID {
+		t.Fatal("Wrong caller:", info)
+	}
+	if info["callee"] != callee.ID || info["procedure"] != stuckProc || info["registration"] != registered.Registration {
+		t.Fatal("Wrong callee:", info)
+	}
+	if started, _ := wamp.AsString(info["started"]); started == "" {
+		t.Fatal("Missing start time")
+	}
+
+	result = callMeta(t, admin, wamp.MetaProcCallGet, wamp.List{info["id"]}, nil)
+	if got, _ := wamp.AsDict(result.Arguments[0]); got["procedure"] != stuckProc {
+		t.Fatal("Wrong call info:", got)
+	}
+
+	admin.Send(&wamp.Call{
+		Request:   wamp.GlobalID(),
+		Procedure: wamp.MetaProcCallGet,
+		Arguments: wamp.List{wamp.GlobalID()},
+	})
+	expectErrorURI(t, admin, wamp.ErrNoSuchCall)
+
+	admin.Send(&wamp.Call{
+		Request:     wamp.GlobalID(),
+		Procedure:   wamp.MetaProcCallCancel,
+		Arguments:   wamp.List{info["id"]},
+		ArgumentsKw: wamp.Dict{wamp.OptMode: "bogus"},
+	})
+	expectErrorURI(t, admin, wamp.ErrInvalidArgument)
+
+	// Canceling the call returns an error to the caller.
+	callMeta(t, admin, wamp.MetaProcCallCancel, wamp.List{info["id"]},
+		wamp.Dict{wamp.OptMode: wamp.CancelModeSkip})
+	errMsg := recvMsg(t, caller, wamp.ERROR).(*wamp.Error)
+	if errMsg.Request != callID || errMsg.Error != wamp.ErrCanceled {
+		t.Fatal("Wrong error for canceled call:", errMsg)
+	}
+
+	result = callMeta(t, admin, wamp.MetaProcCallList, nil, nil)
+	if calls, _ = wamp.AsList(result.Arguments[0]); len(calls) != 0 {
+		t.Fatal("Expected no calls, got", len(calls))
+	}
+}
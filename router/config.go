@@ -2,6 +2,7 @@ package router
 
 import (
 	"github.com/gammazero/nexus/v3/router/auth"
+	"github.com/gammazero/nexus/v3/router/durable"
 	"github.com/gammazero/nexus/v3/wamp"
 )
 
@@ -20,6 +21,21 @@ type Config struct {
 	// allows unauthenticated clients to create new realms.
 	RealmTemplate *RealmConfig `json:"realm_template"`
 
+	// RealmTemplates are used by the router to create new realms when a
+	// client requests to join a realm that does not yet exist, and the realm
+	// URI matches a template's pattern.  The most specific matching template
+	// is used: an exact match is preferred over a prefix match, and a prefix
+	// match over a wildcard match, and the longest matching prefix or
+	// wildcard pattern is used.  RealmTemplate, if defined, is used when no
+	// template matches.  Otherwise, clients cannot join realms that do not
+	// match any template.
+	RealmTemplates []*RealmTemplate `json:"realm_templates"`
+
+	// Bridges make topics and procedures of one realm available in another
+	// realm.  Bridges are set up between the realms in RealmConfigs when the
+	// router is created.
+	Bridges []*Bridge `json:"bridges"`
+
 	// Enable debug logging for router, realm, broker, dealer
 	Debug bool
 	// Interval in seconds for logging memory stats.  O to disable.
@@ -38,11 +54,16 @@ type RealmConfig struct {
 	// Allow anonymous authentication.  If an auth.AnonymousAuth Authenticator
 	// if not supplied, then router supplies on with AuthRole of "anonymous".
 	AnonymousAuth bool `json:"anonymous_auth"`
+	// Allow authentication of clients connected over a Unix socket by the
+	// user and group IDs of the client process.  Maps the IDs to the authid
+	// and authrole of clients.  See auth.PeerCredAuth.
+	PeerCredAuth *auth.PeerCredAuth `json:"peercred_auth"`
 	// Allow publisher and caller identity disclosure when requested.
 	AllowDisclose bool `json:"allow_disclose"`
 	// Slice of Authenticator interfaces.
 	Authenticators []auth.Authenticator
-	// Authorizer called for each message.
+	// Authorizer called for each message.  This runs before any
+	// InboundInterceptors.
 	Authorizer Authorizer
 	// Require authentication for local clients.  Normally local clients are
 	// always trusted.  Setting this treats local clients the same as remote.
@@ -71,6 +92,10 @@ type RealmConfig struct {
 	// procedure.  This is disabled by default to avoid requiring Authorizer
 	// logic when it may not be needed otherwise.
 	EnableMetaModify bool `json:"enable_meta_modify"`
+	// EnableMetaCancel enables the wamp.call.cancel meta procedure.  This is
+	// disabled by default to avoid requiring Authorizer logic when it may not
+	// be needed otherwise.
+	EnableMetaCancel bool `json:"enable_meta_cancel"`
 
 	// PublishFilterFactory is a function used to create a
 	// PublishFilter to check which sessions a publication should be
@@ -79,4 +104,157 @@ type RealmConfig struct {
 	// This value is not set via json config, but is configured when
 	// embedding nexus.  A value of nil enables the default filtering.
 	PublishFilterFactory FilterFactory
+
+	// PayloadSchemas are JSON Schema documents used to validate the payloads
+	// of CALL, YIELD, and PUBLISH messages, for matching procedure and topic
+	// URIs.
+	PayloadSchemas []*PayloadSchema `json:"payload_schemas"`
+
+	// URIRewrites are rules that map procedure and topic URIs to new URIs,
+	// so that both old and new URIs can be used.
+	URIRewrites []*URIRewrite `json:"uri_rewrites"`
+
+	// Resource limits for the realm.  A value of 0 means no limit.  Each
+	// limit is reported by the wamp.realm.get_quotas meta procedure.
+	//
+	// MaxSessions limits the number of sessions attached to the realm.
+	MaxSessions int `json:"max_sessions"`
+	// MaxSubscriptionsPerSession limits the number of subscriptions of each
+	// session.
+	MaxSubscriptionsPerSession int `json:"max_subscriptions_per_session"`
+	// MaxRegistrationsPerSession limits the number of registrations of each
+	// session.
+	MaxRegistrationsPerSession int `json:"max_registrations_per_session"`
+	// MaxPendingCallsPerCaller limits the number of calls that each session
+	// can have waiting for a result.
+	MaxPendingCallsPerCaller int `json:"max_pending_calls_per_caller"`
+	// MaxPayloadSize limits the size, in bytes, of serialized CALL, YIELD,
+	// PUBLISH, and ERROR messages received from clients.  The size is that
+	// of the message as received by the transport, so it depends on the
+	// serialization used.  Messages from local clients, which are not
+	// serialized, are not limited.
+	MaxPayloadSize int `json:"max_payload_size"`
+
+	// SlowConsumerPolicy is what the router does when a message cannot be
+	// sent to a session because the session's outbound queue is full:
+	// "drop_newest", "drop_oldest", "disconnect", or "block".  Default is
+	// "drop_newest".  The number of messages dropped for each session is
+	// reported by the wamp.session.get meta procedure.
+	//
+	// The "drop_oldest" policy only discards EVENT messages, so that replies
+	// and invocations are not lost.
+	//
+	// The "block" policy waits in the broker or dealer goroutine, so while a
+	// send to a slow session is blocked no other messages are routed in the
+	// realm, or in the routing shard when RoutingShards is set.  After a wait
+	// times out, messages to the session are dropped without waiting until
+	// its queue has space again, so a session that stops reading stalls the
+	// realm for at most SlowConsumerBlockMsec.
+	SlowConsumerPolicy string `json:"slow_consumer_policy"`
+	// SlowConsumerBlockMsec is the number of milliseconds to wait for space
+	// in a session's outbound queue, when SlowConsumerPolicy is "block",
+	// before dropping the message.  Default is 100.
+	SlowConsumerBlockMsec int `json:"slow_consumer_block_msec"`
+
+	// SessionResumeSec is the number of seconds that a session is kept, after
+	// its transport is unexpectedly lost, so that the client can resume the
+	// session.  While detached, the session keeps its subscriptions and
+	// registrations, and messages for the session are buffered.  Only
+	// sessions that request resumption are kept.  0 (default) disables
+	// session resumption.
+	//
+	// The client resuming a session must authenticate with the same authid
+	// as the session.  Anonymous clients are given a new authid each time
+	// they authenticate, so anonymous sessions can only be resumed if
+	// SessionResumeAnonymous is set.
+	SessionResumeSec int `json:"session_resume_sec"`
+	// SessionResumeAnonymous allows an anonymous session to be resumed by an
+	// anonymous client with the session's resume token, if the client
+	// connects over the same type of transport and from the same host as the
+	// session.  Since the resume token is the only credential, this is
+	// disabled by default.
+	SessionResumeAnonymous bool `json:"session_resume_anonymous"`
+	// SessionResumeBuffer is the maximum number of messages buffered for a
+	// detached session.  Messages that do not fit are dropped.  Default is
+	// 64.
+	SessionResumeBuffer int `json:"session_resume_buffer"`
+
+	// DurableDir is the directory where the events of durable subscriptions
+	// are stored.  The realm's events are stored in a subdirectory named by
+	// the realm URI.  Durable subscriptions are enabled when DurableDir or
+	// DurableStore is set.
+	//
+	// A subscriber makes a subscription durable by subscribing with the
+	// "durable" option set to a name.  Events for the durable subscription
+	// are stored, including while the subscriber is offline, until
+	// acknowledged using the wamp.subscription.ack_durable meta procedure.
+	// Stored events are redelivered when a session with the same authid
+	// subscribes with the same durable name.
+	//
+	// Events are stored by the broker goroutine as they are published, so
+	// each publication to a topic with durable subscriptions waits for the
+	// store, holding up routing in the realm, or in the routing shard when
+	// RoutingShards is set.  The file store encodes each event as JSON and
+	// writes it to a file, without syncing the file.
+	DurableDir string `json:"durable_dir"`
+	// DurableMaxEvents limits the number of events stored for each durable
+	// subscription.  The oldest events are discarded to stay within the limit.
+	// Default is 10000.
+	DurableMaxEvents int `json:"durable_max_events"`
+	// DurableMaxAgeSec is the number of seconds that events are stored for a
+	// durable subscription, after which they are discarded.  A durable
+	// subscription that has had no subscribed session for this long is
+	// removed, along with its stored events.  Default is 604800 (7 days).
+	DurableMaxAgeSec int `json:"durable_max_age_sec"`
+	// DurableStore stores the events of durable subscriptions, instead of
+	// storing them in DurableDir.  The store may be shared by realms, such as
+	// realms created from a template, since the events of each realm are
+	// stored in separate queues.  The store is closed when the router is
+	// closed.
+	//
+	// This value is not set via json config, but is configured when
+	// embedding nexus.
+	DurableStore durable.Store
+
+	// EventAckTimeoutMsec is the number of milliseconds to wait for a
+	// subscriber to acknowledge an event delivered with at-least-once
+	// delivery, before redelivering the event.  Default is 5000.
+	//
+	// A subscriber requests at-least-once delivery by subscribing with the
+	// "delivery" option set to "at_least_once".  Each event for the
+	// subscription then has a "delivery_seq" detail, and the subscriber
+	// acknowledges the event by calling wamp.subscription.ack, or requests
+	// immediate redelivery by calling wamp.subscription.nack, with the
+	// subscription ID and sequence number.
+	EventAckTimeoutMsec int `json:"event_ack_timeout_msec"`
+	// EventMaxRedeliveries is the maximum number of times an event is
+	// redelivered before it is discarded.  Default is 3.
+	EventMaxRedeliveries int `json:"event_max_redeliveries"`
+
+	// RoutingShards is the number of goroutines that route the realm's
+	// publications and calls, so that independent topics and procedures are
+	// routed in parallel.  Topics and procedures with exact matching are
+	// assigned to a shard by the hash of their URI, and an additional shard
+	// routes all subscriptions and registrations with pattern matching.
+	// Events from a publisher, and invocations from a caller, stay in order.
+	// A value of about GOMAXPROCS lets routing use all CPUs.  0 or 1 (default)
+	// routes all messages in a single goroutine.
+	//
+	// RoutingShards is experimental.  Its scaling has not been measured on
+	// multi-core machines, and it may change or be removed.
+	RoutingShards int `json:"routing_shards"`
+
+	// InboundInterceptors is an ordered chain of interceptors called for
+	// each message sent by a session to the router, before the message is
+	// routed.
+	//
+	// This value is not set via json config, but is configured when
+	// embedding nexus.
+	InboundInterceptors []Interceptor
+	// OutboundInterceptors is an ordered chain of interceptors called for
+	// each message sent by the router to a session.
+	//
+	// This value is not set via json config, but is configured when
+	// embedding nexus.
+	OutboundInterceptors []Interceptor
 }
@@ -52,11 +52,15 @@ type registration struct {
 
 // invocation tracks in-progress invocation
 type invocation struct {
-	callID      requestID
-	callee      *wamp.Session
-	canceled    bool
-	retryCount  int
-	timerCancel context.CancelFunc
+	callID       requestID
+	callee       *wamp.Session
+	procedure    wamp.URI
+	registration wamp.ID
+	started      time.Time
+	timeout      int64
+	canceled     bool
+	retryCount   int
+	timerCancel  context.CancelFunc
 }
 
 type requestID struct {
@@ -69,6 +73,10 @@ type dealer struct {
 	procRegMap    map[wamp.URI]*registration
 	pfxProcRegMap map[wamp.URI]*registration
 	wcProcRegMap  map[wamp.URI]*registration
+	// Index the prefix and wildcard procedures for matching called
+	// procedures.
+	pfxProcTrie prefixTrie
+	wcProcTrie  wildcardTrie
 
 	// registration ID -> registration
 	// Used to lookup registration by ID, needed for unregister.
@@ -76,6 +84,8 @@ type dealer struct {
 
 	// call ID -> caller session
 	calls map[requestID]*wamp.Session
+	// caller session ID -> number of pending calls
+	pendingCalls *callCounter
 
 	// invocation ID -> {call ID, callee, canceled}
 	invocations map[wamp.ID]*invocation
@@ -89,8 +99,8 @@ type dealer struct {
 
 	actionChan chan func()
 
-	// Generate registration IDs.
-	idGen *wamp.IDGen
+	// Generate registration and invocation IDs.
+	idGen shardIDGen
 
 	// Used for round-robin call invocation.
 	prng *rand.Rand
@@ -101,9 +111,29 @@ type dealer struct {
 
 	metaPeer wamp.Peer
 
+	// Session used by bridges in this realm.  Calls from this session are
+	// not routed back to it.
+	bridge *wamp.Session
+
 	// Meta-procedure registration ID -> handler func.
 	metaProcMap map[wamp.ID]func(*wamp.Invocation) wamp.Message
 
+	// Interceptors called for each message sent to a session.
+	outbound interceptorChain
+	// Validates call and result payloads.
+	validator *payloadValidator
+	// Rewrites procedure URIs.
+	rewriter *uriRewriter
+
+	// Closed when draining and there are no outstanding invocations.  New
+	// calls are refused while draining.
+	drained       chan struct{}
+	drainedClosed bool
+
+	// Resource limits, 0 for no limit.
+	maxRegistrations int
+	maxPendingCalls  int
+
 	log   stdlog.StdLog
 	debug bool
 }
@@ -123,6 +153,7 @@ func newDealer(logger stdlog.StdLog, strictURI, allowDisclose, debug bool) *deal
 		registrations: map[wamp.ID]*registration{},
 
 		calls:            map[requestID]*wamp.Session{},
+		pendingCalls:     &callCounter{},
 		invocations:      map[wamp.ID]*invocation{},
 		invocationByCall: map[requestID]wamp.ID{},
 		calleeRegIDSet:   map[*wamp.Session]map[wamp.ID]struct{}{},
@@ -132,8 +163,7 @@ func newDealer(logger stdlog.StdLog, strictURI, allowDisclose, debug bool) *deal
 		// channel is appropriate.
 		actionChan: make(chan func()),
 
-		idGen: new(wamp.IDGen),
-		prng:  rand.New(rand.NewSource(time.Now().Unix())),
+		prng: rand.New(rand.NewSource(time.Now().Unix())),
 
 		strictURI:     strictURI,
 		allowDisclose: allowDisclose,
@@ -152,6 +182,12 @@ func (d *dealer) setMetaPeer(metaPeer wamp.Peer) {
 	}
 }
 
+func (d *dealer) setBridge(bridge *wamp.Session) {
+	d.actionChan <- func() {
+		d.bridge = bridge
+	}
+}
+
 // role returns the role information for the "dealer" role.  The data returned
 // is suitable for use as broker role info in a WELCOME message.
 func (d *dealer) role() wamp.Dict {
@@ -164,6 +200,26 @@ func (d *dealer) role() wamp.Dict {
 // invocation policy, multiple callees may register to handle the same
 // procedure.
 func (d *dealer) register(callee *wamp.Session, msg *wamp.Register) {
+	req := d.checkRegister(callee, msg)
+	if req == nil {
+		return
+	}
+	d.routeRegister(callee, req, 0)
+}
+
+// registerRequest is a registration request that has been checked and is
+// ready to route.
+type registerRequest struct {
+	msg      *wamp.Register
+	match    string
+	invoke   string
+	disclose bool
+	wampURI  bool
+}
+
+// checkRegister validates and rewrites the registration request.  Returns nil
+// if the request is rejected, after sending an error to the callee.
+func (d *dealer) checkRegister(callee *wamp.Session, msg *wamp.Register) *registerRequest {
 	if callee == nil || msg == nil {
 		panic("dealer.Register with nil session or message")
 	}
@@ -183,7 +239,15 @@ func (d *dealer) register(callee *wamp.Session, msg *wamp.Register) {
 			Arguments: wamp.List{errMsg},
 			Details:   wamp.Dict{},
 		})
-		return
+		return nil
+	}
+
+	// Rewrite the procedure, if there is a rule for it, without modifying
+	// the callee's message.
+	if procedure, ok := d.rewriter.rewrite(msg.Procedure); ok {
+		rewritten := *msg
+		rewritten.Procedure = procedure
+		msg = &rewritten
 	}
 
 	wampURI := strings.HasPrefix(string(msg.Procedure), "wamp.")
@@ -200,7 +264,7 @@ func (d *dealer) register(callee *wamp.Session, msg *wamp.Register) {
 			Arguments: wamp.List{errMsg},
 			Details:   wamp.Dict{},
 		})
-		return
+		return nil
 	}
 
 	// If callee requests disclosure of caller identity, but dealer does not
@@ -218,15 +282,28 @@ func (d *dealer) register(callee *wamp.Session, msg *wamp.Register) {
 				Details: wamp.Dict{},
 				Error:   wamp.ErrOptionDisallowedDiscloseMe,
 			})
-			return
+			return nil
 		}
 	}
 
 	invoke, _ := wamp.AsString(msg.Options[wamp.OptInvoke])
+	return &registerRequest{
+		msg:      msg,
+		match:    match,
+		invoke:   invoke,
+		disclose: disclose,
+		wampURI:  wampURI,
+	}
+}
+
+// routeRegister registers the callee as requested.  otherRegs is the number
+// of the callee's registrations in other shards.
+func (d *dealer) routeRegister(callee *wamp.Session, req *registerRequest, otherRegs int) {
 	var metaPubs []*wamp.Publish
 	done := make(chan struct{})
 	d.actionChan <- func() {
-		metaPubs = d.syncRegister(callee, msg, match, invoke, disclose, wampURI)
+		metaPubs = d.syncRegister(callee, req.msg, req.match, req.invoke,
+			req.disclose, req.wampURI, otherRegs)
 		close(done)
 	}
 	<-done
@@ -255,12 +332,45 @@ func (d *dealer) unregister(callee *wamp.Session, msg *wamp.Unregister) {
 
 // call invokes a registered remote procedure.
 func (d *dealer) call(caller *wamp.Session, msg *wamp.Call) {
+	msg, origProc := d.checkCall(caller, msg)
+	if msg == nil {
+		return
+	}
+	d.actionChan <- func() {
+		d.syncCall(caller, msg, origProc)
+	}
+}
+
+// checkCall rewrites and validates the call.  Returns the call to route and
+// the procedure as called by the caller if rewritten, or nil if the call is
+// rejected, after sending an error to the caller.
+func (d *dealer) checkCall(caller *wamp.Session, msg *wamp.Call) (*wamp.Call, wamp.URI) {
 	if caller == nil || msg == nil {
 		panic("dealer.Call with nil session or message")
 	}
-	d.actionChan <- func() {
-		d.syncCall(caller, msg)
+	// Rewrite the procedure, if there is a rule for it, without modifying
+	// the caller's message.
+	var origProc wamp.URI
+	if procedure, ok := d.rewriter.rewrite(msg.Procedure); ok {
+		origProc = msg.Procedure
+		rewritten := *msg
+		rewritten.Procedure = procedure
+		msg = &rewritten
+	}
+	// Validate payload against any schema for the procedure.
+	if err := d.validator.validateArgs(msg.Procedure, msg.Arguments, msg.ArgumentsKw); err != nil {
+		d.log.Printf("Rejected %s to %s from session %s: %s",
+			msg.MessageType(), msg.Procedure, caller, err)
+		d.trySend(caller, &wamp.Error{
+			Type:      msg.MessageType(),
+			Request:   msg.Request,
+			Error:     wamp.ErrInvalidArgument,
+			Arguments: wamp.List{err.Error()},
+			Details:   wamp.Dict{},
+		})
+		return nil, ""
 	}
+	return msg, origProc
 }
 
 // cancel actively cancels a call that is in progress.
@@ -282,6 +392,18 @@ func (d *dealer) call(caller *wamp.Session, msg *wamp.Call) {
 //
 // If the callee does not support call canceling, then behavior is "skip".
 func (d *dealer) cancel(caller *wamp.Session, msg *wamp.Cancel) {
+	mode := d.cancelMode(caller, msg)
+	if mode == "" {
+		return
+	}
+	d.actionChan <- func() {
+		d.syncCancel(caller, msg, mode, wamp.ErrCanceled, nil)
+	}
+}
+
+// cancelMode returns the cancel mode of the CANCEL message, or "" if the mode
+// is invalid, after sending an error to the caller.
+func (d *dealer) cancelMode(caller *wamp.Session, msg *wamp.Cancel) string {
 	if caller == nil || msg == nil {
 		panic("dealer.Cancel with nil session or message")
 	}
@@ -299,11 +421,9 @@ func (d *dealer) cancel(caller *wamp.Session, msg *wamp.Cancel) {
 			Arguments: wamp.List{fmt.Sprint("invalid cancel mode ", mode)},
 			Details:   wamp.Dict{},
 		})
-		return
-	}
-	d.actionChan <- func() {
-		d.syncCancel(caller, msg, mode, wamp.ErrCanceled, nil)
+		return ""
 	}
+	return mode
 }
 
 // yield handles the result of successfully processing and finishing the
@@ -390,6 +510,23 @@ func (d *dealer) removeSession(sess *wamp.Session) {
 	}
 }
 
+// drain stops the dealer from accepting new calls, other than calls to meta
+// procedures.  The returned channel is closed when there are no outstanding
+// invocations.
+func (d *dealer) drain() <-chan struct{} {
+	var drained chan struct{}
+	sync := make(chan struct{})
+	d.actionChan <- func() {
+		if d.drained == nil {
+			d.drained = make(chan struct{})
+		}
+		drained = d.drained
+		close(sync)
+	}
+	<-sync
+	return drained
+}
+
 // close stops the dealer, letting already queued actions finish.
 func (d *dealer) close() {
 	close(d.actionChan)
@@ -398,13 +535,30 @@ func (d *dealer) close() {
 func (d *dealer) run() {
 	for action := range d.actionChan {
 		action()
+		if d.drained != nil && !d.drainedClosed && len(d.invocations) == 0 {
+			close(d.drained)
+			d.drainedClosed = true
+		}
 	}
 	if d.debug {
 		d.log.Print("Dealer stopped")
 	}
 }
 
-func (d *dealer) syncRegister(callee *wamp.Session, msg *wamp.Register, match, invokePolicy string, disclose, wampURI bool) []*wamp.Publish {
+func (d *dealer) syncRegister(callee *wamp.Session, msg *wamp.Register, match, invokePolicy string, disclose, wampURI bool, otherRegs int) []*wamp.Publish {
+	// Check registration limit.  The meta session is not limited.
+	if d.maxRegistrations > 0 && callee.ID != metaID && otherRegs+len(d.calleeRegIDSet[callee]) >= d.maxRegistrations {
+		d.log.Println("REGISTER for", msg.Procedure, "exceeds registration limit for callee", callee)
+		d.trySend(callee, &wamp.Error{
+			Type:      msg.MessageType(),
+			Request:   msg.Request,
+			Details:   wamp.Dict{},
+			Error:     wamp.ErrRegistrationLimitExceeded,
+			Arguments: wamp.List{fmt.Sprint("session has maximum of ", d.maxRegistrations, " registrations")},
+		})
+		return nil
+	}
+
 	var metaPubs []*wamp.Publish
 	var reg *registration
 	switch match {
@@ -438,8 +592,10 @@ func (d *dealer) syncRegister(callee *wamp.Session, msg *wamp.Register, match, i
 			d.procRegMap[msg.Procedure] = reg
 		case wamp.MatchPrefix:
 			d.pfxProcRegMap[msg.Procedure] = reg
+			d.pfxProcTrie.add(msg.Procedure)
 		case wamp.MatchWildcard:
 			d.wcProcRegMap[msg.Procedure] = reg
+			d.wcProcTrie.add(msg.Procedure)
 		}
 
 		if !wampURI && d.metaPeer != nil {
@@ -591,15 +747,13 @@ func (d *dealer) syncMatchProcedure(procedure wamp.URI) (*registration, bool) {
 		// match, and prefer the most specific math (longest matched pattern).
 		// If there is a tie, then prefer the first longest prefix.
 		matchCount := -1 // initialize matchCount to -1 to catch an empty registration.
-		for pfxProc, pfxReg := range d.pfxProcRegMap {
-			if procedure.PrefixMatch(pfxProc) {
-				if len(pfxProc) > matchCount {
-					reg = pfxReg
-					matchCount = len(pfxProc)
-					ok = true
-				}
+		d.pfxProcTrie.match(procedure, func(pfxProc wamp.URI) {
+			if len(pfxProc) > matchCount {
+				reg = d.pfxProcRegMap[pfxProc]
+				matchCount = len(pfxProc)
+				ok = true
 			}
-		}
+		})
 		// According to the spec, we have to prefer prefix match over wildcard
 		// match:
 		// https://wamp-proto.org/static/rfc/draft-oberstet-hybi-crossbar-wamp.html#rfc.section.14.3.8.1.4.2
@@ -607,20 +761,18 @@ func (d *dealer) syncMatchProcedure(procedure wamp.URI) (*registration, bool) {
 			return reg, ok
 		}
 
-		for wcProc, wcReg := range d.wcProcRegMap {
-			if procedure.WildcardMatch(wcProc) {
-				if len(wcProc) > matchCount {
-					reg = wcReg
-					matchCount = len(wcProc)
-					ok = true
-				}
+		d.wcProcTrie.match(procedure, func(wcProc wamp.URI) {
+			if len(wcProc) > matchCount {
+				reg = d.wcProcRegMap[wcProc]
+				matchCount = len(wcProc)
+				ok = true
 			}
-		}
+		})
 	}
 	return reg, ok
 }
 
-func (d *dealer) syncCall(caller *wamp.Session, msg *wamp.Call) {
+func (d *dealer) syncCall(caller *wamp.Session, msg *wamp.Call, origProc wamp.URI) {
 	reg, ok := d.syncMatchProcedure(msg.Procedure)
 	if !ok || len(reg.callees) == 0 {
 		// If no registered procedure, send error.
@@ -633,6 +785,30 @@ func (d *dealer) syncCall(caller *wamp.Session, msg *wamp.Call) {
 		return
 	}
 
+	// If draining, then only allow calls to meta procedures.
+	if d.drained != nil && reg.callees[0].ID != metaID {
+		d.trySend(caller, &wamp.Error{
+			Type:      msg.MessageType(),
+			Request:   msg.Request,
+			Details:   wamp.Dict{},
+			Error:     wamp.ErrSystemShutdown,
+			Arguments: wamp.List{"router is shutting down"},
+		})
+		return
+	}
+
+	// Check pending call limit.  Calls to meta procedures are not limited.
+	if d.maxPendingCalls > 0 && reg.callees[0].ID != metaID && d.pendingCalls.count(caller.ID) >= d.maxPendingCalls {
+		d.trySend(caller, &wamp.Error{
+			Type:      msg.MessageType(),
+			Request:   msg.Request,
+			Details:   wamp.Dict{},
+			Error:     wamp.ErrPendingCallLimitExceeded,
+			Arguments: wamp.List{fmt.Sprint("caller has maximum of ", d.maxPendingCalls, " pending calls")},
+		})
+		return
+	}
+
 	var callee *wamp.Session
 
 	// If there are multiple callees, then select a callee based invocation
@@ -661,6 +837,19 @@ func (d *dealer) syncCall(caller *wamp.Session, msg *wamp.Call) {
 	} else {
 		callee = reg.callees[0]
 	}
+
+	// A call forwarded by a bridge is not forwarded by another bridge, to
+	// prevent forwarding loops.
+	if caller == d.bridge && callee == d.bridge {
+		d.trySend(caller, &wamp.Error{
+			Type:      msg.MessageType(),
+			Request:   msg.Request,
+			Details:   wamp.Dict{},
+			Error:     wamp.ErrNoSuchProcedure,
+			Arguments: wamp.List{"bridged call cannot be bridged again"},
+		})
+		return
+	}
 	details := wamp.Dict{}
 
 	// A Caller might want to issue a call providing a timeout for the call to
@@ -685,8 +874,10 @@ func (d *dealer) syncCall(caller *wamp.Session, msg *wamp.Call) {
 	if reg.disclose {
 		if callee.ID == metaID {
 			details[wamp.RoleCaller] = caller.ID
+			discloseCaller(caller, details)
+		} else {
+			d.discloseCallerOf(caller, msg, details)
 		}
-		discloseCaller(caller, details)
 	} else {
 		// A Caller MAY request the disclosure of its identity (its WAMP
 		// session ID) to endpoints of a routed call.  This is indicated by the
@@ -704,7 +895,7 @@ func (d *dealer) syncCall(caller *wamp.Session, msg *wamp.Call) {
 				return
 			}
 			if callee.HasFeature(wamp.RoleCallee, wamp.FeatureCallerIdent) {
-				discloseCaller(caller, details)
+				d.discloseCallerOf(caller, msg, details)
 			}
 		}
 	}
@@ -728,16 +919,25 @@ func (d *dealer) syncCall(caller *wamp.Session, msg *wamp.Call) {
 		// the client.
 		details[wamp.OptProcedure] = msg.Procedure
 	}
+	// If the procedure was rewritten, then supply the procedure as provided
+	// by the caller.
+	if origProc != "" {
+		details[wamp.DetailOriginalProcedure] = origProc
+	}
 
 	reqID := requestID{
 		session: caller.ID,
 		request: msg.Request,
 	}
-	d.calls[reqID] = caller
+	d.syncAddCall(reqID, caller)
 	invocationID := d.idGen.Next()
 	invk := &invocation{
-		callID: reqID,
-		callee: callee,
+		callID:       reqID,
+		callee:       callee,
+		procedure:    msg.Procedure,
+		registration: reg.id,
+		started:      time.Now(),
+		timeout:      timeout,
 	}
 	d.invocations[invocationID] = invk
 	d.invocationByCall[reqID] = invocationID
@@ -861,7 +1061,7 @@ func (d *dealer) syncCancel(caller *wamp.Session, msg *wamp.Cancel, mode string,
 	// callee to be dropped.
 	//
 	// This also stops repeated CANCEL messages.
-	delete(d.calls, reqID)
+	d.syncDelCall(reqID)
 	delete(d.invocationByCall, reqID)
 	delete(d.invocations, invocationID)
 
@@ -914,6 +1114,29 @@ func (d *dealer) syncYield(callee *wamp.Session, msg *wamp.Yield, progress, canR
 		return false
 	}
 
+	// Validate result payload against any schema for the called procedure.
+	// A non-conforming result ends the call with an error to the caller.
+	if err := d.validator.validateResult(invk.procedure, msg.Arguments, msg.ArgumentsKw); err != nil {
+		d.log.Printf("Rejected %s for %s from session %s: %s",
+			msg.MessageType(), invk.procedure, callee, err)
+		// Stop the callee from sending more progressive results for the
+		// call that is ended.
+		if progress {
+			d.trySend(callee, &wamp.Interrupt{
+				Request: msg.Request,
+				Options: wamp.Dict{wamp.OptMode: wamp.CancelModeKillNoWait},
+			})
+		}
+		d.syncError(&wamp.Error{
+			Type:      wamp.INVOCATION,
+			Request:   msg.Request,
+			Details:   wamp.Dict{},
+			Error:     wamp.ErrInvalidArgument,
+			Arguments: wamp.List{err.Error()},
+		})
+		return false
+	}
+
 	callID := invk.callID
 	// Find caller for this result.
 	caller, ok := d.calls[callID]
@@ -939,7 +1162,7 @@ func (d *dealer) syncYield(callee *wamp.Session, msg *wamp.Yield, progress, canR
 			// Delete callID -> invocation.
 			delete(d.invocationByCall, callID)
 			// Delete pending call since it is finished.
-			delete(d.calls, callID)
+			d.syncDelCall(callID)
 		}()
 	}
 
@@ -955,12 +1178,20 @@ func (d *dealer) syncYield(callee *wamp.Session, msg *wamp.Yield, progress, canR
 	// callee wait and retry sending this message again.  The caller may be
 	// blocked when the callee is generating progressive responses faster than
 	// the caller can handle them.
-	res := &wamp.Result{
+	var res wamp.Message = &wamp.Result{
 		Request:     callID.request,
 		Details:     details,
 		Arguments:   msg.Arguments,
 		ArgumentsKw: msg.ArgumentsKw,
 	}
+	if res = d.outbound.interceptOutbound(caller, res); res == nil {
+		// Dropped by interceptor.  A dropped progressive result does not
+		// end the call.
+		if !progress {
+			d.sendCallDropped(caller, callID.request, wamp.RESULT)
+		}
+		return false
+	}
 	err := caller.TrySend(res)
 	if err != nil {
 		if canRetry {
@@ -1002,17 +1233,42 @@ func (d *dealer) syncError(msg *wamp.Error) {
 			callID)
 		return
 	}
-	delete(d.calls, callID)
+	d.syncDelCall(callID)
 
 	// Send error to the caller.
-	d.trySend(caller, &wamp.Error{
+	var errMsg wamp.Message = &wamp.Error{
 		Type:        wamp.CALL,
 		Request:     callID.request,
 		Error:       msg.Error,
 		Details:     msg.Details,
 		Arguments:   msg.Arguments,
 		ArgumentsKw: msg.ArgumentsKw,
+	}
+	if errMsg = d.outbound.interceptOutbound(caller, errMsg); errMsg == nil {
+		d.sendCallDropped(caller, callID.request, wamp.ERROR)
+		return
+	}
+	if err := caller.TrySend(errMsg); err != nil {
+		d.log.Printf("!!! Dropped %s to session %s: %s", errMsg.MessageType(), caller, err)
+	}
+}
+
+// sendCallDropped sends an ERROR to a caller whose final RESULT or ERROR was
+// dropped by an outbound interceptor, since the caller would otherwise wait
+// for the call to finish forever.  The ERROR is not intercepted.
+func (d *dealer) sendCallDropped(caller *wamp.Session, request wamp.ID, msgType wamp.MessageType) {
+	d.log.Println("Interceptor dropped", msgType, "for call", request,
+		"to caller", caller)
+	err := caller.TrySend(&wamp.Error{
+		Type:      wamp.CALL,
+		Request:   request,
+		Details:   wamp.Dict{},
+		Error:     wamp.ErrCanceled,
+		Arguments: wamp.List{"call " + msgType.String() + " dropped by router"},
 	})
+	if err != nil {
+		d.log.Printf("!!! Dropped ERROR to session %s: %s", caller, err)
+	}
 }
 
 func (d *dealer) syncRemoveSession(sess *wamp.Session) []*wamp.Publish {
@@ -1083,7 +1339,7 @@ func (d *dealer) syncRemoveSession(sess *wamp.Session) []*wamp.Publish {
 			continue
 		}
 		// Removed session has pending call.
-		delete(d.calls, req)
+		d.syncDelCall(req)
 
 		// If there is a pending invocation for the call, remove it.
 		if invkID, ok := d.invocationByCall[req]; ok {
@@ -1100,6 +1356,23 @@ func (d *dealer) syncRemoveSession(sess *wamp.Session) []*wamp.Publish {
 	return metaPubs
 }
 
+// syncAddCall adds a pending call for the caller.
+func (d *dealer) syncAddCall(reqID requestID, caller *wamp.Session) {
+	if _, ok := d.calls[reqID]; !ok {
+		d.pendingCalls.add(reqID.session)
+	}
+	d.calls[reqID] = caller
+}
+
+// syncDelCall deletes a pending call, if the call is still pending.
+func (d *dealer) syncDelCall(reqID requestID) {
+	if _, ok := d.calls[reqID]; !ok {
+		return
+	}
+	delete(d.calls, reqID)
+	d.pendingCalls.remove(reqID.session)
+}
+
 // syncDelCalleeReg deletes the the callee from the specified registration and
 // deletes the registration from the set of registrations for the callee.
 //
@@ -1139,8 +1412,10 @@ func (d *dealer) syncDelCalleeReg(callee *wamp.Session, regID wamp.ID) (bool, er
 			delete(d.procRegMap, reg.procedure)
 		case wamp.MatchPrefix:
 			delete(d.pfxProcRegMap, reg.procedure)
+			d.pfxProcTrie.remove(reg.procedure)
 		case wamp.MatchWildcard:
 			delete(d.wcProcRegMap, reg.procedure)
+			d.wcProcTrie.remove(reg.procedure)
 		}
 		if d.debug {
 			d.log.Printf("Deleted registration %v for procedure %v", regID,
@@ -1155,7 +1430,21 @@ func (d *dealer) syncDelCalleeReg(callee *wamp.Session, regID wamp.ID) (bool, er
 
 // regList retrieves registration IDs listed according to match policies.
 func (d *dealer) regList(msg *wamp.Invocation) wamp.Message {
-	var exactRegs, pfxRegs, wcRegs []wamp.ID
+	exactRegs, pfxRegs, wcRegs := d.listRegIDs(nil, nil, nil)
+	dict := wamp.Dict{
+		wamp.MatchExact:    exactRegs,
+		wamp.MatchPrefix:   pfxRegs,
+		wamp.MatchWildcard: wcRegs,
+	}
+	return &wamp.Yield{
+		Request:   msg.Request,
+		Arguments: wamp.List{dict},
+	}
+}
+
+// listRegIDs appends the IDs of the dealer's registrations, by match policy,
+// to the given lists.
+func (d *dealer) listRegIDs(exactRegs, pfxRegs, wcRegs []wamp.ID) ([]wamp.ID, []wamp.ID, []wamp.ID) {
 	sync := make(chan struct{})
 	d.actionChan <- func() {
 		for _, reg := range d.procRegMap {
@@ -1170,15 +1459,7 @@ func (d *dealer) regList(msg *wamp.Invocation) wamp.Message {
 		close(sync)
 	}
 	<-sync
-	dict := wamp.Dict{
-		wamp.MatchExact:    exactRegs,
-		wamp.MatchPrefix:   pfxRegs,
-		wamp.MatchWildcard: wcRegs,
-	}
-	return &wamp.Yield{
-		Request:   msg.Request,
-		Arguments: wamp.List{dict},
-	}
+	return exactRegs, pfxRegs, wcRegs
 }
 
 // regLookup obtains the registration (if any) managing a procedure, according
@@ -1187,6 +1468,7 @@ func (d *dealer) regLookup(msg *wamp.Invocation) wamp.Message {
 	var regID wamp.ID
 	if len(msg.Arguments) != 0 {
 		if procedure, ok := wamp.AsURI(msg.Arguments[0]); ok {
+			procedure = d.rewriter.rewriteURI(procedure)
 			var match string
 			if len(msg.Arguments) > 1 {
 				if opts, ok := wamp.AsDict(msg.Arguments[1]); ok {
@@ -1225,15 +1507,7 @@ func (d *dealer) regMatch(msg *wamp.Invocation) wamp.Message {
 	var regID wamp.ID
 	if len(msg.Arguments) != 0 {
 		if procedure, ok := wamp.AsURI(msg.Arguments[0]); ok {
-			sync := make(chan wamp.ID)
-			d.actionChan <- func() {
-				var r wamp.ID
-				if reg, ok := d.syncMatchProcedure(procedure); ok {
-					r = reg.id
-				}
-				sync <- r
-			}
-			regID = <-sync
+			regID = d.matchRegID(d.rewriter.rewriteURI(procedure))
 		}
 	}
 	return &wamp.Yield{
@@ -1242,6 +1516,20 @@ func (d *dealer) regMatch(msg *wamp.Invocation) wamp.Message {
 	}
 }
 
+// matchRegID returns the ID of the dealer's registration best matching the
+// procedure, or 0 if there is no matching registration.
+func (d *dealer) matchRegID(procedure wamp.URI) wamp.ID {
+	sync := make(chan wamp.ID)
+	d.actionChan <- func() {
+		var r wamp.ID
+		if reg, ok := d.syncMatchProcedure(procedure); ok {
+			r = reg.id
+		}
+		sync <- r
+	}
+	return <-sync
+}
+
 // regGet retrieves information on a particular registration.
 func (d *dealer) regGet(msg *wamp.Invocation) wamp.Message {
 	var dict wamp.Dict
@@ -1345,6 +1633,10 @@ func (d *dealer) regCountCallees(msg *wamp.Invocation) wamp.Message {
 }
 
 func (d *dealer) trySend(sess *wamp.Session, msg wamp.Message) bool {
+	if msg = d.outbound.interceptOutbound(sess, msg); msg == nil {
+		// Dropped by interceptor.
+		return true
+	}
 	if err := sess.TrySend(msg); err != nil {
 		d.log.Printf("!!! Dropped %s to session %s: %s", msg.MessageType(), sess, err)
 		return false
@@ -1352,6 +1644,21 @@ func (d *dealer) trySend(sess *wamp.Session, msg wamp.Message) bool {
 	return true
 }
 
+// discloseCallerOf adds the identity of the call's caller to
+// INVOCATION.Details.  For a call forwarded by a bridge, this is the identity
+// of the caller whose call the bridge forwarded.
+func (d *dealer) discloseCallerOf(caller *wamp.Session, msg *wamp.Call, details wamp.Dict) {
+	if caller == d.bridge {
+		if identity, ok := wamp.AsDict(msg.Options[optBridgeCaller]); ok {
+			for k, v := range identity {
+				details[k] = v
+			}
+			return
+		}
+	}
+	discloseCaller(caller, details)
+}
+
 // discloseCaller adds caller identity information to INVOCATION.Details.
 func discloseCaller(caller *wamp.Session, details wamp.Dict) {
 	details[wamp.RoleCaller] = caller.ID
@@ -0,0 +1,327 @@
+package router
+
+import (
+	"sync"
+
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// dealerShards routes the calls and registrations of a realm using one or
+// more dealer shards.  With a single shard, all messages are handled by that
+// dealer as if it were not sharded.
+type dealerShards struct {
+	// All shards.  When there is more than one shard, the last is the
+	// pattern shard.
+	shards []*dealer
+	// Shards for registrations with exact matching, selected by procedure.
+	exact []*dealer
+	// Shard for registrations with pattern matching.
+	pattern *dealer
+
+	maxRegistrations int
+	maxPendingCalls  int
+	// Held while checking the registration limit and registering, so that
+	// concurrent registers for a session cannot exceed the limit.
+	limitLock sync.Mutex
+}
+
+// newDealerShards creates n shards for registrations with exact matching,
+// calling newShard to create each, and a separate shard for registrations
+// with pattern matching if n is greater than one.
+func newDealerShards(n int, newShard func() *dealer) *dealerShards {
+	if n <= 1 {
+		d := newShard()
+		return &dealerShards{
+			shards:           []*dealer{d},
+			exact:            []*dealer{d},
+			pattern:          d,
+			maxRegistrations: d.maxRegistrations,
+			maxPendingCalls:  d.maxPendingCalls,
+		}
+	}
+	shards := make([]*dealer, n+1)
+	pendingCalls := &callCounter{}
+	for i := range shards {
+		d := newShard()
+		d.idGen = shardIDGen{shard: int64(i), shards: int64(len(shards))}
+		d.pendingCalls = pendingCalls
+		shards[i] = d
+	}
+	return &dealerShards{
+		shards:           shards,
+		exact:            shards[:n],
+		pattern:          shards[n],
+		maxRegistrations: shards[0].maxRegistrations,
+		maxPendingCalls:  shards[0].maxPendingCalls,
+	}
+}
+
+// role returns the role information for the "dealer" role.
+func (s *dealerShards) role() wamp.Dict {
+	return dealerRole
+}
+
+// setMetaPeer sets the client that the dealer shards use to publish meta
+// events.
+func (s *dealerShards) setMetaPeer(metaPeer wamp.Peer) {
+	for _, d := range s.shards {
+		d.setMetaPeer(metaPeer)
+	}
+}
+
+func (s *dealerShards) setBridge(bridge *wamp.Session) {
+	for _, d := range s.shards {
+		d.setBridge(bridge)
+	}
+}
+
+// procedureShard returns the shard for registrations of the procedure with
+// the match policy.
+func (s *dealerShards) procedureShard(procedure wamp.URI, match string) *dealer {
+	if match == wamp.MatchPrefix || match == wamp.MatchWildcard {
+		return s.pattern
+	}
+	return s.exact[shardOfURI(procedure, len(s.exact))]
+}
+
+// idShard returns the shard that generated the ID.
+func (s *dealerShards) idShard(id wamp.ID) *dealer {
+	return s.shards[shardOfID(id, len(s.shards))]
+}
+
+// argShard returns the shard that generated the ID in the first argument of
+// the meta procedure call, or the pattern shard if there is no ID.
+func (s *dealerShards) argShard(msg *wamp.Invocation) *dealer {
+	if len(msg.Arguments) != 0 {
+		if id, ok := wamp.AsID(msg.Arguments[0]); ok {
+			return s.idShard(id)
+		}
+	}
+	return s.pattern
+}
+
+// register registers a callee to handle calls to a procedure, in the shard of
+// the procedure.
+func (s *dealerShards) register(callee *wamp.Session, msg *wamp.Register) {
+	if len(s.shards) == 1 {
+		s.pattern.register(callee, msg)
+		return
+	}
+	req := s.pattern.checkRegister(callee, msg)
+	if req == nil {
+		return
+	}
+	d := s.procedureShard(req.msg.Procedure, req.match)
+
+	// The registration limit applies to the registrations in all shards.
+	var otherRegs int
+	if s.maxRegistrations > 0 && callee.ID != metaID {
+		s.limitLock.Lock()
+		defer s.limitLock.Unlock()
+		for _, other := range s.shards {
+			if other != d {
+				regs, _ := other.quotaUsage(callee)
+				otherRegs += regs
+			}
+		}
+	}
+	d.routeRegister(callee, req, otherRegs)
+}
+
+// unregister removes a procedure registration, in the shard that has the
+// registration.
+func (s *dealerShards) unregister(callee *wamp.Session, msg *wamp.Unregister) {
+	s.idShard(msg.Registration).unregister(callee, msg)
+}
+
+// call invokes a registered procedure.  The call is routed by the shard of the
+// procedure if the procedure has a registration with exact matching, and
+// otherwise by the pattern shard.  The caller waits for the call to be routed,
+// so that invocations from the caller stay in order.
+func (s *dealerShards) call(caller *wamp.Session, msg *wamp.Call) {
+	if len(s.shards) == 1 {
+		s.pattern.call(caller, msg)
+		return
+	}
+	msg, origProc := s.pattern.checkCall(caller, msg)
+	if msg == nil {
+		return
+	}
+	d := s.procedureShard(msg.Procedure, wamp.MatchExact)
+	var routed bool
+	runSync(d.actionChan, func() {
+		if _, ok := d.procRegMap[msg.Procedure]; ok {
+			d.syncCall(caller, msg, origProc)
+			routed = true
+		}
+	})
+	if !routed {
+		runSync(s.pattern.actionChan, func() {
+			s.pattern.syncCall(caller, msg, origProc)
+		})
+	}
+}
+
+// cancel cancels a call that is in progress, in whichever shard has the call.
+func (s *dealerShards) cancel(caller *wamp.Session, msg *wamp.Cancel) {
+	if len(s.shards) == 1 {
+		s.pattern.cancel(caller, msg)
+		return
+	}
+	mode := s.pattern.cancelMode(caller, msg)
+	if mode == "" {
+		return
+	}
+	for _, d := range s.shards {
+		d := d
+		d.actionChan <- func() {
+			d.syncCancel(caller, msg, mode, wamp.ErrCanceled, nil)
+		}
+	}
+}
+
+// yield handles the result of a call, in the shard that has the invocation.
+func (s *dealerShards) yield(callee *wamp.Session, msg *wamp.Yield) {
+	s.idShard(msg.Request).yield(callee, msg)
+}
+
+// error handles an invocation error returned by the callee, in the shard that
+// has the invocation.
+func (s *dealerShards) error(msg *wamp.Error) {
+	s.idShard(msg.Request).error(msg)
+}
+
+// removeSession removes the session's registrations and calls from all
+// shards.
+func (s *dealerShards) removeSession(sess *wamp.Session) {
+	for _, d := range s.shards {
+		d.removeSession(sess)
+	}
+}
+
+// drain stops all shards from accepting new calls, other than calls to meta
+// procedures.  The returned channels are closed when there are no
+// outstanding invocations in each shard.
+func (s *dealerShards) drain() []<-chan struct{} {
+	drained := make([]<-chan struct{}, len(s.shards))
+	for i, d := range s.shards {
+		drained[i] = d.drain()
+	}
+	return drained
+}
+
+// close stops all shards.
+func (s *dealerShards) close() {
+	for _, d := range s.shards {
+		d.close()
+	}
+}
+
+// quotaUsage returns the number of registrations and pending calls of the
+// session.
+func (s *dealerShards) quotaUsage(sess *wamp.Session) (regs, calls int) {
+	for _, d := range s.shards {
+		r, c := d.quotaUsage(sess)
+		regs += r
+		// Pending calls are counted by all shards together.
+		calls = c
+	}
+	return
+}
+
+// ----- Meta Procedure Handlers -----
+
+// regList retrieves registration IDs listed according to match policies.
+func (s *dealerShards) regList(msg *wamp.Invocation) wamp.Message {
+	var exactRegs, pfxRegs, wcRegs []wamp.ID
+	for _, d := range s.shards {
+		exactRegs, pfxRegs, wcRegs = d.listRegIDs(exactRegs, pfxRegs, wcRegs)
+	}
+	dict := wamp.Dict{
+		wamp.MatchExact:    exactRegs,
+		wamp.MatchPrefix:   pfxRegs,
+		wamp.MatchWildcard: wcRegs,
+	}
+	return &wamp.Yield{
+		Request:   msg.Request,
+		Arguments: wamp.List{dict},
+	}
+}
+
+// regLookup obtains the registration (if any) managing a procedure, according
+// to some match policy.
+func (s *dealerShards) regLookup(msg *wamp.Invocation) wamp.Message {
+	d := s.pattern
+	if len(msg.Arguments) != 0 {
+		if procedure, ok := wamp.AsURI(msg.Arguments[0]); ok {
+			var match string
+			if len(msg.Arguments) > 1 {
+				if opts, ok := wamp.AsDict(msg.Arguments[1]); ok {
+					match, _ = wamp.AsString(opts[wamp.OptMatch])
+				}
+			}
+			d = s.procedureShard(d.rewriter.rewriteURI(procedure), match)
+		}
+	}
+	return d.regLookup(msg)
+}
+
+// regMatch obtains the registration best matching a given procedure URI.  A
+// registration with exact matching is preferred over one with pattern
+// matching.
+func (s *dealerShards) regMatch(msg *wamp.Invocation) wamp.Message {
+	var regID wamp.ID
+	if len(msg.Arguments) != 0 {
+		if procedure, ok := wamp.AsURI(msg.Arguments[0]); ok {
+			procedure = s.pattern.rewriter.rewriteURI(procedure)
+			d := s.procedureShard(procedure, wamp.MatchExact)
+			regID = d.matchRegID(procedure)
+			if regID == 0 && d != s.pattern {
+				regID = s.pattern.matchRegID(procedure)
+			}
+		}
+	}
+	return &wamp.Yield{
+		Request:   msg.Request,
+		Arguments: wamp.List{regID},
+	}
+}
+
+// regGet retrieves information on a particular registration.
+func (s *dealerShards) regGet(msg *wamp.Invocation) wamp.Message {
+	return s.argShard(msg).regGet(msg)
+}
+
+// regListCallees retrieves a list of session IDs for sessions currently
+// attached to the registration.
+func (s *dealerShards) regListCallees(msg *wamp.Invocation) wamp.Message {
+	return s.argShard(msg).regListCallees(msg)
+}
+
+// regCountCallees obtains the number of sessions currently attached to the
+// registration.
+func (s *dealerShards) regCountCallees(msg *wamp.Invocation) wamp.Message {
+	return s.argShard(msg).regCountCallees(msg)
+}
+
+// callList retrieves information on all calls currently waiting for a result.
+func (s *dealerShards) callList(msg *wamp.Invocation) wamp.Message {
+	calls := wamp.List{}
+	for _, d := range s.shards {
+		calls = d.listCalls(calls)
+	}
+	return &wamp.Yield{
+		Request:   msg.Request,
+		Arguments: wamp.List{calls},
+	}
+}
+
+// callGet retrieves information on a call waiting for a result.
+func (s *dealerShards) callGet(msg *wamp.Invocation) wamp.Message {
+	return s.argShard(msg).callGet(msg)
+}
+
+// callCancel cancels a call waiting for a result.
+func (s *dealerShards) callCancel(msg *wamp.Invocation) wamp.Message {
+	return s.argShard(msg).callCancel(msg)
+}
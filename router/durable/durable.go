@@ -0,0 +1,73 @@
+// Package durable provides storage for the events of durable subscriptions.
+//
+// Events are kept in named queues.  Each event appended to a queue is assigned
+// the next sequence number in that queue, and events are removed from the
+// front of the queue when acknowledged by the subscriber or when the queue
+// exceeds its size or age limit.
+package durable
+
+import (
+	"time"
+
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// Event is an event stored for a durable subscription.
+type Event struct {
+	// Sequence number of the event in its queue.  This is assigned by the
+	// Store when the event is appended.
+	Seq uint64 `json:"seq"`
+	// When the event was published.
+	Time        time.Time `json:"time"`
+	Publication wamp.ID   `json:"publication"`
+	Details     wamp.Dict `json:"details,omitempty"`
+	Arguments   wamp.List `json:"args,omitempty"`
+	ArgumentsKw wamp.Dict `json:"kwargs,omitempty"`
+}
+
+// Store persists the events of durable subscriptions.  A Store must be safe
+// for concurrent use, since it may be shared by the brokers of several realms.
+// The broker calls the Store as it routes events, so a slow Store delays the
+// routing of other messages.
+type Store interface {
+	// Append adds the event to the end of the queue, creating the queue if it
+	// does not exist, and sets the event's sequence number.
+	Append(queue string, event *Event) error
+
+	// Events returns the events in the queue, in order, that have a sequence
+	// number greater than after.
+	Events(queue string, after uint64) ([]*Event, error)
+
+	// Remove removes the events in the queue that have a sequence number less
+	// than or equal to seq.
+	Remove(queue string, seq uint64) error
+
+	// Trim removes events from the front of the queue so that the queue has
+	// no more than maxEvents events, and has no events published before the
+	// given time.  A maxEvents of 0 or a zero time means no limit.
+	Trim(queue string, maxEvents int, before time.Time) error
+
+	// Delete deletes the queue and all of its events.
+	Delete(queue string) error
+
+	// Close closes the store.
+	Close() error
+}
+
+// trimSeq returns the highest sequence number of the events that Trim must
+// remove from the ordered events, or 0 if no events are to be removed.
+func trimSeq(events []*Event, maxEvents int, before time.Time) uint64 {
+	var n int
+	if maxEvents > 0 && len(events) > maxEvents {
+		n = len(events) - maxEvents
+	}
+	if !before.IsZero() {
+		for n < len(events) && events[n].Time.Before(before) {
+			n++
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	return events[n-1].Seq
+}
@@ -0,0 +1,326 @@
+package durable
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	queueFileExt = ".queue"
+
+	// A queue file is compacted when it has more than this number of
+	// records that are not stored events, and more of these records than
+	// events.
+	compactThreshold = 256
+)
+
+// record is a line in a queue file.  A record either stores an event, or
+// records that all events through a sequence number have been removed.
+type record struct {
+	Event   *Event `json:"event,omitempty"`
+	Removed uint64 `json:"removed,omitempty"`
+}
+
+// fileQueue is a queue that is loaded from, and appended to, a queue file.
+type fileQueue struct {
+	file    *os.File
+	events  []*Event
+	nextSeq uint64
+	// Sequence number through which events have been removed.
+	removed uint64
+	// Number of records in the file that do not store a current event.
+	garbage int
+}
+
+// FileStore is a Store that keeps each queue in a file in a directory.  Each
+// queue is loaded into memory when first used, and changes are appended to
+// the queue's file.
+//
+// Event values are stored as JSON, so they are received by subscribers as
+// they would be after JSON serialization.
+type FileStore struct {
+	dir    string
+	mu     sync.Mutex
+	queues map[string]*fileQueue
+	closed bool
+}
+
+// NewFileStore returns a FileStore that stores queues in the directory,
+// creating the directory if it does not exist.
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &FileStore{
+		dir:    dir,
+		queues: map[string]*fileQueue{},
+	}, nil
+}
+
+// Append adds the event to the end of the queue and sets the event's
+// sequence number.
+func (s *FileStore) Append(queue string, event *Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q, err := s.queue(queue)
+	if err != nil {
+		return err
+	}
+	event.Seq = q.nextSeq
+	if err = q.write(&record{Event: event}); err != nil {
+		return err
+	}
+	q.nextSeq++
+	q.events = append(q.events, event)
+	return nil
+}
+
+// Events returns the events in the queue that have a sequence number greater
+// than after.
+func (s *FileStore) Events(queue string, after uint64) ([]*Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q, err := s.queue(queue)
+	if err != nil {
+		return nil, err
+	}
+	var events []*Event
+	for _, ev := range q.events {
+		if ev.Seq > after {
+			events = append(events, ev)
+		}
+	}
+	return events, nil
+}
+
+// Remove removes the events in the queue that have a sequence number less
+// than or equal to seq.
+func (s *FileStore) Remove(queue string, seq uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q, err := s.queue(queue)
+	if err != nil {
+		return err
+	}
+	return s.remove(queue, q, seq)
+}
+
+// Trim removes events from the front of the queue to keep the queue within
+// the size and age limits.
+func (s *FileStore) Trim(queue string, maxEvents int, before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q, err := s.queue(queue)
+	if err != nil {
+		return err
+	}
+	seq := trimSeq(q.events, maxEvents, before)
+	if seq == 0 {
+		return nil
+	}
+	return s.remove(queue, q, seq)
+}
+
+// Delete deletes the queue and its file.
+func (s *FileStore) Delete(queue string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errors.New("store closed")
+	}
+	if q, ok := s.queues[queue]; ok {
+		q.file.Close()
+		delete(s.queues, queue)
+	}
+	err := os.Remove(s.path(queue))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Close closes the files of all loaded queues.
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	var err error
+	for _, q := range s.queues {
+		if cerr := q.file.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	s.queues = nil
+	return err
+}
+
+// path returns the path of the queue's file.  The queue name is hex encoded
+// so that any name can be used.
+func (s *FileStore) path(queue string) string {
+	return filepath.Join(s.dir, hex.EncodeToString([]byte(queue))+queueFileExt)
+}
+
+// queue returns the loaded queue, loading it from its file if necessary.  The
+// caller must hold the lock.
+func (s *FileStore) queue(queue string) (*fileQueue, error) {
+	if s.closed {
+		return nil, errors.New("store closed")
+	}
+	if q, ok := s.queues[queue]; ok {
+		return q, nil
+	}
+	q, err := loadQueue(s.path(queue))
+	if err != nil {
+		return nil, err
+	}
+	s.queues[queue] = q
+	return q, nil
+}
+
+// remove removes events through seq, and compacts the queue's file if it
+// has too many records that do not store events.  The caller must hold the
+// lock.
+func (s *FileStore) remove(queue string, q *fileQueue, seq uint64) error {
+	if seq >= q.nextSeq {
+		seq = q.nextSeq - 1
+	}
+	if seq <= q.removed {
+		return nil
+	}
+	if err := q.write(&record{Removed: seq}); err != nil {
+		return err
+	}
+	q.removed = seq
+	var n int
+	for n < len(q.events) && q.events[n].Seq <= seq {
+		n++
+	}
+	q.events = q.events[n:]
+	q.garbage += n + 1
+
+	if q.garbage > compactThreshold && q.garbage > len(q.events) {
+		return q.compact(s.path(queue))
+	}
+	return nil
+}
+
+// loadQueue reads the queue from its file, and opens the file for appending.
+func loadQueue(path string) (*fileQueue, error) {
+	q := &fileQueue{nextSeq: 1}
+	f, err := os.Open(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if f != nil {
+		err = q.read(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("cannot read queue file %s: %s", path, err)
+		}
+	}
+	if q.file, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// read reads the records of a queue file.
+func (q *fileQueue) read(f *os.File) error {
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var rec record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return err
+		}
+		if rec.Event != nil {
+			q.events = append(q.events, rec.Event)
+			if rec.Event.Seq >= q.nextSeq {
+				q.nextSeq = rec.Event.Seq + 1
+			}
+			continue
+		}
+		if rec.Removed > q.removed {
+			q.removed = rec.Removed
+			if q.removed >= q.nextSeq {
+				q.nextSeq = q.removed + 1
+			}
+		}
+		q.garbage++
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	// Discard events that were removed.
+	events := q.events[:0]
+	for _, ev := range q.events {
+		if ev.Seq > q.removed {
+			events = append(events, ev)
+		} else {
+			q.garbage++
+		}
+	}
+	q.events = events
+	return nil
+}
+
+// write appends a record to the queue file.
+func (q *fileQueue) write(rec *record) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	_, err = q.file.Write(append(b, '\n'))
+	return err
+}
+
+// compact rewrites the queue file with only the current events, and a record
+// of the last removed sequence number so that sequence numbers are not
+// reused.
+func (q *fileQueue) compact(path string) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	err = enc.Encode(&record{Removed: q.removed})
+	for _, ev := range q.events {
+		if err != nil {
+			break
+		}
+		err = enc.Encode(&record{Event: ev})
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	q.file.Close()
+	if q.file, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600); err != nil {
+		return err
+	}
+	q.garbage = 1
+	return nil
+}
@@ -0,0 +1,144 @@
+package durable
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+const testQueue = "user1/queue1"
+
+func newTestStore(t *testing.T) (*FileStore, string) {
+	dir, err := ioutil.TempDir("", "durable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewFileStore(dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return s, dir
+}
+
+func appendEvents(t *testing.T, s Store, queue string, n int) {
+	for i := 1; i <= n; i++ {
+		ev := &Event{
+			Time:        time.Now(),
+			Publication: wamp.GlobalID(),
+			Arguments:   wamp.List{i},
+		}
+		if err := s.Append(queue, ev); err != nil {
+			t.Fatal(err)
+		}
+		if ev.Seq == 0 {
+			t.Fatal("Event not assigned sequence number")
+		}
+	}
+}
+
+func checkSeqs(t *testing.T, s Store, after uint64, expect ...uint64) {
+	events, err := s.Events(testQueue, after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != len(expect) {
+		t.Fatalf("Expected %d events, got %d", len(expect), len(events))
+	}
+	for i := range events {
+		if events[i].Seq != expect[i] {
+			t.Fatalf("Expected event %d to have seq %d, got %d", i, expect[i],
+				events[i].Seq)
+		}
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	s, dir := newTestStore(t)
+	defer os.RemoveAll(dir)
+
+	appendEvents(t, s, testQueue, 5)
+	checkSeqs(t, s, 0, 1, 2, 3, 4, 5)
+	checkSeqs(t, s, 3, 4, 5)
+
+	if err := s.Remove(testQueue, 2); err != nil {
+		t.Fatal(err)
+	}
+	checkSeqs(t, s, 0, 3, 4, 5)
+
+	if err := s.Trim(testQueue, 2, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	checkSeqs(t, s, 0, 4, 5)
+
+	// Reopen store and check that events and sequence numbers persist.
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if s, err := NewFileStore(dir); err != nil {
+		t.Fatal(err)
+	} else {
+		checkSeqs(t, s, 0, 4, 5)
+		if err = s.Remove(testQueue, 5); err != nil {
+			t.Fatal(err)
+		}
+		s.Close()
+	}
+	s, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSeqs(t, s, 0)
+	appendEvents(t, s, testQueue, 1)
+	checkSeqs(t, s, 0, 6)
+
+	if err = s.Delete(testQueue); err != nil {
+		t.Fatal(err)
+	}
+	checkSeqs(t, s, 0)
+	s.Close()
+}
+
+func TestFileStoreTrimAge(t *testing.T) {
+	s, dir := newTestStore(t)
+	defer os.RemoveAll(dir)
+	defer s.Close()
+
+	old := &Event{Time: time.Now().Add(-time.Hour)}
+	if err := s.Append(testQueue, old); err != nil {
+		t.Fatal(err)
+	}
+	appendEvents(t, s, testQueue, 1)
+	if err := s.Trim(testQueue, 0, time.Now().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	checkSeqs(t, s, 0, 2)
+}
+
+func TestFileStoreCompact(t *testing.T) {
+	s, dir := newTestStore(t)
+	defer os.RemoveAll(dir)
+
+	const n = 2 * compactThreshold
+	for i := 0; i < n; i++ {
+		appendEvents(t, s, testQueue, 1)
+		if err := s.Remove(testQueue, uint64(i+1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	appendEvents(t, s, testQueue, 1)
+	if s.queues[testQueue].garbage > compactThreshold {
+		t.Fatal("Queue file was not compacted")
+	}
+	s.Close()
+
+	s, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	checkSeqs(t, s, 0, n+1)
+}
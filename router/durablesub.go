@@ -0,0 +1,412 @@
+package router
+
+import (
+	"context"
+	"net/url"
+	"time"
+
+	"github.com/gammazero/nexus/v3/router/durable"
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+const (
+	defaultDurableMaxEvents = 10000
+	defaultDurableMaxAge    = 7 * 24 * time.Hour
+
+	// Minimum interval at which durable subscriptions are checked for
+	// removal.
+	minDurableExpireInterval = 10 * time.Millisecond
+
+	// Time to wait for a session's outbound queue to drain, when redelivering
+	// stored events to the session.
+	durableReplayRetry = 50 * time.Millisecond
+)
+
+// durableSub is a durable subscription.  Events for a durable subscription are
+// stored until acknowledged by the subscriber, including while no session is
+// subscribed, and stored events are redelivered when a session subscribes
+// with the same durable name and authid.
+type durableSub struct {
+	// Name of the durable subscription's queue in the store.
+	queue string
+	topic wamp.URI
+	match string
+
+	// ID and details of the last subscribed session, used to filter and
+	// disclose events while no session is subscribed.
+	lastID      wamp.ID
+	lastDetails wamp.Dict
+	pubIdent    bool
+
+	// Subscribed session and its subscription ID.  sess is nil while no
+	// session is subscribed, and detached is when the last session
+	// unsubscribed.
+	sess     *wamp.Session
+	subID    wamp.ID
+	detached time.Time
+	// Cancels redelivery of stored events.  This is non-nil while stored
+	// events are being redelivered, during which time new events are only
+	// stored.
+	cancel context.CancelFunc
+}
+
+// durableQueue returns the name of the queue for a durable subscription in
+// the realm.
+func durableQueue(realm wamp.URI, authid, name string) string {
+	return url.PathEscape(string(realm)) + "/" + url.PathEscape(authid) + "/" +
+		url.PathEscape(name)
+}
+
+// matches returns true if the topic matches the durable subscription.
+func (d *durableSub) matches(topic wamp.URI) bool {
+	switch d.match {
+	case wamp.MatchPrefix:
+		return topic.PrefixMatch(d.topic)
+	case wamp.MatchWildcard:
+		return topic.WildcardMatch(d.topic)
+	}
+	return topic == d.topic
+}
+
+// syncDurableSubscribe makes the subscriber's subscription durable, and starts
+// redelivering any stored events.  If another session is subscribed with the
+// same durable name, then that session's subscription is no longer durable.
+func (b *broker) syncDurableSubscribe(subscriber *wamp.Session, sub *subscription, authid, name string) {
+	queue := durableQueue(b.durableRealm, authid, name)
+	d, ok := b.durables[queue]
+	if ok && d.sess == subscriber && d.subID == sub.id {
+		// Already subscribed.
+		return
+	}
+	if ok && d.sess != nil {
+		b.syncDetachDurable(d)
+	}
+	if other := sub.durable[subscriber]; other != nil {
+		b.syncDetachDurable(other)
+	}
+	if !ok {
+		d = &durableSub{queue: queue}
+		b.durables[queue] = d
+		b.syncStartExpireDurable()
+	}
+
+	d.topic = sub.topic
+	d.match = sub.match
+	d.lastID = subscriber.ID
+	subscriber.Lock()
+	d.lastDetails = make(wamp.Dict, len(subscriber.Details))
+	for k, v := range subscriber.Details {
+		d.lastDetails[k] = v
+	}
+	subscriber.Unlock()
+	d.pubIdent = subscriber.HasFeature(wamp.RoleSubscriber, wamp.FeaturePubIdent)
+
+	d.sess = subscriber
+	d.subID = sub.id
+	d.detached = time.Time{}
+	b.syncCountPatternRoutes()
+	if sub.durable == nil {
+		sub.durable = map[*wamp.Session]*durableSub{}
+	}
+	sub.durable[subscriber] = d
+
+	if b.durableClosed {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	d.cancel = cancel
+	b.durableReplays.Add(1)
+	go b.replayDurable(ctx, d, subscriber, sub.id)
+}
+
+// syncDetachDurable detaches the subscribed session from the durable
+// subscription.  Events continue to be stored for the durable subscription,
+// until it is removed by syncExpireDurable.
+func (b *broker) syncDetachDurable(d *durableSub) {
+	if d.cancel != nil {
+		d.cancel()
+		d.cancel = nil
+	}
+	if sub, ok := b.subscriptions[d.subID]; ok {
+		delete(sub.durable, d.sess)
+	}
+	if d.sess != nil {
+		d.detached = time.Now()
+	}
+	d.sess = nil
+	d.subID = 0
+}
+
+// syncDeleteDurable deletes the durable subscription and its stored events.
+func (b *broker) syncDeleteDurable(d *durableSub) {
+	b.syncDetachDurable(d)
+	delete(b.durables, d.queue)
+	b.syncCountPatternRoutes()
+	if err := b.durableStore.Delete(d.queue); err != nil {
+		b.log.Println("Error deleting durable subscription queue:", err)
+	}
+}
+
+// syncForgetDurable removes the durable subscription without deleting its
+// stored events.  This is done when the durable subscription has moved to
+// another shard, because its topic has changed.
+func (b *broker) syncForgetDurable(queue string) {
+	d, ok := b.durables[queue]
+	if !ok {
+		return
+	}
+	b.syncDetachDurable(d)
+	delete(b.durables, queue)
+	b.syncCountPatternRoutes()
+}
+
+// syncPubDurable stores an event for each matching durable subscription, and
+// sends the event to the subscribed session, if any.
+func (b *broker) syncPubDurable(pub *wamp.Session, msg *wamp.Publish, pubID wamp.ID, origTopic wamp.URI, excludePublisher, disclose bool, filter PublishFilter) {
+	before := time.Now().Add(-b.durableMaxAge)
+	for _, d := range b.durables {
+		if !d.matches(msg.Topic) {
+			continue
+		}
+		if d.sess == pub && excludePublisher {
+			continue
+		}
+		if filter != nil {
+			// Filter using the details of the last subscribed session, since
+			// there may not be a subscribed session.
+			safeSession := wamp.Session{ID: d.lastID, Details: d.lastDetails}
+			if !filter.Allowed(&safeSession) {
+				continue
+			}
+		}
+
+		ev := &durable.Event{
+			Time:        time.Now(),
+			Publication: pubID,
+			Details:     wamp.Dict{},
+			Arguments:   msg.Arguments,
+			ArgumentsKw: msg.ArgumentsKw,
+		}
+		if d.match == wamp.MatchPrefix || d.match == wamp.MatchWildcard {
+			ev.Details[detailTopic] = msg.Topic
+		}
+		if origTopic != "" {
+			ev.Details[wamp.DetailOriginalTopic] = origTopic
+		}
+		if disclose && d.pubIdent {
+			disclosePublisher(pub, ev.Details)
+		}
+
+		if err := b.durableStore.Append(d.queue, ev); err != nil {
+			b.log.Println("Error storing event for durable subscription:", err)
+			if d.sess != nil && d.cancel == nil {
+				b.trySend(d.sess, durableEvent(ev, d.subID))
+			}
+			continue
+		}
+		if err := b.durableStore.Trim(d.queue, b.durableMaxEvents, before); err != nil {
+			b.log.Println("Error trimming durable subscription queue:", err)
+		}
+		if d.sess != nil && d.cancel == nil {
+			b.trySend(d.sess, durableEvent(ev, d.subID))
+		}
+	}
+}
+
+// replayDurable redelivers the stored events of a durable subscription to the
+// subscribed session, until all stored events are delivered or until
+// canceled.  Once all stored events are delivered, new events are delivered
+// as they are published.
+//
+// Events are sent by the broker goroutine, the same as other events, so that
+// sending is ordered with the removal of the session.  When the session's
+// outbound queue is full, the remaining events are sent after waiting for
+// the queue to drain.
+func (b *broker) replayDurable(ctx context.Context, d *durableSub, sess *wamp.Session, subID wamp.ID) {
+	defer b.durableReplays.Done()
+	var after uint64
+	for {
+		var blocked bool
+		sync := make(chan struct{})
+		b.actionChan <- func() {
+			defer close(sync)
+			if ctx.Err() != nil {
+				return
+			}
+			blocked = b.syncReplayDurable(d, sess, subID, &after)
+			if !blocked {
+				// Caught up, so send new events when published.
+				d.cancel()
+				d.cancel = nil
+			}
+		}
+		<-sync
+		if !blocked {
+			return
+		}
+		timer := time.NewTimer(durableReplayRetry)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// syncReplayDurable sends the stored events that have a sequence number
+// greater than after, and updates after to the last event sent.  Returns true
+// if the session's outbound queue is full, so that not all events were sent.
+func (b *broker) syncReplayDurable(d *durableSub, sess *wamp.Session, subID wamp.ID, after *uint64) bool {
+	events, err := b.durableStore.Events(d.queue, *after)
+	if err != nil {
+		b.log.Println("Error reading durable subscription queue:", err)
+		return false
+	}
+	for _, ev := range events {
+		if msg := b.outbound.interceptOutbound(sess, durableEvent(ev, subID)); msg != nil {
+			if sess.TrySend(msg) != nil {
+				return true
+			}
+		}
+		*after = ev.Seq
+	}
+	return false
+}
+
+// syncStartExpireDurable starts the periodic removal of durable subscriptions
+// that have no subscribed session, if not already started.
+func (b *broker) syncStartExpireDurable() {
+	if b.durableExpireStop != nil || b.durableClosed {
+		return
+	}
+	b.durableExpireStop = make(chan struct{})
+	b.durableExpireDone = make(chan struct{})
+	go b.runExpireDurable(b.durableExpireStop, b.durableExpireDone)
+}
+
+// runExpireDurable periodically removes durable subscriptions that have no
+// subscribed session, until stopped.
+func (b *broker) runExpireDurable(stop, done chan struct{}) {
+	defer close(done)
+	interval := b.durableMaxAge / 4
+	if interval < minDurableExpireInterval {
+		interval = minDurableExpireInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+		select {
+		case b.actionChan <- b.syncExpireDurable:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// syncExpireDurable deletes the durable subscriptions, and their stored
+// events, that have had no subscribed session for longer than the maximum
+// event age.  Any events stored for such a subscription are older than the
+// maximum age, so would have been discarded anyway.  Otherwise, the durable
+// subscription of a subscriber that never returns would be kept forever.
+func (b *broker) syncExpireDurable() {
+	expired := time.Now().Add(-b.durableMaxAge)
+	for _, d := range b.durables {
+		if d.sess == nil && !d.detached.IsZero() && d.detached.Before(expired) {
+			b.log.Println("Removing durable subscription", d.queue,
+				"with no subscriber since", wamp.ISO8601(d.detached))
+			b.syncDeleteDurable(d)
+		}
+	}
+}
+
+// syncCloseDurable stops redelivery of stored events for all durable
+// subscriptions, and prevents new redelivery from starting.  Also stops the
+// removal of durable subscriptions, and returns a channel that is closed when
+// removal has stopped, or nil if removal was not started.
+func (b *broker) syncCloseDurable() <-chan struct{} {
+	b.durableClosed = true
+	for _, d := range b.durables {
+		if d.cancel != nil {
+			d.cancel()
+			d.cancel = nil
+		}
+	}
+	if b.durableExpireStop == nil {
+		return nil
+	}
+	close(b.durableExpireStop)
+	b.durableExpireStop = nil
+	return b.durableExpireDone
+}
+
+// durableEvent returns an EVENT message for a stored event.  The message has
+// its own copy of the event's details and arguments, since the stored event
+// may be kept in memory by the store.
+func durableEvent(ev *durable.Event, subID wamp.ID) *wamp.Event {
+	event := &wamp.Event{
+		Publication:  ev.Publication,
+		Subscription: subID,
+		Details:      make(wamp.Dict, len(ev.Details)+1),
+	}
+	for k, v := range ev.Details {
+		event.Details[k] = v
+	}
+	event.Details[wamp.DetailDurableSeq] = ev.Seq
+	if len(ev.Arguments) != 0 {
+		event.Arguments = make(wamp.List, len(ev.Arguments))
+		copy(event.Arguments, ev.Arguments)
+	}
+	if len(ev.ArgumentsKw) != 0 {
+		event.ArgumentsKw = make(wamp.Dict, len(ev.ArgumentsKw))
+		for k, v := range ev.ArgumentsKw {
+			event.ArgumentsKw[k] = v
+		}
+	}
+	return event
+}
+
+// subAckDurable acknowledges the events of the caller's durable subscription
+// through a sequence number, removing them from the store.
+//
+// The arguments are the durable name of the subscription and the sequence
+// number of the last event processed.
+func (b *broker) subAckDurable(msg *wamp.Invocation) wamp.Message {
+	if len(msg.Arguments) < 2 {
+		return makeError(msg.Request, wamp.ErrInvalidArgument)
+	}
+	name, ok := wamp.AsString(msg.Arguments[0])
+	if !ok || name == "" {
+		return makeError(msg.Request, wamp.ErrInvalidArgument)
+	}
+	seq, ok := wamp.AsInt64(msg.Arguments[1])
+	if !ok || seq < 0 {
+		return makeError(msg.Request, wamp.ErrInvalidArgument)
+	}
+	authid, _ := wamp.AsString(msg.Details["caller_authid"])
+	queue := durableQueue(b.durableRealm, authid, name)
+
+	var found bool
+	var err error
+	sync := make(chan struct{})
+	b.actionChan <- func() {
+		if _, found = b.durables[queue]; found {
+			err = b.durableStore.Remove(queue, uint64(seq))
+		}
+		close(sync)
+	}
+	<-sync
+	if !found {
+		return makeError(msg.Request, wamp.ErrNoSuchSubscription)
+	}
+	if err != nil {
+		b.log.Println("Error acknowledging durable subscription events:", err)
+		return makeError(msg.Request, wamp.ErrDurableStoreFailure)
+	}
+	return &wamp.Yield{Request: msg.Request}
+}
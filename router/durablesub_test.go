@@ -0,0 +1,319 @@
+package router
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gammazero/nexus/v3/router/durable"
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+const durableTopic = wamp.URI("durable.topic")
+
+func newDurableTestRouter(t *testing.T, maxEvents int) (Router, string) {
+	dir, err := ioutil.TempDir("", "nexus-durable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newTestRouterWithRealm(t, &RealmConfig{
+		DurableDir:       dir,
+		DurableMaxEvents: maxEvents,
+	}), dir
+}
+
+// durableSubscriber creates a client that subscribes with the durable name.
+func durableSubscriber(t *testing.T, r Router, name string) (*wamp.Session, wamp.ID) {
+	return durableSubscriberInRealm(t, r, testRealm, name)
+}
+
+// durableSubscriberInRealm creates a client in the realm that subscribes with
+// the durable name.
+func durableSubscriberInRealm(t *testing.T, r Router, realm wamp.URI, name string) (*wamp.Session, wamp.ID) {
+	sub, err := testClientInRealm(r, realm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub.Send(&wamp.Subscribe{
+		Request: wamp.GlobalID(),
+		Topic:   durableTopic,
+		Options: wamp.Dict{wamp.OptDurable: name},
+	})
+	msg, err := wamp.RecvTimeout(sub, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	subscribed, ok := msg.(*wamp.Subscribed)
+	if !ok {
+		t.Fatal("Expected SUBSCRIBED, got:", msg.MessageType())
+	}
+	return sub, subscribed.Subscription
+}
+
+func publishDurable(t *testing.T, pub *wamp.Session, args ...int) {
+	for _, arg := range args {
+		pub.Send(&wamp.Publish{
+			Request:   wamp.GlobalID(),
+			Options:   wamp.Dict{wamp.OptAcknowledge: true},
+			Topic:     durableTopic,
+			Arguments: wamp.List{arg},
+		})
+		msg, err := wamp.RecvTimeout(pub, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := msg.(*wamp.Published); !ok {
+			t.Fatal("Expected PUBLISHED, got:", msg.MessageType())
+		}
+	}
+}
+
+// recvDurable receives events and checks their arguments and sequence
+// numbers.
+func recvDurable(t *testing.T, sub *wamp.Session, args []int, seqs []int) {
+	for i := range args {
+		msg, err := wamp.RecvTimeout(sub, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		event, ok := msg.(*wamp.Event)
+		if !ok {
+			t.Fatal("Expected EVENT, got:", msg.MessageType())
+		}
+		arg, _ := wamp.AsInt64(event.Arguments[0])
+		seq, _ := wamp.AsInt64(event.Details[wamp.DetailDurableSeq])
+		if int(arg) != args[i] || int(seq) != seqs[i] {
+			t.Fatalf("Expected event %d with seq %d, got event %d with seq %d",
+				args[i], seqs[i], arg, seq)
+		}
+	}
+}
+
+// leave disconnects the client and waits for the router to remove the session.
+func leave(t *testing.T, sess *wamp.Session) {
+	sess.Send(&wamp.Goodbye{
+		Reason:  wamp.CloseRealm,
+		Details: wamp.Dict{},
+	})
+	if _, err := wamp.RecvTimeout(sess, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	sess.Close()
+}
+
+func TestDurableSubscription(t *testing.T) {
+	r, dir := newDurableTestRouter(t, 0)
+	defer os.RemoveAll(dir)
+	defer r.Close()
+
+	pub, err := testClient(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sub, _ := durableSubscriber(t, r, "d1")
+	publishDurable(t, pub, 1)
+	recvDurable(t, sub, []int{1}, []int{1})
+	leave(t, sub)
+
+	// Events published while offline are stored, and unacknowledged events
+	// are redelivered on the next subscribe.
+	publishDurable(t, pub, 2, 3)
+	sub, _ = durableSubscriber(t, r, "d1")
+	recvDurable(t, sub, []int{1, 2, 3}, []int{1, 2, 3})
+
+	// Acknowledge events through 2.
+	sub.Send(&wamp.Call{
+		Request:   wamp.GlobalID(),
+		Procedure: wamp.MetaProcSubAckDurable,
+		Arguments: wamp.List{"d1", 2},
+	})
+	msg, err := wamp.RecvTimeout(sub, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := msg.(*wamp.Result); !ok {
+		t.Fatal("Expected RESULT, got:", msg.MessageType())
+	}
+
+	publishDurable(t, pub, 4)
+	recvDurable(t, sub, []int{4}, []int{4})
+	leave(t, sub)
+
+	sub, subID := durableSubscriber(t, r, "d1")
+	recvDurable(t, sub, []int{3, 4}, []int{3, 4})
+
+	// Unsubscribing discards the durable subscription's events.
+	sub.Send(&wamp.Unsubscribe{Request: wamp.GlobalID(), Subscription: subID})
+	msg, err = wamp.RecvTimeout(sub, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := msg.(*wamp.Unsubscribed); !ok {
+		t.Fatal("Expected UNSUBSCRIBED, got:", msg.MessageType())
+	}
+	leave(t, sub)
+	publishDurable(t, pub, 5)
+	sub, _ = durableSubscriber(t, r, "d1")
+	publishDurable(t, pub, 6)
+	recvDurable(t, sub, []int{6}, []int{1})
+}
+
+func TestDurableSubscriptionLimit(t *testing.T) {
+	r, dir := newDurableTestRouter(t, 2)
+	defer os.RemoveAll(dir)
+	defer r.Close()
+
+	pub, err := testClient(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, _ := durableSubscriber(t, r, "d1")
+	leave(t, sub)
+
+	publishDurable(t, pub, 1, 2, 3)
+	sub, _ = durableSubscriber(t, r, "d1")
+	recvDurable(t, sub, []int{2, 3}, []int{2, 3})
+}
+
+func TestDurableSubscriptionReplayQueueFull(t *testing.T) {
+	r, dir := newDurableTestRouter(t, 0)
+	defer os.RemoveAll(dir)
+	defer r.Close()
+
+	pub, err := testClient(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, _ := durableSubscriber(t, r, "d1")
+	leave(t, sub)
+	publishDurable(t, pub, 1, 2, 3, 4, 5)
+
+	// Stored events are redelivered as the subscriber's small outbound queue
+	// drains.
+	sub, err = testClientQSize(r, testRealm, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub.Send(&wamp.Subscribe{
+		Request: wamp.GlobalID(),
+		Topic:   durableTopic,
+		Options: wamp.Dict{wamp.OptDurable: "d1"},
+	})
+	msg, err := wamp.RecvTimeout(sub, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := msg.(*wamp.Subscribed); !ok {
+		t.Fatal("Expected SUBSCRIBED, got:", msg.MessageType())
+	}
+	recvDurable(t, sub, []int{1, 2, 3, 4, 5}, []int{1, 2, 3, 4, 5})
+	publishDurable(t, pub, 6)
+	recvDurable(t, sub, []int{6}, []int{6})
+}
+
+func TestDurableSubscriptionExpire(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nexus-durable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	r := newTestRouterWithRealm(t, &RealmConfig{
+		DurableDir:       dir,
+		DurableMaxAgeSec: 1,
+	})
+	defer r.Close()
+
+	pub, err := testClient(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, _ := durableSubscriber(t, r, "d1")
+	leave(t, sub)
+	publishDurable(t, pub, 1)
+
+	// The durable subscription, with its stored events, is removed after
+	// having no subscriber for longer than the max age.
+	time.Sleep(1500 * time.Millisecond)
+	sub, _ = durableSubscriber(t, r, "d1")
+	publishDurable(t, pub, 2)
+	recvDurable(t, sub, []int{2}, []int{1})
+}
+
+func TestDurableSubscriptionSharedStore(t *testing.T) {
+	const (
+		realm1 = wamp.URI("com.acme.tenant.t1")
+		realm2 = wamp.URI("com.acme.tenant.t2")
+	)
+	dir, err := ioutil.TempDir("", "nexus-durable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := durable.NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &Config{
+		RealmTemplates: []*RealmTemplate{
+			{
+				Pattern:        "com.acme.tenant",
+				Match:          wamp.MatchPrefix,
+				IdleTimeoutSec: 1,
+				Realm: &RealmConfig{
+					AnonymousAuth: true,
+					DurableStore:  store,
+				},
+			},
+		},
+		Debug: debug,
+	}
+	r, err := NewRouter(config, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// Each realm has a durable subscription with the same authid and name.
+	sub1, _ := durableSubscriberInRealm(t, r, realm1, "d1")
+	sub2, _ := durableSubscriberInRealm(t, r, realm2, "d1")
+	leave(t, sub2)
+	pub, err := testClientInRealm(r, realm2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publishDurable(t, pub, 2)
+	leave(t, pub)
+	leave(t, sub1)
+
+	// The idle realm is removed without closing the shared store, and the
+	// events of the other realm are not stored in its queue.
+	time.Sleep(1500 * time.Millisecond)
+	if hasRealm(r, realm2) {
+		t.Fatal("Idle realm was not removed")
+	}
+	sub1, _ = durableSubscriberInRealm(t, r, realm1, "d1")
+	pub, err = testClientInRealm(r, realm1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publishDurable(t, pub, 1)
+	recvDurable(t, sub1, []int{1}, []int{1})
+}
+
+func TestDurableSubscriptionNotEnabled(t *testing.T) {
+	r := newTestRouterWithRealm(t, &RealmConfig{})
+	defer r.Close()
+
+	sub, err := testClient(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub.Send(&wamp.Subscribe{
+		Request: wamp.GlobalID(),
+		Topic:   durableTopic,
+		Options: wamp.Dict{wamp.OptDurable: "d1"},
+	})
+	expectErrorURI(t, sub, wamp.ErrOptionNotAllowed)
+}
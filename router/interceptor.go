@@ -0,0 +1,243 @@
+package router
+
+import (
+	"github.com/gammazero/nexus/v3/stdlog"
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// Interceptor is the interface implemented by a type that inspects messages
+// as they pass through the router.  An Interceptor can observe, rewrite, drop,
+// or reply to a message.
+//
+// Inbound interceptors are called for every message a session sends to the
+// router, before the message is routed to the broker or dealer.  Outbound
+// interceptors are called for every message the broker or dealer sends to a
+// session, before the message is queued for sending.  The internal meta
+// session is not intercepted.
+//
+// The session passed to an Interceptor only contains the session ID and
+// details, and the session is locked while the interceptor chain runs, so
+// the interceptor may safely read and modify the session details.
+type Interceptor interface {
+	// Intercept returns the message to continue processing with, which may
+	// be the original message, a modified message, or a different message.
+	// Returning a nil message drops the message.
+	//
+	// If a non-nil reply is returned by an inbound interceptor, then the
+	// reply is sent back to the session and the message is not routed.  The
+	// reply is ignored by outbound interceptors.
+	//
+	// If an outbound interceptor drops the RESULT or ERROR that ends a call,
+	// then the caller is sent a wamp.error.canceled ERROR instead.
+	//
+	// Outbound messages may be shared by multiple recipients.  An outbound
+	// interceptor that rewrites a message must return a new message instead
+	// of modifying the one it was given.
+	Intercept(sess *wamp.Session, msg wamp.Message) (next wamp.Message, reply wamp.Message)
+}
+
+// InterceptorFunc is an adapter to allow the use of an ordinary function as an
+// Interceptor.
+type InterceptorFunc func(*wamp.Session, wamp.Message) (wamp.Message, wamp.Message)
+
+// Intercept calls f(sess, msg).
+func (f InterceptorFunc) Intercept(sess *wamp.Session, msg wamp.Message) (wamp.Message, wamp.Message) {
+	return f(sess, msg)
+}
+
+// TypedInterceptor is an Interceptor that dispatches each message to the
+// function for that message type.  Messages of a type that has no function
+// assigned are passed through unchanged.
+//
+// Each function has the same return semantics as Interceptor.Intercept.
+//
+// For example, to reject calls to a procedure:
+//
+//     &router.TypedInterceptor{
+//         Call: func(sess *wamp.Session, msg *wamp.Call) (wamp.Message, wamp.Message) {
+//             if msg.Procedure == "com.example.forbidden" {
+//                 return nil, router.ErrorReply(msg, wamp.ErrNotAuthorized, nil)
+//             }
+//             return msg, nil
+//         },
+//     }
+type TypedInterceptor struct {
+	// Messages sent by sessions to the router.
+	Publish     func(*wamp.Session, *wamp.Publish) (wamp.Message, wamp.Message)
+	Subscribe   func(*wamp.Session, *wamp.Subscribe) (wamp.Message, wamp.Message)
+	Unsubscribe func(*wamp.Session, *wamp.Unsubscribe) (wamp.Message, wamp.Message)
+	Register    func(*wamp.Session, *wamp.Register) (wamp.Message, wamp.Message)
+	Unregister  func(*wamp.Session, *wamp.Unregister) (wamp.Message, wamp.Message)
+	Call        func(*wamp.Session, *wamp.Call) (wamp.Message, wamp.Message)
+	Cancel      func(*wamp.Session, *wamp.Cancel) (wamp.Message, wamp.Message)
+	Yield       func(*wamp.Session, *wamp.Yield) (wamp.Message, wamp.Message)
+
+	// Messages sent by the router to sessions.
+	Event      func(*wamp.Session, *wamp.Event) (wamp.Message, wamp.Message)
+	Invocation func(*wamp.Session, *wamp.Invocation) (wamp.Message, wamp.Message)
+	Interrupt  func(*wamp.Session, *wamp.Interrupt) (wamp.Message, wamp.Message)
+	Result     func(*wamp.Session, *wamp.Result) (wamp.Message, wamp.Message)
+
+	// ERROR messages in either direction.
+	Error func(*wamp.Session, *wamp.Error) (wamp.Message, wamp.Message)
+}
+
+// Intercept calls the function assigned for the type of message, if any.
+func (t *TypedInterceptor) Intercept(sess *wamp.Session, msg wamp.Message) (wamp.Message, wamp.Message) {
+	switch msg := msg.(type) {
+	case *wamp.Publish:
+		if t.Publish != nil {
+			return t.Publish(sess, msg)
+		}
+	case *wamp.Subscribe:
+		if t.Subscribe != nil {
+			return t.Subscribe(sess, msg)
+		}
+	case *wamp.Unsubscribe:
+		if t.Unsubscribe != nil {
+			return t.Unsubscribe(sess, msg)
+		}
+	case *wamp.Register:
+		if t.Register != nil {
+			return t.Register(sess, msg)
+		}
+	case *wamp.Unregister:
+		if t.Unregister != nil {
+			return t.Unregister(sess, msg)
+		}
+	case *wamp.Call:
+		if t.Call != nil {
+			return t.Call(sess, msg)
+		}
+	case *wamp.Cancel:
+		if t.Cancel != nil {
+			return t.Cancel(sess, msg)
+		}
+	case *wamp.Yield:
+		if t.Yield != nil {
+			return t.Yield(sess, msg)
+		}
+	case *wamp.Event:
+		if t.Event != nil {
+			return t.Event(sess, msg)
+		}
+	case *wamp.Invocation:
+		if t.Invocation != nil {
+			return t.Invocation(sess, msg)
+		}
+	case *wamp.Interrupt:
+		if t.Interrupt != nil {
+			return t.Interrupt(sess, msg)
+		}
+	case *wamp.Result:
+		if t.Result != nil {
+			return t.Result(sess, msg)
+		}
+	case *wamp.Error:
+		if t.Error != nil {
+			return t.Error(sess, msg)
+		}
+	}
+	return msg, nil
+}
+
+// ErrorReply returns an ERROR message that responds to the request message
+// with the given error URI and arguments.  This is intended for use by inbound
+// interceptors to reject a message.
+//
+// A nil reply is returned if the message is a PUBLISH that did not request
+// acknowledgement, since the publisher does not expect any response.
+func ErrorReply(msg wamp.Message, errURI wamp.URI, args wamp.List) wamp.Message {
+	errRsp := &wamp.Error{
+		Type:      msg.MessageType(),
+		Error:     errURI,
+		Arguments: args,
+		Details:   wamp.Dict{},
+	}
+	// Get the Request from request types of messages.
+	switch msg := msg.(type) {
+	case *wamp.Publish:
+		// a publish error should only be sent when OptAcknowledge is set.
+		if pubAck, _ := msg.Options[wamp.OptAcknowledge].(bool); !pubAck {
+			return nil
+		}
+		errRsp.Request = msg.Request
+	case *wamp.Subscribe:
+		errRsp.Request = msg.Request
+	case *wamp.Unsubscribe:
+		errRsp.Request = msg.Request
+	case *wamp.Register:
+		errRsp.Request = msg.Request
+	case *wamp.Unregister:
+		errRsp.Request = msg.Request
+	case *wamp.Call:
+		errRsp.Request = msg.Request
+	case *wamp.Cancel:
+		errRsp.Request = msg.Request
+	case *wamp.Yield:
+		errRsp.Request = msg.Request
+	}
+	return errRsp
+}
+
+// interceptorChain is an ordered list of interceptors.
+type interceptorChain []Interceptor
+
+// run passes the message through each interceptor in the chain, in order.
+// Processing stops when an interceptor drops the message or returns a reply.
+//
+// A safe session is given to the interceptors to prevent access to the
+// session's Peer, and the session is locked since there is no telling what the
+// interceptors will do to the session details.
+func (c interceptorChain) run(sess *wamp.Session, msg wamp.Message) (wamp.Message, wamp.Message) {
+	safeSession := &wamp.Session{
+		ID:      sess.ID,
+		Details: sess.Details,
+	}
+	var reply wamp.Message
+	sess.Lock()
+	for _, interceptor := range c {
+		msg, reply = interceptor.Intercept(safeSession, msg)
+		if msg == nil || reply != nil {
+			break
+		}
+	}
+	sess.Unlock()
+	return msg, reply
+}
+
+// interceptOutbound runs the outbound interceptor chain for a message about to
+// be sent to a session.  Returns nil if the message was dropped.
+func (c interceptorChain) interceptOutbound(sess *wamp.Session, msg wamp.Message) wamp.Message {
+	if len(c) == 0 || sess.ID == metaID {
+		return msg
+	}
+	msg, _ = c.run(sess, msg)
+	return msg
+}
+
+// authzInterceptor is an Interceptor that calls an Authorizer to authorize
+// each message.  If authorization fails or if the session is not authorized,
+// then the message is dropped and an error reply is returned.
+type authzInterceptor struct {
+	authorizer Authorizer
+	log        stdlog.StdLog
+}
+
+func (a *authzInterceptor) Intercept(sess *wamp.Session, msg wamp.Message) (wamp.Message, wamp.Message) {
+	isAuthz, err := a.authorizer.Authorize(sess, msg)
+	if isAuthz {
+		return msg, nil
+	}
+	if err != nil {
+		// Error trying to authorize.  Include error message.
+		a.log.Println("Client", sess, "authorization failed:", err)
+		return nil, ErrorReply(msg, wamp.ErrAuthorizationFailed,
+			wamp.List{err.Error()})
+	}
+	// Session not authorized.  The inability to return a message is
+	// intentional, so as not to encourage returning information that could
+	// disclose any clues about authorization to an attacker.
+	a.log.Println("Client", sess, msg.MessageType(), "not authorized")
+	return nil, ErrorReply(msg, wamp.ErrNotAuthorized, nil)
+}
@@ -0,0 +1,315 @@
+package router
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+func newTestRouterWithRealm(t *testing.T, realmConfig *RealmConfig) Router {
+	realmConfig.URI = testRealm
+	config := &Config{
+		RealmConfigs: []*RealmConfig{realmConfig},
+		Debug:        debug,
+	}
+	r, err := NewRouter(config, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// Test that inbound interceptors can rewrite, drop, and reply to messages.
+func TestInboundInterceptor(t *testing.T) {
+	const (
+		oldTopic  = wamp.URI("interceptor.old")
+		newTopic  = wamp.URI("interceptor.new")
+		dropTopic = wamp.URI("interceptor.drop")
+	)
+	rewrite := &TypedInterceptor{
+		Subscribe: func(sess *wamp.Session, msg *wamp.Subscribe) (wamp.Message, wamp.Message) {
+			if msg.Topic == oldTopic {
+				return &wamp.Subscribe{
+					Request: msg.Request,
+					Options: msg.Options,
+					Topic:   newTopic,
+				}, nil
+			}
+			return msg, nil
+		},
+	}
+	reject := &TypedInterceptor{
+		Subscribe: func(sess *wamp.Session, msg *wamp.Subscribe) (wamp.Message, wamp.Message) {
+			if msg.Topic == denyTopic {
+				return nil, ErrorReply(msg, wamp.ErrNotAuthorized, nil)
+			}
+			return msg, nil
+		},
+	}
+	drop := InterceptorFunc(func(sess *wamp.Session, msg wamp.Message) (wamp.Message, wamp.Message) {
+		if pub, ok := msg.(*wamp.Publish); ok && pub.Topic == dropTopic {
+			return nil, nil
+		}
+		return msg, nil
+	})
+
+	r := newTestRouterWithRealm(t, &RealmConfig{
+		InboundInterceptors: []Interceptor{rewrite, reject, drop},
+	})
+	defer r.Close()
+
+	sub, err := testClient(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Test that interceptor replies with error and message is not routed.
+	sub.Send(&wamp.Subscribe{Request: wamp.GlobalID(), Topic: denyTopic})
+	msg, err := wamp.RecvTimeout(sub, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errMsg, ok := msg.(*wamp.Error)
+	if !ok {
+		t.Fatal("Expected ERROR, got:", msg.MessageType())
+	}
+	if errMsg.Error != wamp.ErrNotAuthorized {
+		t.Fatal("Wrong error URI:", errMsg.Error)
+	}
+
+	// Test that interceptor rewrites topic.
+	sub.Send(&wamp.Subscribe{Request: wamp.GlobalID(), Topic: oldTopic})
+	msg, err = wamp.RecvTimeout(sub, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok = msg.(*wamp.Subscribed); !ok {
+		t.Fatal("Expected SUBSCRIBED, got:", msg.MessageType())
+	}
+
+	pub, err := testClient(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Test that dropped publication is not delivered.
+	pub.Send(&wamp.Publish{Request: wamp.GlobalID(), Topic: dropTopic})
+	// Publish to rewritten topic.
+	pub.Send(&wamp.Publish{Request: wamp.GlobalID(), Topic: newTopic})
+	msg, err = wamp.RecvTimeout(sub, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok = msg.(*wamp.Event); !ok {
+		t.Fatal("Expected EVENT, got:", msg.MessageType())
+	}
+}
+
+// Test that outbound interceptors can rewrite and drop messages sent by the
+// broker and dealer.
+func TestOutboundInterceptor(t *testing.T) {
+	const (
+		testTopic     = wamp.URI("interceptor.topic")
+		testProcedure = wamp.URI("interceptor.proc")
+	)
+	outbound := &TypedInterceptor{
+		Event: func(sess *wamp.Session, msg *wamp.Event) (wamp.Message, wamp.Message) {
+			if len(msg.Arguments) == 0 {
+				return nil, nil
+			}
+			// Return a new message, since the event is shared.
+			return &wamp.Event{
+				Subscription: msg.Subscription,
+				Publication:  msg.Publication,
+				Details:      msg.Details,
+				Arguments:    append(wamp.List{"intercepted"}, msg.Arguments...),
+			}, nil
+		},
+		Result: func(sess *wamp.Session, msg *wamp.Result) (wamp.Message, wamp.Message) {
+			msg.Arguments = wamp.List{"intercepted"}
+			return msg, nil
+		},
+	}
+
+	r := newTestRouterWithRealm(t, &RealmConfig{
+		OutboundInterceptors: []Interceptor{outbound},
+	})
+	defer r.Close()
+
+	sub, err := testClient(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub.Send(&wamp.Subscribe{Request: wamp.GlobalID(), Topic: testTopic})
+	msg, err := wamp.RecvTimeout(sub, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := msg.(*wamp.Subscribed); !ok {
+		t.Fatal("Expected SUBSCRIBED, got:", msg.MessageType())
+	}
+
+	pub, err := testClient(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// First event is dropped by interceptor.
+	pub.Send(&wamp.Publish{Request: wamp.GlobalID(), Topic: testTopic})
+	pub.Send(&wamp.Publish{
+		Request:   wamp.GlobalID(),
+		Topic:     testTopic,
+		Arguments: wamp.List{"hello"},
+	})
+	msg, err = wamp.RecvTimeout(sub, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	event, ok := msg.(*wamp.Event)
+	if !ok {
+		t.Fatal("Expected EVENT, got:", msg.MessageType())
+	}
+	if len(event.Arguments) != 2 || event.Arguments[0] != "intercepted" {
+		t.Fatal("Event was not rewritten:", event.Arguments)
+	}
+
+	// Test that RESULT sent to caller is intercepted.
+	callee, err := testClient(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	callee.Send(&wamp.Register{Request: wamp.GlobalID(), Procedure: testProcedure})
+	msg, err = wamp.RecvTimeout(callee, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok = msg.(*wamp.Registered); !ok {
+		t.Fatal("Expected REGISTERED, got:", msg.MessageType())
+	}
+
+	callID := wamp.GlobalID()
+	pub.Send(&wamp.Call{Request: callID, Procedure: testProcedure})
+	msg, err = wamp.RecvTimeout(callee, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inv, ok := msg.(*wamp.Invocation)
+	if !ok {
+		t.Fatal("Expected INVOCATION, got:", msg.MessageType())
+	}
+	callee.Send(&wamp.Yield{Request: inv.Request, Arguments: wamp.List{"result"}})
+	msg, err = wamp.RecvTimeout(pub, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, ok := msg.(*wamp.Result)
+	if !ok {
+		t.Fatal("Expected RESULT, got:", msg.MessageType())
+	}
+	if result.Request != callID {
+		t.Fatal("Wrong request ID in RESULT")
+	}
+	if len(result.Arguments) != 1 || result.Arguments[0] != "intercepted" {
+		t.Fatal("Result was not rewritten:", result.Arguments)
+	}
+}
+
+// Test that the caller gets an error, instead of waiting forever, when an
+// outbound interceptor drops the RESULT or ERROR that ends a call.
+func TestOutboundInterceptorDropResult(t *testing.T) {
+	const testProcedure = wamp.URI("interceptor.proc")
+	drop := func(sess *wamp.Session, msg wamp.Message) (wamp.Message, wamp.Message) {
+		switch msg.(type) {
+		case *wamp.Result, *wamp.Error:
+			return nil, nil
+		}
+		return msg, nil
+	}
+	r := newTestRouterWithRealm(t, &RealmConfig{
+		OutboundInterceptors: []Interceptor{InterceptorFunc(drop)},
+	})
+	defer r.Close()
+
+	callee, err := testClient(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	callee.Send(&wamp.Register{Request: wamp.GlobalID(), Procedure: testProcedure})
+	recvMsg(t, callee, wamp.REGISTERED)
+	caller, err := testClient(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caller.Send(&wamp.Call{Request: wamp.GlobalID(), Procedure: testProcedure})
+	inv := recvMsg(t, callee, wamp.INVOCATION).(*wamp.Invocation)
+	callee.Send(&wamp.Yield{Request: inv.Request})
+	expectErrorURI(t, caller, wamp.ErrCanceled)
+
+	caller.Send(&wamp.Call{Request: wamp.GlobalID(), Procedure: testProcedure})
+	inv = recvMsg(t, callee, wamp.INVOCATION).(*wamp.Invocation)
+	callee.Send(&wamp.Error{
+		Type:    wamp.INVOCATION,
+		Request: inv.Request,
+		Details: wamp.Dict{},
+		Error:   "interceptor.error",
+	})
+	expectErrorURI(t, caller, wamp.ErrCanceled)
+}
+
+// Test that the authorizer runs before other inbound interceptors.
+func TestAuthorizerInterceptorOrder(t *testing.T) {
+	var called bool
+	interceptor := InterceptorFunc(func(sess *wamp.Session, msg wamp.Message) (wamp.Message, wamp.Message) {
+		if _, ok := msg.(*wamp.Subscribe); ok {
+			called = true
+		}
+		return msg, nil
+	})
+	r := newTestRouterWithRealm(t, &RealmConfig{
+		Authorizer:          &testAuthz{},
+		RequireLocalAuthz:   true,
+		InboundInterceptors: []Interceptor{interceptor},
+	})
+	defer r.Close()
+
+	sub, err := testClient(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub.Send(&wamp.Subscribe{Request: wamp.GlobalID(), Topic: denyTopic})
+	msg, err := wamp.RecvTimeout(sub, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := msg.(*wamp.Error); !ok {
+		t.Fatal("Expected ERROR, got:", msg.MessageType())
+	}
+	if called {
+		t.Fatal("Interceptor should not be called for unauthorized message")
+	}
+}
+
+func TestErrorReply(t *testing.T) {
+	call := &wamp.Call{Request: 123, Procedure: "some.proc"}
+	reply := ErrorReply(call, wamp.ErrInvalidArgument, wamp.List{"bad"})
+	errMsg, ok := reply.(*wamp.Error)
+	if !ok {
+		t.Fatal("Expected ERROR")
+	}
+	if errMsg.Type != wamp.CALL || errMsg.Request != 123 {
+		t.Fatal("Wrong type or request in ERROR")
+	}
+	if errMsg.Error != wamp.ErrInvalidArgument || len(errMsg.Arguments) != 1 {
+		t.Fatal("Wrong error or arguments in ERROR")
+	}
+
+	pub := &wamp.Publish{Request: 456, Topic: "some.topic"}
+	if ErrorReply(pub, wamp.ErrNotAuthorized, nil) != nil {
+		t.Fatal("Expected no reply to PUBLISH without acknowledge")
+	}
+	pub.Options = wamp.Dict{wamp.OptAcknowledge: true}
+	if ErrorReply(pub, wamp.ErrNotAuthorized, nil) == nil {
+		t.Fatal("Expected reply to PUBLISH with acknowledge")
+	}
+}
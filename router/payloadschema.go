@@ -0,0 +1,169 @@
+package router
+
+import (
+	"fmt"
+
+	"github.com/gammazero/nexus/v3/router/schema"
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// PayloadSchema associates JSON Schema documents with a procedure or topic
+// URI.  Messages with payloads that do not conform to the schemas are rejected
+// with wamp.error.invalid_argument.
+//
+// Positional arguments are validated as a JSON array, and keyword arguments
+// as a JSON object.  Missing arguments are validated as an empty array or
+// object.  A nil schema does not validate anything.
+//
+// In a JSON configuration, each schema is given either inline as a JSON
+// object, or as a string that is the path of a file containing the schema.
+// A relative path is relative to the working directory of the process.
+type PayloadSchema struct {
+	// URI of the procedure or topic, or URI pattern if Match is "prefix" or
+	// "wildcard".
+	URI wamp.URI `json:"uri"`
+	// Match is the matching policy for URI: "exact", "prefix", or "wildcard".
+	// Default is "exact".
+	Match string `json:"match"`
+
+	// Args and Kwargs validate the arguments of CALL and PUBLISH messages.
+	Args   *schema.Schema `json:"args"`
+	Kwargs *schema.Schema `json:"kwargs"`
+
+	// ResultArgs and ResultKwargs validate the arguments of YIELD messages
+	// sent in response to calls to the procedure.
+	ResultArgs   *schema.Schema `json:"result_args"`
+	ResultKwargs *schema.Schema `json:"result_kwargs"`
+}
+
+// payloadValidator finds the PayloadSchema for a URI and validates message
+// payloads against it.  A nil payloadValidator does not validate anything.
+//
+// URIs are matched using the same rules as for registrations: an exact match
+// is preferred over a prefix match, and a prefix match over a wildcard match.
+// The longest matching prefix or wildcard pattern is used.
+type payloadValidator struct {
+	exact    map[wamp.URI]*PayloadSchema
+	prefix   map[wamp.URI]*PayloadSchema
+	wildcard map[wamp.URI]*PayloadSchema
+}
+
+// newPayloadValidator returns a payloadValidator for the given schemas, or nil
+// if there are no schemas.
+func newPayloadValidator(schemas []*PayloadSchema, strictURI bool) (*payloadValidator, error) {
+	if len(schemas) == 0 {
+		return nil, nil
+	}
+	v := &payloadValidator{
+		exact:    map[wamp.URI]*PayloadSchema{},
+		prefix:   map[wamp.URI]*PayloadSchema{},
+		wildcard: map[wamp.URI]*PayloadSchema{},
+	}
+	for _, ps := range schemas {
+		var uriMap map[wamp.URI]*PayloadSchema
+		switch ps.Match {
+		case "", wamp.MatchExact:
+			uriMap = v.exact
+		case wamp.MatchPrefix:
+			uriMap = v.prefix
+		case wamp.MatchWildcard:
+			uriMap = v.wildcard
+		default:
+			return nil, fmt.Errorf("invalid match policy %q for payload schema %v",
+				ps.Match, ps.URI)
+		}
+		if !ps.URI.ValidURI(strictURI, ps.Match) {
+			return nil, fmt.Errorf("invalid payload schema URI %v (URI strict checking %v)",
+				ps.URI, strictURI)
+		}
+		if _, ok := uriMap[ps.URI]; ok {
+			return nil, fmt.Errorf("duplicate payload schema for %v", ps.URI)
+		}
+		uriMap[ps.URI] = ps
+	}
+	return v, nil
+}
+
+// match returns the PayloadSchema for the URI, or nil if there is none.
+func (v *payloadValidator) match(uri wamp.URI) *PayloadSchema {
+	if ps, ok := v.exact[uri]; ok {
+		return ps
+	}
+	var found *PayloadSchema
+	var matchCount int
+	for pfx, ps := range v.prefix {
+		if uri.PrefixMatch(pfx) && len(pfx) > matchCount {
+			found = ps
+			matchCount = len(pfx)
+		}
+	}
+	if found != nil {
+		return found
+	}
+	for wc, ps := range v.wildcard {
+		if uri.WildcardMatch(wc) && len(wc) > matchCount {
+			found = ps
+			matchCount = len(wc)
+		}
+	}
+	return found
+}
+
+// validateArgs validates CALL or PUBLISH arguments for the URI.
+func (v *payloadValidator) validateArgs(uri wamp.URI, args wamp.List, kwargs wamp.Dict) error {
+	if v == nil {
+		return nil
+	}
+	ps := v.match(uri)
+	if ps == nil {
+		return nil
+	}
+	return validatePayload(ps.Args, ps.Kwargs, args, kwargs)
+}
+
+// validateResult validates YIELD arguments for a call to the procedure.
+func (v *payloadValidator) validateResult(procedure wamp.URI, args wamp.List, kwargs wamp.Dict) error {
+	if v == nil {
+		return nil
+	}
+	ps := v.match(procedure)
+	if ps == nil {
+		return nil
+	}
+	return validatePayload(ps.ResultArgs, ps.ResultKwargs, args, kwargs)
+}
+
+func validatePayload(argsSchema, kwargsSchema *schema.Schema, args wamp.List, kwargs wamp.Dict) error {
+	if argsSchema != nil {
+		if args == nil {
+			args = wamp.List{}
+		}
+		if err := argsSchema.Validate(args); err != nil {
+			return fmt.Errorf("args%s", pathSuffix(err))
+		}
+	}
+	if kwargsSchema != nil {
+		if kwargs == nil {
+			kwargs = wamp.Dict{}
+		}
+		if err := kwargsSchema.Validate(kwargs); err != nil {
+			return fmt.Errorf("kwargs%s", pathSuffix(err))
+		}
+	}
+	return nil
+}
+
+// pathSuffix formats a validation error to follow "args" or "kwargs".
+func pathSuffix(err error) string {
+	verr, ok := err.(*schema.ValidationError)
+	if !ok {
+		return ": " + err.Error()
+	}
+	switch {
+	case verr.Path == "":
+		return ": " + verr.Message
+	case verr.Path[0] == '[':
+		return verr.Path + ": " + verr.Message
+	}
+	return "." + verr.Path + ": " + verr.Message
+}
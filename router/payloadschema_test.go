@@ -0,0 +1,293 @@
+package router
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gammazero/nexus/v3/router/schema"
+	"github.com/gammazero/nexus/v3/transport"
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+func mustParseSchema(t *testing.T, doc string) *schema.Schema {
+	s, err := schema.Parse([]byte(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestPayloadSchemaCall(t *testing.T) {
+	const procedure = wamp.URI("schema.proc.add")
+	r := newTestRouterWithRealm(t, &RealmConfig{
+		PayloadSchemas: []*PayloadSchema{
+			{
+				URI:   "schema.proc.",
+				Match: wamp.MatchPrefix,
+				Args: mustParseSchema(t, `{
+                    "type": "array",
+                    "items": {"type": "integer"},
+                    "minItems": 2
+                }`),
+				ResultArgs: mustParseSchema(t, `{
+                    "type": "array",
+                    "items": [{"type": "integer"}]
+                }`),
+			},
+		},
+	})
+	defer r.Close()
+
+	callee, err := testClient(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	callee.Send(&wamp.Register{Request: wamp.GlobalID(), Procedure: procedure})
+	msg, err := wamp.RecvTimeout(callee, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := msg.(*wamp.Registered); !ok {
+		t.Fatal("Expected REGISTERED, got:", msg.MessageType())
+	}
+
+	caller, err := testClient(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Test that invalid call is rejected before reaching callee.
+	callID := wamp.GlobalID()
+	caller.Send(&wamp.Call{
+		Request:   callID,
+		Procedure: procedure,
+		Arguments: wamp.List{1, "two"},
+	})
+	msg, err = wamp.RecvTimeout(caller, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errMsg, ok := msg.(*wamp.Error)
+	if !ok {
+		t.Fatal("Expected ERROR, got:", msg.MessageType())
+	}
+	if errMsg.Error != wamp.ErrInvalidArgument || errMsg.Request != callID {
+		t.Fatal("Wrong error or request:", errMsg.Error, errMsg.Request)
+	}
+	if len(errMsg.Arguments) != 1 || errMsg.Arguments[0] != "args[1]: expected integer, got string" {
+		t.Fatal("Wrong error arguments:", errMsg.Arguments)
+	}
+
+	// Test that non-conforming result is replaced by error.
+	callID = wamp.GlobalID()
+	caller.Send(&wamp.Call{
+		Request:   callID,
+		Procedure: procedure,
+		Arguments: wamp.List{1, 2},
+	})
+	msg, err = wamp.RecvTimeout(callee, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inv, ok := msg.(*wamp.Invocation)
+	if !ok {
+		t.Fatal("Expected INVOCATION, got:", msg.MessageType())
+	}
+	callee.Send(&wamp.Yield{Request: inv.Request, Arguments: wamp.List{"three"}})
+	msg, err = wamp.RecvTimeout(caller, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errMsg, ok = msg.(*wamp.Error); !ok {
+		t.Fatal("Expected ERROR, got:", msg.MessageType())
+	}
+	if errMsg.Error != wamp.ErrInvalidArgument || errMsg.Request != callID {
+		t.Fatal("Wrong error or request:", errMsg.Error, errMsg.Request)
+	}
+
+	// Test that conforming call and result are routed.
+	callID = wamp.GlobalID()
+	caller.Send(&wamp.Call{
+		Request:   callID,
+		Procedure: procedure,
+		Arguments: wamp.List{1, 2},
+	})
+	msg, err = wamp.RecvTimeout(callee, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inv, ok = msg.(*wamp.Invocation); !ok {
+		t.Fatal("Expected INVOCATION, got:", msg.MessageType())
+	}
+	callee.Send(&wamp.Yield{Request: inv.Request, Arguments: wamp.List{3}})
+	msg, err = wamp.RecvTimeout(caller, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok = msg.(*wamp.Result); !ok {
+		t.Fatal("Expected RESULT, got:", msg.MessageType())
+	}
+}
+
+func TestPayloadSchemaPublish(t *testing.T) {
+	const topic = wamp.URI("schema.topic")
+	r := newTestRouterWithRealm(t, &RealmConfig{
+		PayloadSchemas: []*PayloadSchema{
+			{
+				URI: topic,
+				Kwargs: mustParseSchema(t, `{
+                    "type": "object",
+                    "required": ["name"]
+                }`),
+			},
+		},
+	})
+	defer r.Close()
+
+	sub, err := testClient(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub.Send(&wamp.Subscribe{Request: wamp.GlobalID(), Topic: topic})
+	msg, err := wamp.RecvTimeout(sub, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := msg.(*wamp.Subscribed); !ok {
+		t.Fatal("Expected SUBSCRIBED, got:", msg.MessageType())
+	}
+
+	pub, err := testClient(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubID := wamp.GlobalID()
+	pub.Send(&wamp.Publish{
+		Request: pubID,
+		Options: wamp.Dict{wamp.OptAcknowledge: true},
+		Topic:   topic,
+	})
+	msg, err = wamp.RecvTimeout(pub, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errMsg, ok := msg.(*wamp.Error)
+	if !ok {
+		t.Fatal("Expected ERROR, got:", msg.MessageType())
+	}
+	if errMsg.Error != wamp.ErrInvalidArgument || errMsg.Request != pubID {
+		t.Fatal("Wrong error or request:", errMsg.Error, errMsg.Request)
+	}
+
+	pub.Send(&wamp.Publish{
+		Request:     wamp.GlobalID(),
+		Topic:       topic,
+		ArgumentsKw: wamp.Dict{"name": "somebody"},
+	})
+	msg, err = wamp.RecvTimeout(sub, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok = msg.(*wamp.Event); !ok {
+		t.Fatal("Expected EVENT, got:", msg.MessageType())
+	}
+}
+
+func TestPayloadSchemaConfig(t *testing.T) {
+	s := mustParseSchema(t, `true`)
+	for _, ps := range []*PayloadSchema{
+		{URI: "a.b", Match: "bogus", Args: s},
+		{URI: "a..b", Args: s},
+	} {
+		if _, err := newPayloadValidator([]*PayloadSchema{ps}, false); err == nil {
+			t.Error("Expected error for invalid payload schema", ps.URI)
+		}
+	}
+	_, err := newPayloadValidator([]*PayloadSchema{{URI: "a.b"}, {URI: "a.b"}}, false)
+	if err == nil {
+		t.Error("Expected error for duplicate payload schema")
+	}
+
+	v, err := newPayloadValidator([]*PayloadSchema{
+		{URI: "a.b.c"},
+		{URI: "a.", Match: wamp.MatchPrefix},
+		{URI: "a.b.", Match: wamp.MatchPrefix},
+		{URI: "a..c", Match: wamp.MatchWildcard},
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for uri, expect := range map[wamp.URI]wamp.URI{
+		"a.b.c": "a.b.c",
+		"a.b.d": "a.b.",
+		"a.x.c": "a.",
+		"x.y.z": "",
+	} {
+		ps := v.match(uri)
+		if ps == nil {
+			if expect != "" {
+				t.Error("Expected match for", uri)
+			}
+			continue
+		}
+		if ps.URI != expect {
+			t.Errorf("Expected %v to match %v, matched %v", uri, expect, ps.URI)
+		}
+	}
+}
+
+// Test that a callee sending progressive results is interrupted when one of
+// its results does not conform to the schema.
+func TestPayloadSchemaProgressiveResult(t *testing.T) {
+	const procedure = wamp.URI("schema.proc.count")
+	r := newTestRouterWithRealm(t, &RealmConfig{
+		PayloadSchemas: []*PayloadSchema{
+			{
+				URI:        procedure,
+				ResultArgs: mustParseSchema(t, `{"type": "array", "items": {"type": "integer"}}`),
+			},
+		},
+	})
+	defer r.Close()
+
+	// Callee that supports progressive results.
+	client, server := transport.LinkedPeers()
+	go client.Send(&wamp.Hello{Realm: testRealm, Details: wamp.Dict{
+		"roles": wamp.Dict{
+			"callee": wamp.Dict{
+				"features": wamp.Dict{
+					wamp.FeatureProgCallResults: true,
+					wamp.FeatureCallCanceling:   true,
+				},
+			},
+		},
+	}})
+	if err := r.Attach(server); err != nil {
+		t.Fatal(err)
+	}
+	callee := wamp.NewSession(client, 0, nil, nil)
+	recvMsg(t, callee, wamp.WELCOME)
+	callee.Send(&wamp.Register{Request: wamp.GlobalID(), Procedure: procedure})
+	recvMsg(t, callee, wamp.REGISTERED)
+
+	caller, err := testClient(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caller.Send(&wamp.Call{
+		Request:   wamp.GlobalID(),
+		Procedure: procedure,
+		Options:   wamp.Dict{wamp.OptReceiveProgress: true},
+	})
+	inv := recvMsg(t, callee, wamp.INVOCATION).(*wamp.Invocation)
+	progress := wamp.Dict{wamp.OptProgress: true}
+	callee.Send(&wamp.Yield{Request: inv.Request, Options: progress, Arguments: wamp.List{1}})
+	recvMsg(t, caller, wamp.RESULT)
+
+	callee.Send(&wamp.Yield{Request: inv.Request, Options: progress, Arguments: wamp.List{"two"}})
+	expectErrorURI(t, caller, wamp.ErrInvalidArgument)
+	interrupt := recvMsg(t, callee, wamp.INTERRUPT).(*wamp.Interrupt)
+	if interrupt.Request != inv.Request {
+		t.Fatal("Wrong request ID in INTERRUPT")
+	}
+}
@@ -0,0 +1,30 @@
+package router
+
+import "github.com/gammazero/nexus/v3/wamp"
+
+// The router wraps the transport peer of each session in other peers: a
+// slowConsumerPeer, and a resumablePeer under it if the session is
+// resumable.
+
+// wrappedPeer is implemented by peers that wrap another peer.
+type wrappedPeer interface {
+	// Unwrap returns the wrapped peer, or nil if there is none.
+	Unwrap() wamp.Peer
+}
+
+// findPeer calls match for the peer and for each peer that it wraps, from
+// the outermost peer inward, until match returns true.  Returns true if
+// match returned true.
+func findPeer(peer wamp.Peer, match func(wamp.Peer) bool) bool {
+	for peer != nil {
+		if match(peer) {
+			return true
+		}
+		w, ok := peer.(wrappedPeer)
+		if !ok {
+			break
+		}
+		peer = w.Unwrap()
+	}
+	return false
+}
@@ -0,0 +1,313 @@
+package router
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultProxyHeaderTimeout = 10 * time.Second
+
+	// proxyV1MaxLen is the maximum length of a PROXY protocol v1 header,
+	// including the terminating CRLF.
+	proxyV1MaxLen = 107
+)
+
+// proxyV2Sig is the signature that begins a PROXY protocol v2 header.
+var proxyV2Sig = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// ProxyProtocol configures a server to accept HAProxy PROXY protocol headers.
+// A load balancer or proxy sends this header at the start of a connection to
+// tell the server the address of the client that the connection is for.  When
+// a connection has a header, the client address from the header is used as
+// the connection's remote address.  Both version 1 (text) and version 2
+// (binary) headers are accepted.
+//
+// See https://www.haproxy.org/download/2.4/doc/proxy-protocol.txt
+type ProxyProtocol struct {
+	// Required rejects connections that do not begin with a PROXY protocol
+	// header.  Otherwise, connections without a header are accepted as
+	// direct connections from the client.
+	Required bool
+
+	// TrustedCIDRs lists the networks, in CIDR notation, from which PROXY
+	// protocol headers are accepted.  A header is not looked for on a
+	// connection from any other address, and the connection is rejected if a
+	// header is required.  If empty, then headers are accepted from any
+	// address, which is only allowed when headers are required.  Otherwise,
+	// a client connecting directly could send a header that spoofs its
+	// address.
+	TrustedCIDRs []string
+
+	// HeaderTimeout is the time allowed to receive the header.  The default
+	// is defaultProxyHeaderTimeout.
+	HeaderTimeout time.Duration
+}
+
+// proxyConfig is a ProxyProtocol with its trusted networks parsed.
+type proxyConfig struct {
+	required bool
+	trusted  []*net.IPNet
+	timeout  time.Duration
+}
+
+// compile checks the ProxyProtocol configuration and returns it in the form
+// used to wrap connections.  Returns nil if p is nil.
+func (p *ProxyProtocol) compile() (*proxyConfig, error) {
+	if p == nil {
+		return nil, nil
+	}
+	cfg := &proxyConfig{
+		required: p.Required,
+		timeout:  p.HeaderTimeout,
+	}
+	if cfg.timeout == 0 {
+		cfg.timeout = defaultProxyHeaderTimeout
+	}
+	if !p.Required && len(p.TrustedCIDRs) == 0 {
+		return nil, errors.New("trusted proxy networks required when PROXY protocol header is optional")
+	}
+	for _, cidr := range p.TrustedCIDRs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy network: %s", err)
+		}
+		cfg.trusted = append(cfg.trusted, ipNet)
+	}
+	return cfg, nil
+}
+
+// compileFor returns the configuration used to wrap connections on the
+// network, or nil if p is nil or the network does not use TCP.
+func (p *ProxyProtocol) compileFor(network string) (*proxyConfig, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		return p.compile()
+	}
+	return nil, nil
+}
+
+// trusts returns true if PROXY headers are accepted from the address.
+func (c *proxyConfig) trusts(addr net.Addr) bool {
+	if len(c.trusted) == 0 {
+		return true
+	}
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, ipNet := range c.trusted {
+		if ipNet.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// wrap returns a connection that reads a PROXY protocol header, if there is
+// one, before any other data is read from the connection.  Returns conn if c
+// is nil.
+func (c *proxyConfig) wrap(conn net.Conn) net.Conn {
+	if c == nil {
+		return conn
+	}
+	return &proxyConn{Conn: conn, cfg: c}
+}
+
+// proxyListener is a net.Listener that wraps each accepted connection to read
+// its PROXY protocol header.
+type proxyListener struct {
+	net.Listener
+	cfg *proxyConfig
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return l.cfg.wrap(conn), nil
+}
+
+// wrapListener returns a listener that wraps each accepted connection to read
+// its PROXY protocol header.  Returns l if cfg is nil.
+func wrapListener(l net.Listener, cfg *proxyConfig) net.Listener {
+	if cfg == nil {
+		return l
+	}
+	return &proxyListener{Listener: l, cfg: cfg}
+}
+
+// proxyConn is a connection that may begin with a PROXY protocol header.  The
+// header is read by the first call to Read or RemoteAddr, which happens in
+// the goroutine handling the connection, so that a slow client does not hold
+// up accepting other connections.
+type proxyConn struct {
+	net.Conn
+	cfg *proxyConfig
+
+	once   sync.Once
+	r      *bufio.Reader
+	remote net.Addr
+	err    error
+}
+
+// header reads the PROXY protocol header, if not already read, and returns
+// any error reading it.
+func (c *proxyConn) header() error {
+	c.once.Do(c.readHeader)
+	return c.err
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(b)
+}
+
+// RemoteAddr returns the client address from the PROXY protocol header, or the
+// address of the other end of the connection if there is no header.
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyConn) readHeader() {
+	c.r = bufio.NewReaderSize(c.Conn, 512)
+	if !c.cfg.trusts(c.Conn.RemoteAddr()) {
+		if c.cfg.required {
+			c.err = fmt.Errorf("PROXY header required from untrusted address %s",
+				c.Conn.RemoteAddr())
+		}
+		return
+	}
+
+	c.Conn.SetReadDeadline(time.Now().Add(c.cfg.timeout))
+	defer c.Conn.SetReadDeadline(time.Time{})
+
+	first, err := c.r.Peek(1)
+	if err != nil {
+		c.err = err
+		return
+	}
+	switch first[0] {
+	case 'P':
+		if b, err := c.r.Peek(6); err == nil && string(b) == "PROXY " {
+			c.remote, c.err = readProxyV1(c.r)
+			return
+		}
+	case proxyV2Sig[0]:
+		if b, err := c.r.Peek(len(proxyV2Sig)); err == nil && bytes.Equal(b, proxyV2Sig) {
+			c.remote, c.err = readProxyV2(c.r)
+			return
+		}
+	}
+	if c.cfg.required {
+		c.err = errors.New("missing PROXY header")
+	}
+}
+
+// readProxyV1 reads a text PROXY protocol header and returns the client
+// address.  The address is nil if the header does not provide it.
+func readProxyV1(r *bufio.Reader) (net.Addr, error) {
+	line, err := r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull || len(line) > proxyV1MaxLen {
+		return nil, errors.New("PROXY header too long")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.New("PROXY header not terminated by CRLF")
+	}
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 {
+		return nil, errors.New("invalid PROXY header")
+	}
+	ip := net.ParseIP(fields[2])
+	if ip == nil {
+		return nil, errors.New("invalid source address in PROXY header")
+	}
+	switch fields[1] {
+	case "TCP4":
+		if ip.To4() == nil {
+			return nil, errors.New("source address in PROXY header is not IPv4")
+		}
+	case "TCP6":
+		if ip.To4() != nil {
+			return nil, errors.New("source address in PROXY header is not IPv6")
+		}
+	default:
+		return nil, errors.New("invalid protocol in PROXY header")
+	}
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, errors.New("invalid source port in PROXY header")
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readProxyV2 reads a binary PROXY protocol header and returns the client
+// address.  The address is nil if the header does not provide it, as for a
+// health check from the proxy itself.
+func readProxyV2(r *bufio.Reader) (net.Addr, error) {
+	var hdr [16]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	if hdr[12]>>4 != 2 {
+		return nil, errors.New("unsupported PROXY header version")
+	}
+	body := make([]byte, binary.BigEndian.Uint16(hdr[14:]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+
+	switch hdr[12] & 0xf {
+	case 0x0:
+		// LOCAL command: the connection is from the proxy itself.
+		return nil, nil
+	case 0x1:
+		// PROXY command.
+	default:
+		return nil, errors.New("invalid command in PROXY header")
+	}
+	switch hdr[13] >> 4 {
+	case 0x1:
+		// AF_INET: source address, destination address, source port,
+		// destination port.
+		if len(body) < 12 {
+			return nil, errors.New("PROXY header address too short")
+		}
+		ip := make(net.IP, net.IPv4len)
+		copy(ip, body[:4])
+		return &net.TCPAddr{IP: ip, Port: int(binary.BigEndian.Uint16(body[8:]))}, nil
+	case 0x2:
+		// AF_INET6.
+		if len(body) < 36 {
+			return nil, errors.New("PROXY header address too short")
+		}
+		ip := make(net.IP, net.IPv6len)
+		copy(ip, body[:16])
+		return &net.TCPAddr{IP: ip, Port: int(binary.BigEndian.Uint16(body[32:]))}, nil
+	}
+	// AF_UNSPEC or AF_UNIX: no usable client address.
+	return nil, nil
+}
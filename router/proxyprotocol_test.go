@@ -0,0 +1,300 @@
+package router
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fortytw2/leaktest"
+	"github.com/gammazero/nexus/v3/router/auth"
+	"github.com/gammazero/nexus/v3/transport"
+	"github.com/gammazero/nexus/v3/transport/serialize"
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// peerAuth is an anonymous authenticator that reports the peer address from
+// the transport details of each client.
+type peerAuth struct {
+	peers chan string
+}
+
+func (a *peerAuth) AuthMethod() string { return "anonymous" }
+
+func (a *peerAuth) Authenticate(sid wamp.ID, details wamp.Dict, client wamp.Peer) (*wamp.Welcome, error) {
+	peer, _ := wamp.DictValue(details, []string{"transport", "peer"})
+	s, _ := wamp.AsString(peer)
+	a.peers <- s
+	return &wamp.Welcome{
+		Details: wamp.Dict{"authid": "someone", "authrole": "user"},
+	}, nil
+}
+
+func newPeerAuthRouter(t *testing.T) (Router, *peerAuth) {
+	pa := &peerAuth{peers: make(chan string, 1)}
+	r, err := NewRouter(&Config{
+		RealmConfigs: []*RealmConfig{
+			{
+				URI:            testRealm,
+				Authenticators: []auth.Authenticator{pa},
+			},
+		},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r, pa
+}
+
+// proxyV2Header returns a binary PROXY protocol header for a TCP connection
+// from the source address.
+func proxyV2Header(src *net.TCPAddr) []byte {
+	var fam byte
+	var addrs []byte
+	if ip4 := src.IP.To4(); ip4 != nil {
+		fam = 0x11
+		addrs = append(append(addrs, ip4...), 127, 0, 0, 1)
+	} else {
+		fam = 0x21
+		addrs = append(append(addrs, src.IP.To16()...), net.IPv6loopback...)
+	}
+	ports := make([]byte, 4)
+	binary.BigEndian.PutUint16(ports, uint16(src.Port))
+	addrs = append(addrs, ports...)
+
+	hdr := append([]byte{}, proxyV2Sig...)
+	hdr = append(hdr, 0x21, fam, 0, 0)
+	binary.BigEndian.PutUint16(hdr[14:], uint16(len(addrs)))
+	return append(hdr, addrs...)
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	for _, tc := range []struct {
+		header string
+		expect string
+	}{
+		{"PROXY TCP4 192.0.2.1 192.0.2.2 5000 8000\r\n", "192.0.2.1:5000"},
+		{"PROXY TCP6 2001:db8::1 2001:db8::2 5001 8000\r\n", "[2001:db8::1]:5001"},
+		{"PROXY UNKNOWN\r\n", ""},
+		{string(proxyV2Header(&net.TCPAddr{IP: net.ParseIP("198.51.100.7"), Port: 6000})), "198.51.100.7:6000"},
+		{string(proxyV2Header(&net.TCPAddr{IP: net.ParseIP("2001:db8::7"), Port: 6001})), "[2001:db8::7]:6001"},
+		// LOCAL command.
+		{string(append(append([]byte{}, proxyV2Sig...), 0x20, 0x00, 0, 0)), ""},
+	} {
+		r := bufio.NewReader(strings.NewReader(tc.header + "data"))
+		var addr net.Addr
+		var err error
+		if strings.HasPrefix(tc.header, "PROXY") {
+			addr, err = readProxyV1(r)
+		} else {
+			addr, err = readProxyV2(r)
+		}
+		if err != nil {
+			t.Fatalf("Error reading header %q: %s", tc.header, err)
+		}
+		var got string
+		if addr != nil {
+			got = addr.String()
+		}
+		if got != tc.expect {
+			t.Fatalf("Expected address %q from header %q, got %q", tc.expect, tc.header, got)
+		}
+		if rest, _ := ioutil.ReadAll(r); string(rest) != "data" {
+			t.Fatalf("Header %q not fully consumed", tc.header)
+		}
+	}
+
+	for _, header := range []string{
+		"PROXY TCP4 192.0.2.1 192.0.2.2 5000\r\n",
+		"PROXY TCP4 2001:db8::1 192.0.2.2 5000 8000\r\n",
+		"PROXY TCP6 192.0.2.1 192.0.2.2 5000 8000\r\n",
+		"PROXY UDP4 192.0.2.1 192.0.2.2 5000 8000\r\n",
+		"PROXY TCP4 192.0.2.1 192.0.2.2 70000 8000\r\n",
+		"PROXY TCP4 192.0.2.1 192.0.2.2 5000 8000\n",
+		"PROXY TCP4 " + strings.Repeat("1", 120) + "\r\n",
+	} {
+		if _, err := readProxyV1(bufio.NewReader(strings.NewReader(header))); err == nil {
+			t.Fatalf("Expected error reading header %q", header)
+		}
+	}
+	badVersion := proxyV2Header(&net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1})
+	badVersion[12] = 0x11
+	truncated := proxyV2Header(&net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1})
+	for _, header := range [][]byte{badVersion, truncated[:len(truncated)-1]} {
+		if _, err := readProxyV2(bufio.NewReader(bytes.NewReader(header))); err == nil {
+			t.Fatalf("Expected error reading header %q", header)
+		}
+	}
+}
+
+func TestWSProxyProtocol(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	r, pa := newPeerAuthRouter(t)
+	defer r.Close()
+
+	s := NewWebsocketServer(r)
+	s.ProxyProtocol = &ProxyProtocol{
+		Required:     true,
+		TrustedCIDRs: []string{"127.0.0.0/8"},
+	}
+	closer, err := s.ListenAndServe(wsAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer.Close()
+
+	// Dial through a "proxy" that sends a PROXY header for the client.
+	wsCfg := &transport.WebsocketConfig{
+		Dial: func(network, addr string) (net.Conn, error) {
+			conn, err := net.Dial(network, addr)
+			if err != nil {
+				return nil, err
+			}
+			_, err = fmt.Fprintf(conn, "PROXY TCP4 192.0.2.10 127.0.0.1 40000 8000\r\n")
+			return conn, err
+		},
+	}
+	client, err := transport.ConnectWebsocketPeer(context.Background(),
+		fmt.Sprintf("ws://%s/", wsAddr), serialize.JSON, nil, r.Logger(), wsCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkWelcome(t, client)
+	if peer := <-pa.peers; peer != "192.0.2.10:40000" {
+		t.Fatal("Wrong peer address in transport details:", peer)
+	}
+
+	// The header is required.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err = transport.ConnectWebsocketPeer(ctx, fmt.Sprintf("ws://%s/", wsAddr),
+		serialize.JSON, nil, r.Logger(), nil)
+	if err == nil {
+		t.Fatal("Expected error connecting without PROXY header")
+	}
+}
+
+func TestRSProxyProtocol(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	r, pa := newPeerAuthRouter(t)
+	defer r.Close()
+
+	s := NewRawSocketServer(r)
+	s.ProxyProtocol = &ProxyProtocol{TrustedCIDRs: []string{"127.0.0.0/8"}}
+	closer, err := s.ListenAndServe("tcp", tcpAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer.Close()
+
+	// connect opens a rawsocket connection that starts with the header, and
+	// returns the result of joining the realm.
+	connect := func(header []byte) (wamp.Message, error) {
+		conn, err := net.Dial("tcp", tcpAddr)
+		if err != nil {
+			return nil, err
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(time.Second))
+
+		// PROXY header, then rawsocket handshake for JSON serialization.
+		handshake := append(append([]byte{}, header...), 0x7f, 0xf1, 0, 0)
+		if _, err = conn.Write(handshake); err != nil {
+			return nil, err
+		}
+		reply := make([]byte, 4)
+		if _, err = io.ReadFull(conn, reply); err != nil {
+			return nil, err
+		}
+		if reply[0] != 0x7f || reply[1]&0xf != 1 {
+			return nil, fmt.Errorf("bad handshake reply %x", reply)
+		}
+
+		var serializer serialize.JSONSerializer
+		b, err := serializer.Serialize(&wamp.Hello{Realm: testRealm, Details: clientRoles})
+		if err != nil {
+			return nil, err
+		}
+		frame := []byte{0, byte(len(b) >> 16), byte(len(b) >> 8), byte(len(b))}
+		if _, err = conn.Write(append(frame, b...)); err != nil {
+			return nil, err
+		}
+		if _, err = io.ReadFull(conn, frame); err != nil {
+			return nil, err
+		}
+		b = make([]byte, int(frame[1])<<16|int(frame[2])<<8|int(frame[3]))
+		if _, err = io.ReadFull(conn, b); err != nil {
+			return nil, err
+		}
+		return serializer.Deserialize(b)
+	}
+
+	for _, tc := range []struct {
+		header []byte
+		expect string
+	}{
+		{proxyV2Header(&net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 5000}), "[2001:db8::1]:5000"},
+		{[]byte("PROXY TCP4 192.0.2.20 127.0.0.1 5001 8181\r\n"), "192.0.2.20:5001"},
+		// Header is optional, so the connection address is used.
+		{nil, "127.0.0.1:"},
+	} {
+		msg, err := connect(tc.header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := msg.(*wamp.Welcome); !ok {
+			t.Fatalf("expected WELCOME, got %s: %+v", msg.MessageType(), msg)
+		}
+		if peer := <-pa.peers; !strings.HasPrefix(peer, tc.expect) {
+			t.Fatal("Wrong peer address in transport details:", peer)
+		}
+	}
+}
+
+func TestProxyProtocolUntrusted(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	r, err := NewRouter(routerConfig, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	s := NewRawSocketServer(r)
+	s.ProxyProtocol = &ProxyProtocol{TrustedCIDRs: []string{"bad"}}
+	if _, err = s.ListenAndServe("tcp", tcpAddr); err == nil {
+		t.Fatal("Expected error for invalid trusted network")
+	}
+
+	// Optional headers must be limited to trusted networks.
+	s.ProxyProtocol = &ProxyProtocol{}
+	if _, err = s.ListenAndServe("tcp", tcpAddr); err == nil {
+		t.Fatal("Expected error for optional header without trusted networks")
+	}
+
+	// Headers are required, but not accepted from this address.
+	s.ProxyProtocol = &ProxyProtocol{
+		Required:     true,
+		TrustedCIDRs: []string{"10.0.0.0/8"},
+	}
+	closer, err := s.ListenAndServe("tcp", tcpAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer.Close()
+
+	_, err = transport.ConnectRawSocketPeer(context.Background(), "tcp",
+		tcpAddr, serialize.JSON, nil, r.Logger(), 0)
+	if err == nil {
+		t.Fatal("Expected error connecting from untrusted address")
+	}
+}
@@ -0,0 +1,166 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gammazero/nexus/v3/transport"
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// errSessionLimitExceeded is returned by realm.handleSession when the realm
+// already has the maximum number of sessions.
+var errSessionLimitExceeded = errors.New("realm has maximum number of sessions")
+
+// checkPayloadSize checks that the serialized size of a CALL, YIELD, PUBLISH,
+// or ERROR message does not exceed the realm's maximum payload size.  The size
+// is measured by the transport as the message is received, so messages from
+// local sessions, which are not serialized, are not limited.  Returns the
+// message to route, or nil if the message was rejected.
+//
+// A rejected CALL or PUBLISH is answered with an ERROR to the session.  A
+// rejected YIELD or ERROR from a callee is replaced by an ERROR that is routed
+// to the caller, so that the caller is not left waiting for a response.
+func (r *realm) checkPayloadSize(sess *wamp.Session, msg wamp.Message) wamp.Message {
+	if r.maxPayloadSize <= 0 {
+		return msg
+	}
+	size, over := overSizeLimit(sess.Peer, msg)
+	if !over {
+		return msg
+	}
+
+	r.log.Printf("Rejected %s from session %s: payload size %d exceeds %d",
+		msg.MessageType(), sess, size, r.maxPayloadSize)
+	errArgs := wamp.List{fmt.Sprint("payload size ", size, " exceeds maximum of ",
+		r.maxPayloadSize)}
+	switch msg := msg.(type) {
+	case *wamp.Yield:
+		return &wamp.Error{
+			Type:      wamp.INVOCATION,
+			Request:   msg.Request,
+			Details:   wamp.Dict{},
+			Error:     wamp.ErrPayloadSizeExceeded,
+			Arguments: errArgs,
+		}
+	case *wamp.Error:
+		return &wamp.Error{
+			Type:      msg.Type,
+			Request:   msg.Request,
+			Details:   wamp.Dict{},
+			Error:     wamp.ErrPayloadSizeExceeded,
+			Arguments: errArgs,
+		}
+	}
+	if reply := ErrorReply(msg, wamp.ErrPayloadSizeExceeded, errArgs); reply != nil {
+		if err := sess.TrySend(reply); err != nil {
+			r.log.Println("!!! client blocked, could not send payload size error")
+		}
+	}
+	return nil
+}
+
+// setSizeLimit sets the size limit of the messages received by a transport
+// peer, if the peer supports it.
+func setSizeLimit(peer wamp.Peer, n int) {
+	if p, ok := peer.(transport.SizeLimitPeer); ok {
+		p.SetSizeLimit(n)
+	}
+}
+
+// overSizeLimit returns the serialized size of a message received from the
+// transport peer under any peers that the router wraps it in, and true, if the
+// message exceeded the peer's size limit.
+func overSizeLimit(peer wamp.Peer, msg wamp.Message) (int, bool) {
+	var size int
+	var over bool
+	findPeer(peer, func(peer wamp.Peer) bool {
+		p, ok := peer.(transport.SizeLimitPeer)
+		if ok {
+			size, over = p.OverSizeLimit(msg)
+		}
+		return ok
+	})
+	return size, over
+}
+
+// realmGetQuotas is a non-standard meta procedure that returns the resource
+// limits of the realm, and the number of sessions currently attached to the
+// realm.  A limit of 0 means no limit.
+func (r *realm) realmGetQuotas(msg *wamp.Invocation) wamp.Message {
+	retChan := make(chan int)
+	r.actionChan <- func() {
+		retChan <- len(r.clients)
+	}
+	nclients := <-retChan
+
+	return &wamp.Yield{
+		Request: msg.Request,
+		Arguments: wamp.List{wamp.Dict{
+			"max_sessions":                  r.maxSessions,
+			"max_subscriptions_per_session": r.broker.maxSubscriptions,
+			"max_registrations_per_session": r.dealer.maxRegistrations,
+			"max_pending_calls_per_caller":  r.dealer.maxPendingCalls,
+			"max_payload_size":              r.maxPayloadSize,
+			"sessions":                      nclients,
+		}},
+	}
+}
+
+// sessionGetQuotaUsage is a non-standard meta procedure that returns the
+// number of subscriptions, registrations, and pending calls of the session
+// identified by session ID.
+func (r *realm) sessionGetQuotaUsage(msg *wamp.Invocation) wamp.Message {
+	if len(msg.Arguments) == 0 {
+		return makeError(msg.Request, wamp.ErrNoSuchSession)
+	}
+
+	sid, ok := wamp.AsID(msg.Arguments[0])
+	if !ok {
+		return makeError(msg.Request, wamp.ErrNoSuchSession)
+	}
+
+	retChan := make(chan *wamp.Session)
+	r.actionChan <- func() {
+		sess, _ := r.clients[sid]
+		retChan <- sess
+	}
+	sess := <-retChan
+	if sess == nil {
+		return makeError(msg.Request, wamp.ErrNoSuchSession)
+	}
+
+	subs := r.broker.subscriptionCount(sess)
+	regs, calls := r.dealer.quotaUsage(sess)
+
+	return &wamp.Yield{
+		Request: msg.Request,
+		Arguments: wamp.List{wamp.Dict{
+			"subscriptions": subs,
+			"registrations": regs,
+			"pending_calls": calls,
+		}},
+	}
+}
+
+// subscriptionCount returns the number of subscriptions of the session.
+func (b *broker) subscriptionCount(sess *wamp.Session) int {
+	retChan := make(chan int)
+	b.actionChan <- func() {
+		retChan <- len(b.sessionSubIDSet[sess])
+	}
+	return <-retChan
+}
+
+// quotaUsage returns the number of registrations and pending calls of the
+// session.
+func (d *dealer) quotaUsage(sess *wamp.Session) (regs, calls int) {
+	sync := make(chan struct{})
+	d.actionChan <- func() {
+		regs = len(d.calleeRegIDSet[sess])
+		calls = d.pendingCalls.count(sess.ID)
+		close(sync)
+	}
+	<-sync
+	return
+}
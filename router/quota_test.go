@@ -0,0 +1,309 @@
+package router
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gammazero/nexus/v3/transport"
+	"github.com/gammazero/nexus/v3/transport/serialize"
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+func expectErrorURI(t *testing.T, sess *wamp.Session, errURI wamp.URI) {
+	msg, err := wamp.RecvTimeout(sess, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errMsg, ok := msg.(*wamp.Error)
+	if !ok {
+		t.Fatal("Expected ERROR, got:", msg.MessageType())
+	}
+	if errMsg.Error != errURI {
+		t.Fatal("Expected error", errURI, "got", errMsg.Error)
+	}
+}
+
+func TestSessionLimit(t *testing.T) {
+	r := newTestRouterWithRealm(t, &RealmConfig{MaxSessions: 1})
+	defer r.Close()
+
+	sess, err := testClient(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = testClient(r); err == nil {
+		t.Fatal("Expected error attaching session over limit")
+	}
+
+	// Test that a session can join after another leaves.
+	sess.Send(&wamp.Goodbye{Reason: wamp.CloseRealm, Details: wamp.Dict{}})
+	if _, err = wamp.RecvTimeout(sess, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, err = testClient(r); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSubscriptionLimit(t *testing.T) {
+	r := newTestRouterWithRealm(t, &RealmConfig{MaxSubscriptionsPerSession: 2})
+	defer r.Close()
+
+	sub, err := testClient(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, topic := range []wamp.URI{"quota.a", "quota.b", "quota.a"} {
+		sub.Send(&wamp.Subscribe{Request: wamp.GlobalID(), Topic: topic})
+		msg, err := wamp.RecvTimeout(sub, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := msg.(*wamp.Subscribed); !ok {
+			t.Fatal("Expected SUBSCRIBED, got:", msg.MessageType())
+		}
+	}
+	sub.Send(&wamp.Subscribe{Request: wamp.GlobalID(), Topic: "quota.c"})
+	expectErrorURI(t, sub, wamp.ErrSubscriptionLimitExceeded)
+}
+
+func TestRegistrationLimit(t *testing.T) {
+	r := newTestRouterWithRealm(t, &RealmConfig{MaxRegistrationsPerSession: 1})
+	defer r.Close()
+
+	callee, err := testClient(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	callee.Send(&wamp.Register{Request: wamp.GlobalID(), Procedure: "quota.a"})
+	msg, err := wamp.RecvTimeout(callee, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := msg.(*wamp.Registered); !ok {
+		t.Fatal("Expected REGISTERED, got:", msg.MessageType())
+	}
+	callee.Send(&wamp.Register{Request: wamp.GlobalID(), Procedure: "quota.b"})
+	expectErrorURI(t, callee, wamp.ErrRegistrationLimitExceeded)
+}
+
+func TestPendingCallLimit(t *testing.T) {
+	const procedure = wamp.URI("quota.proc")
+	r := newTestRouterWithRealm(t, &RealmConfig{MaxPendingCallsPerCaller: 1})
+	defer r.Close()
+
+	callee, err := testClient(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	callee.Send(&wamp.Register{Request: wamp.GlobalID(), Procedure: procedure})
+	if _, err = wamp.RecvTimeout(callee, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	caller, err := testClient(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caller.Send(&wamp.Call{Request: wamp.GlobalID(), Procedure: procedure})
+	msg, err := wamp.RecvTimeout(callee, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inv, ok := msg.(*wamp.Invocation)
+	if !ok {
+		t.Fatal("Expected INVOCATION, got:", msg.MessageType())
+	}
+
+	// Second call exceeds limit while first is pending.
+	caller.Send(&wamp.Call{Request: wamp.GlobalID(), Procedure: procedure})
+	expectErrorURI(t, caller, wamp.ErrPendingCallLimitExceeded)
+
+	// Check usage reported by meta procedure.  The pending calls include the
+	// call to the meta procedure.
+	caller.Send(&wamp.Call{
+		Request:   wamp.GlobalID(),
+		Procedure: wamp.MetaProcSessionGetQuotaUsage,
+		Arguments: wamp.List{caller.ID},
+	})
+	msg, err = wamp.RecvTimeout(caller, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, ok := msg.(*wamp.Result)
+	if !ok {
+		t.Fatal("Expected RESULT, got:", msg.MessageType())
+	}
+	usage, _ := wamp.AsDict(result.Arguments[0])
+	if n, _ := wamp.AsInt64(usage["pending_calls"]); n != 2 {
+		t.Fatal("Expected 2 pending calls, got:", usage)
+	}
+
+	// Finishing the first call allows another.
+	callee.Send(&wamp.Yield{Request: inv.Request})
+	if msg, err = wamp.RecvTimeout(caller, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok = msg.(*wamp.Result); !ok {
+		t.Fatal("Expected RESULT, got:", msg.MessageType())
+	}
+	caller.Send(&wamp.Call{Request: wamp.GlobalID(), Procedure: procedure})
+	msg, err = wamp.RecvTimeout(callee, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok = msg.(*wamp.Invocation); !ok {
+		t.Fatal("Expected INVOCATION, got:", msg.MessageType())
+	}
+}
+
+// rawSocketTestClient connects a client to the router over a raw socket, so
+// that the messages it sends are serialized.
+func rawSocketTestClient(t *testing.T, r Router, addr string) *wamp.Session {
+	client, err := transport.ConnectRawSocketPeer(context.Background(), "tcp",
+		addr, serialize.JSON, nil, r.Logger(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Send(&wamp.Hello{Realm: testRealm, Details: clientRoles})
+	sess := wamp.NewSession(client, 0, nil, nil)
+	recvMsg(t, sess, wamp.WELCOME)
+	return sess
+}
+
+func TestPayloadSizeLimit(t *testing.T) {
+	const (
+		procedure = wamp.URI("quota.proc")
+		topic     = wamp.URI("quota.topic")
+	)
+	r := newTestRouterWithRealm(t, &RealmConfig{
+		AnonymousAuth:  true,
+		MaxPayloadSize: 64,
+	})
+	defer r.Close()
+	closer, err := NewRawSocketServer(r).ListenAndServe("tcp", tcpAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer.Close()
+
+	callee := rawSocketTestClient(t, r, tcpAddr)
+	defer callee.Close()
+	callee.Send(&wamp.Register{Request: wamp.GlobalID(), Procedure: procedure})
+	recvMsg(t, callee, wamp.REGISTERED)
+	caller := rawSocketTestClient(t, r, tcpAddr)
+	defer caller.Close()
+	big := strings.Repeat("x", 64)
+
+	caller.Send(&wamp.Call{
+		Request:   wamp.GlobalID(),
+		Procedure: procedure,
+		Arguments: wamp.List{big},
+	})
+	expectErrorURI(t, caller, wamp.ErrPayloadSizeExceeded)
+
+	caller.Send(&wamp.Publish{
+		Request:     wamp.GlobalID(),
+		Topic:       topic,
+		Options:     wamp.Dict{wamp.OptAcknowledge: true},
+		ArgumentsKw: wamp.Dict{"data": big},
+	})
+	expectErrorURI(t, caller, wamp.ErrPayloadSizeExceeded)
+
+	// Test that a YIELD that is too large results in error to caller.
+	caller.Send(&wamp.Call{Request: wamp.GlobalID(), Procedure: procedure})
+	inv := recvMsg(t, callee, wamp.INVOCATION).(*wamp.Invocation)
+	callee.Send(&wamp.Yield{Request: inv.Request, Arguments: wamp.List{big}})
+	expectErrorURI(t, caller, wamp.ErrPayloadSizeExceeded)
+
+	// Test that messages within the limit are routed.
+	caller.Send(&wamp.Call{Request: wamp.GlobalID(), Procedure: procedure})
+	inv = recvMsg(t, callee, wamp.INVOCATION).(*wamp.Invocation)
+	callee.Send(&wamp.Yield{Request: inv.Request})
+	recvMsg(t, caller, wamp.RESULT)
+
+	// Test that local clients are not limited.
+	local, err := testClient(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	local.Send(&wamp.Call{
+		Request:   wamp.GlobalID(),
+		Procedure: procedure,
+		Arguments: wamp.List{big},
+	})
+	recvMsg(t, callee, wamp.INVOCATION)
+}
+
+func TestRealmGetQuotas(t *testing.T) {
+	r := newTestRouterWithRealm(t, &RealmConfig{
+		MaxSessions:                5,
+		MaxSubscriptionsPerSession: 10,
+		MaxPayloadSize:             1024,
+	})
+	defer r.Close()
+
+	caller, err := testClient(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caller.Send(&wamp.Call{
+		Request:   wamp.GlobalID(),
+		Procedure: wamp.MetaProcRealmGetQuotas,
+	})
+	msg, err := wamp.RecvTimeout(caller, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, ok := msg.(*wamp.Result)
+	if !ok {
+		t.Fatal("Expected RESULT, got:", msg.MessageType())
+	}
+	quotas, _ := wamp.AsDict(result.Arguments[0])
+	for k, expect := range map[string]int64{
+		"max_sessions":                  5,
+		"max_subscriptions_per_session": 10,
+		"max_registrations_per_session": 0,
+		"max_payload_size":              1024,
+		"sessions":                      1,
+	} {
+		if n, _ := wamp.AsInt64(quotas[k]); n != expect {
+			t.Errorf("Expected %s to be %d, got %v", k, expect, quotas[k])
+		}
+	}
+}
+
+func TestPayloadSizeLimitBeforeInterceptors(t *testing.T) {
+	const topic = wamp.URI("quota.topic")
+	// Interceptor that replaces each PUBLISH with a copy.
+	rewrite := &TypedInterceptor{
+		Publish: func(sess *wamp.Session, msg *wamp.Publish) (wamp.Message, wamp.Message) {
+			pub := *msg
+			return &pub, nil
+		},
+	}
+	r := newTestRouterWithRealm(t, &RealmConfig{
+		AnonymousAuth:       true,
+		MaxPayloadSize:      64,
+		InboundInterceptors: []Interceptor{rewrite},
+	})
+	defer r.Close()
+	closer, err := NewRawSocketServer(r).ListenAndServe("tcp", tcpAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer.Close()
+
+	pub := rawSocketTestClient(t, r, tcpAddr)
+	defer pub.Close()
+	pub.Send(&wamp.Publish{
+		Request:     wamp.GlobalID(),
+		Topic:       topic,
+		Options:     wamp.Dict{wamp.OptAcknowledge: true},
+		ArgumentsKw: wamp.Dict{"data": strings.Repeat("x", 64)},
+	})
+	expectErrorURI(t, pub, wamp.ErrPayloadSizeExceeded)
+}
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/gammazero/nexus/v3/transport"
+	"github.com/gammazero/nexus/v3/wamp"
 )
 
 // RawSocketServer handles socket connections.
@@ -23,6 +24,11 @@ type RawSocketServer struct {
 	// client.  The default is defaultOutQueueSize.
 	OutQueueSize int
 
+	// ProxyProtocol, if not nil, configures the server to accept PROXY
+	// protocol headers on TCP connections, from load balancers that report
+	// the client address this way.
+	ProxyProtocol *ProxyProtocol
+
 	router Router
 }
 
@@ -36,13 +42,17 @@ func NewRawSocketServer(r Router) *RawSocketServer {
 // ListenAndServe listens on the specified endpoint and starts a goroutine that
 // accepts new client connections until the returned io.closer is closed.
 func (s *RawSocketServer) ListenAndServe(network, address string) (io.Closer, error) {
+	proxyCfg, err := s.ProxyProtocol.compileFor(network)
+	if err != nil {
+		return nil, err
+	}
 	l, err := net.Listen(network, address)
 	if err != nil {
 		return nil, err
 	}
 
 	// Start request handler loop.
-	go s.requestHandler(l)
+	go s.requestHandler(l, proxyCfg, nil)
 
 	return l, nil
 }
@@ -68,18 +78,25 @@ func (s *RawSocketServer) ListenAndServeTLS(network, address string, tlscfg *tls
 		tlscfg.Certificates = append(tlscfg.Certificates, cert)
 	}
 
-	l, err := tls.Listen(network, address, tlscfg)
+	proxyCfg, err := s.ProxyProtocol.compileFor(network)
+	if err != nil {
+		return nil, err
+	}
+	l, err := net.Listen(network, address)
 	if err != nil {
 		return nil, err
 	}
 
 	// Start request handler loop.
-	go s.requestHandler(l)
+	go s.requestHandler(l, proxyCfg, tlscfg)
 
 	return l, nil
 }
 
-func (s *RawSocketServer) requestHandler(l net.Listener) {
+// requestHandler accepts connections from the listener.  Each connection
+// reads a PROXY protocol header if proxyCfg is not nil, and then uses TLS if
+// tlscfg is not nil.
+func (s *RawSocketServer) requestHandler(l net.Listener, proxyCfg *proxyConfig, tlscfg *tls.Config) {
 	for {
 		conn, err := l.Accept()
 		if err != nil {
@@ -95,6 +112,10 @@ func (s *RawSocketServer) requestHandler(l net.Listener) {
 				tcpConn.SetKeepAlive(false)
 			}
 		}
+		conn = proxyCfg.wrap(conn)
+		if tlscfg != nil {
+			conn = tls.Server(conn, tlscfg)
+		}
 		go s.handleRawSocket(conn)
 	}
 }
@@ -113,7 +134,30 @@ func (s *RawSocketServer) handleRawSocket(conn net.Conn) {
 		return
 	}
 
-	if err := s.router.Attach(peer); err != nil {
+	// The address of the client is from the PROXY protocol header if there is
+	// one.
+	transportDetails := wamp.Dict{"type": transportTypeRawSocket}
+	addrDetails(transportDetails, conn.RemoteAddr(), conn.LocalAddr())
+	serializerDetails(transportDetails, peer)
+	tlsDetails(transportDetails, connTLSState(conn))
+
+	// Provide the credentials of the process connected to a Unix socket, so
+	// that they are available to authenticators as
+	// details.transport.peer_cred.
+	if unixConn, ok := conn.(*net.UnixConn); ok {
+		cred, err := transport.UnixPeerCred(unixConn)
+		if err != nil {
+			s.router.Logger().Println("Cannot get unix socket peer credentials:", err)
+		} else {
+			transportDetails["peer_cred"] = wamp.Dict{
+				"uid": int64(cred.UID),
+				"gid": int64(cred.GID),
+				"pid": int64(cred.PID),
+			}
+		}
+	}
+
+	if err := s.router.AttachClient(peer, transportDetails); err != nil {
 		s.router.Logger().Println("Error attaching to router:", err)
 	}
 }
@@ -2,9 +2,13 @@ package router
 
 import (
 	"context"
+	"os"
+	"path/filepath"
+	"runtime"
 	"testing"
 
 	"github.com/fortytw2/leaktest"
+	"github.com/gammazero/nexus/v3/router/auth"
 	"github.com/gammazero/nexus/v3/transport"
 	"github.com/gammazero/nexus/v3/transport/serialize"
 	"github.com/gammazero/nexus/v3/wamp"
@@ -75,3 +79,125 @@ func TestRSHandshakeMsgpack(t *testing.T) {
 	}
 	client.Close()
 }
+
+func TestRSHandshakeUBJSON(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	r, err := NewRouter(routerConfig, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	clsr, err := NewRawSocketServer(r).ListenAndServe("tcp", tcpAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clsr.Close()
+
+	client, err := transport.ConnectRawSocketPeer(context.Background(), "tcp",
+		tcpAddr, serialize.UBJSON, nil, r.Logger(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client.Send(&wamp.Hello{Realm: testRealm, Details: clientRoles})
+	msg, ok := <-client.Recv()
+	if !ok {
+		t.Fatal("Receive buffer closed")
+	}
+
+	if _, ok = msg.(*wamp.Welcome); !ok {
+		t.Fatalf("expected WELCOME, got %s: %+v", msg.MessageType(), msg)
+	}
+	client.Close()
+}
+
+func TestRSUnixPeerCred(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("peer credentials only supported on linux")
+	}
+	defer leaktest.Check(t)()
+
+	uid := uint32(os.Getuid())
+	r, err := NewRouter(&Config{
+		RealmConfigs: []*RealmConfig{
+			{
+				URI: testRealm,
+				PeerCredAuth: &auth.PeerCredAuth{
+					Users: map[uint32]auth.PeerCredIdentity{
+						uid: {AuthID: "local-service", AuthRole: "service"},
+					},
+				},
+			},
+		},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	sockPath := filepath.Join(t.TempDir(), "nexus.sock")
+	s := NewRawSocketServer(r)
+	clsr, err := s.ListenAndServe("unix", sockPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clsr.Close()
+	clsr2, err := s.ListenAndServe("tcp", tcpAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clsr2.Close()
+
+	hello := func() *wamp.Hello {
+		return &wamp.Hello{
+			Realm: testRealm,
+			Details: wamp.Dict{
+				"authmethods": wamp.List{"peercred"},
+				"roles":       clientRoles["roles"],
+			},
+		}
+	}
+
+	client, err := transport.ConnectRawSocketPeer(context.Background(), "unix",
+		sockPath, serialize.JSON, nil, r.Logger(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Send(hello())
+	msg, ok := <-client.Recv()
+	if !ok {
+		t.Fatal("Receive buffer closed")
+	}
+	welcome, ok := msg.(*wamp.Welcome)
+	if !ok {
+		t.Fatalf("expected WELCOME, got %s: %+v", msg.MessageType(), msg)
+	}
+	if authid, _ := wamp.AsString(welcome.Details["authid"]); authid != "local-service" {
+		t.Fatal("Wrong authid:", authid)
+	}
+	if authrole, _ := wamp.AsString(welcome.Details["authrole"]); authrole != "service" {
+		t.Fatal("Wrong authrole:", authrole)
+	}
+	client.Close()
+
+	// A TCP client cannot supply its own peer credentials.
+	client, err = transport.ConnectRawSocketPeer(context.Background(), "tcp",
+		tcpAddr, serialize.JSON, nil, r.Logger(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spoofed := hello()
+	spoofed.Details["transport"] = wamp.Dict{
+		"peer_cred": wamp.Dict{"uid": int64(uid), "gid": int64(uid), "pid": int64(1)},
+	}
+	client.Send(spoofed)
+	msg, ok = <-client.Recv()
+	if !ok {
+		t.Fatal("Receive buffer closed")
+	}
+	if _, ok = msg.(*wamp.Abort); !ok {
+		t.Fatalf("expected ABORT, got %s: %+v", msg.MessageType(), msg)
+	}
+	client.Close()
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"strconv"
 	"sync"
+	"time"
 
 	"github.com/gammazero/nexus/v3/router/auth"
 	"github.com/gammazero/nexus/v3/stdlog"
@@ -30,10 +31,13 @@ type testamentBucket struct {
 // authentication and authorization.  WAMP messages are only routed within a
 // Realm.
 type realm struct {
-	broker *broker
-	dealer *dealer
+	broker *brokerShards
+	dealer *dealerShards
 
-	authorizer Authorizer
+	// Inbound interceptors for remote sessions, and for local sessions that
+	// are not subject to authorization.
+	inbound      interceptorChain
+	localInbound interceptorChain
 
 	// authmethod -> Authenticator
 	authenticators map[string]auth.Authenticator
@@ -42,6 +46,8 @@ type realm struct {
 	clients map[wamp.ID]*wamp.Session
 	// session ID -> testament
 	testaments map[wamp.ID]testamentBucket
+	// resume token -> detached session
+	detached map[string]*wamp.Session
 
 	metaPeer  wamp.Peer
 	metaSess  *wamp.Session
@@ -70,6 +76,26 @@ type realm struct {
 
 	enableMetaKill   bool
 	enableMetaModify bool
+	enableMetaCancel bool
+
+	// Resource limits, 0 for no limit.
+	maxSessions    int
+	maxPayloadSize int
+
+	slowConsumerPolicy string
+	slowConsumerBlock  time.Duration
+
+	// How long a detached session is kept for resumption, 0 if disabled.
+	sessionResume       time.Duration
+	sessionResumeBuffer int
+	resumeAnonymous     bool
+
+	// Calls onIdle when the realm has had no sessions for idleTimeout, if
+	// idleTimeout is not 0.
+	idleTimeout time.Duration
+	idleTimer   *time.Timer
+	idleSince   time.Time
+	onIdle      func()
 }
 
 var (
@@ -80,18 +106,21 @@ var (
 )
 
 // newRealm creates a new realm with the given RealmConfig, broker and dealer.
-func newRealm(config *RealmConfig, broker *broker, dealer *dealer, logger stdlog.StdLog, debug bool) (*realm, error) {
+func newRealm(config *RealmConfig, broker *brokerShards, dealer *dealerShards, logger stdlog.StdLog, debug bool) (*realm, error) {
 	if !config.URI.ValidURI(config.StrictURI, "") {
 		return nil, fmt.Errorf(
 			"invalid realm URI %v (URI strict checking %v)", config.URI, config.StrictURI)
 	}
+	if err := checkSlowConsumerPolicy(config.SlowConsumerPolicy); err != nil {
+		return nil, err
+	}
 
 	r := &realm{
 		broker:      broker,
 		dealer:      dealer,
-		authorizer:  config.Authorizer,
 		clients:     map[wamp.ID]*wamp.Session{},
 		testaments:  map[wamp.ID]testamentBucket{},
+		detached:    map[string]*wamp.Session{},
 		actionChan:  make(chan func()),
 		metaIDGen:   new(wamp.IDGen),
 		metaDone:    make(chan struct{}),
@@ -104,6 +133,22 @@ func newRealm(config *RealmConfig, broker *broker, dealer *dealer, logger stdlog
 
 		enableMetaKill:   config.EnableMetaKill,
 		enableMetaModify: config.EnableMetaModify,
+		enableMetaCancel: config.EnableMetaCancel,
+
+		maxSessions:    config.MaxSessions,
+		maxPayloadSize: config.MaxPayloadSize,
+
+		slowConsumerPolicy: config.SlowConsumerPolicy,
+		slowConsumerBlock:  time.Duration(config.SlowConsumerBlockMsec) * time.Millisecond,
+	}
+	if r.slowConsumerBlock <= 0 {
+		r.slowConsumerBlock = defaultSlowConsumerBlock
+	}
+	r.sessionResume = time.Duration(config.SessionResumeSec) * time.Second
+	r.sessionResumeBuffer = config.SessionResumeBuffer
+	r.resumeAnonymous = config.SessionResumeAnonymous
+	if r.sessionResumeBuffer <= 0 {
+		r.sessionResumeBuffer = defaultSessionResumeBuffer
 	}
 
 	if debug {
@@ -113,12 +158,30 @@ func newRealm(config *RealmConfig, broker *broker, dealer *dealer, logger stdlog
 		if r.enableMetaKill {
 			r.log.Println("Session meta modify_details procedure enabled")
 		}
+		if r.enableMetaCancel {
+			r.log.Println("Call meta cancel procedure enabled")
+		}
 	}
 	if r.metaStrict && len(config.MetaIncludeSessionDetails) != 0 {
 		r.metaIncDetails = make([]string, len(config.MetaIncludeSessionDetails))
 		copy(r.metaIncDetails, config.MetaIncludeSessionDetails)
 	}
 
+	// Authorization is the first interceptor in the inbound chain.  Local
+	// sessions skip authorization unless required by config.
+	r.localInbound = interceptorChain(config.InboundInterceptors)
+	if config.Authorizer != nil {
+		r.inbound = append(interceptorChain{&authzInterceptor{
+			authorizer: config.Authorizer,
+			log:        logger,
+		}}, config.InboundInterceptors...)
+		if r.localAuthz {
+			r.localInbound = r.inbound
+		}
+	} else {
+		r.inbound = r.localInbound
+	}
+
 	r.authenticators = map[string]auth.Authenticator{}
 	for _, auth := range config.Authenticators {
 		r.authenticators[auth.AuthMethod()] = auth
@@ -134,6 +197,15 @@ func newRealm(config *RealmConfig, broker *broker, dealer *dealer, logger stdlog
 		}
 	}
 
+	// If peer credential authentication is configured, then install it
+	// unless an authenticator for the method has already been provided.
+	if config.PeerCredAuth != nil {
+		authr := config.PeerCredAuth
+		if _, ok := r.authenticators[authr.AuthMethod()]; !ok {
+			r.authenticators[authr.AuthMethod()] = authr
+		}
+	}
+
 	return r, nil
 }
 
@@ -181,10 +253,16 @@ func (r *realm) close() {
 	// running, before closing.
 	r.waitReady()
 
+	// Stop redelivering events before the sessions are closed, since the
+	// sessions are not removed from the broker.
+	r.broker.stopRedeliver()
+
 	// Kick all clients off.  Sending shutdownGoodbye causes client message
 	// handlers to exit without sending meta events.
 	sync := make(chan struct{})
 	r.actionChan <- func() {
+		r.syncStopIdle()
+		r.idleTimeout = 0
 		for _, c := range r.clients {
 			c.EndRecv(shutdownGoodbye)
 		}
@@ -242,6 +320,12 @@ func (r *realm) run() {
 	r.registerMetaProcedure(wamp.MetaProcRegGet, r.dealer.regGet)
 	r.registerMetaProcedure(wamp.MetaProcRegListCallees, r.dealer.regListCallees)
 	r.registerMetaProcedure(wamp.MetaProcRegCountCallees, r.dealer.regCountCallees)
+	// Register to handle call meta procedures.
+	r.registerMetaProcedure(wamp.MetaProcCallList, r.dealer.callList)
+	r.registerMetaProcedure(wamp.MetaProcCallGet, r.dealer.callGet)
+	if r.enableMetaCancel {
+		r.registerMetaProcedure(wamp.MetaProcCallCancel, r.dealer.callCancel)
+	}
 
 	// Register to handle subscription meta procedures.
 	r.registerMetaProcedure(wamp.MetaProcSubList, r.broker.subList)
@@ -250,11 +334,19 @@ func (r *realm) run() {
 	r.registerMetaProcedure(wamp.MetaProcSubGet, r.broker.subGet)
 	r.registerMetaProcedure(wamp.MetaProcSubListSubscribers, r.broker.subListSubscribers)
 	r.registerMetaProcedure(wamp.MetaProcSubCountSubscribers, r.broker.subCountSubscribers)
+	r.registerMetaProcedure(wamp.MetaProcSubAckDurable, r.broker.subAckDurable)
+	r.registerMetaProcedure(wamp.MetaProcSubAck, r.broker.subAck)
+	r.registerMetaProcedure(wamp.MetaProcSubNack, r.broker.subNack)
 
 	// Register to handle testament meta procedures.
 	r.registerMetaProcedure(wamp.MetaProcSessionAddTestament, r.testamentAdd)
 	r.registerMetaProcedure(wamp.MetaProcSessionFlushTestaments, r.testamentFlush)
 
+	// Register to handle quota meta procedures.
+	r.registerMetaProcedure(wamp.MetaProcRealmGetQuotas, r.realmGetQuotas)
+	r.registerMetaProcedure(wamp.MetaProcSessionGetQuotaUsage, r.sessionGetQuotaUsage)
+	r.registerMetaProcedure(wamp.MetaProcSessionGetStats, r.sessionGetStats)
+
 	go r.metaProcedureHandler()
 
 	for action := range r.actionChan {
@@ -285,18 +377,29 @@ func (r *realm) createMetaSession() {
 }
 
 // onJoin is called when a non-meta session joins this realm.  The session is
-// stored in the realm's clients and a meta event is published.
+// stored in the realm's clients and a meta event is published.  If the realm
+// already has the maximum number of sessions, then errSessionLimitExceeded is
+// returned and the session is not stored.
 //
 // Note: onJoin() is called from handleSession, not handleInboundMessages, so
 // that it is not called for the meta client.
-func (r *realm) onJoin(sess *wamp.Session) {
-	r.waitHandlers.Add(1)
+func (r *realm) onJoin(sess *wamp.Session) error {
+	var err error
 	sync := make(chan struct{})
 	r.actionChan <- func() {
-		r.clients[sess.ID] = sess
+		if r.maxSessions > 0 && len(r.clients) >= r.maxSessions {
+			err = errSessionLimitExceeded
+		} else {
+			r.waitHandlers.Add(1)
+			r.clients[sess.ID] = sess
+			r.syncStopIdle()
+		}
 		close(sync)
 	}
 	<-sync
+	if err != nil {
+		return err
+	}
 
 	// Session Meta Events MUST be dispatched by the Router to the same realm
 	// as the WAMP session which triggered the event.
@@ -312,6 +415,7 @@ func (r *realm) onJoin(sess *wamp.Session) {
 		Topic:     wamp.MetaEventSessionOnJoin,
 		Arguments: wamp.List{output},
 	})
+	return nil
 }
 
 // onLeave is called when a non-meta session leaves this realm.  The session is
@@ -333,6 +437,9 @@ func (r *realm) onLeave(sess *wamp.Session, shutdown, killAll bool) {
 	sync := make(chan struct{})
 	r.actionChan <- func() {
 		delete(r.clients, sess.ID)
+		if len(r.clients) == 0 {
+			r.syncStartIdle()
+		}
 		testaments, hasTstm = r.testaments[sess.ID]
 		if hasTstm {
 			delete(r.testaments, sess.ID)
@@ -354,19 +461,8 @@ func (r *realm) onLeave(sess *wamp.Session, shutdown, killAll bool) {
 		return
 	}
 	if hasTstm {
-		sendTestaments := func(testaments []testament) {
-			for i := range testaments {
-				r.metaPeer.Send(&wamp.Publish{
-					Request:     wamp.GlobalID(),
-					Topic:       testaments[i].topic,
-					Arguments:   testaments[i].args,
-					ArgumentsKw: testaments[i].kwargs,
-					Options:     testaments[i].options,
-				})
-			}
-		}
-		sendTestaments(testaments.detached)
-		sendTestaments(testaments.destroyed)
+		r.sendTestaments(testaments.detached)
+		r.sendTestaments(testaments.destroyed)
 	}
 	r.metaPeer.Send(&wamp.Publish{
 		Request: wamp.GlobalID(),
@@ -378,6 +474,19 @@ func (r *realm) onLeave(sess *wamp.Session, shutdown, killAll bool) {
 	})
 }
 
+// sendTestaments publishes the testaments.
+func (r *realm) sendTestaments(testaments []testament) {
+	for i := range testaments {
+		r.metaPeer.Send(&wamp.Publish{
+			Request:     wamp.GlobalID(),
+			Topic:       testaments[i].topic,
+			Arguments:   testaments[i].args,
+			ArgumentsKw: testaments[i].kwargs,
+			Options:     testaments[i].options,
+		})
+	}
+}
+
 // HandleSession starts a session attached to this realm.
 //
 // Routing occurs only between WAMP Sessions that have joined the same Realm.
@@ -394,32 +503,48 @@ func (r *realm) handleSession(sess *wamp.Session) error {
 	}
 
 	// Ensure session is capable of receiving exit signal before releasing lock
-	r.onJoin(sess)
+	err := r.onJoin(sess)
 	r.closeLock.Unlock()
+	if err != nil {
+		return err
+	}
 
 	if r.debug {
 		r.log.Println("Handling messages for session", sess)
 	}
 	go func() {
-		shutdown, killAll, err := r.handleInboundMessages(sess)
-		if err != nil {
-			abortMsg := wamp.Abort{
-				Reason:  wamp.ErrProtocolViolation,
-				Details: wamp.Dict{wamp.OptMessage: err.Error()},
+		for {
+			shutdown, killAll, lost, err := r.handleInboundMessages(sess)
+			if err != nil {
+				abortMsg := wamp.Abort{
+					Reason:  wamp.ErrProtocolViolation,
+					Details: wamp.Dict{wamp.OptMessage: err.Error()},
+				}
+				r.log.Println("Aborting session", sess, ":", err)
+				sess.TrySend(&abortMsg)
 			}
-			r.log.Println("Aborting session", sess, ":", err)
-			sess.TrySend(&abortMsg)
+			// If the transport was lost, a resumable session is detached
+			// and waits for the client to resume it.
+			if lost {
+				var resumed bool
+				if resumed, shutdown = r.detachSession(sess); resumed {
+					continue
+				}
+			}
+			r.onLeave(sess, shutdown, killAll)
+			sess.Close()
+			return
 		}
-		r.onLeave(sess, shutdown, killAll)
-		sess.Close()
 	}()
 
 	return nil
 }
 
 // handleInboundMessages handles the messages sent from a client session to
-// the router.
-func (r *realm) handleInboundMessages(sess *wamp.Session) (bool, bool, error) {
+// the router.  Returns whether the handler exited due to realm shutdown, a
+// kill_all, or the loss of the session's transport, and returns an error if
+// the session must be aborted.
+func (r *realm) handleInboundMessages(sess *wamp.Session) (bool, bool, bool, error) {
 	if r.debug {
 		defer r.log.Println("Ended session", sess)
 	}
@@ -432,7 +557,7 @@ func (r *realm) handleInboundMessages(sess *wamp.Session) (bool, bool, error) {
 		case msg, open = <-recv:
 			if !open {
 				r.log.Println("Lost", sess)
-				return false, false, nil
+				return false, false, true, nil
 			}
 		case <-recvDone:
 			goodbye := sess.Goodbye()
@@ -442,7 +567,7 @@ func (r *realm) handleInboundMessages(sess *wamp.Session) (bool, bool, error) {
 					r.log.Printf("Stop session %s: system shutdown", sess)
 				}
 				sess.TrySend(goodbye)
-				return true, false, nil
+				return true, false, false, nil
 			}
 			if r.debug {
 				r.log.Printf("Kill session %s: %s", sess, goodbye.Reason)
@@ -452,7 +577,7 @@ func (r *realm) handleInboundMessages(sess *wamp.Session) (bool, bool, error) {
 				killAll = true
 			}
 			sess.TrySend(goodbye)
-			return false, killAll, nil
+			return false, killAll, false, nil
 		}
 
 		if r.debug {
@@ -460,10 +585,18 @@ func (r *realm) handleInboundMessages(sess *wamp.Session) (bool, bool, error) {
 				msg.MessageType(), msg)
 		}
 
-		// Note: meta session is always authorized
-		if r.authorizer != nil && sess != r.metaSess && !r.authzMessage(sess, msg) {
-			// Not authorized; error response sent; do not process message.
-			continue
+		// Note: meta session is always authorized and never intercepted.
+		if sess != r.metaSess {
+			// The size is checked first, since the transport reports the size
+			// of the message it received, and not of any message that an
+			// interceptor replaces it with.
+			if msg = r.checkPayloadSize(sess, msg); msg == nil {
+				continue
+			}
+			if msg = r.interceptMessage(sess, msg); msg == nil {
+				// Dropped or replied to; do not process message.
+				continue
+			}
 		}
 
 		switch msg := msg.(type) {
@@ -489,7 +622,7 @@ func (r *realm) handleInboundMessages(sess *wamp.Session) (bool, bool, error) {
 			// An INVOCATION error is the only type of ERROR message the
 			// router should receive.
 			if msg.Type != wamp.INVOCATION {
-				return false, false, fmt.Errorf("invalid ERROR received: %v", msg)
+				return false, false, false, fmt.Errorf("invalid ERROR received: %v", msg)
 			}
 			r.dealer.error(msg)
 
@@ -503,84 +636,36 @@ func (r *realm) handleInboundMessages(sess *wamp.Session) (bool, bool, error) {
 				r.log.Println("GOODBYE from session", sess, "reason:",
 					msg.Reason)
 			}
-			return false, false, nil
+			return false, false, false, nil
 
 		default:
 			// Received unrecognized message type.
-			return false, false, fmt.Errorf("unexpected %v", msg.MessageType())
+			return false, false, false, fmt.Errorf("unexpected %v", msg.MessageType())
 		}
 	}
 }
 
-// authzMessage checks if the session is authorized to send the message.  If
-// authorization fails or if the session is not authorized, then an error
-// response is returned to the client, and this method returns false.
-func (r *realm) authzMessage(sess *wamp.Session, msg wamp.Message) bool {
-	// If the client is local, then do not check authorization, unless
-	// requested in config.
-	if sess.Peer.IsLocal() && !r.localAuthz {
-		return true
+// interceptMessage runs the inbound interceptor chain for a message sent by
+// the session.  If an interceptor replies to the message, then the reply is
+// sent to the session.  Returns the message to route, or nil if the message
+// was dropped or replied to.
+func (r *realm) interceptMessage(sess *wamp.Session, msg wamp.Message) wamp.Message {
+	chain := r.inbound
+	if sess.Peer.IsLocal() {
+		chain = r.localInbound
 	}
-
-	// Create a safe session to prevent access to the session.Peer.
-	safeSession := &wamp.Session{
-		ID:      sess.ID,
-		Details: sess.Details,
+	if len(chain) == 0 {
+		return msg
 	}
 
-	// Write-lock the session, becuase there is no telling what the Authorizer
-	// will do to the session details.
-	sess.Lock()
-	isAuthz, err := r.authorizer.Authorize(safeSession, msg)
-	sess.Unlock()
-
-	if !isAuthz {
-		skipResponse := false
-		errRsp := &wamp.Error{Type: msg.MessageType(), Details: wamp.Dict{}}
-		// Get the Request from request types of messages.
-		switch msg := msg.(type) {
-		case *wamp.Publish:
-			// a publish error should only be sent when OptAcknowledge is set.
-			if pubAck, _ := msg.Options[wamp.OptAcknowledge].(bool); !pubAck {
-				skipResponse = true
-			}
-			errRsp.Request = msg.Request
-		case *wamp.Subscribe:
-			errRsp.Request = msg.Request
-		case *wamp.Unsubscribe:
-			errRsp.Request = msg.Request
-		case *wamp.Register:
-			errRsp.Request = msg.Request
-		case *wamp.Unregister:
-			errRsp.Request = msg.Request
-		case *wamp.Call:
-			errRsp.Request = msg.Request
-		case *wamp.Cancel:
-			errRsp.Request = msg.Request
-		case *wamp.Yield:
-			errRsp.Request = msg.Request
-		}
-		if err != nil {
-			// Error trying to authorize.  Include error message.
-			errRsp.Error = wamp.ErrAuthorizationFailed
-			errRsp.Arguments = wamp.List{err.Error()}
-			r.log.Println("Client", sess, "authorization failed:", err)
-		} else {
-			// Session not authorized.  The inability to return a message is
-			// intentional, so as not to encourage returning information that
-			// could disclose any clues about authorization to an attacker.
-			errRsp.Error = wamp.ErrNotAuthorized
-			r.log.Println("Client", sess, msg.MessageType(), "not authorized")
-		}
-		if !skipResponse {
-			err = sess.TrySend(errRsp)
-			if err != nil {
-				r.log.Println("!!! client blocked, could not send authz error")
-			}
+	msg, reply := chain.run(sess, msg)
+	if reply != nil {
+		if err := sess.TrySend(reply); err != nil {
+			r.log.Println("!!! client blocked, could not send interceptor reply")
 		}
-		return false
+		return nil
 	}
-	return true
+	return msg
 }
 
 // authClient authenticates the client according to the authmethods in the
@@ -874,6 +959,7 @@ func (r *realm) sessionGet(msg *wamp.Invocation) wamp.Message {
 	// is set to true.
 	sess.Lock()
 	output := r.cleanSessionDetails(sess.Details)
+	output = r.addDroppedCount(sess, output)
 	sess.Unlock()
 
 	return &wamp.Yield{
@@ -0,0 +1,177 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// RealmTemplate configures the realms that the router creates when a client
+// requests to join a realm that does not exist, and the realm's URI matches
+// the template's pattern.
+type RealmTemplate struct {
+	// Pattern is the URI that realm URIs are matched against.
+	Pattern wamp.URI `json:"pattern"`
+	// Match is the matching policy for Pattern: "exact", "prefix", or
+	// "wildcard".  Default is "exact".
+	Match string `json:"match"`
+	// IdleTimeoutSec is the number of seconds that a realm created from this
+	// template is kept while it has no sessions, after which the realm is
+	// removed.  0 (default) means the realm is never removed.
+	IdleTimeoutSec int `json:"idle_timeout_sec"`
+	// Realm is the configuration of the realms created from this template.
+	// The configuration's URI is ignored, and replaced by the URI of each
+	// created realm.
+	Realm *RealmConfig `json:"realm"`
+}
+
+// checkRealmTemplate returns an error if the template is not valid.
+func (r *router) checkRealmTemplate(tmpl *RealmTemplate) error {
+	switch tmpl.Match {
+	case "", wamp.MatchExact, wamp.MatchPrefix, wamp.MatchWildcard:
+	default:
+		return fmt.Errorf("invalid match policy %q for realm template %v",
+			tmpl.Match, tmpl.Pattern)
+	}
+	if !tmpl.Pattern.ValidURI(false, tmpl.Match) {
+		return fmt.Errorf("invalid realm template pattern %v", tmpl.Pattern)
+	}
+	if tmpl.Realm == nil {
+		return errors.New("missing realm configuration for realm template " +
+			string(tmpl.Pattern))
+	}
+	if err := r.checkRealmConfig(tmpl.Realm); err != nil {
+		return fmt.Errorf("invalid realm template %v: %s", tmpl.Pattern, err)
+	}
+	return nil
+}
+
+// checkRealmConfig returns an error if a realm cannot be created from the
+// configuration, ignoring the configuration's URI.
+func (r *router) checkRealmConfig(config *RealmConfig) error {
+	realmConfig := *config
+	realmConfig.URI = "some.valid.realm"
+	_, err := newRealm(&realmConfig, nil, nil, r.log, r.debug)
+	return err
+}
+
+// matches returns true if the realm URI matches the template's pattern.
+func (tmpl *RealmTemplate) matches(uri wamp.URI) bool {
+	switch tmpl.Match {
+	case wamp.MatchPrefix:
+		return uri.PrefixMatch(tmpl.Pattern)
+	case wamp.MatchWildcard:
+		return uri.WildcardMatch(tmpl.Pattern)
+	}
+	return uri == tmpl.Pattern
+}
+
+// realmTemplateFor returns the configuration for creating the realm with the
+// given URI, and the realm's idle timeout.  The most specific matching
+// template is used, or the default realm template if there is no matching
+// template.  Returns nil if the realm cannot be created.
+//
+// Templates are matched using the same rules as for registrations: an exact
+// match is preferred over a prefix match, and a prefix match over a wildcard
+// match.  The longest matching prefix or wildcard pattern is used.
+func (r *router) realmTemplateFor(uri wamp.URI) (*RealmConfig, time.Duration) {
+	var found *RealmTemplate
+	for _, tmpl := range r.realmTemplates {
+		if !tmpl.matches(uri) {
+			continue
+		}
+		if found == nil || matchRank(tmpl.Match) > matchRank(found.Match) ||
+			(tmpl.Match == found.Match && len(tmpl.Pattern) > len(found.Pattern)) {
+			found = tmpl
+		}
+	}
+	if found == nil {
+		return r.realmTemplate, 0
+	}
+	return found.Realm, time.Duration(found.IdleTimeoutSec) * time.Second
+}
+
+// matchRank orders match policies from least to most specific.
+func matchRank(match string) int {
+	switch match {
+	case wamp.MatchWildcard:
+		return 0
+	case wamp.MatchPrefix:
+		return 1
+	}
+	return 2
+}
+
+// removeIdleRealm removes the realm if it still has no sessions.  This is
+// called when the realm has had no sessions for its idle timeout.
+func (r *router) removeIdleRealm(uri wamp.URI, realm *realm) {
+	// Hold the lock so that the router's action channel is not closed while
+	// in use.
+	r.idleLock.Lock()
+	if r.stopped {
+		r.idleLock.Unlock()
+		return
+	}
+	var remove bool
+	sync := make(chan struct{})
+	r.actionChan <- func() {
+		if r.realms[uri] == realm && realm.idle() {
+			delete(r.realms, uri)
+			remove = true
+		}
+		close(sync)
+	}
+	<-sync
+	r.idleLock.Unlock()
+
+	if remove {
+		realm.close()
+		r.log.Println("Removed idle realm:", uri)
+	}
+}
+
+// setIdleTimeout has the realm call onIdle after it has had no sessions for
+// the timeout.
+func (r *realm) setIdleTimeout(timeout time.Duration, onIdle func()) {
+	sync := make(chan struct{})
+	r.actionChan <- func() {
+		r.idleTimeout = timeout
+		r.onIdle = onIdle
+		if len(r.clients) == 0 {
+			r.syncStartIdle()
+		}
+		close(sync)
+	}
+	<-sync
+}
+
+// idle returns true if the realm has had no sessions for its idle timeout.
+func (r *realm) idle() bool {
+	var idle bool
+	sync := make(chan struct{})
+	r.actionChan <- func() {
+		idle = r.idleTimer != nil && time.Since(r.idleSince) >= r.idleTimeout
+		close(sync)
+	}
+	<-sync
+	return idle
+}
+
+// syncStartIdle starts the idle timer, if the realm has an idle timeout.
+func (r *realm) syncStartIdle() {
+	if r.idleTimeout <= 0 || r.idleTimer != nil {
+		return
+	}
+	r.idleSince = time.Now()
+	r.idleTimer = time.AfterFunc(r.idleTimeout, r.onIdle)
+}
+
+// syncStopIdle stops the idle timer.
+func (r *realm) syncStopIdle() {
+	if r.idleTimer != nil {
+		r.idleTimer.Stop()
+		r.idleTimer = nil
+	}
+}
@@ -0,0 +1,127 @@
+package router
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// hasRealm returns true if the router has the realm.
+func hasRealm(r Router, uri wamp.URI) bool {
+	rt := r.(*router)
+	var found bool
+	sync := make(chan struct{})
+	rt.actionChan <- func() {
+		_, found = rt.realms[uri]
+		close(sync)
+	}
+	<-sync
+	return found
+}
+
+func TestRealmTemplates(t *testing.T) {
+	const tenantRealm = wamp.URI("com.acme.tenant.t1")
+	config := &Config{
+		RealmTemplates: []*RealmTemplate{
+			{
+				Pattern:        "com.acme.tenant",
+				Match:          wamp.MatchPrefix,
+				IdleTimeoutSec: 1,
+				Realm:          &RealmConfig{AnonymousAuth: true},
+			},
+		},
+		Debug: debug,
+	}
+	r, err := NewRouter(config, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// Realm that does not match a template is refused.
+	if _, err = testClientInRealm(r, "com.other.realm"); err == nil {
+		t.Fatal("Expected error joining realm that does not match template")
+	}
+
+	sess, err := testClientInRealm(r, tenantRealm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hasRealm(r, tenantRealm) {
+		t.Fatal("Realm was not created from template")
+	}
+
+	// Realm is not removed while it has a session.
+	time.Sleep(1200 * time.Millisecond)
+	if !hasRealm(r, tenantRealm) {
+		t.Fatal("Realm with session was removed")
+	}
+
+	sess.Send(&wamp.Goodbye{Reason: wamp.CloseRealm, Details: wamp.Dict{}})
+	if _, err = wamp.RecvTimeout(sess, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	sess.Close()
+	time.Sleep(1500 * time.Millisecond)
+	if hasRealm(r, tenantRealm) {
+		t.Fatal("Idle realm was not removed")
+	}
+}
+
+func TestRealmTemplateConfig(t *testing.T) {
+	for _, tmpl := range []*RealmTemplate{
+		{Pattern: "com.acme", Match: "bogus", Realm: &RealmConfig{}},
+		{Pattern: "com..acme", Realm: &RealmConfig{}},
+		{Pattern: "com.acme"},
+		{Pattern: "com.acme", Realm: &RealmConfig{SlowConsumerPolicy: "bogus"}},
+	} {
+		config := &Config{RealmTemplates: []*RealmTemplate{tmpl}}
+		if _, err := NewRouter(config, logger); err == nil {
+			t.Fatal("Expected error for invalid realm template:", tmpl.Pattern)
+		}
+	}
+
+	// Duplicate templates are rejected.
+	config := &Config{RealmTemplates: []*RealmTemplate{
+		{Pattern: "com.acme", Realm: &RealmConfig{}},
+		{Pattern: "com.acme", Match: wamp.MatchExact, Realm: &RealmConfig{}},
+	}}
+	if _, err := NewRouter(config, logger); err == nil {
+		t.Fatal("Expected error for duplicate realm template")
+	}
+}
+
+// Test that the most specific matching template is used, regardless of the
+// order of the templates.
+func TestRealmTemplatePrecedence(t *testing.T) {
+	templates := []*RealmTemplate{
+		{Pattern: "com..t1", Match: wamp.MatchWildcard, IdleTimeoutSec: 1},
+		{Pattern: "com.acme", Match: wamp.MatchPrefix, IdleTimeoutSec: 2},
+		{Pattern: "com.acme.tenant", Match: wamp.MatchPrefix, IdleTimeoutSec: 3},
+		{Pattern: "com.acme.tenant.special", IdleTimeoutSec: 4},
+	}
+	for _, tmpl := range templates {
+		tmpl.Realm = &RealmConfig{}
+	}
+	r, err := NewRouter(&Config{RealmTemplates: templates}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	for uri, expect := range map[wamp.URI]time.Duration{
+		"com.other.t1":            1,
+		"com.acme.t1":             2,
+		"com.acme.tenant.t1":      3,
+		"com.acme.tenant.special": 4,
+	} {
+		realmConfig, idleTimeout := r.(*router).realmTemplateFor(uri)
+		if realmConfig == nil {
+			t.Fatal("No template for", uri)
+		}
+		if idleTimeout != expect*time.Second {
+			t.Error("Wrong template for", uri, "idle timeout", idleTimeout)
+		}
+	}
+}
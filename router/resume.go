@@ -0,0 +1,343 @@
+package router
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gammazero/nexus/v3/transport/serialize"
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+const defaultSessionResumeBuffer = 64
+
+// resumablePeer is the peer of a resumable session.  When the session's
+// transport is lost, the peer is detached from the transport and buffers
+// messages sent to the session until a new transport is attached.
+type resumablePeer struct {
+	mu sync.Mutex
+	// Transport peer, nil while detached.
+	peer       wamp.Peer
+	buffer     []wamp.Message
+	bufferSize int
+
+	// Token used to resume the session.
+	token string
+	// Receives the new transport peer when the session is resumed.
+	resume chan wamp.Peer
+}
+
+// newResumablePeer creates a resumablePeer attached to the transport peer.
+func newResumablePeer(peer wamp.Peer, bufferSize int) (*resumablePeer, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return &resumablePeer{
+		peer:       peer,
+		bufferSize: bufferSize,
+		token:      hex.EncodeToString(b),
+		resume:     make(chan wamp.Peer, 1),
+	}, nil
+}
+
+// TrySend sends a message to the transport, or buffers the message if
+// detached.  Returns error if blocked or if the buffer is full.
+func (p *resumablePeer) TrySend(msg wamp.Message) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.peer != nil {
+		return p.peer.TrySend(msg)
+	}
+	return p.bufferMessage(msg)
+}
+
+// TrySendDropOldest sends a message to the transport, or buffers the message
+// if detached, discarding the oldest queued or buffered message if full.
+func (p *resumablePeer) TrySendDropOldest(msg wamp.Message) (wamp.Message, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.peer != nil {
+		dos, ok := p.peer.(wamp.DropOldestSender)
+		if !ok {
+			return nil, p.peer.TrySend(msg)
+		}
+		return dos.TrySendDropOldest(msg)
+	}
+	var dropped wamp.Message
+	if len(p.buffer) != 0 && len(p.buffer) >= p.bufferSize {
+		dropped = p.buffer[0]
+		p.buffer = p.buffer[1:]
+	}
+	return dropped, p.bufferMessage(msg)
+}
+
+// SendCtx sends a message to the transport, or buffers the message if
+// detached.
+func (p *resumablePeer) SendCtx(ctx context.Context, msg wamp.Message) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.peer != nil {
+		return p.peer.SendCtx(ctx, msg)
+	}
+	return p.bufferMessage(msg)
+}
+
+// Send sends a message to the transport, or buffers the message if detached.
+func (p *resumablePeer) Send(msg wamp.Message) error {
+	p.mu.Lock()
+	peer := p.peer
+	if peer == nil {
+		defer p.mu.Unlock()
+		return p.bufferMessage(msg)
+	}
+	p.mu.Unlock()
+	return peer.Send(msg)
+}
+
+// Recv returns the channel of messages from the transport.
+func (p *resumablePeer) Recv() <-chan wamp.Message {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.peer == nil {
+		return nil
+	}
+	return p.peer.Recv()
+}
+
+// IsLocal returns true if the transport is local.
+func (p *resumablePeer) IsLocal() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.peer != nil && p.peer.IsLocal()
+}
+
+// AcceptsSharedMessage returns true if the transport accepts shared messages.
+// While detached, shared messages are buffered, and are unshared when
+// attaching a transport that does not accept them.
+func (p *resumablePeer) AcceptsSharedMessage() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.peer == nil || serialize.AcceptsSharedMessage(p.peer)
+}
+
+// Unwrap returns the transport peer, or nil while detached.
+func (p *resumablePeer) Unwrap() wamp.Peer {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.peer
+}
+
+// Close closes the transport, if attached, and discards buffered messages.
+func (p *resumablePeer) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.peer != nil {
+		p.peer.Close()
+		p.peer = nil
+	}
+	p.buffer = nil
+}
+
+// bufferMessage buffers a message while detached.  The caller must hold the
+// lock.
+func (p *resumablePeer) bufferMessage(msg wamp.Message) error {
+	if len(p.buffer) >= p.bufferSize {
+		return errors.New("detached session buffer full")
+	}
+	p.buffer = append(p.buffer, msg)
+	return nil
+}
+
+// detach closes the transport, after which messages are buffered.
+func (p *resumablePeer) detach() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.peer != nil {
+		p.peer.Close()
+		p.peer = nil
+	}
+}
+
+// attach attaches a new transport and sends it the buffered messages.
+// Returns the number of buffered messages that were dropped because they
+// could not be sent to the transport.
+func (p *resumablePeer) attach(peer wamp.Peer) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var dropped int
+	for i := range p.buffer {
+		msg := p.buffer[i]
+		// Events shared with other subscribers are only for peers that
+		// accept them.
+		if !serialize.AcceptsSharedMessage(peer) {
+			msg = serialize.Unshare(msg)
+		}
+		if err := peer.TrySend(msg); err != nil {
+			dropped++
+		}
+	}
+	p.buffer = nil
+	p.peer = peer
+	return dropped
+}
+
+// resumableOf returns the resumablePeer of a session, or nil if the session is
+// not resumable.
+func resumableOf(sess *wamp.Session) *resumablePeer {
+	var rp *resumablePeer
+	findPeer(sess.Peer, func(peer wamp.Peer) bool {
+		var ok bool
+		rp, ok = peer.(*resumablePeer)
+		return ok
+	})
+	return rp
+}
+
+// sameClientHost returns true if the transport details show that two clients
+// connected over the same type of transport and network, from the same host.
+func sameClientHost(a, b wamp.Dict) bool {
+	for _, k := range []string{"type", "network"} {
+		if a[k] != b[k] {
+			return false
+		}
+	}
+	peerA, _ := wamp.AsString(a["peer"])
+	peerB, _ := wamp.AsString(b["peer"])
+	return peerHost(peerA) == peerHost(peerB)
+}
+
+// peerHost returns the host of a client address, without the port.
+func peerHost(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// detachSession is called when the transport of a session is lost.  If the
+// session is resumable, then the session is detached from its transport and
+// the session's detached testaments are published.  The session is kept until
+// it is resumed, killed, or the resume grace period expires.
+//
+// Returns true if the session was resumed, in which case message handling
+// continues with the new transport.  Otherwise returns false, and returns true
+// if the session was ended due to realm shutdown.
+func (r *realm) detachSession(sess *wamp.Session) (bool, bool) {
+	rp := resumableOf(sess)
+	if rp == nil {
+		return false, false
+	}
+	rp.detach()
+
+	var detached []testament
+	sync := make(chan struct{})
+	r.actionChan <- func() {
+		r.detached[rp.token] = sess
+		if bucket, ok := r.testaments[sess.ID]; ok {
+			detached = bucket.detached
+			bucket.detached = nil
+			if bucket.destroyed == nil {
+				delete(r.testaments, sess.ID)
+			} else {
+				r.testaments[sess.ID] = bucket
+			}
+		}
+		close(sync)
+	}
+	<-sync
+	r.sendTestaments(detached)
+	r.log.Println("Detached session", sess)
+
+	timer := time.NewTimer(r.sessionResume)
+	defer timer.Stop()
+	var peer wamp.Peer
+	select {
+	case peer = <-rp.resume:
+	case <-timer.C:
+	case <-sess.RecvDone():
+	}
+	if peer == nil {
+		// Remove the detached session, unless it is already being resumed.
+		var claimed bool
+		sync = make(chan struct{})
+		r.actionChan <- func() {
+			if _, ok := r.detached[rp.token]; ok {
+				delete(r.detached, rp.token)
+			} else {
+				claimed = true
+			}
+			close(sync)
+		}
+		<-sync
+		if !claimed {
+			goodbye := sess.Goodbye()
+			if goodbye == nil {
+				r.log.Println("Resume period expired for detached session", sess)
+			}
+			return false, goodbye == shutdownGoodbye || goodbye == wamp.NoGoodbye
+		}
+		peer = <-rp.resume
+	}
+
+	if dropped := rp.attach(peer); dropped != 0 {
+		atomic.AddUint64(&sess.Peer.(*slowConsumerPeer).dropped, uint64(dropped))
+	}
+	r.log.Println("Resumed session", sess)
+	return true, false
+}
+
+// claimDetached finds the detached session with the resume token, and removes
+// it from the detached sessions so that it can be resumed.  The authid of the
+// client resuming the session must match that of the session.  Anonymous
+// authentication gives each client a new authid, so if anonymous resumption
+// is enabled, an anonymous session can be resumed by an anonymous client that
+// has its resume token and connects from the same host.
+func (r *realm) claimDetached(token, authid, authmethod string, transportDetails wamp.Dict) (*wamp.Session, *resumablePeer, error) {
+	r.closeLock.Lock()
+	defer r.closeLock.Unlock()
+	if r.closed {
+		return nil, nil, errors.New("realm closed")
+	}
+
+	var sess *wamp.Session
+	var err error
+	sync := make(chan struct{})
+	r.actionChan <- func() {
+		defer close(sync)
+		sess = r.detached[token]
+		if sess == nil {
+			err = errors.New("no detached session for resume token")
+			return
+		}
+		sess.Lock()
+		sessAuthID, _ := wamp.AsString(sess.Details["authid"])
+		sessAuthMethod, _ := wamp.AsString(sess.Details["authmethod"])
+		sessTransport, _ := wamp.AsDict(sess.Details["transport"])
+		sess.Unlock()
+		if sessAuthMethod == "anonymous" && authmethod == "anonymous" {
+			if !r.resumeAnonymous {
+				err = errors.New("anonymous session resumption not enabled")
+			} else if !sameClientHost(sessTransport, transportDetails) {
+				err = errors.New("resume token used from different host")
+			}
+		} else if sessAuthID != authid {
+			err = errors.New("resume token does not belong to authid")
+		}
+		if err != nil {
+			sess = nil
+			return
+		}
+		delete(r.detached, token)
+	}
+	<-sync
+	if err != nil {
+		return nil, nil, err
+	}
+	return sess, resumableOf(sess), nil
+}
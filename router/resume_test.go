@@ -0,0 +1,268 @@
+package router
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gammazero/nexus/v3/transport"
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// resumeTestClient attaches a client that requests a resumable session, or
+// resumes the session with the resume token if one is given.
+func resumeTestClient(t *testing.T, r Router, token string) (*wamp.Session, *wamp.Welcome) {
+	client, msg := resumeHello(t, r, token)
+	welcome, ok := msg.(*wamp.Welcome)
+	if !ok {
+		t.Fatal("Expected WELCOME, got:", msg.MessageType())
+	}
+	return &wamp.Session{Peer: client, ID: welcome.ID}, welcome
+}
+
+// resumeHello attaches a client as resumeTestClient does, and returns the
+// client and the router's reply to the HELLO.
+func resumeHello(t *testing.T, r Router, token string) (wamp.Peer, wamp.Message) {
+	details := wamp.Dict{
+		"roles":  clientRoles["roles"],
+		"authid": "user1",
+	}
+	if token == "" {
+		details[wamp.DetailResumable] = true
+	} else {
+		details[wamp.DetailResumeToken] = token
+	}
+	client, server := transport.LinkedPeers()
+	go client.Send(&wamp.Hello{Realm: testRealm, Details: details})
+	// An error attaching the client is also sent to the client as ABORT.
+	r.Attach(server)
+	msg, err := wamp.RecvTimeout(client, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, msg
+}
+
+// waitDetached waits for the session with the resume token to be detached
+// from its transport.
+func waitDetached(t *testing.T, r Router, token string) {
+	rt := r.(*router)
+	var realm *realm
+	sync := make(chan struct{})
+	rt.actionChan <- func() {
+		realm = rt.realms[testRealm]
+		close(sync)
+	}
+	<-sync
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); {
+		var detached bool
+		sync = make(chan struct{})
+		realm.actionChan <- func() {
+			_, detached = realm.detached[token]
+			close(sync)
+		}
+		<-sync
+		if detached {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("Session was not detached")
+}
+
+func TestSessionResume(t *testing.T) {
+	const topic = wamp.URI("resume.topic")
+	r := newTestRouterWithRealm(t, &RealmConfig{SessionResumeSec: 5})
+	defer r.Close()
+
+	sub, welcome := resumeTestClient(t, r, "")
+	token, _ := wamp.AsString(welcome.Details[wamp.DetailResumeToken])
+	if token == "" {
+		t.Fatal("WELCOME missing resume token")
+	}
+	sub.Send(&wamp.Subscribe{Request: wamp.GlobalID(), Topic: topic})
+	msg, err := wamp.RecvTimeout(sub, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := msg.(*wamp.Subscribed); !ok {
+		t.Fatal("Expected SUBSCRIBED, got:", msg.MessageType())
+	}
+
+	// Lose the transport, and publish events while detached.
+	sub.Close()
+	waitDetached(t, r, token)
+	pub, err := testClient(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publishAck := func(arg int) {
+		pub.Send(&wamp.Publish{
+			Request:   wamp.GlobalID(),
+			Options:   wamp.Dict{wamp.OptAcknowledge: true},
+			Topic:     topic,
+			Arguments: wamp.List{arg},
+		})
+		if _, err := wamp.RecvTimeout(pub, time.Second); err != nil {
+			t.Fatal(err)
+		}
+	}
+	publishAck(1)
+	publishAck(2)
+
+	// Test that an invalid token cannot resume a session.
+	client, server := transport.LinkedPeers()
+	go client.Send(&wamp.Hello{Realm: testRealm, Details: wamp.Dict{
+		"roles":                clientRoles["roles"],
+		"authid":               "user1",
+		wamp.DetailResumeToken: "bogus",
+	}})
+	if err = r.Attach(server); err == nil {
+		t.Fatal("Expected error resuming with invalid token")
+	}
+
+	// Resume session and receive buffered events.
+	resumed, welcome := resumeTestClient(t, r, token)
+	if resumed.ID != sub.ID {
+		t.Fatal("Resumed session has different ID")
+	}
+	if ok, _ := welcome.Details[wamp.DetailResumed].(bool); !ok {
+		t.Fatal("WELCOME did not indicate session was resumed")
+	}
+	for i := 1; i <= 2; i++ {
+		if arg := recvEventArg(t, resumed); arg != i {
+			t.Fatal("Expected event", i, "got event", arg)
+		}
+	}
+
+	// Test that subscription still works after resume.
+	publishAck(3)
+	if arg := recvEventArg(t, resumed); arg != 3 {
+		t.Fatal("Expected event 3, got event", arg)
+	}
+}
+
+func TestSessionResumeExpire(t *testing.T) {
+	r := newTestRouterWithRealm(t, &RealmConfig{SessionResumeSec: 1})
+	defer r.Close()
+
+	sess, welcome := resumeTestClient(t, r, "")
+	token, _ := wamp.AsString(welcome.Details[wamp.DetailResumeToken])
+	sess.Close()
+
+	caller, err := testClient(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessionCount := func() int64 {
+		caller.Send(&wamp.Call{
+			Request:   wamp.GlobalID(),
+			Procedure: wamp.MetaProcSessionCount,
+		})
+		msg, err := wamp.RecvTimeout(caller, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, ok := msg.(*wamp.Result)
+		if !ok {
+			t.Fatal("Expected RESULT, got:", msg.MessageType())
+		}
+		n, _ := wamp.AsInt64(result.Arguments[0])
+		return n
+	}
+
+	// Detached session is still counted.
+	if n := sessionCount(); n != 2 {
+		t.Fatal("Expected 2 sessions, got", n)
+	}
+	time.Sleep(1500 * time.Millisecond)
+	if n := sessionCount(); n != 1 {
+		t.Fatal("Expected 1 session after resume period, got", n)
+	}
+
+	client, server := transport.LinkedPeers()
+	go client.Send(&wamp.Hello{Realm: testRealm, Details: wamp.Dict{
+		"roles":                clientRoles["roles"],
+		"authid":               "user1",
+		wamp.DetailResumeToken: token,
+	}})
+	if err = r.Attach(server); err == nil {
+		t.Fatal("Expected error resuming expired session")
+	}
+}
+
+// Test that an anonymous session, whose client is given a new authid each
+// time it authenticates, can be resumed with its resume token when anonymous
+// resumption is enabled.
+func TestSessionResumeAnonymous(t *testing.T) {
+	r := newTestRouterWithRealm(t, &RealmConfig{
+		AnonymousAuth:          true,
+		RequireLocalAuth:       true,
+		SessionResumeSec:       5,
+		SessionResumeAnonymous: true,
+	})
+	defer r.Close()
+
+	sess, welcome := resumeTestClient(t, r, "")
+	token, _ := wamp.AsString(welcome.Details[wamp.DetailResumeToken])
+	authid, _ := wamp.AsString(welcome.Details["authid"])
+	sess.Close()
+	waitDetached(t, r, token)
+
+	resumed, welcome := resumeTestClient(t, r, token)
+	if resumed.ID != sess.ID {
+		t.Fatal("Resumed session has different ID")
+	}
+	if ok, _ := welcome.Details[wamp.DetailResumed].(bool); !ok {
+		t.Fatal("WELCOME did not indicate session was resumed")
+	}
+	if id, _ := wamp.AsString(welcome.Details["authid"]); id != authid {
+		t.Fatal("Expected resumed session to keep authid", authid, "got", id)
+	}
+}
+
+// Test that an anonymous session cannot be resumed unless anonymous
+// resumption is enabled.
+func TestSessionResumeAnonymousDisabled(t *testing.T) {
+	r := newTestRouterWithRealm(t, &RealmConfig{
+		AnonymousAuth:    true,
+		RequireLocalAuth: true,
+		SessionResumeSec: 5,
+	})
+	defer r.Close()
+
+	sess, welcome := resumeTestClient(t, r, "")
+	token, _ := wamp.AsString(welcome.Details[wamp.DetailResumeToken])
+	sess.Close()
+	waitDetached(t, r, token)
+
+	client, msg := resumeHello(t, r, token)
+	defer client.Close()
+	abort, ok := msg.(*wamp.Abort)
+	if !ok {
+		t.Fatal("Expected ABORT, got:", msg.MessageType())
+	}
+	if abort.Reason != wamp.ErrNoSuchSession {
+		t.Fatal("Wrong ABORT reason:", abort.Reason)
+	}
+}
+
+func TestSameClientHost(t *testing.T) {
+	tcp := func(peer string) wamp.Dict {
+		return wamp.Dict{"type": transportTypeWebsocket, "network": "tcp", "peer": peer}
+	}
+	for _, tc := range []struct {
+		a, b wamp.Dict
+		same bool
+	}{
+		{tcp("10.0.0.1:5000"), tcp("10.0.0.1:6000"), true},
+		{tcp("10.0.0.1:5000"), tcp("10.0.0.2:5000"), false},
+		{tcp("[::1]:5000"), tcp("[::1]:6000"), true},
+		{tcp("10.0.0.1:5000"), wamp.Dict{"type": transportTypeRawSocket, "network": "tcp", "peer": "10.0.0.1:5000"}, false},
+		{wamp.Dict{"type": transportTypeLocal}, wamp.Dict{"type": transportTypeLocal}, true},
+		{wamp.Dict{"type": transportTypeLocal}, tcp("10.0.0.1:5000"), false},
+	} {
+		if sameClientHost(tc.a, tc.b) != tc.same {
+			t.Error("Wrong result for", tc.a, tc.b)
+		}
+	}
+}
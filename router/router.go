@@ -7,14 +7,17 @@ publication filtering functionality.
 package router
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"runtime"
 	"sync"
 	"time"
 
+	"github.com/gammazero/nexus/v3/router/durable"
 	"github.com/gammazero/nexus/v3/stdlog"
 	"github.com/gammazero/nexus/v3/wamp"
 )
@@ -35,6 +38,11 @@ type Router interface {
 	// Close stops the router and waits message processing to stop.
 	Close()
 
+	// Shutdown gracefully stops the router.  New sessions and calls are
+	// refused, and outstanding calls are allowed to complete before the
+	// router is closed.
+	Shutdown(context.Context) error
+
 	// Logger returns the logger the router is using.
 	Logger() stdlog.StdLog
 
@@ -51,9 +59,21 @@ type router struct {
 
 	actionChan chan func()
 	waitRealms sync.WaitGroup
+	// Waits for the goroutines of bridge sessions.
+	waitBridges sync.WaitGroup
+
+	realmTemplate  *RealmConfig
+	realmTemplates []*RealmTemplate
+	closed         bool
 
-	realmTemplate *RealmConfig
-	closed        bool
+	// Durable subscription stores given in realm configurations, which are
+	// closed when the router is closed.
+	durableStores map[durable.Store]struct{}
+
+	// Held when removing an idle realm, to prevent the action channel from
+	// being closed while in use.
+	idleLock sync.Mutex
+	stopped  bool
 
 	log   stdlog.StdLog
 	debug bool
@@ -69,6 +89,7 @@ func NewRouter(config *Config, logger stdlog.StdLog) (Router, error) {
 
 	r := &router{
 		realms:        map[wamp.URI]*realm{},
+		durableStores: map[durable.Store]struct{}{},
 		actionChan:    make(chan func()),
 		realmTemplate: config.RealmTemplate,
 		log:           logger,
@@ -83,15 +104,29 @@ func NewRouter(config *Config, logger stdlog.StdLog) (Router, error) {
 
 	// Create a realm from the template to validate the template
 	if r.realmTemplate != nil {
-		realmTemplate := *r.realmTemplate
-		realmTemplate.URI = "some.valid.realm"
-		if _, err := newRealm(&realmTemplate, nil, nil, r.log, r.debug); err != nil {
+		if err := r.checkRealmConfig(r.realmTemplate); err != nil {
 			return nil, fmt.Errorf("Invalid realmTemplate: %s", err)
 		}
 	}
+	for _, tmpl := range config.RealmTemplates {
+		if err := r.checkRealmTemplate(tmpl); err != nil {
+			return nil, err
+		}
+		for _, other := range r.realmTemplates {
+			if other.Pattern == tmpl.Pattern && matchRank(other.Match) == matchRank(tmpl.Match) {
+				return nil, fmt.Errorf("duplicate realm template %v", tmpl.Pattern)
+			}
+		}
+		r.realmTemplates = append(r.realmTemplates, tmpl)
+	}
 
 	go r.run()
 
+	if err := r.startBridges(config.Bridges); err != nil {
+		r.Close()
+		return nil, err
+	}
+
 	if config.MemStatsLogSec != 0 {
 		go r.logMemStats(time.Duration(config.MemStatsLogSec) * time.Second)
 	}
@@ -125,8 +160,8 @@ func (r *router) Attach(client wamp.Peer) error {
 // This exposes it to authenticator and authorizer logic.  The information
 // includes items useful for authentication, in details.transport.auth.
 //
-// See websocketpeer.WebSocketConfig for information provided by websocket
-// connections.
+// See the transport details in transportdetails.go for the information
+// provided by the servers in this package.
 func (r *router) AttachClient(client wamp.Peer, transportDetails wamp.Dict) error {
 	sendAbort := func(reason wamp.URI, abortErr error) {
 		abortMsg := wamp.Abort{Reason: reason}
@@ -170,6 +205,7 @@ func (r *router) AttachClient(client wamp.Peer, transportDetails wamp.Dict) erro
 	sync := make(chan error)
 	r.actionChan <- func() {
 		if r.closed {
+			// Closed or shutting down.
 			sendAbort(wamp.ErrSystemShutdown, nil)
 			sync <- errors.New("router is closing, not accepting new clients")
 			return
@@ -181,7 +217,8 @@ func (r *router) AttachClient(client wamp.Peer, transportDetails wamp.Dict) erro
 		if !found {
 			// If the router is not configured to automatically create the
 			// realm, then respond with an ABORT message.
-			if r.realmTemplate == nil {
+			template, idleTimeout := r.realmTemplateFor(hello.Realm)
+			if template == nil {
 				sendAbort(wamp.ErrNoSuchRealm, nil)
 				sync <- fmt.Errorf("no realm \"%s\" exists on this router",
 					string(hello.Realm))
@@ -189,7 +226,7 @@ func (r *router) AttachClient(client wamp.Peer, transportDetails wamp.Dict) erro
 			}
 
 			// Create the new realm based on template
-			config := *r.realmTemplate
+			config := *template
 			config.URI = hello.Realm
 			if realm, err = r.addRealm(&config); err != nil {
 				sendAbort(wamp.ErrNoSuchRealm, nil)
@@ -198,6 +235,12 @@ func (r *router) AttachClient(client wamp.Peer, transportDetails wamp.Dict) erro
 				return
 
 			}
+			if idleTimeout > 0 {
+				newRealm := realm
+				realm.setIdleTimeout(idleTimeout, func() {
+					r.removeIdleRealm(hello.Realm, newRealm)
+				})
+			}
 			r.log.Println("Auto-added realm:", hello.Realm)
 		}
 		sync <- nil
@@ -207,11 +250,28 @@ func (r *router) AttachClient(client wamp.Peer, transportDetails wamp.Dict) erro
 		return err
 	}
 
+	// Limit the size of messages received from the client before the client
+	// is welcomed and can send any.
+	setSizeLimit(client, realm.maxPayloadSize)
+
 	hello.Details = wamp.NormalizeDict(hello.Details)
 	sid := wamp.GlobalID()
 
-	// Create new session.
-	sess := wamp.NewSession(client, sid, nil, hello.Details)
+	// Create new session.  The router sends to the session through a peer
+	// that applies the realm's slow consumer policy.  If the client requests
+	// a resumable session, and the realm allows this, then that peer sends to
+	// the client through a peer that can be detached and resumed.
+	var rp *resumablePeer
+	scPeer := newSlowConsumerPeer(client, realm.slowConsumerPolicy, realm.slowConsumerBlock)
+	if resumable, _ := hello.Details[wamp.DetailResumable].(bool); resumable && realm.sessionResume > 0 {
+		if rp, err = newResumablePeer(client, realm.sessionResumeBuffer); err != nil {
+			sendAbort(wamp.ErrSystemShutdown, err)
+			return err
+		}
+		scPeer.Peer = rp
+	}
+	sess := wamp.NewSession(scPeer, sid, nil, hello.Details)
+	scPeer.sess = sess
 
 	// A Client must announce the roles it supports via
 	// Hello.Details.roles|dict, where the keys can be: publisher, subscriber,
@@ -229,9 +289,16 @@ func (r *router) AttachClient(client wamp.Peer, transportDetails wamp.Dict) erro
 		return err
 	}
 
-	// Include any transport details with HELLO.Details.
+	// Include any transport details with HELLO.Details.  Transport details
+	// sent by the client are discarded, since authenticators rely on these
+	// coming from the transport.
+	if transportDetails == nil && client.IsLocal() {
+		transportDetails = wamp.Dict{"type": transportTypeLocal}
+	}
 	if len(transportDetails) != 0 {
 		hello.Details["transport"] = transportDetails
+	} else {
+		delete(hello.Details, "transport")
 	}
 
 	// Handle any necessary client auth.  This results in either a WELCOME
@@ -244,6 +311,38 @@ func (r *router) AttachClient(client wamp.Peer, transportDetails wamp.Dict) erro
 		return errors.New("authentication error: " + err.Error())
 	}
 
+	// If the client is resuming a detached session, then attach the client to
+	// that session instead of starting a new session.
+	if token, _ := wamp.AsString(hello.Details[wamp.DetailResumeToken]); token != "" && realm.sessionResume > 0 {
+		authid, _ := wamp.AsString(welcome.Details["authid"])
+		authmethod, _ := wamp.AsString(welcome.Details["authmethod"])
+		resumed, resumedPeer, err := realm.claimDetached(token, authid, authmethod, transportDetails)
+		if err != nil {
+			sendAbort(wamp.ErrNoSuchSession, err)
+			return err
+		}
+		// The session keeps its authid, which differs from the one just
+		// given to an anonymous client.
+		resumed.Lock()
+		if len(transportDetails) != 0 {
+			resumed.Details["transport"] = transportDetails
+		}
+		welcome.Details["authid"] = resumed.Details["authid"]
+		resumed.Unlock()
+		welcome.ID = resumed.ID
+		welcome.Details[wamp.DetailResumeToken] = token
+		welcome.Details[wamp.DetailResumed] = true
+		client.Send(welcome) // Blocking OK; this is session goroutine.
+
+		// Give new transport to the detached session, which sends any
+		// buffered messages and resumes handling messages.
+		resumedPeer.resume <- client
+		if r.debug {
+			r.log.Println("Finished resuming session:", resumed.ID)
+		}
+		return nil
+	}
+
 	// Fill in the values of the welcome message and send to client.
 	welcome.ID = sid
 
@@ -267,11 +366,21 @@ func (r *router) AttachClient(client wamp.Peer, transportDetails wamp.Dict) erro
 	sess.Details = sessDetails
 
 	if err := realm.handleSession(sess); err != nil {
-		// Any error returned here is a shutdown error.
+		if err == errSessionLimitExceeded {
+			sendAbort(wamp.ErrSessionLimitExceeded, err)
+			return err
+		}
+		// Any other error returned here is a shutdown error.
 		sendAbort(wamp.ErrSystemShutdown, nil)
 		return err
 	}
 
+	// The resume token is only given to the client, and is not included in
+	// the session details.
+	if rp != nil {
+		welcome.Details[wamp.DetailResumeToken] = rp.token
+	}
+
 	client.Send(welcome) // Blocking OK; this is session goroutine.
 	if r.debug {
 		r.log.Println("Finished attaching session:", sid)
@@ -297,10 +406,63 @@ func (r *router) Close() {
 	<-sync
 	// Wait for all existing realms to close.
 	r.waitRealms.Wait()
+	r.waitBridges.Wait()
+	for store := range r.durableStores {
+		if err := store.Close(); err != nil {
+			r.log.Println("Error closing durable subscription store:", err)
+		}
+	}
+	r.idleLock.Lock()
+	r.stopped = true
+	r.idleLock.Unlock()
 	close(r.actionChan)
 	r.log.Println("Router stopped")
 }
 
+// Shutdown gracefully stops the router.
+//
+// First, the router stops accepting new sessions, and the dealer in each realm
+// stops accepting new calls, responding to them with wamp.error.system_shutdown.
+// Calls to meta procedures are still allowed.  Existing sessions remain
+// connected, so that callees can continue to yield results and publish events.
+//
+// Next, Shutdown waits for all outstanding invocations to complete, or for the
+// context to be done.
+//
+// Finally, the router is closed, sending GOODBYE to all sessions.  If the
+// context is done before all invocations complete, then the context's error
+// is returned after the router is closed.
+func (r *router) Shutdown(ctx context.Context) error {
+	var drained []<-chan struct{}
+	sync := make(chan struct{})
+	r.actionChan <- func() {
+		// Prevent new or attachment to existing realms.  Drain the dealers
+		// at the same time, so that calls are refused once sessions are.
+		r.closed = true
+		for _, realm := range r.realms {
+			drained = append(drained, realm.dealer.drain()...)
+		}
+		close(sync)
+	}
+	<-sync
+	r.log.Println("Router draining")
+
+	var err error
+	for i := range drained {
+		select {
+		case <-drained[i]:
+			continue
+		case <-ctx.Done():
+			err = ctx.Err()
+			r.log.Println("Router drain incomplete:", err)
+		}
+		break
+	}
+
+	r.Close()
+	return err
+}
+
 // AddRealm allows the addition of a realm after construction
 func (r *router) AddRealm(config *RealmConfig) error {
 	var err error
@@ -347,15 +509,74 @@ func (r *router) addRealm(config *RealmConfig) (*realm, error) {
 		return nil, errors.New("realm already exists: " + string(config.URI))
 	}
 
-	realm, err := newRealm(
-		config,
-		newBroker(r.log, config.StrictURI, config.AllowDisclose, r.debug, config.PublishFilterFactory),
-		newDealer(r.log, config.StrictURI, config.AllowDisclose, r.debug),
-		r.log, r.debug)
+	validator, err := newPayloadValidator(config.PayloadSchemas, config.StrictURI)
+	if err != nil {
+		return nil, err
+	}
+	rewriter, err := newURIRewriter(config.URIRewrites, config.StrictURI)
+	if err != nil {
+		return nil, err
+	}
+
+	// A store created for the realm is closed with the realm.  A store given
+	// in the configuration may be shared by realms, such as those created
+	// from one template, so is closed when the router is closed.
+	durableStore := config.DurableStore
+	var ownStore durable.Store
+	if durableStore == nil && config.DurableDir != "" {
+		fileStore, err := durable.NewFileStore(filepath.Join(config.DurableDir, string(config.URI)))
+		if err != nil {
+			return nil, err
+		}
+		durableStore = fileStore
+		ownStore = fileStore
+	}
+
+	b := newBrokerShards(config.RoutingShards, func() *broker {
+		b := newBroker(r.log, config.StrictURI, config.AllowDisclose, r.debug, config.PublishFilterFactory)
+		// Outbound interceptors, payload validation, URI rewriting, and
+		// limits are set before any messages are routed.
+		b.outbound = interceptorChain(config.OutboundInterceptors)
+		b.validator = validator
+		b.rewriter = rewriter
+		b.maxSubscriptions = config.MaxSubscriptionsPerSession
+		b.durableStore = durableStore
+		b.durableRealm = config.URI
+		if config.DurableMaxEvents > 0 {
+			b.durableMaxEvents = config.DurableMaxEvents
+		}
+		if config.DurableMaxAgeSec > 0 {
+			b.durableMaxAge = time.Duration(config.DurableMaxAgeSec) * time.Second
+		}
+		if config.EventAckTimeoutMsec > 0 {
+			b.eventAckTimeout = time.Duration(config.EventAckTimeoutMsec) * time.Millisecond
+		}
+		if config.EventMaxRedeliveries > 0 {
+			b.eventMaxRedeliveries = config.EventMaxRedeliveries
+		}
+		return b
+	})
+	b.ownStore = ownStore
+	d := newDealerShards(config.RoutingShards, func() *dealer {
+		d := newDealer(r.log, config.StrictURI, config.AllowDisclose, r.debug)
+		d.outbound = interceptorChain(config.OutboundInterceptors)
+		d.validator = validator
+		d.rewriter = rewriter
+		d.maxRegistrations = config.MaxRegistrationsPerSession
+		d.maxPendingCalls = config.MaxPendingCallsPerCaller
+		return d
+	})
+
+	realm, err := newRealm(config, b, d, r.log, r.debug)
 	if err != nil {
+		b.close()
+		d.close()
 		return nil, err
 	}
 	r.realms[config.URI] = realm
+	if config.DurableStore != nil {
+		r.durableStores[config.DurableStore] = struct{}{}
+	}
 
 	r.waitRealms.Add(1)
 	go func() {
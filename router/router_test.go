@@ -1,6 +1,7 @@
 package router
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
@@ -73,7 +74,13 @@ func newTestRouter() (Router, error) {
 }
 
 func testClientInRealm(r Router, realm wamp.URI) (*wamp.Session, error) {
-	client, server := transport.LinkedPeers()
+	return testClientQSize(r, realm, 0)
+}
+
+// testClientQSize creates a client with the specified router-to-client queue
+// size.  Specifying size 0 uses default size.
+func testClientQSize(r Router, realm wamp.URI, queueSize int) (*wamp.Session, error) {
+	client, server := transport.LinkedPeersQSize(queueSize)
 	// Run as goroutine since Send will block until message read by router, if
 	// client uses unbuffered channel.
 	details := clientRoles
@@ -1273,3 +1280,150 @@ func TestDynamicRealmChange(t *testing.T) {
 		}
 	}
 }
+
+func TestShutdown(t *testing.T) {
+	defer leaktest.Check(t)()
+	r, err := newTestRouter()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	callee, err := testClient(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	callee.Send(&wamp.Register{Request: wamp.GlobalID(), Procedure: testProcedure})
+	msg, err := wamp.RecvTimeout(callee, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := msg.(*wamp.Registered); !ok {
+		t.Fatal("expected REGISTERED, got", msg.MessageType())
+	}
+
+	caller, err := testClient(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	callID := wamp.GlobalID()
+	caller.Send(&wamp.Call{Request: callID, Procedure: testProcedure})
+	msg, err = wamp.RecvTimeout(callee, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inv, ok := msg.(*wamp.Invocation)
+	if !ok {
+		t.Fatal("expected INVOCATION, got", msg.MessageType())
+	}
+
+	shutdownErr := make(chan error)
+	go func() {
+		shutdownErr <- r.Shutdown(context.Background())
+	}()
+
+	// Wait for router to stop accepting new sessions.
+	deadline := time.Now().Add(time.Second)
+	for {
+		cli, err := testClient(r)
+		if err != nil {
+			break
+		}
+		cli.Close()
+		if time.Now().After(deadline) {
+			t.Fatal("new session accepted while shutting down")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// New calls must be refused while draining.
+	caller.Send(&wamp.Call{Request: wamp.GlobalID(), Procedure: testProcedure})
+	msg, err = wamp.RecvTimeout(caller, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errMsg, ok := msg.(*wamp.Error)
+	if !ok {
+		t.Fatal("expected ERROR, got", msg.MessageType())
+	}
+	if errMsg.Error != wamp.ErrSystemShutdown {
+		t.Fatal("wrong error:", errMsg.Error)
+	}
+
+	// Calls to meta procedures are still allowed.
+	caller.Send(&wamp.Call{Request: wamp.GlobalID(), Procedure: wamp.MetaProcSessionCount})
+	msg, err = wamp.RecvTimeout(caller, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok = msg.(*wamp.Result); !ok {
+		t.Fatal("expected RESULT, got", msg.MessageType())
+	}
+
+	select {
+	case <-shutdownErr:
+		t.Fatal("shutdown finished with outstanding invocation")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Outstanding call completes.
+	callee.Send(&wamp.Yield{Request: inv.Request})
+	msg, err = wamp.RecvTimeout(caller, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result, ok := msg.(*wamp.Result); !ok || result.Request != callID {
+		t.Fatal("expected RESULT for outstanding call, got", msg.MessageType())
+	}
+
+	select {
+	case err = <-shutdownErr:
+		if err != nil {
+			t.Fatal("unexpected shutdown error:", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("shutdown did not finish after invocation completed")
+	}
+
+	// Sessions receive GOODBYE after drain.
+	for _, cli := range []*wamp.Session{caller, callee} {
+		msg, err = wamp.RecvTimeout(cli, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok = msg.(*wamp.Goodbye); !ok {
+			t.Fatal("expected GOODBYE, got", msg.MessageType())
+		}
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	defer leaktest.Check(t)()
+	r, err := newTestRouter()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	callee, err := testClient(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	callee.Send(&wamp.Register{Request: wamp.GlobalID(), Procedure: testProcedure})
+	if _, err = wamp.RecvTimeout(callee, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	caller, err := testClient(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caller.Send(&wamp.Call{Request: wamp.GlobalID(), Procedure: testProcedure})
+	if _, err = wamp.RecvTimeout(callee, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// Callee never yields, so shutdown must give up when context is done.
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err = r.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatal("expected context.DeadlineExceeded, got", err)
+	}
+}
@@ -0,0 +1,822 @@
+// Package schema provides validation of WAMP message payloads using JSON
+// Schema documents.
+//
+// A subset of JSON Schema draft 7 is supported, which covers the keywords
+// needed to describe message payloads:
+//
+//     type, enum, const, $ref (local to the document), definitions, $defs,
+//     allOf, anyOf, oneOf, not,
+//     multipleOf, minimum, maximum, exclusiveMinimum, exclusiveMaximum,
+//     minLength, maxLength, pattern,
+//     items, additionalItems, minItems, maxItems, uniqueItems,
+//     properties, patternProperties, additionalProperties, required,
+//     minProperties, maxProperties
+//
+// Annotation keywords, such as title and description, are accepted and have no
+// effect.  A schema that uses any other keyword, such as format or if, is
+// rejected when it is parsed, so that a schema is never silently weaker than
+// it was written to be.  Binary data is validated as a string.
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Schema is a compiled JSON Schema document.
+type Schema struct {
+	root *node
+	// File schema was loaded from, if any.
+	file string
+}
+
+// ValidationError describes the first part of a value that does not conform
+// to a schema.
+type ValidationError struct {
+	// Path to the non-conforming value, for example "[1].name".
+	Path string
+	// Message describing why the value does not conform.
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	if e.Path == "" {
+		return e.Message
+	}
+	return e.Path + ": " + e.Message
+}
+
+// Parse compiles a JSON Schema document.
+func Parse(data []byte) (*Schema, error) {
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid schema document: %s", err)
+	}
+	c := &compiler{
+		doc:  doc,
+		refs: map[string]*node{},
+	}
+	root, err := c.compile(doc, "#")
+	if err != nil {
+		return nil, err
+	}
+	return &Schema{root: root}, nil
+}
+
+// Load reads and compiles a JSON Schema document from a file.
+func Load(path string) (*Schema, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	s.file = path
+	return s, nil
+}
+
+// UnmarshalJSON compiles a schema given in a JSON configuration.  The schema
+// is either given inline, as a JSON object or boolean, or as a string that is
+// the path of a file to load the schema document from.
+func (s *Schema) UnmarshalJSON(data []byte) error {
+	var path string
+	if err := json.Unmarshal(data, &path); err == nil {
+		loaded, err := Load(path)
+		if err != nil {
+			return err
+		}
+		*s = *loaded
+		return nil
+	}
+	parsed, err := Parse(data)
+	if err != nil {
+		return err
+	}
+	*s = *parsed
+	return nil
+}
+
+// File returns the path of the file the schema was loaded from, or an empty
+// string if the schema was not loaded from a file.
+func (s *Schema) File() string {
+	return s.file
+}
+
+// Validate checks that the value conforms to the schema.  The value may be
+// any value produced by deserializing a WAMP message, such as wamp.List,
+// wamp.Dict, or any other type that can be encoded as JSON.  If the value does
+// not conform, then a *ValidationError is returned.
+func (s *Schema) Validate(v interface{}) error {
+	return s.root.validate(normalize(v), "")
+}
+
+// node is a compiled schema or subschema.
+type node struct {
+	// Set for boolean schemas, true accepts everything and false nothing.
+	boolean *bool
+
+	types    []string
+	enum     []interface{}
+	constVal interface{}
+	hasConst bool
+
+	allOf []*node
+	anyOf []*node
+	oneOf []*node
+	not   *node
+
+	multipleOf       *float64
+	minimum          *float64
+	maximum          *float64
+	exclusiveMinimum *float64
+	exclusiveMaximum *float64
+
+	minLength *int
+	maxLength *int
+	pattern   *regexp.Regexp
+
+	items           *node
+	tupleItems      []*node
+	additionalItems *node
+	minItems        *int
+	maxItems        *int
+	uniqueItems     bool
+
+	properties           map[string]*node
+	patternProperties    map[*regexp.Regexp]*node
+	additionalProperties *node
+	required             []string
+	minProperties        *int
+	maxProperties        *int
+}
+
+// keywords are the keywords that the compiler supports, and the annotation
+// keywords that have no effect on validation.
+var keywords = map[string]struct{}{
+	"type": {}, "enum": {}, "const": {}, "$ref": {}, "definitions": {}, "$defs": {},
+	"allOf": {}, "anyOf": {}, "oneOf": {}, "not": {},
+	"multipleOf": {}, "minimum": {}, "maximum": {}, "exclusiveMinimum": {}, "exclusiveMaximum": {},
+	"minLength": {}, "maxLength": {}, "pattern": {},
+	"items": {}, "additionalItems": {}, "minItems": {}, "maxItems": {}, "uniqueItems": {},
+	"properties": {}, "patternProperties": {}, "additionalProperties": {}, "required": {},
+	"minProperties": {}, "maxProperties": {},
+
+	// Annotations
+	"$schema": {}, "$id": {}, "id": {}, "$comment": {}, "title": {}, "description": {},
+	"default": {}, "examples": {}, "readOnly": {}, "writeOnly": {},
+}
+
+type compiler struct {
+	doc interface{}
+	// JSON pointer -> compiled node, used to resolve (recursive) references.
+	refs map[string]*node
+}
+
+func (c *compiler) compile(v interface{}, ptr string) (*node, error) {
+	n := &node{}
+	if err := c.compileInto(n, v, ptr); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+func (c *compiler) compileInto(n *node, v interface{}, ptr string) error {
+	if b, ok := v.(bool); ok {
+		n.boolean = &b
+		return nil
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("%s: schema must be an object or boolean", ptr)
+	}
+	for key := range m {
+		if _, ok := keywords[key]; !ok {
+			return fmt.Errorf("%s: unsupported keyword %q", ptr, key)
+		}
+	}
+
+	// As in draft 7, keywords beside $ref are ignored.
+	if ref, ok := m["$ref"]; ok {
+		refStr, ok := ref.(string)
+		if !ok {
+			return fmt.Errorf("%s: $ref must be a string", ptr)
+		}
+		target, err := c.resolve(refStr)
+		if err != nil {
+			return fmt.Errorf("%s: %s", ptr, err)
+		}
+		*n = node{allOf: []*node{target}}
+		return nil
+	}
+
+	var err error
+	if t, ok := m["type"]; ok {
+		switch t := t.(type) {
+		case string:
+			n.types = []string{t}
+		case []interface{}:
+			for _, tt := range t {
+				s, ok := tt.(string)
+				if !ok {
+					return fmt.Errorf("%s/type: must be string or array of strings", ptr)
+				}
+				n.types = append(n.types, s)
+			}
+		default:
+			return fmt.Errorf("%s/type: must be string or array of strings", ptr)
+		}
+		for _, typ := range n.types {
+			switch typ {
+			case "null", "boolean", "object", "array", "number", "integer", "string":
+			default:
+				return fmt.Errorf("%s/type: unknown type %q", ptr, typ)
+			}
+		}
+	}
+	if e, ok := m["enum"]; ok {
+		if n.enum, ok = e.([]interface{}); !ok {
+			return fmt.Errorf("%s/enum: must be an array", ptr)
+		}
+	}
+	if cv, ok := m["const"]; ok {
+		n.constVal = cv
+		n.hasConst = true
+	}
+
+	if n.allOf, err = c.compileList(m, "allOf", ptr); err != nil {
+		return err
+	}
+	if n.anyOf, err = c.compileList(m, "anyOf", ptr); err != nil {
+		return err
+	}
+	if n.oneOf, err = c.compileList(m, "oneOf", ptr); err != nil {
+		return err
+	}
+	if n.not, err = c.compileKey(m, "not", ptr); err != nil {
+		return err
+	}
+
+	if n.multipleOf, err = getNumber(m, "multipleOf", ptr); err != nil {
+		return err
+	}
+	if n.multipleOf != nil && *n.multipleOf <= 0 {
+		return fmt.Errorf("%s/multipleOf: must be greater than 0", ptr)
+	}
+	if n.minimum, err = getNumber(m, "minimum", ptr); err != nil {
+		return err
+	}
+	if n.maximum, err = getNumber(m, "maximum", ptr); err != nil {
+		return err
+	}
+	// Support both boolean (draft 4) and numeric exclusive limits.
+	if exMin, ok := m["exclusiveMinimum"].(bool); ok {
+		if exMin {
+			n.exclusiveMinimum, n.minimum = n.minimum, nil
+		}
+	} else if n.exclusiveMinimum, err = getNumber(m, "exclusiveMinimum", ptr); err != nil {
+		return err
+	}
+	if exMax, ok := m["exclusiveMaximum"].(bool); ok {
+		if exMax {
+			n.exclusiveMaximum, n.maximum = n.maximum, nil
+		}
+	} else if n.exclusiveMaximum, err = getNumber(m, "exclusiveMaximum", ptr); err != nil {
+		return err
+	}
+
+	if n.minLength, err = getCount(m, "minLength", ptr); err != nil {
+		return err
+	}
+	if n.maxLength, err = getCount(m, "maxLength", ptr); err != nil {
+		return err
+	}
+	if p, ok := m["pattern"]; ok {
+		if n.pattern, err = compilePattern(p, ptr+"/pattern"); err != nil {
+			return err
+		}
+	}
+
+	if items, ok := m["items"]; ok {
+		if list, ok := items.([]interface{}); ok {
+			for i := range list {
+				item, err := c.compile(list[i], ptr+"/items/"+strconv.Itoa(i))
+				if err != nil {
+					return err
+				}
+				n.tupleItems = append(n.tupleItems, item)
+			}
+		} else if n.items, err = c.compile(items, ptr+"/items"); err != nil {
+			return err
+		}
+	}
+	if n.additionalItems, err = c.compileKey(m, "additionalItems", ptr); err != nil {
+		return err
+	}
+	if n.minItems, err = getCount(m, "minItems", ptr); err != nil {
+		return err
+	}
+	if n.maxItems, err = getCount(m, "maxItems", ptr); err != nil {
+		return err
+	}
+	if u, ok := m["uniqueItems"]; ok {
+		if n.uniqueItems, ok = u.(bool); !ok {
+			return fmt.Errorf("%s/uniqueItems: must be a boolean", ptr)
+		}
+	}
+
+	if props, ok := m["properties"]; ok {
+		pm, ok := props.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s/properties: must be an object", ptr)
+		}
+		n.properties = make(map[string]*node, len(pm))
+		for name, sub := range pm {
+			if n.properties[name], err = c.compile(sub, ptr+"/properties/"+name); err != nil {
+				return err
+			}
+		}
+	}
+	if props, ok := m["patternProperties"]; ok {
+		pm, ok := props.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s/patternProperties: must be an object", ptr)
+		}
+		n.patternProperties = make(map[*regexp.Regexp]*node, len(pm))
+		for pat, sub := range pm {
+			re, err := compilePattern(pat, ptr+"/patternProperties")
+			if err != nil {
+				return err
+			}
+			if n.patternProperties[re], err = c.compile(sub, ptr+"/patternProperties/"+pat); err != nil {
+				return err
+			}
+		}
+	}
+	if n.additionalProperties, err = c.compileKey(m, "additionalProperties", ptr); err != nil {
+		return err
+	}
+	if req, ok := m["required"]; ok {
+		list, ok := req.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s/required: must be an array of strings", ptr)
+		}
+		for _, r := range list {
+			name, ok := r.(string)
+			if !ok {
+				return fmt.Errorf("%s/required: must be an array of strings", ptr)
+			}
+			n.required = append(n.required, name)
+		}
+	}
+	if n.minProperties, err = getCount(m, "minProperties", ptr); err != nil {
+		return err
+	}
+	if n.maxProperties, err = getCount(m, "maxProperties", ptr); err != nil {
+		return err
+	}
+	return nil
+}
+
+// resolve returns the compiled node for a reference to a location within the
+// schema document.  Nodes are compiled once per location, which allows
+// recursive references.
+func (c *compiler) resolve(ref string) (*node, error) {
+	if !strings.HasPrefix(ref, "#") {
+		return nil, fmt.Errorf("unsupported $ref %q, only references within the document are supported", ref)
+	}
+	if n, ok := c.refs[ref]; ok {
+		return n, nil
+	}
+	v := c.doc
+	if ptr := strings.TrimPrefix(ref, "#"); ptr != "" {
+		if !strings.HasPrefix(ptr, "/") {
+			return nil, fmt.Errorf("invalid $ref %q", ref)
+		}
+		for _, tok := range strings.Split(ptr[1:], "/") {
+			tok = strings.Replace(tok, "~1", "/", -1)
+			tok = strings.Replace(tok, "~0", "~", -1)
+			switch cur := v.(type) {
+			case map[string]interface{}:
+				var ok bool
+				if v, ok = cur[tok]; !ok {
+					return nil, fmt.Errorf("$ref %q not found", ref)
+				}
+			case []interface{}:
+				i, err := strconv.Atoi(tok)
+				if err != nil || i < 0 || i >= len(cur) {
+					return nil, fmt.Errorf("$ref %q not found", ref)
+				}
+				v = cur[i]
+			default:
+				return nil, fmt.Errorf("$ref %q not found", ref)
+			}
+		}
+	}
+	n := &node{}
+	c.refs[ref] = n
+	if err := c.compileInto(n, v, ref); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+func (c *compiler) compileKey(m map[string]interface{}, key, ptr string) (*node, error) {
+	v, ok := m[key]
+	if !ok {
+		return nil, nil
+	}
+	return c.compile(v, ptr+"/"+key)
+}
+
+func (c *compiler) compileList(m map[string]interface{}, key, ptr string) ([]*node, error) {
+	v, ok := m[key]
+	if !ok {
+		return nil, nil
+	}
+	list, ok := v.([]interface{})
+	if !ok || len(list) == 0 {
+		return nil, fmt.Errorf("%s/%s: must be a non-empty array", ptr, key)
+	}
+	nodes := make([]*node, len(list))
+	for i := range list {
+		n, err := c.compile(list[i], ptr+"/"+key+"/"+strconv.Itoa(i))
+		if err != nil {
+			return nil, err
+		}
+		nodes[i] = n
+	}
+	return nodes, nil
+}
+
+func getNumber(m map[string]interface{}, key, ptr string) (*float64, error) {
+	v, ok := m[key]
+	if !ok {
+		return nil, nil
+	}
+	f, ok := v.(float64)
+	if !ok {
+		return nil, fmt.Errorf("%s/%s: must be a number", ptr, key)
+	}
+	return &f, nil
+}
+
+func getCount(m map[string]interface{}, key, ptr string) (*int, error) {
+	f, err := getNumber(m, key, ptr)
+	if err != nil || f == nil {
+		return nil, err
+	}
+	if *f < 0 || *f != math.Trunc(*f) {
+		return nil, fmt.Errorf("%s/%s: must be a non-negative integer", ptr, key)
+	}
+	i := int(*f)
+	return &i, nil
+}
+
+func compilePattern(v interface{}, ptr string) (*regexp.Regexp, error) {
+	s, ok := v.(string)
+	if !ok {
+		return nil, fmt.Errorf("%s: must be a string", ptr)
+	}
+	re, err := regexp.Compile(s)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", ptr, err)
+	}
+	return re, nil
+}
+
+func (n *node) validate(v interface{}, path string) error {
+	if n.boolean != nil {
+		if !*n.boolean {
+			return invalid(path, "no value allowed")
+		}
+		return nil
+	}
+
+	if len(n.types) != 0 {
+		typ := typeOf(v)
+		var ok bool
+		for _, t := range n.types {
+			if t == typ || (t == "number" && typ == "integer") {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return invalid(path, fmt.Sprintf("expected %s, got %s",
+				strings.Join(n.types, " or "), typ))
+		}
+	}
+	if n.enum != nil {
+		var ok bool
+		for i := range n.enum {
+			if equal(v, n.enum[i]) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return invalid(path, "value is not one of the allowed values")
+		}
+	}
+	if n.hasConst && !equal(v, n.constVal) {
+		return invalid(path, "value does not match constant")
+	}
+
+	for _, sub := range n.allOf {
+		if err := sub.validate(v, path); err != nil {
+			return err
+		}
+	}
+	if n.anyOf != nil {
+		var ok bool
+		for _, sub := range n.anyOf {
+			if sub.validate(v, path) == nil {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return invalid(path, "value does not match any allowed schema")
+		}
+	}
+	if n.oneOf != nil {
+		var count int
+		for _, sub := range n.oneOf {
+			if sub.validate(v, path) == nil {
+				count++
+			}
+		}
+		if count != 1 {
+			return invalid(path, fmt.Sprintf(
+				"value must match exactly one schema, matched %d", count))
+		}
+	}
+	if n.not != nil && n.not.validate(v, path) == nil {
+		return invalid(path, "value matches disallowed schema")
+	}
+
+	switch v := v.(type) {
+	case float64:
+		return n.validateNumber(v, path)
+	case string:
+		return n.validateString(v, path)
+	case []interface{}:
+		return n.validateArray(v, path)
+	case map[string]interface{}:
+		return n.validateObject(v, path)
+	}
+	return nil
+}
+
+func (n *node) validateNumber(v float64, path string) error {
+	if n.multipleOf != nil {
+		q := v / *n.multipleOf
+		if math.Abs(q-math.Round(q)) > 1e-9 {
+			return invalid(path, fmt.Sprint("must be a multiple of ", *n.multipleOf))
+		}
+	}
+	if n.minimum != nil && v < *n.minimum {
+		return invalid(path, fmt.Sprint("must be at least ", *n.minimum))
+	}
+	if n.maximum != nil && v > *n.maximum {
+		return invalid(path, fmt.Sprint("must be at most ", *n.maximum))
+	}
+	if n.exclusiveMinimum != nil && v <= *n.exclusiveMinimum {
+		return invalid(path, fmt.Sprint("must be greater than ", *n.exclusiveMinimum))
+	}
+	if n.exclusiveMaximum != nil && v >= *n.exclusiveMaximum {
+		return invalid(path, fmt.Sprint("must be less than ", *n.exclusiveMaximum))
+	}
+	return nil
+}
+
+func (n *node) validateString(v, path string) error {
+	if n.minLength != nil || n.maxLength != nil {
+		length := utf8.RuneCountInString(v)
+		if n.minLength != nil && length < *n.minLength {
+			return invalid(path, fmt.Sprint("length must be at least ", *n.minLength))
+		}
+		if n.maxLength != nil && length > *n.maxLength {
+			return invalid(path, fmt.Sprint("length must be at most ", *n.maxLength))
+		}
+	}
+	if n.pattern != nil && !n.pattern.MatchString(v) {
+		return invalid(path, fmt.Sprintf("does not match pattern %q", n.pattern))
+	}
+	return nil
+}
+
+func (n *node) validateArray(v []interface{}, path string) error {
+	if n.minItems != nil && len(v) < *n.minItems {
+		return invalid(path, fmt.Sprint("must have at least ", *n.minItems, " items"))
+	}
+	if n.maxItems != nil && len(v) > *n.maxItems {
+		return invalid(path, fmt.Sprint("must have at most ", *n.maxItems, " items"))
+	}
+	if n.uniqueItems {
+		for i := range v {
+			for j := i + 1; j < len(v); j++ {
+				if equal(v[i], v[j]) {
+					return invalid(path, fmt.Sprintf(
+						"items %d and %d are not unique", i, j))
+				}
+			}
+		}
+	}
+	if n.items != nil {
+		for i := range v {
+			if err := n.items.validate(v[i], indexPath(path, i)); err != nil {
+				return err
+			}
+		}
+	}
+	if n.tupleItems != nil {
+		for i := range v {
+			itemPath := indexPath(path, i)
+			if i < len(n.tupleItems) {
+				if err := n.tupleItems[i].validate(v[i], itemPath); err != nil {
+					return err
+				}
+			} else if n.additionalItems != nil {
+				if err := n.additionalItems.validate(v[i], itemPath); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (n *node) validateObject(v map[string]interface{}, path string) error {
+	for _, name := range n.required {
+		if _, ok := v[name]; !ok {
+			return invalid(path, fmt.Sprintf("missing required property %q", name))
+		}
+	}
+	if n.minProperties != nil && len(v) < *n.minProperties {
+		return invalid(path, fmt.Sprint("must have at least ", *n.minProperties, " properties"))
+	}
+	if n.maxProperties != nil && len(v) > *n.maxProperties {
+		return invalid(path, fmt.Sprint("must have at most ", *n.maxProperties, " properties"))
+	}
+
+	// Check properties in sorted order so that errors are deterministic.
+	names := make([]string, 0, len(v))
+	for name := range v {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		propPath := propertyPath(path, name)
+		var matched bool
+		if sub, ok := n.properties[name]; ok {
+			matched = true
+			if err := sub.validate(v[name], propPath); err != nil {
+				return err
+			}
+		}
+		for re, sub := range n.patternProperties {
+			if re.MatchString(name) {
+				matched = true
+				if err := sub.validate(v[name], propPath); err != nil {
+					return err
+				}
+			}
+		}
+		if !matched && n.additionalProperties != nil {
+			if err := n.additionalProperties.validate(v[name], propPath); err != nil {
+				if n.additionalProperties.boolean != nil {
+					return invalid(path, fmt.Sprintf("property %q is not allowed", name))
+				}
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func invalid(path, msg string) error {
+	return &ValidationError{Path: path, Message: msg}
+}
+
+func indexPath(path string, i int) string {
+	return path + "[" + strconv.Itoa(i) + "]"
+}
+
+func propertyPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// typeOf returns the JSON Schema type name of a normalized value.
+func typeOf(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == math.Trunc(v) && !math.IsInf(v, 0) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func equal(a, b interface{}) bool {
+	return reflect.DeepEqual(a, b)
+}
+
+// normalize converts a deserialized message value into the types produced by
+// decoding JSON: nil, bool, float64, string, []interface{}, and
+// map[string]interface{}.
+func normalize(v interface{}) interface{} {
+	switch v := v.(type) {
+	case nil, bool, float64, string:
+		return v
+	case []byte:
+		return string(v)
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i := range v {
+			out[i] = normalize(v[i])
+		}
+		return out
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			out[k] = normalize(val)
+		}
+		return out
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Bool:
+		return rv.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(rv.Uint())
+	case reflect.Float32, reflect.Float64:
+		return rv.Float()
+	case reflect.String:
+		return rv.String()
+	case reflect.Slice, reflect.Array:
+		if rv.Kind() == reflect.Slice && rv.IsNil() {
+			return nil
+		}
+		if rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() == reflect.Uint8 {
+			return string(rv.Bytes())
+		}
+		out := make([]interface{}, rv.Len())
+		for i := range out {
+			out[i] = normalize(rv.Index(i).Interface())
+		}
+		return out
+	case reflect.Map:
+		if rv.IsNil() {
+			return nil
+		}
+		out := make(map[string]interface{}, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			out[fmt.Sprint(iter.Key().Interface())] = normalize(iter.Value().Interface())
+		}
+		return out
+	case reflect.Ptr, reflect.Interface:
+		if rv.IsNil() {
+			return nil
+		}
+	}
+
+	// Structs and other types are converted by encoding them as JSON.
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	var out interface{}
+	if err = json.Unmarshal(data, &out); err != nil {
+		return fmt.Sprint(v)
+	}
+	return out
+}
@@ -0,0 +1,182 @@
+package schema
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+const testSchema = `{
+    "type": "object",
+    "required": ["name", "count"],
+    "properties": {
+        "name": {"type": "string", "minLength": 1, "pattern": "^[a-z]+$"},
+        "count": {"type": "integer", "minimum": 0, "exclusiveMaximum": 10},
+        "tags": {"type": "array", "items": {"type": "string"}, "uniqueItems": true},
+        "kind": {"enum": ["a", "b"]},
+        "child": {"$ref": "#/definitions/node"}
+    },
+    "additionalProperties": false,
+    "definitions": {
+        "node": {
+            "type": "object",
+            "properties": {
+                "value": {"type": "number"},
+                "child": {"$ref": "#/definitions/node"}
+            }
+        }
+    }
+}`
+
+func TestValidate(t *testing.T) {
+	s, err := Parse([]byte(testSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid := []interface{}{
+		wamp.Dict{"name": "abc", "count": 3},
+		wamp.Dict{"name": "abc", "count": int64(0), "tags": wamp.List{"x", "y"}},
+		wamp.Dict{"name": "abc", "count": uint64(9), "kind": "b"},
+		map[string]interface{}{"name": "abc", "count": 1.0,
+			"child": wamp.Dict{"value": 1.5, "child": wamp.Dict{"value": 2}}},
+	}
+	for i := range valid {
+		if err = s.Validate(valid[i]); err != nil {
+			t.Error("Expected value", i, "to be valid, got:", err)
+		}
+	}
+
+	invalid := map[string]interface{}{
+		"missing required property \"count\"":          wamp.Dict{"name": "abc"},
+		"name: length must be at least 1":              wamp.Dict{"name": "", "count": 1},
+		"name: expected string, got integer":           wamp.Dict{"name": 1, "count": 1},
+		"count: expected integer, got number":          wamp.Dict{"name": "abc", "count": 1.5},
+		"count: must be less than 10":                  wamp.Dict{"name": "abc", "count": 10},
+		"tags[1]: expected string, got boolean":        wamp.Dict{"name": "abc", "count": 1, "tags": wamp.List{"x", true}},
+		"tags: items 0 and 1 are not unique":           wamp.Dict{"name": "abc", "count": 1, "tags": wamp.List{"x", "x"}},
+		"kind: value is not one of the allowed values": wamp.Dict{"name": "abc", "count": 1, "kind": "c"},
+		"property \"extra\" is not allowed":            wamp.Dict{"name": "abc", "count": 1, "extra": 1},
+		"child.child.value: expected number, got string": wamp.Dict{"name": "abc", "count": 1,
+			"child": wamp.Dict{"child": wamp.Dict{"value": "x"}}},
+		"expected object, got array": wamp.List{},
+	}
+	for expect, v := range invalid {
+		err = s.Validate(v)
+		if err == nil {
+			t.Error("Expected error:", expect)
+			continue
+		}
+		if _, ok := err.(*ValidationError); !ok {
+			t.Error("Expected *ValidationError")
+		}
+		if err.Error() != expect {
+			t.Errorf("Expected error %q, got %q", expect, err)
+		}
+	}
+}
+
+func TestValidateCombinators(t *testing.T) {
+	s, err := Parse([]byte(`{
+        "type": "array",
+        "items": [
+            {"anyOf": [{"type": "string"}, {"type": "null"}]},
+            {"oneOf": [{"multipleOf": 2}, {"multipleOf": 3}]},
+            {"not": {"const": 0}}
+        ],
+        "additionalItems": false,
+        "minItems": 1
+    }`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Validate(wamp.List{nil, 4, 1}); err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []wamp.List{
+		{},
+		{1},
+		{"a", 6},
+		{"a", 2, 0},
+		{"a", 2, 1, 3},
+	} {
+		if err = s.Validate(v); err == nil {
+			t.Error("Expected error for", v)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, doc := range []string{
+		`[]`,
+		`{"type": "float"}`,
+		`{"minLength": -1}`,
+		`{"pattern": "("}`,
+		`{"$ref": "#/definitions/missing"}`,
+		`{"$ref": "other.json"}`,
+		`{"anyOf": []}`,
+		// Unsupported keywords.
+		`{"type": "string", "format": "email"}`,
+		`{"if": {"type": "string"}, "then": {"minLength": 1}}`,
+		`{"type": "array", "contains": {"type": "number"}}`,
+		`{"propertyNames": {"pattern": "^a"}}`,
+		`{"dependencies": {"a": ["b"]}}`,
+		`{"properties": {"a": {"format": "date"}}}`,
+	} {
+		if _, err := Parse([]byte(doc)); err == nil {
+			t.Error("Expected error parsing", doc)
+		}
+	}
+
+	// Annotations are accepted.
+	doc := `{"$schema": "http://json-schema.org/draft-07/schema#", "title": "t",
+		"description": "d", "default": 1, "examples": [1], "type": "number"}`
+	if _, err := Parse([]byte(doc)); err != nil {
+		t.Error("Unexpected error parsing annotations:", err)
+	}
+}
+
+func TestUnmarshalJSON(t *testing.T) {
+	dir, err := ioutil.TempDir("", "schema")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "args.json")
+	if err = ioutil.WriteFile(path, []byte(`{"type": "array", "maxItems": 1}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var cfg struct {
+		Inline *Schema `json:"inline"`
+		File   *Schema `json:"file"`
+	}
+	cfgData, _ := json.Marshal(map[string]interface{}{
+		"inline": map[string]interface{}{"type": "string"},
+		"file":   path,
+	})
+	if err = json.Unmarshal(cfgData, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Inline.File() != "" {
+		t.Error("Inline schema should not have file")
+	}
+	if err = cfg.Inline.Validate("hello"); err != nil {
+		t.Error(err)
+	}
+	if cfg.File.File() != path {
+		t.Error("Wrong schema file:", cfg.File.File())
+	}
+	if err = cfg.File.Validate(wamp.List{1, 2}); err == nil {
+		t.Error("Expected error from schema loaded from file")
+	}
+
+	var s Schema
+	if err = json.Unmarshal([]byte(`"/does/not/exist.json"`), &s); err == nil {
+		t.Error("Expected error loading missing schema file")
+	}
+}
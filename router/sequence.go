@@ -0,0 +1,83 @@
+package router
+
+import (
+	"container/list"
+
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// maxTopicSequences is the number of topics for which a subscriber's
+// per-topic sequence numbers are kept, for each subscription.  When a prefix
+// or wildcard subscription receives events for more topics, the sequence of
+// the least recently published topic is forgotten, and numbering for that
+// topic restarts at 1 if it is published to again.
+const maxTopicSequences = 1024
+
+// eventSequence numbers the events sent to a subscriber for a subscription,
+// either per subscription or per topic.  Events are numbered before they are
+// sent, so an event dropped when sending leaves a gap in the numbers.
+type eventSequence struct {
+	mode string
+	// Last sequence number per subscription.
+	seq uint64
+	// Last sequence number per topic, for at most maxTopics topics.  Topics
+	// are kept in order of use, most recent first, so that the least recently
+	// used topic is evicted.
+	topicSeq  map[wamp.URI]*list.Element
+	topicLRU  *list.List
+	maxTopics int
+}
+
+// topicSequence is the last sequence number of a topic.
+type topicSequence struct {
+	topic wamp.URI
+	seq   uint64
+}
+
+// newEventSequence returns an eventSequence that numbers events in the mode.
+func newEventSequence(mode string, maxTopics int) *eventSequence {
+	seq := &eventSequence{mode: mode}
+	if mode == wamp.SequenceTopic {
+		seq.topicSeq = map[wamp.URI]*list.Element{}
+		seq.topicLRU = list.New()
+		seq.maxTopics = maxTopics
+	}
+	return seq
+}
+
+// stamp adds the sequence number of the next event published to the topic to
+// the event details.
+func (s *eventSequence) stamp(details wamp.Dict, topic wamp.URI) {
+	if s.mode != wamp.SequenceTopic {
+		s.seq++
+		details[wamp.DetailSubscriptionSeq] = s.seq
+		return
+	}
+	elem, ok := s.topicSeq[topic]
+	if ok {
+		s.topicLRU.MoveToFront(elem)
+	} else {
+		if s.topicLRU.Len() >= s.maxTopics {
+			oldest := s.topicLRU.Back()
+			delete(s.topicSeq, oldest.Value.(*topicSequence).topic)
+			s.topicLRU.Remove(oldest)
+		}
+		elem = s.topicLRU.PushFront(&topicSequence{topic: topic})
+		s.topicSeq[topic] = elem
+	}
+	ts := elem.Value.(*topicSequence)
+	ts.seq++
+	details[wamp.DetailTopicSeq] = ts.seq
+}
+
+// syncSetSequence has events numbered for the subscriber.  Numbering restarts
+// if the subscriber changes the sequence mode.
+func (b *broker) syncSetSequence(subscriber *wamp.Session, sub *subscription, mode string) {
+	if seq, ok := sub.sequences[subscriber]; ok && seq.mode == mode {
+		return
+	}
+	if sub.sequences == nil {
+		sub.sequences = map[*wamp.Session]*eventSequence{}
+	}
+	sub.sequences[subscriber] = newEventSequence(mode, maxTopicSequences)
+}
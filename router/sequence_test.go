@@ -0,0 +1,134 @@
+package router
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+func recvEventDetail(t *testing.T, sub *wamp.Session, key string) int64 {
+	msg, err := wamp.RecvTimeout(sub, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	event, ok := msg.(*wamp.Event)
+	if !ok {
+		t.Fatal("Expected EVENT, got:", msg.MessageType())
+	}
+	n, ok := wamp.AsInt64(event.Details[key])
+	if !ok {
+		t.Fatal("Event missing detail", key)
+	}
+	return n
+}
+
+func TestTopicSequence(t *testing.T) {
+	r := newTestRouterWithRealm(t, &RealmConfig{})
+	defer r.Close()
+
+	sub, err := testClient(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub.Send(&wamp.Subscribe{
+		Request: wamp.GlobalID(),
+		Topic:   "seq",
+		Options: wamp.Dict{
+			wamp.OptMatch:    wamp.MatchPrefix,
+			wamp.OptSequence: wamp.SequenceTopic,
+		},
+	})
+	msg, err := wamp.RecvTimeout(sub, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := msg.(*wamp.Subscribed); !ok {
+		t.Fatal("Expected SUBSCRIBED, got:", msg.MessageType())
+	}
+
+	pub, err := testClient(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, topic := range []wamp.URI{"seq.a", "seq.b", "seq.a"} {
+		pub.Send(&wamp.Publish{Request: wamp.GlobalID(), Topic: topic})
+	}
+	for _, expect := range []int64{1, 1, 2} {
+		if seq := recvEventDetail(t, sub, wamp.DetailTopicSeq); seq != expect {
+			t.Fatal("Expected topic seq", expect, "got", seq)
+		}
+	}
+
+	sub.Send(&wamp.Subscribe{
+		Request: wamp.GlobalID(),
+		Topic:   "seq",
+		Options: wamp.Dict{wamp.OptSequence: "bogus"},
+	})
+	expectErrorURI(t, sub, wamp.ErrInvalidArgument)
+}
+
+// Test that events dropped because the subscriber's queue is full leave a gap
+// in the subscription sequence numbers.
+func TestSubscriptionSequenceGap(t *testing.T) {
+	r := newTestRouterWithRealm(t, &RealmConfig{})
+	defer r.Close()
+
+	sub, err := testClientQSize(r, testRealm, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub.Send(&wamp.Subscribe{
+		Request: wamp.GlobalID(),
+		Topic:   slowTopic,
+		Options: wamp.Dict{wamp.OptSequence: wamp.SequenceSubscription},
+	})
+	msg, err := wamp.RecvTimeout(sub, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := msg.(*wamp.Subscribed); !ok {
+		t.Fatal("Expected SUBSCRIBED, got:", msg.MessageType())
+	}
+
+	pub, err := testClient(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publishEvents(t, pub, 3)
+	syncBroker(t, pub)
+	if seq := recvEventDetail(t, sub, wamp.DetailSubscriptionSeq); seq != 1 {
+		t.Fatal("Expected subscription seq 1, got", seq)
+	}
+	publishEvents(t, pub, 1)
+	if seq := recvEventDetail(t, sub, wamp.DetailSubscriptionSeq); seq != 4 {
+		t.Fatal("Expected subscription seq 4, got", seq)
+	}
+}
+
+// Test that per-topic sequence numbers are kept for a limited number of
+// topics, forgetting the least recently published topic.
+func TestTopicSequenceEviction(t *testing.T) {
+	seq := newEventSequence(wamp.SequenceTopic, 2)
+	for _, tc := range []struct {
+		topic  wamp.URI
+		expect int64
+	}{
+		{"seq.a", 1},
+		{"seq.b", 1},
+		{"seq.a", 2},
+		{"seq.c", 1}, // evicts seq.b
+		{"seq.a", 3},
+		{"seq.b", 1}, // evicts seq.c
+		{"seq.a", 4},
+	} {
+		details := wamp.Dict{}
+		seq.stamp(details, tc.topic)
+		if n, _ := wamp.AsInt64(details[wamp.DetailTopicSeq]); n != tc.expect {
+			t.Fatal("Expected", tc.topic, "seq", tc.expect, "got", n)
+		}
+	}
+	if len(seq.topicSeq) != 2 || seq.topicLRU.Len() != 2 {
+		t.Fatal("Expected 2 topic sequences, got", len(seq.topicSeq))
+	}
+}
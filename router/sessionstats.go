@@ -0,0 +1,77 @@
+package router
+
+import (
+	"github.com/gammazero/nexus/v3/transport"
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// peerStats returns the traffic stats of the transport peer under any peers
+// that the router wraps it in.  The stats of a resumed session only count
+// the traffic since the session was resumed.  While a session is detached,
+// the queue depth is the number of buffered messages.
+func peerStats(peer wamp.Peer) transport.PeerStats {
+	var stats transport.PeerStats
+	findPeer(peer, func(peer wamp.Peer) bool {
+		switch p := peer.(type) {
+		case *resumablePeer:
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			if p.peer != nil {
+				return false
+			}
+			stats.QueueDepth = len(p.buffer)
+			stats.QueueSize = p.bufferSize
+			return true
+		case transport.StatsPeer:
+			stats = p.Stats()
+			return true
+		}
+		return false
+	})
+	return stats
+}
+
+// sessionGetStats is a session meta procedure that retrieves the number of
+// messages and bytes sent and received by a specific session, the depth and
+// size of its outbound queue, and the number of messages dropped because the
+// queue was full.
+func (r *realm) sessionGetStats(msg *wamp.Invocation) wamp.Message {
+	if len(msg.Arguments) == 0 {
+		return makeError(msg.Request, wamp.ErrNoSuchSession)
+	}
+
+	sid, ok := wamp.AsID(msg.Arguments[0])
+	if !ok {
+		return makeError(msg.Request, wamp.ErrNoSuchSession)
+	}
+
+	retChan := make(chan *wamp.Session)
+	r.actionChan <- func() {
+		sess, _ := r.clients[sid]
+		retChan <- sess
+	}
+	sess := <-retChan
+	if sess == nil {
+		return makeError(msg.Request, wamp.ErrNoSuchSession)
+	}
+
+	stats := peerStats(sess.Peer)
+	var dropped uint64
+	if p, ok := sess.Peer.(*slowConsumerPeer); ok {
+		dropped = p.droppedCount()
+	}
+
+	return &wamp.Yield{
+		Request: msg.Request,
+		Arguments: wamp.List{wamp.Dict{
+			"session":             sid,
+			"messages_in":         stats.MessagesIn,
+			"messages_out":        stats.MessagesOut,
+			"bytes_in":            stats.BytesIn,
+			"bytes_out":           stats.BytesOut,
+			"queue_depth":         stats.QueueDepth,
+			"queue_size":          stats.QueueSize,
+			detailDroppedMessages: dropped,
+		}},
+	}
+}
@@ -0,0 +1,48 @@
+package router
+
+import (
+	"testing"
+
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+func TestSessionGetStats(t *testing.T) {
+	r := newTestRouterWithRealm(t, &RealmConfig{})
+	defer r.Close()
+
+	sess, err := testClient(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		sess.Send(&wamp.Publish{Request: wamp.GlobalID(), Topic: "stats.topic"})
+	}
+
+	admin, err := testClient(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := callMeta(t, admin, wamp.MetaProcSessionGetStats, wamp.List{sess.ID}, nil)
+	stats, _ := wamp.AsDict(result.Arguments[0])
+	// The HELLO and PUBLISH messages were received from the session.
+	if in, _ := wamp.AsInt64(stats["messages_in"]); in != 4 {
+		t.Fatal("Expected 4 messages in, got", in)
+	}
+	// The WELCOME message was sent to the session.
+	if out, _ := wamp.AsInt64(stats["messages_out"]); out != 1 {
+		t.Fatal("Expected 1 message out, got", out)
+	}
+	if size, _ := wamp.AsInt64(stats["queue_size"]); size == 0 {
+		t.Fatal("Missing queue size")
+	}
+	if _, ok := stats[detailDroppedMessages]; !ok {
+		t.Fatal("Missing dropped message count")
+	}
+
+	admin.Send(&wamp.Call{
+		Request:   wamp.GlobalID(),
+		Procedure: wamp.MetaProcSessionGetStats,
+		Arguments: wamp.List{wamp.GlobalID()},
+	})
+	expectErrorURI(t, admin, wamp.ErrNoSuchSession)
+}
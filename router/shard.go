@@ -0,0 +1,119 @@
+package router
+
+import (
+	"sync"
+
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// Routing for a realm may be sharded across multiple brokers and dealers, each
+// running in its own goroutine, so that independent topics and procedures are
+// routed in parallel.  Subscriptions and registrations with exact matching
+// are kept by the shard selected by the hash of their URI.  Subscriptions and
+// registrations with pattern matching are kept by a separate pattern shard,
+// so that pattern matching works the same as without sharding.
+//
+// A session's messages are routed by the session's handler goroutine, which
+// waits for each message to be routed before routing the next.  This keeps
+// the order of events from a publisher, and of invocations from a caller,
+// when the messages are routed by different shards.  Publications are
+// instead handed to a queue for each publisher, which has a goroutine that
+// routes them in the same way, so that the publisher does not wait for each
+// publication to be routed.
+
+// maxShardID is the largest WAMP ID.
+const maxShardID = 1 << 53
+
+// shardIDGen generates IDs that identify the shard that generated them.  IDs
+// are sequential per shard, starting at shard+1 and incremented by the number
+// of shards, so that the shard of an ID is (ID-1) modulo the number of
+// shards.  The zero value generates the IDs of a single shard.
+type shardIDGen struct {
+	next   int64
+	shard  int64
+	shards int64
+}
+
+// Next returns the next ID.
+func (g *shardIDGen) Next() wamp.ID {
+	shards := g.shards
+	if shards == 0 {
+		shards = 1
+	}
+	id := g.next*shards + g.shard + 1
+	if id > maxShardID {
+		g.next = 0
+		id = g.shard + 1
+	}
+	g.next++
+	return wamp.ID(id)
+}
+
+// shardOfID returns the index of the shard that generated the ID.
+func shardOfID(id wamp.ID, shards int) int {
+	if id < 1 {
+		return 0
+	}
+	return int((int64(id) - 1) % int64(shards))
+}
+
+// shardOfURI returns the index of the shard for the URI, using the FNV-1a hash
+// of the URI.
+func shardOfURI(uri wamp.URI, shards int) int {
+	if shards == 1 {
+		return 0
+	}
+	h := uint32(2166136261)
+	for i := 0; i < len(uri); i++ {
+		h ^= uint32(uri[i])
+		h *= 16777619
+	}
+	return int(h % uint32(shards))
+}
+
+// runSync runs the action in the goroutine that reads actionChan, and waits
+// for it to finish.
+func runSync(actionChan chan<- func(), action func()) {
+	done := make(chan struct{})
+	actionChan <- func() {
+		action()
+		close(done)
+	}
+	<-done
+}
+
+// callCounter counts the pending calls of each caller.  The dealer shards of
+// a realm share a callCounter, so that the pending call limit applies to all
+// of a caller's calls.
+type callCounter struct {
+	mu     sync.Mutex
+	counts map[wamp.ID]int
+}
+
+// add counts a pending call of the caller.
+func (c *callCounter) add(caller wamp.ID) {
+	c.mu.Lock()
+	if c.counts == nil {
+		c.counts = map[wamp.ID]int{}
+	}
+	c.counts[caller]++
+	c.mu.Unlock()
+}
+
+// remove uncounts a pending call of the caller.
+func (c *callCounter) remove(caller wamp.ID) {
+	c.mu.Lock()
+	if n := c.counts[caller]; n > 1 {
+		c.counts[caller] = n - 1
+	} else {
+		delete(c.counts, caller)
+	}
+	c.mu.Unlock()
+}
+
+// count returns the number of pending calls of the caller.
+func (c *callCounter) count(caller wamp.ID) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.counts[caller]
+}
@@ -0,0 +1,286 @@
+package router
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gammazero/nexus/v3/transport"
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+func TestShardIDGen(t *testing.T) {
+	const shards = 5
+	for shard := 0; shard < shards; shard++ {
+		g := shardIDGen{shard: int64(shard), shards: shards}
+		prev := wamp.ID(0)
+		for i := 0; i < 10; i++ {
+			id := g.Next()
+			if id <= prev {
+				t.Fatal("IDs not increasing:", prev, id)
+			}
+			if s := shardOfID(id, shards); s != shard {
+				t.Fatalf("ID %d from shard %d mapped to shard %d", id, shard, s)
+			}
+			prev = id
+		}
+	}
+
+	// IDs wrap around without leaving the shard.
+	g := shardIDGen{next: maxShardID / shards, shard: 3, shards: shards}
+	if id := g.Next(); id > maxShardID || shardOfID(id, shards) != 3 {
+		t.Fatal("Bad ID after wrap:", id)
+	}
+
+	var single shardIDGen
+	if id := single.Next(); id != 1 {
+		t.Fatal("Expected first ID 1, got", id)
+	}
+}
+
+func TestShardedRouting(t *testing.T) {
+	r := newTestRouterWithRealm(t, &RealmConfig{RoutingShards: 4})
+	defer r.Close()
+
+	sub, err := testClient(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := testClient(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Subscribe to topics spread across the shards, and to all of them by
+	// prefix.
+	const topicCount = 8
+	subIDs := map[wamp.ID]bool{}
+	for i := 0; i < topicCount; i++ {
+		sub.Send(&wamp.Subscribe{
+			Request: wamp.GlobalID(),
+			Topic:   wamp.URI(fmt.Sprint("shard.topic.", i)),
+		})
+		subscribed := recvMsg(t, sub, wamp.SUBSCRIBED).(*wamp.Subscribed)
+		subIDs[subscribed.Subscription] = true
+	}
+	sub.Send(&wamp.Subscribe{
+		Request: wamp.GlobalID(),
+		Topic:   "shard.topic",
+		Options: wamp.Dict{wamp.OptMatch: wamp.MatchPrefix},
+	})
+	pfxSubID := recvMsg(t, sub, wamp.SUBSCRIBED).(*wamp.Subscribed).Subscription
+	subIDs[pfxSubID] = true
+	if len(subIDs) != topicCount+1 {
+		t.Fatal("Subscription IDs are not unique")
+	}
+
+	// Events are received in the order published, each by the exact and
+	// prefix subscriptions.
+	for i := 0; i < topicCount; i++ {
+		pub.Send(&wamp.Publish{
+			Request:   wamp.GlobalID(),
+			Topic:     wamp.URI(fmt.Sprint("shard.topic.", i)),
+			Arguments: wamp.List{i},
+		})
+	}
+	for i := 0; i < topicCount; i++ {
+		for j := 0; j < 2; j++ {
+			event := recvMsg(t, sub, wamp.EVENT).(*wamp.Event)
+			if arg, _ := wamp.AsInt64(event.Arguments[0]); arg != int64(i) {
+				t.Fatal("Expected event", i, "got", arg)
+			}
+			if event.Subscription == pfxSubID {
+				if _, ok := event.Details[detailTopic]; !ok {
+					t.Fatal("Missing topic in event for prefix subscription")
+				}
+			}
+		}
+	}
+
+	// Meta procedures see the subscriptions in all shards.
+	pub.Send(&wamp.Call{
+		Request:   wamp.GlobalID(),
+		Procedure: wamp.MetaProcSubMatch,
+		Arguments: wamp.List{"shard.topic.3"},
+	})
+	result := recvMsg(t, pub, wamp.RESULT).(*wamp.Result)
+	matched, _ := wamp.AsList(result.Arguments[0])
+	if len(matched) != 2 {
+		t.Fatal("Expected 2 matching subscriptions, got", len(matched))
+	}
+	pub.Send(&wamp.Call{
+		Request:   wamp.GlobalID(),
+		Procedure: wamp.MetaProcSubGet,
+		Arguments: wamp.List{pfxSubID},
+	})
+	result = recvMsg(t, pub, wamp.RESULT).(*wamp.Result)
+	details, _ := wamp.AsDict(result.Arguments[0])
+	if details["uri"] != wamp.URI("shard.topic") {
+		t.Fatal("Wrong subscription details:", details)
+	}
+
+	// Unsubscribe is routed to the shard that has the subscription.
+	for subID := range subIDs {
+		sub.Send(&wamp.Unsubscribe{Request: wamp.GlobalID(), Subscription: subID})
+		recvMsg(t, sub, wamp.UNSUBSCRIBED)
+	}
+
+	// Calls are routed to exact and prefix registrations.
+	callee, err := testClient(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	callee.Send(&wamp.Register{Request: wamp.GlobalID(), Procedure: "shard.proc"})
+	exactRegID := recvMsg(t, callee, wamp.REGISTERED).(*wamp.Registered).Registration
+	callee.Send(&wamp.Register{
+		Request:   wamp.GlobalID(),
+		Procedure: "shard",
+		Options:   wamp.Dict{wamp.OptMatch: wamp.MatchPrefix},
+	})
+	pfxRegID := recvMsg(t, callee, wamp.REGISTERED).(*wamp.Registered).Registration
+
+	for _, proc := range []wamp.URI{"shard.proc", "shard.other"} {
+		callID := wamp.GlobalID()
+		pub.Send(&wamp.Call{Request: callID, Procedure: proc})
+		inv := recvMsg(t, callee, wamp.INVOCATION).(*wamp.Invocation)
+		expect := exactRegID
+		if proc != "shard.proc" {
+			expect = pfxRegID
+		}
+		if inv.Registration != expect {
+			t.Fatal("Call to", proc, "routed to wrong registration")
+		}
+		callee.Send(&wamp.Yield{Request: inv.Request})
+		if res := recvMsg(t, pub, wamp.RESULT).(*wamp.Result); res.Request != callID {
+			t.Fatal("Wrong result request ID")
+		}
+	}
+
+	// Unregister is routed to the shard that has the registration.
+	for _, regID := range []wamp.ID{exactRegID, pfxRegID} {
+		callee.Send(&wamp.Unregister{Request: wamp.GlobalID(), Registration: regID})
+		recvMsg(t, callee, wamp.UNREGISTERED)
+	}
+	pub.Send(&wamp.Call{Request: wamp.GlobalID(), Procedure: "shard.proc"})
+	if errMsg := recvMsg(t, pub, wamp.ERROR).(*wamp.Error); errMsg.Error != wamp.ErrNoSuchProcedure {
+		t.Fatal("Expected", wamp.ErrNoSuchProcedure, "got", errMsg.Error)
+	}
+}
+
+func TestShardedSubscriptionLimit(t *testing.T) {
+	const maxSubs = 2
+	b := newBrokerShards(4, func() *broker {
+		b := newBroker(logger, false, true, debug, nil)
+		b.maxSubscriptions = maxSubs
+		return b
+	})
+	defer b.close()
+
+	// Subscribe concurrently for the same session, to topics in different
+	// shards, as meta procedures and bridges can.
+	sess := wamp.NewSession(newTestPeer(), 0, nil, nil)
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			b.subscribe(sess, &wamp.Subscribe{
+				Request: wamp.GlobalID(),
+				Topic:   wamp.URI(fmt.Sprint("shard.limit.", i)),
+			})
+		}(i)
+	}
+	wg.Wait()
+	if n := b.subscriptionCount(sess); n != maxSubs {
+		t.Fatal("Expected", maxSubs, "subscriptions, got", n)
+	}
+}
+
+// Test that a publisher does not wait for its publications to be routed by a
+// busy shard, and that the publications are still routed in order.
+func TestShardedPublishHandoff(t *testing.T) {
+	const topic = wamp.URI("shard.handoff")
+	b := newBrokerShards(4, func() *broker {
+		return newBroker(logger, false, true, debug, nil)
+	})
+	defer b.close()
+
+	client, subPeer := transport.LinkedPeersQSize(16)
+	subClient := wamp.NewSession(client, 0, nil, nil)
+	sub := wamp.NewSession(subPeer, 0, nil, nil)
+	b.subscribe(sub, &wamp.Subscribe{Request: wamp.GlobalID(), Topic: topic})
+	recvMsg(t, subClient, wamp.SUBSCRIBED)
+
+	// Block the topic's shard.
+	shard := b.topicShard(topic, wamp.MatchExact)
+	unblock := make(chan struct{})
+	shard.actionChan <- func() { <-unblock }
+
+	pub := wamp.NewSession(newTestPeer(), 0, nil, nil)
+	published := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			b.publish(pub, &wamp.Publish{
+				Request:   wamp.GlobalID(),
+				Topic:     topic,
+				Arguments: wamp.List{i},
+			})
+		}
+		close(published)
+	}()
+	select {
+	case <-published:
+	case <-time.After(time.Second):
+		t.Fatal("Publisher waited for busy shard")
+	}
+
+	close(unblock)
+	for i := 0; i < 10; i++ {
+		event := recvMsg(t, subClient, wamp.EVENT).(*wamp.Event)
+		if arg, _ := wamp.AsInt64(event.Arguments[0]); arg != int64(i) {
+			t.Fatal("Expected event", i, "got", arg)
+		}
+	}
+}
+
+// Test that publishing with a single shard is routed directly by the broker,
+// without a publisher queue.
+func TestSingleShardPublish(t *testing.T) {
+	b := newBrokerShards(1, func() *broker {
+		return newBroker(logger, false, true, debug, nil)
+	})
+	defer b.close()
+
+	pub := wamp.NewSession(newTestPeer(), 0, nil, nil)
+	b.publish(pub, &wamp.Publish{Request: wamp.GlobalID(), Topic: "shard.single"})
+	if len(b.publishers) != 0 {
+		t.Fatal("Publication from single shard was queued")
+	}
+}
+
+// BenchmarkShardedPublish measures the time to publish an event to a
+// subscriber, with a single shard and with multiple shards.
+func BenchmarkShardedPublish(b *testing.B) {
+	for _, shards := range []int{1, 4} {
+		b.Run(fmt.Sprint("shards=", shards), func(b *testing.B) {
+			s := newBrokerShards(shards, func() *broker {
+				return newBroker(logger, false, true, false, nil)
+			})
+			defer s.close()
+
+			subClient, subPeer := transport.LinkedPeers()
+			sub := wamp.NewSession(subPeer, 0, nil, nil)
+			s.subscribe(sub, &wamp.Subscribe{Request: wamp.GlobalID(), Topic: "shard.bench"})
+			<-subClient.Recv()
+			pub := wamp.NewSession(newTestPeer(), 0, nil, nil)
+			msg := &wamp.Publish{Request: wamp.GlobalID(), Topic: "shard.bench"}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				s.publish(pub, msg)
+				<-subClient.Recv()
+			}
+		})
+	}
+}
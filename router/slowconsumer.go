@@ -0,0 +1,188 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gammazero/nexus/v3/transport/serialize"
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// Slow consumer policies.  These determine what is done when a message cannot
+// be sent to a session because the session's outbound queue is full.
+const (
+	// Drop the message being sent.
+	SlowConsumerDropNewest = "drop_newest"
+	// Discard the oldest EVENT in the queue to make room for the message
+	// being sent.  Other messages are not discarded, and the message being
+	// sent is dropped if the queue has no event.
+	SlowConsumerDropOldest = "drop_oldest"
+	// Drop the message being sent and close the session with a GOODBYE
+	// having the reason wamp.close.slow_consumer.
+	SlowConsumerDisconnect = "disconnect"
+	// Wait for space in the queue, up to a deadline, and then drop the
+	// message being sent.  Routing in the realm is stalled while waiting.
+	// Once a wait has timed out, messages are dropped without waiting until
+	// the queue has space again, so that a session that stops reading does
+	// not stall the realm for every message sent to it.
+	SlowConsumerBlock = "block"
+
+	defaultSlowConsumerBlock = 100 * time.Millisecond
+
+	// Session detail reporting the number of messages dropped for a session.
+	detailDroppedMessages = "dropped_messages"
+)
+
+// slowConsumerPeer wraps a session's peer to apply a slow consumer policy to
+// messages sent by the router, and to count the messages dropped.
+type slowConsumerPeer struct {
+	// Number of dropped messages, accessed atomically.  This is first to
+	// ensure 64-bit alignment.
+	dropped uint64
+	// Set to 1, atomically, when waiting for space in the queue has timed
+	// out, and cleared when a message is queued without waiting.
+	blockTimedOut int32
+
+	wamp.Peer
+
+	// Held while sending when the policy may discard queued messages, since
+	// discarding takes messages from the queue and queues them again.
+	sendMu sync.Mutex
+
+	policy       string
+	blockTimeout time.Duration
+
+	// Session that is closed by the disconnect policy.
+	sess *wamp.Session
+}
+
+// newSlowConsumerPeer returns a slowConsumerPeer that applies the slow
+// consumer policy to messages sent to the peer.
+func newSlowConsumerPeer(peer wamp.Peer, policy string, blockTimeout time.Duration) *slowConsumerPeer {
+	return &slowConsumerPeer{
+		Peer:         peer,
+		policy:       policy,
+		blockTimeout: blockTimeout,
+	}
+}
+
+// checkSlowConsumerPolicy returns an error if the policy is not valid.
+func checkSlowConsumerPolicy(policy string) error {
+	switch policy {
+	case "", SlowConsumerDropNewest, SlowConsumerDropOldest,
+		SlowConsumerDisconnect, SlowConsumerBlock:
+		return nil
+	}
+	return fmt.Errorf("invalid slow consumer policy: %q", policy)
+}
+
+// TrySend sends a message to the peer, applying the slow consumer policy if
+// the peer's outbound queue is full.  Returns an error if the message was
+// dropped.
+func (p *slowConsumerPeer) TrySend(msg wamp.Message) error {
+	if p.policy == SlowConsumerDropOldest || p.policy == SlowConsumerDisconnect {
+		p.sendMu.Lock()
+		defer p.sendMu.Unlock()
+	}
+	err := p.Peer.TrySend(msg)
+	if err == nil {
+		if p.policy == SlowConsumerBlock {
+			atomic.StoreInt32(&p.blockTimedOut, 0)
+		}
+		return nil
+	}
+
+	switch p.policy {
+	case SlowConsumerDropOldest:
+		if p.sendDropOldest(msg) {
+			return nil
+		}
+	case SlowConsumerBlock:
+		if atomic.LoadInt32(&p.blockTimedOut) != 0 {
+			break
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), p.blockTimeout)
+		err = p.Peer.SendCtx(ctx, msg)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		atomic.StoreInt32(&p.blockTimedOut, 1)
+	case SlowConsumerDisconnect:
+		// Make room for the GOODBYE sent to the session being disconnected.
+		if _, ok := msg.(*wamp.Goodbye); ok && p.sendDropOldest(msg) {
+			return nil
+		}
+		if p.sess != nil {
+			p.sess.EndRecv(&wamp.Goodbye{
+				Reason:  wamp.CloseSlowConsumer,
+				Details: wamp.Dict{wamp.OptMessage: "outbound queue full"},
+			})
+		}
+	}
+	atomic.AddUint64(&p.dropped, 1)
+	return err
+}
+
+// sendDropOldest sends the message, discarding the oldest queued message if
+// the peer supports this.  Returns true if the message was sent.
+func (p *slowConsumerPeer) sendDropOldest(msg wamp.Message) bool {
+	dos, ok := p.Peer.(wamp.DropOldestSender)
+	if !ok {
+		return false
+	}
+	discarded, err := dos.TrySendDropOldest(msg)
+	if discarded != nil {
+		atomic.AddUint64(&p.dropped, 1)
+	}
+	return err == nil
+}
+
+// AcceptsSharedMessage returns true if the wrapped peer accepts shared
+// messages.
+func (p *slowConsumerPeer) AcceptsSharedMessage() bool {
+	return serialize.AcceptsSharedMessage(p.Peer)
+}
+
+// Unwrap returns the wrapped peer.
+func (p *slowConsumerPeer) Unwrap() wamp.Peer {
+	return p.Peer
+}
+
+// droppedCount returns the number of messages dropped for the peer.
+func (p *slowConsumerPeer) droppedCount() uint64 {
+	return atomic.LoadUint64(&p.dropped)
+}
+
+// addDroppedCount returns the session details output with the number of
+// messages dropped for the session.  In strict mode, the count is only added
+// if it is one of the additional details to include.  The session must be
+// locked, since output may be the session's details.
+func (r *realm) addDroppedCount(sess *wamp.Session, output wamp.Dict) wamp.Dict {
+	p, ok := sess.Peer.(*slowConsumerPeer)
+	if !ok {
+		return output
+	}
+	if r.metaStrict {
+		var include bool
+		for _, k := range r.metaIncDetails {
+			if k == detailDroppedMessages {
+				include = true
+				break
+			}
+		}
+		if !include {
+			return output
+		}
+	}
+	// Copy output, since it may be the session's details.
+	details := make(wamp.Dict, len(output)+1)
+	for k, v := range output {
+		details[k] = v
+	}
+	details[detailDroppedMessages] = p.droppedCount()
+	return details
+}
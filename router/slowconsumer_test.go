@@ -0,0 +1,247 @@
+package router
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+const slowTopic = wamp.URI("slow.topic")
+
+// slowConsumerSetup creates a router with the given slow consumer policy, a
+// subscriber with an outbound queue size of 1, and a publisher.
+func slowConsumerSetup(t *testing.T, policy string, blockMsec int) (Router, *wamp.Session, *wamp.Session) {
+	r := newTestRouterWithRealm(t, &RealmConfig{
+		SlowConsumerPolicy:    policy,
+		SlowConsumerBlockMsec: blockMsec,
+	})
+
+	sub, err := testClientQSize(r, testRealm, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub.Send(&wamp.Subscribe{Request: wamp.GlobalID(), Topic: slowTopic})
+	msg, err := wamp.RecvTimeout(sub, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := msg.(*wamp.Subscribed); !ok {
+		t.Fatal("Expected SUBSCRIBED, got:", msg.MessageType())
+	}
+
+	pub, err := testClient(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r, sub, pub
+}
+
+// publishEvents publishes events with the arguments 1 through n, waiting for
+// each publication to be acknowledged.
+func publishEvents(t *testing.T, pub *wamp.Session, n int) {
+	for i := 1; i <= n; i++ {
+		pub.Send(&wamp.Publish{
+			Request:   wamp.GlobalID(),
+			Options:   wamp.Dict{wamp.OptAcknowledge: true},
+			Topic:     slowTopic,
+			Arguments: wamp.List{i},
+		})
+		msg, err := wamp.RecvTimeout(pub, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := msg.(*wamp.Published); !ok {
+			t.Fatal("Expected PUBLISHED, got:", msg.MessageType())
+		}
+	}
+}
+
+// syncBroker waits for the broker to finish sending previously published
+// events, by waiting for a subscription request to be processed after them.
+func syncBroker(t *testing.T, sess *wamp.Session) {
+	sess.Send(&wamp.Subscribe{Request: wamp.GlobalID(), Topic: "slow.sync"})
+	msg, err := wamp.RecvTimeout(sess, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := msg.(*wamp.Subscribed); !ok {
+		t.Fatal("Expected SUBSCRIBED, got:", msg.MessageType())
+	}
+}
+
+func recvEventArg(t *testing.T, sub *wamp.Session) int {
+	msg, err := wamp.RecvTimeout(sub, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	event, ok := msg.(*wamp.Event)
+	if !ok {
+		t.Fatal("Expected EVENT, got:", msg.MessageType())
+	}
+	arg, _ := wamp.AsInt64(event.Arguments[0])
+	return int(arg)
+}
+
+// droppedMessages gets the number of messages dropped for the session, using
+// the wamp.session.get meta procedure.
+func droppedMessages(t *testing.T, caller *wamp.Session, sid wamp.ID) int64 {
+	caller.Send(&wamp.Call{
+		Request:   wamp.GlobalID(),
+		Procedure: wamp.MetaProcSessionGet,
+		Arguments: wamp.List{sid},
+	})
+	msg, err := wamp.RecvTimeout(caller, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, ok := msg.(*wamp.Result)
+	if !ok {
+		t.Fatal("Expected RESULT, got:", msg.MessageType())
+	}
+	details, _ := wamp.AsDict(result.Arguments[0])
+	dropped, ok := wamp.AsInt64(details[detailDroppedMessages])
+	if !ok {
+		t.Fatal("Session details missing", detailDroppedMessages)
+	}
+	return dropped
+}
+
+func TestSlowConsumerDropNewest(t *testing.T) {
+	r, sub, pub := slowConsumerSetup(t, "", 0)
+	defer r.Close()
+
+	publishEvents(t, pub, 3)
+	syncBroker(t, pub)
+	if arg := recvEventArg(t, sub); arg != 1 {
+		t.Fatal("Expected first event, got event", arg)
+	}
+	if n := droppedMessages(t, pub, sub.ID); n != 2 {
+		t.Fatal("Expected 2 dropped messages, got", n)
+	}
+}
+
+func TestSlowConsumerDropOldest(t *testing.T) {
+	r, sub, pub := slowConsumerSetup(t, SlowConsumerDropOldest, 0)
+	defer r.Close()
+
+	publishEvents(t, pub, 3)
+	syncBroker(t, pub)
+	if arg := recvEventArg(t, sub); arg != 3 {
+		t.Fatal("Expected last event, got event", arg)
+	}
+	if n := droppedMessages(t, pub, sub.ID); n != 2 {
+		t.Fatal("Expected 2 dropped messages, got", n)
+	}
+}
+
+func TestSlowConsumerDropOldestKeepsReplies(t *testing.T) {
+	r, sub, pub := slowConsumerSetup(t, SlowConsumerDropOldest, 0)
+	defer r.Close()
+
+	// Fill the subscriber's queue with a reply, which must not be discarded
+	// to make room for an event.
+	sub.Send(&wamp.Publish{
+		Request: wamp.GlobalID(),
+		Options: wamp.Dict{wamp.OptAcknowledge: true},
+		Topic:   "slow.other",
+	})
+	syncBroker(t, pub)
+	publishEvents(t, pub, 1)
+	syncBroker(t, pub)
+	msg, err := wamp.RecvTimeout(sub, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := msg.(*wamp.Published); !ok {
+		t.Fatal("Expected PUBLISHED, got:", msg.MessageType())
+	}
+	if n := droppedMessages(t, pub, sub.ID); n != 1 {
+		t.Fatal("Expected 1 dropped message, got", n)
+	}
+}
+
+func TestSlowConsumerDisconnect(t *testing.T) {
+	r, sub, pub := slowConsumerSetup(t, SlowConsumerDisconnect, 0)
+	defer r.Close()
+
+	publishEvents(t, pub, 2)
+	syncBroker(t, pub)
+	// The first event may be received before the GOODBYE, if it was not
+	// discarded to make room for the GOODBYE.
+	msg, err := wamp.RecvTimeout(sub, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := msg.(*wamp.Event); ok {
+		if msg, err = wamp.RecvTimeout(sub, time.Second); err != nil {
+			t.Fatal(err)
+		}
+	}
+	goodbye, ok := msg.(*wamp.Goodbye)
+	if !ok {
+		t.Fatal("Expected GOODBYE, got:", msg.MessageType())
+	}
+	if goodbye.Reason != wamp.CloseSlowConsumer {
+		t.Fatal("Wrong GOODBYE reason:", goodbye.Reason)
+	}
+}
+
+func TestSlowConsumerBlock(t *testing.T) {
+	r, sub, pub := slowConsumerSetup(t, SlowConsumerBlock, 1000)
+	defer r.Close()
+
+	// The router blocks sending the second event until the first event is
+	// received.
+	publishEvents(t, pub, 2)
+	if arg := recvEventArg(t, sub); arg != 1 {
+		t.Fatal("Expected first event, got event", arg)
+	}
+	if arg := recvEventArg(t, sub); arg != 2 {
+		t.Fatal("Expected second event, got event", arg)
+	}
+	if n := droppedMessages(t, pub, sub.ID); n != 0 {
+		t.Fatal("Expected 0 dropped messages, got", n)
+	}
+}
+
+func TestSlowConsumerBlockTimeout(t *testing.T) {
+	const blockMsec = 300
+	r, sub, pub := slowConsumerSetup(t, SlowConsumerBlock, blockMsec)
+	defer r.Close()
+
+	// Only the first send that cannot be queued waits.  Once that wait times
+	// out, the other events are dropped without waiting.
+	start := time.Now()
+	publishEvents(t, pub, 5)
+	syncBroker(t, pub)
+	if elapsed := time.Since(start); elapsed >= 2*blockMsec*time.Millisecond {
+		t.Fatal("Publishing blocked for", elapsed)
+	}
+	if arg := recvEventArg(t, sub); arg != 1 {
+		t.Fatal("Expected first event, got event", arg)
+	}
+	if n := droppedMessages(t, pub, sub.ID); n != 4 {
+		t.Fatal("Expected 4 dropped messages, got", n)
+	}
+
+	// Once the queue has space, sending waits again.
+	publishEvents(t, pub, 2)
+	if arg := recvEventArg(t, sub); arg != 1 {
+		t.Fatal("Expected first event, got event", arg)
+	}
+	if arg := recvEventArg(t, sub); arg != 2 {
+		t.Fatal("Expected second event, got event", arg)
+	}
+}
+
+func TestSlowConsumerPolicyConfig(t *testing.T) {
+	config := &Config{
+		RealmConfigs: []*RealmConfig{
+			{URI: testRealm, SlowConsumerPolicy: "bogus"},
+		},
+	}
+	if _, err := NewRouter(config, logger); err == nil {
+		t.Fatal("Expected error for invalid slow consumer policy")
+	}
+}
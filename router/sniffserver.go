@@ -0,0 +1,267 @@
+package router
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// rawsocketMagic is the first byte of a rawsocket handshake.
+	rawsocketMagic = 0x7f
+	// tlsHandshake is the first byte of a TLS ClientHello record.
+	tlsHandshake = 0x16
+
+	defaultSniffTimeout = 10 * time.Second
+)
+
+// SniffServer accepts websocket and rawsocket connections on a single
+// listening socket.  The first bytes of each connection are inspected to
+// determine which transport the client is using:
+//
+//     0x7F      rawsocket handshake, handled by the RawSocketServer
+//     0x16      TLS ClientHello, TLS is terminated and the connection sniffed
+//               again, if the server has a TLS configuration
+//     A-Z       HTTP request line, handled by the WebsocketServer
+//
+// Any other connection is closed.
+type SniffServer struct {
+	// TLSConfig, if not nil, is used to terminate TLS for connections that
+	// begin with a TLS handshake.  Connections that do not begin with a TLS
+	// handshake are still accepted, unless RequireTLS is set.
+	TLSConfig *tls.Config
+	// RequireTLS rejects connections that do not begin with a TLS handshake.
+	RequireTLS bool
+
+	// SniffTimeout is the time allowed for a client to send the first bytes
+	// of its connection, and to complete a TLS handshake.  The default is
+	// defaultSniffTimeout.
+	SniffTimeout time.Duration
+
+	// ProxyProtocol, if not nil, configures the server to accept PROXY
+	// protocol headers on TCP connections.  A header is read before the
+	// connection is sniffed.
+	ProxyProtocol *ProxyProtocol
+
+	wss *WebsocketServer
+	rss *RawSocketServer
+}
+
+// NewSniffServer creates a server that accepts connections for the websocket
+// server and the rawsocket server on the same listening socket.  The
+// configuration of each of those servers, such as the websocket upgrader and
+// the rawsocket TCP keep-alive, applies to the connections that it handles.
+//
+// To run the server, call one of its ListenAndServe methods:
+//
+//     s := NewSniffServer(NewWebsocketServer(r), NewRawSocketServer(r))
+//     closer, err := s.ListenAndServe("tcp", address)
+func NewSniffServer(wss *WebsocketServer, rss *RawSocketServer) *SniffServer {
+	return &SniffServer{
+		wss: wss,
+		rss: rss,
+	}
+}
+
+// ListenAndServe listens on the specified endpoint and starts a goroutine that
+// accepts new client connections until the returned io.closer is closed.
+func (s *SniffServer) ListenAndServe(network, address string) (io.Closer, error) {
+	if s.RequireTLS && s.TLSConfig == nil {
+		return nil, errors.New("TLS required without TLS configuration")
+	}
+	proxyCfg, err := s.ProxyProtocol.compileFor(network)
+	if err != nil {
+		return nil, err
+	}
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	s.serve(l, proxyCfg)
+	return l, nil
+}
+
+// ListenAndServeTLS listens on the specified endpoint and starts a goroutine
+// that accepts new client connections, terminating TLS for those that begin
+// with a TLS handshake, until the returned io.closer is closed.  If tls.Config
+// does not already contain a certificate, then certFile and keyFile, if
+// specified, are used to load an X509 certificate.
+func (s *SniffServer) ListenAndServeTLS(network, address string, tlscfg *tls.Config, certFile, keyFile string) (io.Closer, error) {
+	var hasCert bool
+	if tlscfg == nil {
+		tlscfg = &tls.Config{}
+	} else if len(tlscfg.Certificates) > 0 || tlscfg.GetCertificate != nil {
+		hasCert = true
+	}
+
+	if !hasCert || certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading X509 key pair: %s", err)
+		}
+		tlscfg.Certificates = append(tlscfg.Certificates, cert)
+	}
+	s.TLSConfig = tlscfg
+
+	return s.ListenAndServe(network, address)
+}
+
+// serve starts goroutines that accept connections from the listener, and
+// that serve HTTP on the connections sniffed as HTTP.
+func (s *SniffServer) serve(l net.Listener, proxyCfg *proxyConfig) {
+	httpListener := &connListener{
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+		addr:  l.Addr(),
+	}
+	server := &http.Server{
+		Handler:     s.wss,
+		Addr:        l.Addr().String(),
+		ConnContext: withConnTLSState,
+	}
+	go server.Serve(httpListener)
+	go s.requestHandler(l, proxyCfg, httpListener)
+}
+
+// requestHandler accepts connections from the listener.  Each connection
+// reads a PROXY protocol header if proxyCfg is not nil, and is then sniffed.
+func (s *SniffServer) requestHandler(l net.Listener, proxyCfg *proxyConfig, httpListener *connListener) {
+	defer httpListener.Close()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			// Error normal when listener closed, do not log.
+			l.Close()
+			return
+		}
+		if tcpConn, ok := conn.(*net.TCPConn); ok {
+			if s.rss.KeepAlive != 0 {
+				tcpConn.SetKeepAlive(true)
+				tcpConn.SetKeepAlivePeriod(s.rss.KeepAlive)
+			} else {
+				tcpConn.SetKeepAlive(false)
+			}
+		}
+		go s.handleConn(proxyCfg.wrap(conn), httpListener, false)
+	}
+}
+
+// handleConn reads the first byte of the connection, and hands the
+// connection to the server for the transport that the byte identifies.
+func (s *SniffServer) handleConn(conn net.Conn, httpListener *connListener, isTLS bool) {
+	// Read the PROXY protocol header, with its own timeout, before sniffing
+	// the data that follows it.
+	if pc, ok := conn.(*proxyConn); ok {
+		if err := pc.header(); err != nil {
+			s.reject(pc.Conn, fmt.Sprint("bad PROXY header: ", err))
+			return
+		}
+	}
+
+	timeout := s.SniffTimeout
+	if timeout == 0 {
+		timeout = defaultSniffTimeout
+	}
+	conn.SetReadDeadline(time.Now().Add(timeout))
+
+	r := bufio.NewReader(conn)
+	first, err := r.Peek(1)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	sc := &sniffedConn{Conn: conn, r: r}
+
+	if first[0] == tlsHandshake {
+		if s.TLSConfig == nil || isTLS {
+			s.reject(conn, "unexpected TLS handshake")
+			return
+		}
+		tlsConn := tls.Server(sc, s.TLSConfig)
+		if err = tlsConn.Handshake(); err != nil {
+			s.reject(conn, fmt.Sprint("TLS handshake failed: ", err))
+			return
+		}
+		s.handleConn(tlsConn, httpListener, true)
+		return
+	}
+	if s.RequireTLS && !isTLS {
+		s.reject(conn, "TLS required")
+		return
+	}
+
+	switch {
+	case first[0] == rawsocketMagic:
+		conn.SetReadDeadline(time.Time{})
+		s.rss.handleRawSocket(sc)
+	case first[0] >= 'A' && first[0] <= 'Z':
+		// HTTP methods are upper case, so this is the start of a request
+		// line.  The HTTP server sets its own deadlines.
+		conn.SetReadDeadline(time.Time{})
+		if !httpListener.deliver(sc) {
+			conn.Close()
+		}
+	default:
+		s.reject(conn, fmt.Sprintf("unrecognized protocol byte 0x%02x", first[0]))
+	}
+}
+
+func (s *SniffServer) reject(conn net.Conn, reason string) {
+	s.rss.router.Logger().Println("Rejected connection from", conn.RemoteAddr(),
+		"-", reason)
+	conn.Close()
+}
+
+// sniffedConn is a connection whose first bytes have been read into a buffer
+// to identify the protocol.  Reads return the buffered bytes first.
+type sniffedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *sniffedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// connListener is a net.Listener that accepts the connections delivered to it
+// by the SniffServer.
+type connListener struct {
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+	addr      net.Addr
+}
+
+// deliver hands a connection to the goroutine calling Accept.  Returns false
+// if the listener is closed.
+func (l *connListener) deliver(conn net.Conn) bool {
+	select {
+	case l.conns <- conn:
+		return true
+	case <-l.done:
+		return false
+	}
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, errors.New("listener closed")
+	}
+}
+
+func (l *connListener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *connListener) Addr() net.Addr { return l.addr }
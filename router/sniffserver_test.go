@@ -0,0 +1,202 @@
+package router
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/fortytw2/leaktest"
+	"github.com/gammazero/nexus/v3/transport"
+	"github.com/gammazero/nexus/v3/transport/serialize"
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+const sniffAddr = "127.0.0.1:8282"
+
+func checkWelcome(t *testing.T, client wamp.Peer) {
+	client.Send(&wamp.Hello{Realm: testRealm, Details: clientRoles})
+	select {
+	case msg, ok := <-client.Recv():
+		if !ok {
+			t.Fatal("Receive buffer closed")
+		}
+		if _, ok = msg.(*wamp.Welcome); !ok {
+			t.Fatalf("expected WELCOME, got %s: %+v", msg.MessageType(), msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for WELCOME")
+	}
+	client.Close()
+}
+
+func testCertificate(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestSniffServer(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	r, err := NewRouter(routerConfig, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	s := NewSniffServer(NewWebsocketServer(r), NewRawSocketServer(r))
+	closer, err := s.ListenAndServe("tcp", sniffAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer.Close()
+
+	client, err := transport.ConnectWebsocketPeer(context.Background(),
+		fmt.Sprintf("ws://%s/", sniffAddr), serialize.JSON, nil, r.Logger(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkWelcome(t, client)
+
+	client, err = transport.ConnectRawSocketPeer(context.Background(), "tcp",
+		sniffAddr, serialize.MSGPACK, nil, r.Logger(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkWelcome(t, client)
+
+	// Unrecognized protocols are rejected.
+	conn, err := net.Dial("tcp", sniffAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err = conn.Write([]byte{0x01, 0x02, 0x03, 0x04}); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err = conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("Expected connection to be closed")
+	}
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		t.Fatal("Connection was not closed")
+	}
+}
+
+func TestSniffServerTLS(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	r, err := NewRouter(routerConfig, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	s := NewSniffServer(NewWebsocketServer(r), NewRawSocketServer(r))
+	s.RequireTLS = true
+	tlscfg := &tls.Config{Certificates: []tls.Certificate{testCertificate(t)}}
+	closer, err := s.ListenAndServeTLS("tcp", sniffAddr, tlscfg, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer.Close()
+
+	clientTLS := &tls.Config{InsecureSkipVerify: true}
+	client, err := transport.ConnectWebsocketPeer(context.Background(),
+		fmt.Sprintf("wss://%s/", sniffAddr), serialize.MSGPACK, clientTLS, r.Logger(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkWelcome(t, client)
+
+	client, err = transport.ConnectRawSocketPeer(context.Background(), "tcp",
+		sniffAddr, serialize.JSON, clientTLS, r.Logger(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkWelcome(t, client)
+
+	// Plain connections are rejected when TLS is required.
+	_, err = transport.ConnectRawSocketPeer(context.Background(), "tcp",
+		sniffAddr, serialize.JSON, nil, r.Logger(), 0)
+	if err == nil {
+		t.Fatal("Expected error connecting without TLS")
+	}
+}
+
+func TestSniffServerProxyProtocol(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	r, pa := newPeerAuthRouter(t)
+	defer r.Close()
+
+	s := NewSniffServer(NewWebsocketServer(r), NewRawSocketServer(r))
+	s.ProxyProtocol = &ProxyProtocol{
+		Required:     true,
+		TrustedCIDRs: []string{"127.0.0.0/8"},
+	}
+	closer, err := s.ListenAndServe("tcp", sniffAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer.Close()
+
+	for _, tc := range []struct {
+		header []byte
+		expect string
+	}{
+		{[]byte("PROXY TCP4 192.0.2.30 127.0.0.1 6000 8282\r\n"), "192.0.2.30:6000"},
+		{proxyV2Header(&net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 6001}), "[2001:db8::2]:6001"},
+	} {
+		// Dial through a "proxy" that sends a PROXY header for the client.
+		header := tc.header
+		wsCfg := &transport.WebsocketConfig{
+			Dial: func(network, addr string) (net.Conn, error) {
+				conn, err := net.Dial(network, addr)
+				if err != nil {
+					return nil, err
+				}
+				_, err = conn.Write(header)
+				return conn, err
+			},
+		}
+		client, err := transport.ConnectWebsocketPeer(context.Background(),
+			fmt.Sprintf("ws://%s/", sniffAddr), serialize.JSON, nil, r.Logger(), wsCfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkWelcome(t, client)
+		if peer := <-pa.peers; peer != tc.expect {
+			t.Fatal("Wrong peer address in transport details:", peer)
+		}
+	}
+
+	// The header is required.
+	_, err = transport.ConnectRawSocketPeer(context.Background(), "tcp",
+		sniffAddr, serialize.JSON, nil, r.Logger(), 0)
+	if err == nil {
+		t.Fatal("Expected error connecting without PROXY header")
+	}
+}
@@ -0,0 +1,174 @@
+package router
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gammazero/nexus/v3/transport"
+	"github.com/gammazero/nexus/v3/transport/serialize"
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// Transport details describe the transport that a session is connected over.
+// They are stored in HELLO.Details and session.Details as details.transport,
+// and are available to authenticators, authorizers, publish filters, and the
+// session meta API.  Items that do not apply to a transport are omitted.
+//
+//     type        "websocket", "rawsocket", or "local"
+//     network     network of the connection: "tcp" or "unix"
+//     peer        client address, from the PROXY protocol header if any
+//     local       server address that the client connected to
+//     serializer  "json", "msgpack", "cbor", or "ubjson"
+//     protocol    negotiated websocket subprotocol
+//     http_path   path of the websocket upgrade request
+//     http_headers
+//                 headers of the websocket upgrade request, with lower-case
+//                 names, excluding credentials such as cookies
+//     tls         version, cipher_suite, server_name, and peer_cert_subject,
+//                 if the connection uses TLS
+//     peer_cred   uid, gid, and pid of the process connected to a Unix
+//                 socket
+//     auth        data only for authenticators, which is never included in
+//                 session meta; see WebsocketServer.EnableTrackingCookie
+const (
+	transportTypeWebsocket = "websocket"
+	transportTypeRawSocket = "rawsocket"
+	transportTypeLocal     = "local"
+)
+
+// excludedHTTPHeaders are request headers that are left out of transport
+// details, since they carry credentials that must not be exposed through the
+// session meta API.
+var excludedHTTPHeaders = map[string]bool{
+	"authorization":       true,
+	"cookie":              true,
+	"proxy-authorization": true,
+	"sec-websocket-key":   true,
+}
+
+type tlsStateKey struct{}
+
+// addrDetails adds the network, and the client and server addresses, to the
+// transport details.
+func addrDetails(details wamp.Dict, remote, local net.Addr) {
+	if local != nil {
+		details["network"] = networkName(local.Network())
+		if s := local.String(); s != "" {
+			details["local"] = s
+		}
+	}
+	if remote != nil {
+		if s := remote.String(); s != "" {
+			details["peer"] = s
+		}
+	}
+}
+
+// networkName returns the name of the network without any IP version.
+func networkName(network string) string {
+	switch network {
+	case "tcp4", "tcp6":
+		return "tcp"
+	}
+	return network
+}
+
+// serializerDetails adds the serializer of the peer to the transport details.
+func serializerDetails(details wamp.Dict, peer wamp.Peer) {
+	sp, ok := peer.(transport.SerializedPeer)
+	if !ok {
+		return
+	}
+	if s := sp.Serialization(); s != serialize.AUTO {
+		details["serializer"] = s.String()
+	}
+}
+
+// httpDetails adds the path and headers of the websocket upgrade request to
+// the transport details.
+func httpDetails(details wamp.Dict, r *http.Request) {
+	details["http_path"] = r.URL.Path
+	headers := make(wamp.Dict, len(r.Header))
+	for name, values := range r.Header {
+		name = strings.ToLower(name)
+		if excludedHTTPHeaders[name] {
+			continue
+		}
+		headers[name] = strings.Join(values, ", ")
+	}
+	details["http_headers"] = headers
+}
+
+// tlsDetails adds information about the TLS connection state to the transport
+// details.
+func tlsDetails(details wamp.Dict, state *tls.ConnectionState) {
+	if state == nil {
+		return
+	}
+	tlsDict := wamp.Dict{
+		"version":      tlsVersionName(state.Version),
+		"cipher_suite": tls.CipherSuiteName(state.CipherSuite),
+	}
+	if state.ServerName != "" {
+		tlsDict["server_name"] = state.ServerName
+	}
+	if len(state.PeerCertificates) != 0 {
+		tlsDict["peer_cert_subject"] = state.PeerCertificates[0].Subject.String()
+	}
+	details["tls"] = tlsDict
+}
+
+func tlsVersionName(version uint16) string {
+	switch version {
+	case tls.VersionTLS10:
+		return "TLS 1.0"
+	case tls.VersionTLS11:
+		return "TLS 1.1"
+	case tls.VersionTLS12:
+		return "TLS 1.2"
+	case tls.VersionTLS13:
+		return "TLS 1.3"
+	}
+	return fmt.Sprintf("0x%04x", version)
+}
+
+// connTLSState returns the TLS state of the connection, or nil if the
+// connection does not use TLS.
+func connTLSState(conn net.Conn) *tls.ConnectionState {
+	for {
+		switch c := conn.(type) {
+		case *tls.Conn:
+			state := c.ConnectionState()
+			return &state
+		case *sniffedConn:
+			conn = c.Conn
+		default:
+			return nil
+		}
+	}
+}
+
+// requestTLSState returns the TLS state of the connection that the request
+// was received on, or nil if the connection does not use TLS.
+func requestTLSState(r *http.Request) *tls.ConnectionState {
+	if r.TLS != nil {
+		return r.TLS
+	}
+	// The SniffServer terminates TLS itself, so http.Server does not see that
+	// the connection uses TLS.
+	state, _ := r.Context().Value(tlsStateKey{}).(*tls.ConnectionState)
+	return state
+}
+
+// withConnTLSState returns a context that holds the TLS state of the
+// connection, if it uses TLS.  This is used as http.Server.ConnContext.
+func withConnTLSState(ctx context.Context, conn net.Conn) context.Context {
+	if state := connTLSState(conn); state != nil {
+		return context.WithValue(ctx, tlsStateKey{}, state)
+	}
+	return ctx
+}
@@ -0,0 +1,204 @@
+package router
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fortytw2/leaktest"
+	"github.com/gammazero/nexus/v3/router/auth"
+	"github.com/gammazero/nexus/v3/transport"
+	"github.com/gammazero/nexus/v3/transport/serialize"
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// detailsAuth is an anonymous authenticator that reports the transport
+// details of each client.
+type detailsAuth struct {
+	details chan wamp.Dict
+}
+
+func (a *detailsAuth) AuthMethod() string { return "anonymous" }
+
+func (a *detailsAuth) Authenticate(sid wamp.ID, details wamp.Dict, client wamp.Peer) (*wamp.Welcome, error) {
+	a.details <- wamp.DictChild(details, "transport")
+	return &wamp.Welcome{
+		Details: wamp.Dict{"authid": "someone", "authrole": "user"},
+	}, nil
+}
+
+func newDetailsAuthRouter(t *testing.T) (Router, *detailsAuth) {
+	da := &detailsAuth{details: make(chan wamp.Dict, 1)}
+	r, err := NewRouter(&Config{
+		RealmConfigs: []*RealmConfig{
+			{
+				URI:              testRealm,
+				Authenticators:   []auth.Authenticator{da},
+				RequireLocalAuth: true,
+			},
+		},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r, da
+}
+
+func checkDetail(t *testing.T, details wamp.Dict, path, expect string) {
+	v, _ := wamp.DictValue(details, strings.Split(path, "."))
+	s, _ := wamp.AsString(v)
+	if s != expect {
+		t.Fatalf("Expected transport detail %s to be %q, got %q", path, expect, s)
+	}
+}
+
+func TestTransportDetailsRawSocket(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	r, da := newDetailsAuthRouter(t)
+	defer r.Close()
+
+	s := NewRawSocketServer(r)
+	closer, err := s.ListenAndServe("tcp", tcpAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer.Close()
+
+	client, err := transport.ConnectRawSocketPeer(context.Background(), "tcp",
+		tcpAddr, serialize.MSGPACK, nil, r.Logger(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkWelcome(t, client)
+
+	details := <-da.details
+	checkDetail(t, details, "type", "rawsocket")
+	checkDetail(t, details, "network", "tcp")
+	checkDetail(t, details, "local", tcpAddr)
+	checkDetail(t, details, "serializer", "msgpack")
+	if peer, _ := wamp.AsString(details["peer"]); !strings.HasPrefix(peer, "127.0.0.1:") {
+		t.Fatal("Wrong peer address in transport details:", peer)
+	}
+	if _, ok := details["tls"]; ok {
+		t.Fatal("Unexpected TLS details for connection without TLS")
+	}
+}
+
+func TestTransportDetailsWebsocketTLS(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	r, da := newDetailsAuthRouter(t)
+	defer r.Close()
+
+	s := NewSniffServer(NewWebsocketServer(r), NewRawSocketServer(r))
+	tlscfg := &tls.Config{Certificates: []tls.Certificate{testCertificate(t)}}
+	closer, err := s.ListenAndServeTLS("tcp", sniffAddr, tlscfg, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer.Close()
+
+	// Send a cookie, which must not appear in the details.
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(fmt.Sprintf("https://%s/ws", sniffAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	jar.SetCookies(u, []*http.Cookie{{Name: "secret", Value: "xyzzy"}})
+
+	clientTLS := &tls.Config{InsecureSkipVerify: true, ServerName: "nexus.test"}
+	client, err := transport.ConnectWebsocketPeer(context.Background(),
+		fmt.Sprintf("wss://%s/ws", sniffAddr), serialize.CBOR, clientTLS,
+		r.Logger(), &transport.WebsocketConfig{Jar: jar})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkWelcome(t, client)
+
+	details := <-da.details
+	checkDetail(t, details, "type", "websocket")
+	checkDetail(t, details, "network", "tcp")
+	checkDetail(t, details, "local", sniffAddr)
+	checkDetail(t, details, "serializer", "cbor")
+	checkDetail(t, details, "protocol", "wamp.2.cbor")
+	checkDetail(t, details, "http_path", "/ws")
+	checkDetail(t, details, "http_headers.sec-websocket-protocol", "wamp.2.cbor")
+	checkDetail(t, details, "tls.server_name", "nexus.test")
+	if v, _ := wamp.DictValue(details, []string{"tls", "version"}); v == nil {
+		t.Fatal("Missing TLS version in transport details")
+	}
+	if v, _ := wamp.DictValue(details, []string{"tls", "cipher_suite"}); v == nil {
+		t.Fatal("Missing TLS cipher suite in transport details")
+	}
+	headers := wamp.DictChild(details, "http_headers")
+	if _, ok := headers["cookie"]; ok {
+		t.Fatal("Cookie header included in transport details")
+	}
+
+	// Same for rawsocket over TLS on the same port.
+	client, err = transport.ConnectRawSocketPeer(context.Background(), "tcp",
+		sniffAddr, serialize.JSON, clientTLS, r.Logger(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkWelcome(t, client)
+
+	details = <-da.details
+	checkDetail(t, details, "type", "rawsocket")
+	checkDetail(t, details, "serializer", "json")
+	checkDetail(t, details, "tls.server_name", "nexus.test")
+}
+
+func TestTransportDetailsLocal(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	r, da := newDetailsAuthRouter(t)
+	defer r.Close()
+
+	cli, rtr := transport.LinkedPeers()
+	go func() {
+		if err := r.AttachClient(rtr, nil); err != nil {
+			t.Error(err)
+		}
+	}()
+	// Transport details sent by the client are replaced.
+	cli.Send(&wamp.Hello{
+		Realm: testRealm,
+		Details: wamp.Dict{
+			"roles":     clientRoles["roles"],
+			"transport": wamp.Dict{"type": "rawsocket", "peer": "192.0.2.1:1"},
+		},
+	})
+	var details wamp.Dict
+	select {
+	case details = <-da.details:
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for authentication")
+	}
+
+	// Wait for WELCOME before closing, since the router is still sending it.
+	select {
+	case msg := <-cli.Recv():
+		if _, ok := msg.(*wamp.Welcome); !ok {
+			t.Fatalf("expected WELCOME, got %s: %+v", msg.MessageType(), msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for WELCOME")
+	}
+	cli.Close()
+
+	checkDetail(t, details, "type", "local")
+	if _, ok := details["peer"]; ok {
+		t.Fatal("Client-supplied peer address in transport details")
+	}
+}
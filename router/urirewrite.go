@@ -0,0 +1,101 @@
+package router
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// URIRewrite is a rule that maps a procedure or topic URI to a new URI.  This
+// allows old and new URIs to work together during a migration.
+//
+// Rewrite rules are applied to the URIs in REGISTER, SUBSCRIBE, CALL, and
+// PUBLISH messages before they are routed, and to the URIs given to the
+// registration and subscription lookup and match meta procedures.  Each URI
+// is rewritten once at most, so rules are not chained.
+type URIRewrite struct {
+	// From is the URI to rewrite, or the URI prefix if Match is "prefix".
+	From wamp.URI `json:"from"`
+	// To is the new URI.  For a prefix rule, To replaces the matched From
+	// prefix in the URI.
+	To wamp.URI `json:"to"`
+	// Match is the matching policy for From: "exact" or "prefix".  Default
+	// is "exact".  An exact rule is preferred over a prefix rule, and the
+	// longest matching prefix rule is used.
+	Match string `json:"match"`
+}
+
+// uriRewriter rewrites URIs according to a set of URIRewrite rules.  A nil
+// uriRewriter does not rewrite anything.
+type uriRewriter struct {
+	exact map[wamp.URI]wamp.URI
+	// Prefix rules sorted by decreasing prefix length.
+	prefix []*URIRewrite
+}
+
+// newURIRewriter returns a uriRewriter for the given rules, or nil if there are
+// no rules.
+func newURIRewriter(rules []*URIRewrite, strictURI bool) (*uriRewriter, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	rw := &uriRewriter{
+		exact: map[wamp.URI]wamp.URI{},
+	}
+	prefixes := map[wamp.URI]struct{}{}
+	for _, rule := range rules {
+		if !rule.From.ValidURI(strictURI, rule.Match) || !rule.To.ValidURI(strictURI, rule.Match) {
+			return nil, fmt.Errorf("invalid URI rewrite from %v to %v (URI strict checking %v)",
+				rule.From, rule.To, strictURI)
+		}
+		if strings.HasPrefix(string(rule.From), "wamp.") || strings.HasPrefix(string(rule.To), "wamp.") {
+			return nil, fmt.Errorf("cannot rewrite restricted URI %v to %v",
+				rule.From, rule.To)
+		}
+		switch rule.Match {
+		case "", wamp.MatchExact:
+			if _, ok := rw.exact[rule.From]; ok {
+				return nil, fmt.Errorf("duplicate URI rewrite for %v", rule.From)
+			}
+			rw.exact[rule.From] = rule.To
+		case wamp.MatchPrefix:
+			if _, ok := prefixes[rule.From]; ok {
+				return nil, fmt.Errorf("duplicate URI rewrite for %v", rule.From)
+			}
+			prefixes[rule.From] = struct{}{}
+			rw.prefix = append(rw.prefix, rule)
+		default:
+			return nil, fmt.Errorf("invalid match policy %q for URI rewrite %v",
+				rule.Match, rule.From)
+		}
+	}
+	sort.Slice(rw.prefix, func(i, j int) bool {
+		return len(rw.prefix[i].From) > len(rw.prefix[j].From)
+	})
+	return rw, nil
+}
+
+// rewrite returns the rewritten URI and true, or the original URI and false if
+// no rule applies to the URI.
+func (rw *uriRewriter) rewrite(uri wamp.URI) (wamp.URI, bool) {
+	if rw == nil {
+		return uri, false
+	}
+	if to, ok := rw.exact[uri]; ok {
+		return to, true
+	}
+	for _, rule := range rw.prefix {
+		if uri.PrefixMatch(rule.From) {
+			return rule.To + uri[len(rule.From):], true
+		}
+	}
+	return uri, false
+}
+
+// rewriteURI returns the rewritten URI, or the original URI if no rule applies.
+func (rw *uriRewriter) rewriteURI(uri wamp.URI) wamp.URI {
+	uri, _ = rw.rewrite(uri)
+	return uri
+}
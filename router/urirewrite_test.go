@@ -0,0 +1,191 @@
+package router
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+func TestURIRewriter(t *testing.T) {
+	rw, err := newURIRewriter([]*URIRewrite{
+		{From: "old.proc", To: "new.procedure"},
+		{From: "old.", To: "new.", Match: wamp.MatchPrefix},
+		{From: "old.special.", To: "special.", Match: wamp.MatchPrefix},
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for uri, expect := range map[wamp.URI]wamp.URI{
+		"old.proc":          "new.procedure",
+		"old.proc.x":        "new.proc.x",
+		"old.special.thing": "special.thing",
+		"other.thing":       "other.thing",
+		"older.thing":       "older.thing",
+	} {
+		if got := rw.rewriteURI(uri); got != expect {
+			t.Errorf("Expected %v to be rewritten to %v, got %v", uri, expect, got)
+		}
+	}
+
+	var nilRW *uriRewriter
+	if _, ok := nilRW.rewrite("old.proc"); ok {
+		t.Error("nil rewriter should not rewrite")
+	}
+
+	for _, rule := range []*URIRewrite{
+		{From: "a..b", To: "c.d"},
+		{From: "a.b", To: "c.d", Match: wamp.MatchWildcard},
+		{From: "wamp.session.get", To: "c.d"},
+		{From: "a.b", To: "wamp.session.get"},
+	} {
+		if _, err = newURIRewriter([]*URIRewrite{rule}, false); err == nil {
+			t.Error("Expected error for rewrite from", rule.From, "to", rule.To)
+		}
+	}
+	_, err = newURIRewriter([]*URIRewrite{
+		{From: "a.b", To: "c.d"},
+		{From: "a.b", To: "e.f"},
+	}, false)
+	if err == nil {
+		t.Error("Expected error for duplicate rewrite")
+	}
+}
+
+func TestURIRewriteRouting(t *testing.T) {
+	const (
+		oldProc  = wamp.URI("old.app.add")
+		newProc  = wamp.URI("new.app.add")
+		oldTopic = wamp.URI("old.app.event")
+		newTopic = wamp.URI("new.app.event")
+	)
+	r := newTestRouterWithRealm(t, &RealmConfig{
+		URIRewrites: []*URIRewrite{
+			{From: "old.app.", To: "new.app.", Match: wamp.MatchPrefix},
+		},
+	})
+	defer r.Close()
+
+	callee, err := testClient(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	callee.Send(&wamp.Register{Request: wamp.GlobalID(), Procedure: newProc})
+	msg, err := wamp.RecvTimeout(callee, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registered, ok := msg.(*wamp.Registered)
+	if !ok {
+		t.Fatal("Expected REGISTERED, got:", msg.MessageType())
+	}
+
+	// Test that call to old URI is routed to new registration.
+	caller, err := testClient(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caller.Send(&wamp.Call{Request: wamp.GlobalID(), Procedure: oldProc})
+	msg, err = wamp.RecvTimeout(callee, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inv, ok := msg.(*wamp.Invocation)
+	if !ok {
+		t.Fatal("Expected INVOCATION, got:", msg.MessageType())
+	}
+	if orig, _ := wamp.AsURI(inv.Details[wamp.DetailOriginalProcedure]); orig != oldProc {
+		t.Fatal("Expected original procedure in details, got:", inv.Details)
+	}
+	callee.Send(&wamp.Yield{Request: inv.Request})
+	msg, err = wamp.RecvTimeout(caller, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok = msg.(*wamp.Result); !ok {
+		t.Fatal("Expected RESULT, got:", msg.MessageType())
+	}
+
+	// Test that call to new URI does not report original procedure.
+	caller.Send(&wamp.Call{Request: wamp.GlobalID(), Procedure: newProc})
+	msg, err = wamp.RecvTimeout(callee, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inv, ok = msg.(*wamp.Invocation); !ok {
+		t.Fatal("Expected INVOCATION, got:", msg.MessageType())
+	}
+	if _, ok = inv.Details[wamp.DetailOriginalProcedure]; ok {
+		t.Fatal("Did not expect original procedure in details")
+	}
+	callee.Send(&wamp.Yield{Request: inv.Request})
+	if _, err = wamp.RecvTimeout(caller, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// Test that registration lookup understands alias.
+	callID := wamp.GlobalID()
+	caller.Send(&wamp.Call{
+		Request:   callID,
+		Procedure: wamp.MetaProcRegLookup,
+		Arguments: wamp.List{oldProc},
+	})
+	msg, err = wamp.RecvTimeout(caller, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, ok := msg.(*wamp.Result)
+	if !ok {
+		t.Fatal("Expected RESULT, got:", msg.MessageType())
+	}
+	if len(result.Arguments) == 0 || result.Arguments[0] != registered.Registration {
+		t.Fatal("Lookup of old URI did not return registration:", result.Arguments)
+	}
+
+	// Test that subscription to old URI gets events published to new URI.
+	sub, err := testClient(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub.Send(&wamp.Subscribe{Request: wamp.GlobalID(), Topic: oldTopic})
+	msg, err = wamp.RecvTimeout(sub, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	subscribed, ok := msg.(*wamp.Subscribed)
+	if !ok {
+		t.Fatal("Expected SUBSCRIBED, got:", msg.MessageType())
+	}
+	caller.Send(&wamp.Publish{Request: wamp.GlobalID(), Topic: newTopic})
+	msg, err = wamp.RecvTimeout(sub, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	event, ok := msg.(*wamp.Event)
+	if !ok {
+		t.Fatal("Expected EVENT, got:", msg.MessageType())
+	}
+	if event.Subscription != subscribed.Subscription {
+		t.Fatal("Wrong subscription ID")
+	}
+	if _, ok = event.Details[wamp.DetailOriginalTopic]; ok {
+		t.Fatal("Did not expect original topic in details")
+	}
+
+	// Test that publication to old URI reports original topic.
+	pubMsg := &wamp.Publish{Request: wamp.GlobalID(), Topic: oldTopic}
+	caller.Send(pubMsg)
+	msg, err = wamp.RecvTimeout(sub, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event, ok = msg.(*wamp.Event); !ok {
+		t.Fatal("Expected EVENT, got:", msg.MessageType())
+	}
+	if orig, _ := wamp.AsURI(event.Details[wamp.DetailOriginalTopic]); orig != oldTopic {
+		t.Fatal("Expected original topic in details, got:", event.Details)
+	}
+	if pubMsg.Topic != oldTopic {
+		t.Fatal("Publisher's message was modified")
+	}
+}
@@ -0,0 +1,173 @@
+package router
+
+import (
+	"strings"
+
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// prefixTrie indexes prefix match patterns by their dot-separated URI
+// components, so that finding the patterns that match a URI takes time
+// proportional to the length of the URI instead of the number of patterns.
+//
+// A URI matches a prefix pattern if the pattern is a string prefix of the URI.
+// All components of the pattern, except the last, must equal the URI's
+// components.  The last component of the pattern may be a partial component,
+// so patterns are stored in the node of the components before the last,
+// keyed by the last component.
+type prefixTrie struct {
+	root prefixNode
+}
+
+type prefixNode struct {
+	children map[string]*prefixNode
+	// Last (possibly partial) component -> pattern.
+	patterns map[string]wamp.URI
+}
+
+// add adds a pattern to the trie.
+func (t *prefixTrie) add(pattern wamp.URI) {
+	node := &t.root
+	s := string(pattern)
+	for {
+		i := strings.IndexByte(s, '.')
+		if i == -1 {
+			break
+		}
+		child, ok := node.children[s[:i]]
+		if !ok {
+			child = &prefixNode{}
+			if node.children == nil {
+				node.children = map[string]*prefixNode{}
+			}
+			node.children[s[:i]] = child
+		}
+		node = child
+		s = s[i+1:]
+	}
+	if node.patterns == nil {
+		node.patterns = map[string]wamp.URI{}
+	}
+	node.patterns[s] = pattern
+}
+
+// remove removes a pattern from the trie, along with any nodes that no longer
+// lead to a pattern.
+func (t *prefixTrie) remove(pattern wamp.URI) {
+	t.root.remove(string(pattern))
+}
+
+// remove removes the pattern with the remaining components s, and returns
+// true if the node is empty.
+func (n *prefixNode) remove(s string) bool {
+	i := strings.IndexByte(s, '.')
+	if i == -1 {
+		delete(n.patterns, s)
+	} else if child, ok := n.children[s[:i]]; ok {
+		if child.remove(s[i+1:]) {
+			delete(n.children, s[:i])
+		}
+	}
+	return len(n.children) == 0 && len(n.patterns) == 0
+}
+
+// match calls fn for each pattern that matches the URI.
+func (t *prefixTrie) match(uri wamp.URI, fn func(pattern wamp.URI)) {
+	node := &t.root
+	s := string(uri)
+	for node != nil {
+		comp := s
+		i := strings.IndexByte(s, '.')
+		if i != -1 {
+			comp = s[:i]
+		}
+		// Any pattern whose last component is a prefix of this component
+		// matches.
+		if len(node.patterns) != 0 {
+			for j := 0; j <= len(comp); j++ {
+				if pattern, ok := node.patterns[comp[:j]]; ok {
+					fn(pattern)
+				}
+			}
+		}
+		if i == -1 {
+			return
+		}
+		node = node.children[comp]
+		s = s[i+1:]
+	}
+}
+
+// wildcardTrie indexes wildcard match patterns by their dot-separated URI
+// components.  An empty component in a pattern is a wildcard that matches any
+// component, and is stored as the child with the empty key.
+type wildcardTrie struct {
+	root wildcardNode
+}
+
+type wildcardNode struct {
+	children map[string]*wildcardNode
+	// Pattern that ends at this node, if any.
+	pattern wamp.URI
+}
+
+// add adds a pattern to the trie.
+func (t *wildcardTrie) add(pattern wamp.URI) {
+	node := &t.root
+	for _, comp := range strings.Split(string(pattern), ".") {
+		child, ok := node.children[comp]
+		if !ok {
+			child = &wildcardNode{}
+			if node.children == nil {
+				node.children = map[string]*wildcardNode{}
+			}
+			node.children[comp] = child
+		}
+		node = child
+	}
+	node.pattern = pattern
+}
+
+// remove removes a pattern from the trie, along with any nodes that no longer
+// lead to a pattern.
+func (t *wildcardTrie) remove(pattern wamp.URI) {
+	t.root.remove(strings.Split(string(pattern), "."))
+}
+
+// remove removes the pattern with the remaining components, and returns true
+// if the node is empty.
+func (n *wildcardNode) remove(comps []string) bool {
+	if len(comps) == 0 {
+		n.pattern = ""
+	} else if child, ok := n.children[comps[0]]; ok {
+		if child.remove(comps[1:]) {
+			delete(n.children, comps[0])
+		}
+	}
+	return len(n.children) == 0 && n.pattern == ""
+}
+
+// match calls fn for each pattern that matches the URI.
+func (t *wildcardTrie) match(uri wamp.URI, fn func(pattern wamp.URI)) {
+	if len(t.root.children) == 0 {
+		return
+	}
+	t.root.match(strings.Split(string(uri), "."), fn)
+}
+
+func (n *wildcardNode) match(comps []string, fn func(pattern wamp.URI)) {
+	if len(comps) == 0 {
+		if n.pattern != "" {
+			fn(n.pattern)
+		}
+		return
+	}
+	if child, ok := n.children[comps[0]]; ok {
+		child.match(comps[1:], fn)
+	}
+	if comps[0] != "" {
+		if child, ok := n.children[""]; ok {
+			child.match(comps[1:], fn)
+		}
+	}
+}
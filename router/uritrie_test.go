@@ -0,0 +1,73 @@
+package router
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+var trieURIs = []wamp.URI{
+	"a", "a.b", "a.bc", "a.b.c", "a.b.c.d", "a.x.c", "a..c", "ab.c", "a.b.",
+}
+
+// checkTrieMatch checks that the trie matches the same patterns as matchFn.
+func checkTrieMatch(t *testing.T, patterns []wamp.URI, trieMatch func(wamp.URI, func(wamp.URI)), matchFn func(uri, pattern wamp.URI) bool) {
+	for _, uri := range trieURIs {
+		var got, want []string
+		trieMatch(uri, func(pattern wamp.URI) {
+			got = append(got, string(pattern))
+		})
+		for _, pattern := range patterns {
+			if matchFn(uri, pattern) {
+				want = append(want, string(pattern))
+			}
+		}
+		sort.Strings(got)
+		sort.Strings(want)
+		if len(got) != len(want) {
+			t.Fatalf("%s matched %v, expected %v", uri, got, want)
+		}
+		for i := range got {
+			if got[i] != want[i] {
+				t.Fatalf("%s matched %v, expected %v", uri, got, want)
+			}
+		}
+	}
+}
+
+func TestPrefixTrie(t *testing.T) {
+	patterns := []wamp.URI{"a", "a.b", "a.b.", "a.b.c", "a.", "ab", "x.y"}
+	var trie prefixTrie
+	for _, pattern := range patterns {
+		trie.add(pattern)
+	}
+	checkTrieMatch(t, patterns, trie.match, wamp.URI.PrefixMatch)
+
+	for len(patterns) != 0 {
+		trie.remove(patterns[0])
+		patterns = patterns[1:]
+		checkTrieMatch(t, patterns, trie.match, wamp.URI.PrefixMatch)
+	}
+	if len(trie.root.children) != 0 || len(trie.root.patterns) != 0 {
+		t.Fatal("Trie not empty after removing all patterns")
+	}
+}
+
+func TestWildcardTrie(t *testing.T) {
+	patterns := []wamp.URI{"a..c", "a.b.", ".b.c", "a.b.c", "..", "a.", "a..c.d"}
+	var trie wildcardTrie
+	for _, pattern := range patterns {
+		trie.add(pattern)
+	}
+	checkTrieMatch(t, patterns, trie.match, wamp.URI.WildcardMatch)
+
+	for len(patterns) != 0 {
+		trie.remove(patterns[0])
+		patterns = patterns[1:]
+		checkTrieMatch(t, patterns, trie.match, wamp.URI.WildcardMatch)
+	}
+	if len(trie.root.children) != 0 {
+		t.Fatal("Trie not empty after removing all patterns")
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"net/url"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gammazero/nexus/v3/transport"
@@ -24,13 +25,22 @@ const (
 	jsonWebsocketProtocol    = "wamp.2.json"
 	msgpackWebsocketProtocol = "wamp.2.msgpack"
 	cborWebsocketProtocol    = "wamp.2.cbor"
+	ubjsonWebsocketProtocol  = "wamp.2.ubjson"
+
+	jsonBatchedWebsocketProtocol    = "wamp.2.json.batched"
+	msgpackBatchedWebsocketProtocol = "wamp.2.msgpack.batched"
 
 	defaultOutQueueSize = 64
 )
 
+// wsWriteBufferPool holds the write buffers of websocket connections, so that
+// an idle connection does not hold a write buffer.
+var wsWriteBufferPool sync.Pool
+
 type protocol struct {
 	payloadType int
 	serializer  serialize.Serializer
+	batched     bool
 }
 
 // WebsocketServer handles websocket connections.
@@ -96,6 +106,21 @@ type WebsocketServer struct {
 	// client.  The default is defaultOutQueueSize.
 	OutQueueSize int
 
+	// BatchWindow is how long to wait for more messages to add to a batch
+	// before sending it to a client that uses a batched subprotocol.  If zero,
+	// then a batch is sent as soon as there are no more messages waiting to
+	// be sent.
+	BatchWindow time.Duration
+	// BatchMaxSize is the size, in bytes, at which a batch is sent to a
+	// client without waiting for more messages.  The default is
+	// transport.DefaultBatchMaxSize.
+	BatchMaxSize int
+
+	// ProxyProtocol, if not nil, configures the server's listeners to accept
+	// PROXY protocol headers, from load balancers that report the client
+	// address this way.  This applies to the ListenAndServe methods.
+	ProxyProtocol *ProxyProtocol
+
 	router    Router
 	protocols map[string]protocol
 }
@@ -128,13 +153,19 @@ func NewWebsocketServer(r Router) *WebsocketServer {
 		router:    r,
 		protocols: map[string]protocol{},
 	}
-	s.Upgrader = &websocket.Upgrader{}
+	s.Upgrader = &websocket.Upgrader{WriteBufferPool: &wsWriteBufferPool}
+	s.addProtocol(jsonBatchedWebsocketProtocol, websocket.TextMessage,
+		&serialize.JSONSerializer{}, true)
 	s.addProtocol(jsonWebsocketProtocol, websocket.TextMessage,
-		&serialize.JSONSerializer{})
+		&serialize.JSONSerializer{}, false)
+	s.addProtocol(msgpackBatchedWebsocketProtocol, websocket.BinaryMessage,
+		&serialize.MessagePackSerializer{}, true)
 	s.addProtocol(msgpackWebsocketProtocol, websocket.BinaryMessage,
-		&serialize.MessagePackSerializer{})
+		&serialize.MessagePackSerializer{}, false)
 	s.addProtocol(cborWebsocketProtocol, websocket.BinaryMessage,
-		&serialize.CBORSerializer{})
+		&serialize.CBORSerializer{}, false)
+	s.addProtocol(ubjsonWebsocketProtocol, websocket.BinaryMessage,
+		&serialize.UBJSONSerializer{}, false)
 
 	return s
 }
@@ -224,6 +255,10 @@ func (s *WebsocketServer) AllowOrigins(origins []string) error {
 // ListenAndServe listens on the specified TCP address and starts a goroutine
 // that accepts new client connections until the returned io.closer is closed.
 func (s *WebsocketServer) ListenAndServe(address string) (io.Closer, error) {
+	proxyCfg, err := s.ProxyProtocol.compile()
+	if err != nil {
+		return nil, err
+	}
 	// Call Listen separate from Serve to check for error listening.
 	l, err := net.Listen("tcp", address)
 	if err != nil {
@@ -235,7 +270,7 @@ func (s *WebsocketServer) ListenAndServe(address string) (io.Closer, error) {
 		Handler: s,
 		Addr:    l.Addr().String(),
 	}
-	go server.Serve(l)
+	go server.Serve(wrapListener(l, proxyCfg))
 	return l, nil
 }
 
@@ -262,6 +297,10 @@ func (s *WebsocketServer) ListenAndServeTLS(address string, tlscfg *tls.Config,
 		tlscfg.Certificates = append(tlscfg.Certificates, cert)
 	}
 
+	proxyCfg, err := s.ProxyProtocol.compile()
+	if err != nil {
+		return nil, err
+	}
 	// Call Listen separate from Serve to check for error listening.
 	l, err := net.Listen("tcp", address)
 	if err != nil {
@@ -274,7 +313,7 @@ func (s *WebsocketServer) ListenAndServeTLS(address string, tlscfg *tls.Config,
 		Addr:      l.Addr().String(),
 		TLSConfig: tlscfg,
 	}
-	go server.ServeTLS(l, "", "")
+	go server.ServeTLS(wrapListener(l, proxyCfg), "", "")
 	return l, nil
 }
 
@@ -319,36 +358,64 @@ func (s *WebsocketServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		authDict["request"] = r
 	}
 
-	conn, err := s.Upgrader.Upgrade(w, r, w.Header())
+	// Gorilla selects the first of the server's subprotocols that the client
+	// requests, so give it only the subprotocol preferred by the client.
+	upgrader := *s.Upgrader
+	if proto := s.selectProtocol(r); proto != "" {
+		upgrader.Subprotocols = []string{proto}
+	}
+	conn, err := upgrader.Upgrade(w, r, w.Header())
 	if err != nil {
 		s.router.Logger().Println("Error upgrading to websocket connection:", err)
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	s.handleWebsocket(conn, wamp.Dict{"auth": authDict})
+	// The remote address of the request is the client address from the PROXY
+	// protocol header, if there is one.
+	transportDetails := wamp.Dict{"auth": authDict}
+	addrDetails(transportDetails, conn.RemoteAddr(), conn.LocalAddr())
+	httpDetails(transportDetails, r)
+	tlsDetails(transportDetails, requestTLSState(r))
+	s.handleWebsocket(conn, transportDetails)
 }
 
-// addProtocol registers a serializer for protocol and payload type.
-func (s *WebsocketServer) addProtocol(proto string, payloadType int, serializer serialize.Serializer) error {
+// addProtocol registers a serializer for protocol and payload type, and
+// whether the protocol sends batches of messages.
+func (s *WebsocketServer) addProtocol(proto string, payloadType int, serializer serialize.Serializer, batched bool) error {
 	if payloadType != websocket.TextMessage && payloadType != websocket.BinaryMessage {
 		return fmt.Errorf("invalid payload type: %d", payloadType)
 	}
 	if _, ok := s.protocols[proto]; ok {
 		return errors.New("protocol already registered: " + proto)
 	}
-	s.protocols[proto] = protocol{payloadType, serializer}
+	s.protocols[proto] = protocol{payloadType, serializer, batched}
 	s.Upgrader.Subprotocols = append(s.Upgrader.Subprotocols, proto)
 	return nil
 }
 
+// selectProtocol returns the first subprotocol requested by the client that
+// the server supports, or "" if there is none.
+func (s *WebsocketServer) selectProtocol(r *http.Request) string {
+	for _, proto := range websocket.Subprotocols(r) {
+		for _, serverProto := range s.Upgrader.Subprotocols {
+			if proto == serverProto {
+				return proto
+			}
+		}
+	}
+	return ""
+}
+
 func (s *WebsocketServer) handleWebsocket(conn transport.WebsocketConnection, transportDetails wamp.Dict) {
 	var serializer serialize.Serializer
 	var payloadType int
+	var batched bool
 	// Get serializer and payload type for protocol.
 	if proto, ok := s.protocols[conn.Subprotocol()]; ok {
 		serializer = proto.serializer
 		payloadType = proto.payloadType
+		batched = proto.batched
 	} else {
 		// Although gorilla rejects connections with unregistered protocols,
 		// other websocket implementations may not.
@@ -362,6 +429,17 @@ func (s *WebsocketServer) handleWebsocket(conn transport.WebsocketConnection, tr
 		case cborWebsocketProtocol:
 			serializer = &serialize.CBORSerializer{}
 			payloadType = websocket.BinaryMessage
+		case ubjsonWebsocketProtocol:
+			serializer = &serialize.UBJSONSerializer{}
+			payloadType = websocket.BinaryMessage
+		case jsonBatchedWebsocketProtocol:
+			serializer = &serialize.JSONSerializer{}
+			payloadType = websocket.TextMessage
+			batched = true
+		case msgpackBatchedWebsocketProtocol:
+			serializer = &serialize.MessagePackSerializer{}
+			payloadType = websocket.BinaryMessage
+			batched = true
 		default:
 			conn.Close()
 			return
@@ -374,7 +452,19 @@ func (s *WebsocketServer) handleWebsocket(conn transport.WebsocketConnection, tr
 	if qsize == 0 {
 		qsize = defaultOutQueueSize
 	}
-	peer := transport.NewWebsocketPeer(conn, serializer, payloadType, s.router.Logger(), s.KeepAlive, qsize)
+	var peer wamp.Peer
+	if batched {
+		peer = transport.NewBatchedWebsocketPeer(conn, serializer, payloadType, s.router.Logger(), s.KeepAlive, qsize,
+			transport.WebsocketBatchConfig{Window: s.BatchWindow, MaxSize: s.BatchMaxSize})
+	} else {
+		peer = transport.NewWebsocketPeer(conn, serializer, payloadType, s.router.Logger(), s.KeepAlive, qsize)
+	}
+	if transportDetails == nil {
+		transportDetails = wamp.Dict{}
+	}
+	transportDetails["type"] = transportTypeWebsocket
+	transportDetails["protocol"] = conn.Subprotocol()
+	serializerDetails(transportDetails, peer)
 	if err := s.router.AttachClient(peer, transportDetails); err != nil {
 		s.router.Logger().Println("Client cannot attach to router:", err)
 	}
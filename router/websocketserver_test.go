@@ -1,10 +1,12 @@
 package router
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"net/http"
 	"testing"
+	"time"
 
 	"github.com/fortytw2/leaktest"
 	"github.com/gammazero/nexus/v3/transport"
@@ -100,6 +102,39 @@ func TestWSHandshakeMsgpack(t *testing.T) {
 	client.Close()
 }
 
+func TestWSHandshakeUBJSON(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	r, err := NewRouter(routerConfig, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	closer, err := NewWebsocketServer(r).ListenAndServe(wsAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer.Close()
+
+	client, err := transport.ConnectWebsocketPeer(
+		context.Background(), fmt.Sprintf("ws://%s/", wsAddr), serialize.UBJSON, nil, r.Logger(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client.Send(&wamp.Hello{Realm: testRealm, Details: clientRoles})
+	msg, ok := <-client.Recv()
+	if !ok {
+		t.Fatal("Receive buffer closed")
+	}
+
+	if _, ok = msg.(*wamp.Welcome); !ok {
+		t.Fatalf("expected WELCOME, got %s: %+v", msg.MessageType(), msg)
+	}
+	client.Close()
+}
+
 func TestAllowOrigins(t *testing.T) {
 	s := &WebsocketServer{
 		Upgrader: &websocket.Upgrader{},
@@ -227,3 +262,178 @@ func TestAllowOriginsWithPorts(t *testing.T) {
 		t.Error("Should have allowed:", allowed)
 	}
 }
+
+func TestWSBatchedJSON(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	r, err := NewRouter(routerConfig, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	s := NewWebsocketServer(r)
+	s.BatchWindow = 50 * time.Millisecond
+	closer, err := s.ListenAndServe(wsAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer.Close()
+
+	// The subprotocol is selected in the client's order of preference.
+	dialer := websocket.Dialer{
+		Subprotocols: []string{jsonWebsocketProtocol, jsonBatchedWebsocketProtocol},
+	}
+	conn, _, err := dialer.Dial(fmt.Sprintf("ws://%s/", wsAddr), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn.Subprotocol() != jsonWebsocketProtocol {
+		t.Fatal("Wrong subprotocol:", conn.Subprotocol())
+	}
+	conn.Close()
+
+	dialer.Subprotocols = []string{jsonBatchedWebsocketProtocol, jsonWebsocketProtocol}
+	conn, _, err = dialer.Dial(fmt.Sprintf("ws://%s/", wsAddr), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if conn.Subprotocol() != jsonBatchedWebsocketProtocol {
+		t.Fatal("Wrong subprotocol:", conn.Subprotocol())
+	}
+
+	var serializer serialize.JSONSerializer
+	batch := func(msgs ...wamp.Message) []byte {
+		var b []byte
+		for _, msg := range msgs {
+			data, err := serializer.Serialize(msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b = append(append(b, data...), 0x1e)
+		}
+		return b
+	}
+	// recv reads batches until count messages are received.
+	recv := func(count int) []wamp.Message {
+		var msgs []wamp.Message
+		for len(msgs) < count {
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			msgType, b, err := conn.ReadMessage()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if msgType != websocket.TextMessage {
+				t.Fatal("Expected text message")
+			}
+			if b[len(b)-1] != 0x1e {
+				t.Fatal("Batch not terminated by separator")
+			}
+			for _, data := range bytes.Split(b[:len(b)-1], []byte{0x1e}) {
+				msg, err := serializer.Deserialize(data)
+				if err != nil {
+					t.Fatal(err)
+				}
+				msgs = append(msgs, msg)
+			}
+		}
+		if len(msgs) != count {
+			t.Fatal("Expected", count, "messages, got", len(msgs))
+		}
+		return msgs
+	}
+
+	err = conn.WriteMessage(websocket.TextMessage,
+		batch(&wamp.Hello{Realm: testRealm, Details: clientRoles}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg := recv(1)[0]; msg.MessageType() != wamp.WELCOME {
+		t.Fatalf("expected WELCOME, got %s: %+v", msg.MessageType(), msg)
+	}
+
+	// Send several messages in one batch, and receive the replies batched
+	// together within the batch window.
+	const count = 5
+	var subs []wamp.Message
+	for i := 0; i < count; i++ {
+		subs = append(subs, &wamp.Subscribe{
+			Request: wamp.ID(i + 1),
+			Topic:   wamp.URI(fmt.Sprint("batch.topic.", i)),
+		})
+	}
+	if err = conn.WriteMessage(websocket.TextMessage, batch(subs...)); err != nil {
+		t.Fatal(err)
+	}
+	for i, msg := range recv(count) {
+		subscribed, ok := msg.(*wamp.Subscribed)
+		if !ok {
+			t.Fatalf("expected SUBSCRIBED, got %s: %+v", msg.MessageType(), msg)
+		}
+		if subscribed.Request != wamp.ID(i+1) {
+			t.Fatal("Wrong request ID:", subscribed.Request)
+		}
+	}
+}
+
+func TestWSBatchedMsgpack(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	r, err := NewRouter(routerConfig, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	s := NewWebsocketServer(r)
+	s.BatchMaxSize = 64
+	closer, err := s.ListenAndServe(wsAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer.Close()
+
+	wsCfg := &transport.WebsocketConfig{
+		Batched:     true,
+		BatchWindow: 20 * time.Millisecond,
+	}
+	client, err := transport.ConnectWebsocketPeer(context.Background(),
+		fmt.Sprintf("ws://%s/", wsAddr), serialize.MSGPACK, nil, r.Logger(), wsCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	client.Send(&wamp.Hello{Realm: testRealm, Details: clientRoles})
+	msg, ok := <-client.Recv()
+	if !ok {
+		t.Fatal("Receive buffer closed")
+	}
+	if _, ok = msg.(*wamp.Welcome); !ok {
+		t.Fatalf("expected WELCOME, got %s: %+v", msg.MessageType(), msg)
+	}
+
+	// Replies are sent in batches limited to the maximum size.
+	const count = 20
+	for i := 0; i < count; i++ {
+		client.Send(&wamp.Subscribe{
+			Request: wamp.ID(i + 1),
+			Topic:   wamp.URI(fmt.Sprint("batch.topic.", i)),
+		})
+	}
+	for i := 0; i < count; i++ {
+		select {
+		case msg = <-client.Recv():
+		case <-time.After(time.Second):
+			t.Fatal("Timed out waiting for SUBSCRIBED")
+		}
+		subscribed, ok := msg.(*wamp.Subscribed)
+		if !ok {
+			t.Fatalf("expected SUBSCRIBED, got %s: %+v", msg.MessageType(), msg)
+		}
+		if subscribed.Request != wamp.ID(i+1) {
+			t.Fatal("Wrong request ID:", subscribed.Request)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package transport
+
+import (
+	"bytes"
+	"sync"
+)
+
+// maxPooledBuffer is the capacity of the largest buffer kept for reuse.
+// Larger buffers, used to receive occasional large messages, are left for the
+// garbage collector so that they do not stay allocated.
+const maxPooledBuffer = 64 * 1024
+
+// recvBufferPool holds buffers that peers receive messages into.  A buffer is
+// returned to the pool once its message is deserialized, which is safe since
+// deserialized messages do not reference the data they are decoded from.
+var recvBufferPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// getRecvBuffer gets an empty buffer from the pool.
+func getRecvBuffer() *bytes.Buffer {
+	return recvBufferPool.Get().(*bytes.Buffer)
+}
+
+// putRecvBuffer returns the buffer to the pool, unless it is too large to
+// keep.
+func putRecvBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBuffer {
+		return
+	}
+	buf.Reset()
+	recvBufferPool.Put(buf)
+}
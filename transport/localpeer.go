@@ -2,6 +2,7 @@ package transport
 
 import (
 	"context"
+	"sync/atomic"
 
 	"github.com/gammazero/nexus/v3/wamp"
 )
@@ -33,10 +34,13 @@ func LinkedPeersQSize(queueSize int) (wamp.Peer, wamp.Peer) {
 	// unbuffered.
 	cToR := make(chan wamp.Message)
 
+	rTraffic := &trafficCounter{}
+	cTraffic := &trafficCounter{}
+
 	// router reads from and writes to client
-	r := &localPeer{rd: cToR, wr: rToC}
+	r := &localPeer{rd: cToR, wr: rToC, traffic: rTraffic, linked: cTraffic}
 	// client reads from and writes to router
-	c := &localPeer{rd: rToC, wr: cToR}
+	c := &localPeer{rd: rToC, wr: cToR, traffic: cTraffic, linked: rTraffic}
 
 	return c, r
 }
@@ -44,7 +48,11 @@ func LinkedPeersQSize(queueSize int) (wamp.Peer, wamp.Peer) {
 // localPeer implements Peer
 type localPeer struct {
 	rd <-chan wamp.Message
-	wr chan<- wamp.Message
+	wr chan wamp.Message
+
+	// Count the messages sent by this peer and by the linked peer.
+	traffic *trafficCounter
+	linked  *trafficCounter
 }
 
 // IsLocal returns true is the wamp.Peer is a localPeer.
@@ -55,11 +63,29 @@ func (p *localPeer) Recv() <-chan wamp.Message { return p.rd }
 
 // TrySend writes a message to the peer's outbound message channel.
 func (p *localPeer) TrySend(msg wamp.Message) error {
-	return wamp.TrySend(p.wr, msg)
+	if err := wamp.TrySend(p.wr, msg); err != nil {
+		return err
+	}
+	p.traffic.sent(0)
+	return nil
+}
+
+// TrySendDropOldest writes a message to the peer's outbound message channel,
+// discarding the oldest message in the channel if it is full.
+func (p *localPeer) TrySendDropOldest(msg wamp.Message) (wamp.Message, error) {
+	dropped, err := wamp.TrySendDropOldest(p.wr, msg)
+	if err == nil {
+		p.traffic.sent(0)
+	}
+	return dropped, err
 }
 
 func (p *localPeer) SendCtx(ctx context.Context, msg wamp.Message) error {
-	return wamp.SendCtx(ctx, p.wr, msg)
+	if err := wamp.SendCtx(ctx, p.wr, msg); err != nil {
+		return err
+	}
+	p.traffic.sent(0)
+	return nil
 }
 
 // Send writes a message to the peer's outbound message channel.
@@ -67,9 +93,18 @@ func (p *localPeer) SendCtx(ctx context.Context, msg wamp.Message) error {
 // since this will not block other clients.
 func (p *localPeer) Send(msg wamp.Message) error {
 	p.wr <- msg
+	p.traffic.sent(0)
 	return nil
 }
 
+// Stats returns the number of messages sent and received by the peer, and
+// the depth of its outbound queue.  A local peer does not count bytes.
+func (p *localPeer) Stats() PeerStats {
+	stats := p.traffic.stats(len(p.wr), cap(p.wr))
+	stats.MessagesIn = atomic.LoadUint64(&p.linked.msgsOut)
+	return stats
+}
+
 // Close closes the outgoing channel, waking any readers waiting on data from
 // this peer.
 func (p *localPeer) Close() { close(p.wr) }
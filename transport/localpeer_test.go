@@ -1,10 +1,14 @@
 package transport
 
 import (
+	"io/ioutil"
+	"log"
+	"net"
 	"runtime"
 	"testing"
 	"time"
 
+	"github.com/gammazero/nexus/v3/transport/serialize"
 	"github.com/gammazero/nexus/v3/wamp"
 )
 
@@ -36,6 +40,29 @@ func TestSendRecv(t *testing.T) {
 	}
 }
 
+func TestLocalPeerStats(t *testing.T) {
+	const qsize = 5
+	c, r := LinkedPeersQSize(qsize)
+
+	go c.Send(&wamp.Hello{})
+	<-r.Recv()
+	for i := 0; i < 3; i++ {
+		r.TrySend(&wamp.Publish{})
+	}
+	<-c.Recv()
+
+	stats := r.(StatsPeer).Stats()
+	if stats.MessagesIn != 1 || stats.MessagesOut != 3 {
+		t.Fatal("Wrong message counts:", stats.MessagesIn, stats.MessagesOut)
+	}
+	if stats.QueueDepth != 2 || stats.QueueSize != qsize {
+		t.Fatal("Wrong queue depth:", stats.QueueDepth, "size:", stats.QueueSize)
+	}
+	if stats.BytesIn != 0 || stats.BytesOut != 0 {
+		t.Fatal("Local peer should not count bytes")
+	}
+}
+
 func TestDropOnBlockedClient(t *testing.T) {
 	const qsize = 5
 	_, r := LinkedPeersQSize(qsize)
@@ -110,3 +137,88 @@ func BenchmarkRouterToClient(b *testing.B) {
 		<-c.Recv()
 	}
 }
+
+// rawSocketPeers returns a pair of rawsocket peers connected over TCP
+// loopback.
+func rawSocketPeers(b *testing.B, outQueueSize int) (*rawSocketPeer, *rawSocketPeer) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer l.Close()
+	accepted := make(chan net.Conn)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			close(accepted)
+			return
+		}
+		accepted <- conn
+	}()
+	cConn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		b.Fatal(err)
+	}
+	rConn, ok := <-accepted
+	if !ok {
+		b.Fatal("failed to accept connection")
+	}
+
+	const maxLen = 1 << 24
+	logger := log.New(ioutil.Discard, "", 0)
+	s := &serialize.JSONSerializer{}
+	c := newRawSocketPeer(cConn, s, logger, maxLen, maxLen, outQueueSize)
+	r := newRawSocketPeer(rConn, s, logger, maxLen, maxLen, outQueueSize)
+	return c, r
+}
+
+func benchEvent() *wamp.Event {
+	return &wamp.Event{
+		Subscription: 1234,
+		Publication:  5678,
+		Details:      wamp.Dict{},
+		Arguments:    wamp.List{"hello world", 42},
+		ArgumentsKw:  wamp.Dict{"temperature": 21.5, "unit": "C"},
+	}
+}
+
+// BenchmarkRawSocketClientToRouter sends messages from a client to a router
+// over a rawsocket, one at a time.
+func BenchmarkRawSocketClientToRouter(b *testing.B) {
+	c, r := rawSocketPeers(b, 64)
+	defer c.Close()
+	defer r.Close()
+	msg := benchEvent()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := c.Send(msg); err != nil {
+			b.Fatal(err)
+		}
+		<-r.Recv()
+	}
+}
+
+// BenchmarkRawSocketRouterToClient sends a burst of messages from a router
+// to a client over a rawsocket, so that messages queue up to be written.
+func BenchmarkRawSocketRouterToClient(b *testing.B) {
+	const burst = 64
+	c, r := rawSocketPeers(b, burst)
+	defer c.Close()
+	defer r.Close()
+	msg := benchEvent()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < burst; j++ {
+			if err := r.Send(msg); err != nil {
+				b.Fatal(err)
+			}
+		}
+		for j := 0; j < burst; j++ {
+			<-c.Recv()
+		}
+	}
+}
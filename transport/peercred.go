@@ -0,0 +1,12 @@
+package transport
+
+// PeerCred holds the credentials of the process at the other end of a Unix
+// socket connection, as reported by the operating system.
+type PeerCred struct {
+	// User ID of the process.
+	UID uint32
+	// Primary group ID of the process.
+	GID uint32
+	// Process ID.
+	PID int32
+}
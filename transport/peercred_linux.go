@@ -0,0 +1,32 @@
+//go:build linux
+// +build linux
+
+package transport
+
+import (
+	"net"
+	"syscall"
+)
+
+// UnixPeerCred returns the credentials of the process that connected the
+// Unix socket, read from the socket's SO_PEERCRED option.  The credentials
+// are those of the process when it connected the socket.
+func UnixPeerCred(conn *net.UnixConn) (PeerCred, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return PeerCred{}, err
+	}
+	var ucred *syscall.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		ucred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET,
+			syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return PeerCred{}, err
+	}
+	if credErr != nil {
+		return PeerCred{}, credErr
+	}
+	return PeerCred{UID: ucred.Uid, GID: ucred.Gid, PID: ucred.Pid}, nil
+}
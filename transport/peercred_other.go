@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package transport
+
+import (
+	"errors"
+	"net"
+)
+
+// UnixPeerCred returns the credentials of the process that connected the
+// Unix socket.  This is only supported on Linux.
+func UnixPeerCred(conn *net.UnixConn) (PeerCred, error) {
+	return PeerCred{}, errors.New("peer credentials not supported on this platform")
+}
@@ -19,10 +19,14 @@ import (
 // rawSocketPeer implements the Peer interface, connecting the Send and Recv
 // methods to a socket.
 type rawSocketPeer struct {
+	// Counts traffic.  This is first to ensure 64-bit alignment.
+	traffic trafficCounter
+
 	conn       net.Conn
 	serializer serialize.Serializer
 	sendLimit  int
 	recvLimit  int
+	sizeLimit
 
 	// Used to signal the socket is closed explicitly.
 	closed chan struct{}
@@ -44,10 +48,14 @@ const (
 	rawsocketJSON    = 1
 	rawsocketMsgpack = 2
 	// compatibility with crossbar.io router.
-	rawsocketCBOR = 3
+	rawsocketCBOR   = 3
+	rawsocketUBJSON = 4
 
 	// RawSocket header ID.
 	magic = 0x7f
+
+	// Maximum number of queued messages written together.
+	maxWriteBatch = 64
 )
 
 // ConnectRawSocketPeer creates a new rawSocketPeer with the specified config,
@@ -178,6 +186,10 @@ func (rs *rawSocketPeer) TrySend(msg wamp.Message) error {
 	return wamp.TrySend(rs.wr, msg)
 }
 
+func (rs *rawSocketPeer) TrySendDropOldest(msg wamp.Message) (wamp.Message, error) {
+	return wamp.TrySendDropOldest(rs.wr, msg)
+}
+
 func (rs *rawSocketPeer) SendCtx(ctx context.Context, msg wamp.Message) error {
 	return wamp.SendCtx(ctx, rs.wr, msg)
 }
@@ -188,6 +200,23 @@ func (rs *rawSocketPeer) Send(msg wamp.Message) error {
 
 func (rs *rawSocketPeer) IsLocal() bool { return false }
 
+// Serialization returns the serialization format used by the peer.
+func (rs *rawSocketPeer) Serialization() serialize.Serialization {
+	return serialize.SerializationOf(rs.serializer)
+}
+
+// AcceptsSharedMessage returns true if the peer uses one of the serializers
+// in the serialize package, which serialize a serialize.SharedMessage.
+func (rs *rawSocketPeer) AcceptsSharedMessage() bool {
+	return rs.Serialization() != serialize.AUTO
+}
+
+// Stats returns the number of messages and bytes sent and received by the
+// peer, and the depth of its outbound queue.
+func (rs *rawSocketPeer) Stats() PeerStats {
+	return rs.traffic.stats(len(rs.wr), cap(rs.wr))
+}
+
 // Close closes the rawsocket peer.  This closes the local send channel, and
 // sends a close control message to the socket to tell the other side to
 // close.
@@ -209,45 +238,84 @@ func (rs *rawSocketPeer) Close() {
 
 // sendHandler pulls messages from the write channel, and pushes them to the
 // socket.
+//
+// When messages are queued faster than they are written, up to
+// maxWriteBatch queued messages are written together, using a single writev
+// system call where the connection supports it.
 func (rs *rawSocketPeer) sendHandler() {
 	defer close(rs.writerDone)
 	defer rs.cancelSender()
 
+	// Headers, and header and payload of each message, for a batch of
+	// messages.
+	headers := make([]byte, 4*maxWriteBatch)
+	bufs := make(net.Buffers, 0, 2*maxWriteBatch)
+	sizes := make([]int, 0, maxWriteBatch)
+
 	senderDone := rs.ctxSender.Done()
-sendLoop:
 	for {
+		var msg wamp.Message
 		select {
-		case msg := <-rs.wr:
-			b, err := rs.serializer.Serialize(msg)
-			if err != nil {
-				rs.log.Print(err)
-				continue sendLoop
+		case msg = <-rs.wr:
+		case <-senderDone:
+			return
+		}
+
+		bufs, sizes = bufs[:0], sizes[:0]
+		var goodbyeAck bool
+	batchLoop:
+		for {
+			if b := rs.frame(msg); b != nil {
+				header := headers[4*len(sizes) : 4*len(sizes)+4]
+				lenBytes := intToBytes(len(b))
+				header[0], header[1], header[2], header[3] = 0x0, lenBytes[0], lenBytes[1], lenBytes[2]
+				bufs = append(bufs, header, b)
+				sizes = append(sizes, len(b))
+				goodbyeAck = goodbyeAck || wamp.IsGoodbyeAck(msg)
 			}
-			if len(b) > rs.sendLimit {
-				rs.log.Println("Message size", len(b), "exceeds limit of",
-					rs.sendLimit)
-				continue sendLoop
+			if len(sizes) == maxWriteBatch {
+				break
 			}
-			lenBytes := intToBytes(len(b))
-			header := []byte{0x0, lenBytes[0], lenBytes[1], lenBytes[2]}
-			if _, err = rs.conn.Write(header); err != nil {
-				if !wamp.IsGoodbyeAck(msg) {
-					rs.log.Println("Error writing header:", err)
-				}
-				continue sendLoop
+			select {
+			case msg = <-rs.wr:
+			default:
+				break batchLoop
 			}
-			if _, err = rs.conn.Write(b); err != nil {
-				if !wamp.IsGoodbyeAck(msg) {
-					rs.log.Println("Error writing message:", msg, err)
-				}
-				continue sendLoop
+		}
+		if len(bufs) == 0 {
+			continue
+		}
+
+		// WriteTo consumes bufs, so write from a copy of the slice header.
+		out := bufs
+		if _, err := out.WriteTo(rs.conn); err != nil {
+			if !goodbyeAck {
+				rs.log.Println("Error writing messages:", err)
 			}
-		case <-senderDone:
-			return
+			continue
+		}
+		for _, n := range sizes {
+			rs.traffic.sent(n)
 		}
 	}
 }
 
+// frame serializes a message to be sent.  Returns nil if the message cannot
+// be sent.
+func (rs *rawSocketPeer) frame(msg wamp.Message) []byte {
+	b, err := rs.serializer.Serialize(msg)
+	if err != nil {
+		rs.log.Print(err)
+		return nil
+	}
+	if len(b) > rs.sendLimit {
+		rs.log.Println("Message size", len(b), "exceeds limit of",
+			rs.sendLimit)
+		return nil
+	}
+	return b
+}
+
 // recvHandler pulls messages from the socket and pushes them to the read
 // channel.
 func (rs *rawSocketPeer) recvHandler() {
@@ -286,19 +354,26 @@ MsgLoop:
 		var msg wamp.Message
 		switch header[0] & 0x07 {
 		case 0: // WAMP message
-			buf := make([]byte, length)
-			_, err = io.ReadFull(rs.conn, buf)
+			// Read the message into the unused capacity of a pooled buffer.
+			buf := getRecvBuffer()
+			buf.Grow(length)
+			b := buf.Bytes()[:length]
+			_, err = io.ReadFull(rs.conn, b)
 			if err != nil {
+				putRecvBuffer(buf)
 				rs.log.Println("Error reading message:", err)
 				rs.conn.Close()
 				return
 			}
-			msg, err = rs.serializer.Deserialize(buf)
+			msg, err = rs.serializer.Deserialize(b)
+			putRecvBuffer(buf)
 			if err != nil {
 				// TODO: something more than merely logging?
 				rs.log.Println("Cannot deserialize peer message:", err)
 				continue MsgLoop
 			}
+			rs.traffic.received(length)
+			rs.check(msg, length)
 		case 1: // PING
 			header[0] = 0x02
 			if _, err = rs.conn.Write(header[:]); err != nil {
@@ -393,6 +468,8 @@ func clientHandshake(conn net.Conn, logger stdlog.StdLog, protocol byte, recvLim
 		serializer = &serialize.MessagePackSerializer{}
 	case rawsocketCBOR:
 		serializer = &serialize.CBORSerializer{}
+	case rawsocketUBJSON:
+		serializer = &serialize.UBJSONSerializer{}
 	}
 
 	sendLimit := byteToLength(buf[1] >> 4)
@@ -426,6 +503,8 @@ func serverHandshake(conn net.Conn, logger stdlog.StdLog, recvLimit, outQueueSiz
 		serializer = &serialize.MessagePackSerializer{}
 	case rawsocketCBOR:
 		serializer = &serialize.CBORSerializer{}
+	case rawsocketUBJSON:
+		serializer = &serialize.UBJSONSerializer{}
 	default:
 		conn.Write([]byte{magic, byte(0x1 << 4), 0, 0})
 		return nil, errors.New("serializer unsupported")
@@ -500,6 +579,8 @@ func getProtoByte(serialization serialize.Serialization) (byte, error) {
 		return rawsocketMsgpack, nil
 	case serialize.CBOR:
 		return rawsocketCBOR, nil
+	case serialize.UBJSON:
+		return rawsocketUBJSON, nil
 	default:
 		return 0, errors.New("serialization not supported by rawsocket")
 	}
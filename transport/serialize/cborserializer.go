@@ -21,6 +21,9 @@ type CBORSerializer struct{}
 
 // Serialize encodes a Message into a cbor payload.
 func (s *CBORSerializer) Serialize(msg wamp.Message) ([]byte, error) {
+	if sm, ok := msg.(*SharedMessage); ok {
+		return sm.serialize(s)
+	}
 	var b []byte
 	return b, codec.NewEncoderBytes(&b, ch).Encode(msgToList(msg))
 }
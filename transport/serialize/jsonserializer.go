@@ -22,6 +22,9 @@ type JSONSerializer struct{}
 
 // Serialize encodes a Message into a json payload.
 func (s *JSONSerializer) Serialize(msg wamp.Message) ([]byte, error) {
+	if sm, ok := msg.(*SharedMessage); ok {
+		return sm.serialize(s)
+	}
 	var b []byte
 	return b, codec.NewEncoderBytes(&b, jh).Encode(msgToList(msg))
 }
@@ -18,7 +18,12 @@ func init() {
 
 // MsgpackRegisterExtension registers a custom type for special serialization.
 func MsgpackRegisterExtension(t reflect.Type, ext byte, encode func(reflect.Value) ([]byte, error), decode func(reflect.Value, []byte) error) {
-	mh.AddExt(t, ext, encode, decode)
+	// Peers reuse the buffers that messages are received into, so give the
+	// decode function its own copy of the data.
+	copyDecode := func(rv reflect.Value, data []byte) error {
+		return decode(rv, append([]byte(nil), data...))
+	}
+	mh.AddExt(t, ext, encode, copyDecode)
 }
 
 // MessagePackSerializer is an implementation of Serializer that handles
@@ -27,6 +32,9 @@ type MessagePackSerializer struct{}
 
 // Serialize encodes a Message into a msgpack payload.
 func (s *MessagePackSerializer) Serialize(msg wamp.Message) ([]byte, error) {
+	if sm, ok := msg.(*SharedMessage); ok {
+		return sm.serialize(s)
+	}
 	var b []byte
 	return b, codec.NewEncoderBytes(&b, mh).Encode(msgToList(msg))
 }
@@ -22,11 +22,46 @@ const (
 	MSGPACK
 	// Use CBOR encoding as a payload
 	CBOR
+	// Use UBJSON encoding as a payload
+	UBJSON
 )
 
 // Serialization indicates the data serialization format used in a WAMP session
 type Serialization int
 
+// String returns the name of the serialization format.
+func (s Serialization) String() string {
+	switch s {
+	case AUTO:
+		return "auto"
+	case JSON:
+		return "json"
+	case MSGPACK:
+		return "msgpack"
+	case CBOR:
+		return "cbor"
+	case UBJSON:
+		return "ubjson"
+	}
+	return fmt.Sprintf("Serialization(%d)", int(s))
+}
+
+// SerializationOf returns the serialization format of one of the serializers
+// in this package.  AUTO is returned for any other serializer.
+func SerializationOf(s Serializer) Serialization {
+	switch s.(type) {
+	case *JSONSerializer:
+		return JSON
+	case *MessagePackSerializer:
+		return MSGPACK
+	case *CBORSerializer:
+		return CBOR
+	case *UBJSONSerializer:
+		return UBJSON
+	}
+	return AUTO
+}
+
 // Serializer is the interface implemented by an object that can serialize and
 // deserialize WAMP messages
 type Serializer interface {
@@ -5,6 +5,7 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"math"
 	"reflect"
 	"testing"
 
@@ -153,6 +154,163 @@ func CBORDeserialize(t *testing.T) {
 	}
 }
 
+func TestUBJSONSerialize(t *testing.T) {
+	details := detailRolesFeatures()
+	hello := &wamp.Hello{Realm: "nexus.realm", Details: details}
+
+	s := &UBJSONSerializer{}
+	b, err := s.Serialize(hello)
+	if err != nil {
+		t.Fatal("Serialization error: ", err)
+	}
+	if len(b) == 0 {
+		t.Fatal("no serialized data")
+	}
+
+	msg, err := s.Deserialize(b)
+	if err != nil {
+		t.Fatal("desrialization error: ", err)
+	}
+	if msg.MessageType() != wamp.HELLO {
+		t.Fatal("desrialization to wrong message type: ", msg.MessageType())
+	}
+	hello2 := msg.(*wamp.Hello)
+	if hello2.Realm != hello.Realm {
+		t.Fatal("wrong realm:", hello2.Realm)
+	}
+	if !hasFeature(hello2.Details, "publisher", "subscriber_blackwhite_listing") {
+		t.Fatal("did not deserialize message details")
+	}
+	val, ok := hello2.Details["nothere"]
+	if !ok {
+		t.Fatal("nil value item 'nothere' is missing")
+	}
+	if val != nil {
+		t.Fatal("expected nil value item 'nothere'")
+	}
+}
+
+func TestUBJSONValues(t *testing.T) {
+	type point struct {
+		X      int    `json:"x"`
+		Y      int    `json:"y,omitempty"`
+		Label  string `json:"-"`
+		Weight float64
+	}
+	args := wamp.List{
+		nil, true, false,
+		0, 200, -100, 1000, -70000, int64(1) << 40, uint64(math.MaxUint64),
+		float32(1.5), 3.25,
+		"hello", "",
+		[]byte("hellowamp"), BinaryData("more binary"),
+		wamp.List{1, "two", wamp.List{3}},
+		wamp.Dict{"nested": wamp.Dict{"key": "value"}},
+		point{X: 1, Label: "skipped", Weight: 2.5},
+	}
+	event := &wamp.Event{
+		Subscription: 1234,
+		Publication:  1 << 50,
+		Details:      wamp.Dict{},
+		Arguments:    args,
+	}
+
+	s := &UBJSONSerializer{}
+	b, err := s.Serialize(event)
+	if err != nil {
+		t.Fatal("Serialization error: ", err)
+	}
+	msg, err := s.Deserialize(b)
+	if err != nil {
+		t.Fatal("desrialization error: ", err)
+	}
+	event2, ok := msg.(*wamp.Event)
+	if !ok {
+		t.Fatal("desrialization to wrong message type: ", msg.MessageType())
+	}
+	if event2.Subscription != event.Subscription || event2.Publication != event.Publication {
+		t.Fatal("wrong IDs:", event2.Subscription, event2.Publication)
+	}
+
+	expect := wamp.List{
+		nil, true, false,
+		uint64(0), uint64(200), int64(-100), int64(1000), int64(-70000), int64(1) << 40, "18446744073709551615",
+		1.5, 3.25,
+		"hello", "",
+		[]byte("hellowamp"), []byte("more binary"),
+		[]interface{}{uint64(1), "two", []interface{}{uint64(3)}},
+		map[string]interface{}{"nested": map[string]interface{}{"key": "value"}},
+		map[string]interface{}{"x": uint64(1), "Weight": 2.5},
+	}
+	if len(event2.Arguments) != len(expect) {
+		t.Fatal("wrong number of arguments:", len(event2.Arguments))
+	}
+	for i := range expect {
+		if !reflect.DeepEqual(event2.Arguments[i], expect[i]) {
+			t.Errorf("argument %d: got %#v, expected %#v", i, event2.Arguments[i], expect[i])
+		}
+	}
+}
+
+func TestUBJSONDeserialize(t *testing.T) {
+	s := &UBJSONSerializer{}
+
+	// [1,"nexus.realm",{"roles":{"caller":{}}}] encoded with a mix of
+	// optimized and unoptimized containers, integer types, and no-ops.
+	data := []byte{
+		'[',
+		'i', 1,
+		'N',
+		'S', 'U', 11, 'n', 'e', 'x', 'u', 's', '.', 'r', 'e', 'a', 'l', 'm',
+		'{', '#', 'U', 1,
+		'U', 5, 'r', 'o', 'l', 'e', 's',
+		'{', 'N', 'U', 6, 'c', 'a', 'l', 'l', 'e', 'r', '{', '}', '}',
+		']',
+	}
+	expect := &wamp.Hello{
+		Realm: "nexus.realm",
+		Details: wamp.Dict{
+			"roles": map[string]interface{}{"caller": map[string]interface{}{}},
+		},
+	}
+	msg, err := s.Deserialize(data)
+	if err != nil {
+		t.Fatalf("Error decoding good data: %s, %q", err, data)
+	}
+	if !reflect.DeepEqual(msg, expect) {
+		t.Fatalf("got %+v, expected %+v", msg, expect)
+	}
+
+	// Strongly typed array of int16.
+	v, err := (&ubjDecoder{b: []byte{'[', '$', 'I', '#', 'U', 2, 0x01, 0x00, 0xff, 0xff}}).decode(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(v, []interface{}{int64(256), int64(-1)}) {
+		t.Fatalf("got %#v for typed array", v)
+	}
+}
+
+func TestUBJSONDeserializeFail(t *testing.T) {
+	s := &UBJSONSerializer{}
+	for _, data := range [][]byte{
+		nil,
+		{'[', ']'},                                   // empty array
+		{'[', 'S', 'U', 1, 'a', ']'},                 // array containing string
+		{'[', 'U', 1},                                // unterminated array
+		{'[', 'S', 'U', 10, 'a', ']'},                // string too short
+		{'[', '#', 'l', 0x7f, 0xff, 0xff, 0xff, 'U'}, // count larger than data
+		{'[', '$', 'Z', '#', 'L', 0, 0, 0, 1, 0, 0, 0, 0},
+		{'[', 'U', 1, ']', 'U'}, // extra data
+		{'[', 'x', ']'},         // invalid marker
+		{'[', '$', 'U', 'U', 1}, // type without count
+		bytes.Repeat([]byte{'['}, ubjMaxDepth+1),
+	} {
+		if msg, err := s.Deserialize(data); err == nil {
+			t.Fatalf("Expected error for %q, got result: %v", data, msg)
+		}
+	}
+}
+
 func TestMessagePackSerialize(t *testing.T) {
 	hello := &wamp.Hello{Realm: "nexus.realm", Details: detailRolesFeatures()}
 
@@ -275,6 +433,11 @@ func TestMsgpackExtensions(t *testing.T) {
 	if !ok {
 		t.Fatal("m1.Details[extra] missing")
 	}
+	// The decoded extension must not reference the serialized data, which
+	// peers reuse.
+	for i := range bin {
+		bin[i] = 0
+	}
 	vs1 := string(v1.(BinaryData))
 	vs2, _ := wamp.AsString(v2)
 	if vs1 != vs2 {
@@ -542,3 +705,107 @@ func benchmarkSerialize(b *testing.B, s Serializer, msg wamp.Message) {
 		}
 	}
 }
+
+func TestSharedMessage(t *testing.T) {
+	event := &wamp.Event{
+		Subscription: 1234,
+		Publication:  5678,
+		Details:      wamp.Dict{},
+		Arguments:    wamp.List{"hello world", 42},
+	}
+	shared := NewSharedMessage(event)
+	if shared.MessageType() != wamp.EVENT {
+		t.Fatal("Wrong message type:", shared.MessageType())
+	}
+	if Unshare(shared) != event || Unshare(event) != event {
+		t.Fatal("Unshare did not return wrapped message")
+	}
+
+	for _, s := range []Serializer{&JSONSerializer{}, &MessagePackSerializer{}, &CBORSerializer{}, &UBJSONSerializer{}} {
+		expect, err := s.Serialize(event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := s.Serialize(shared)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, expect) {
+			t.Fatalf("%T: shared message serialized differently", s)
+		}
+		// Another serializer of the same kind shares the serialized frame.
+		other := reflect.New(reflect.TypeOf(s).Elem()).Interface().(Serializer)
+		b2, err := other.Serialize(shared)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if &b2[0] != &b[0] {
+			t.Fatalf("%T: shared message serialized more than once", s)
+		}
+	}
+}
+
+// BenchmarkSerializeFanout serializes the same event for many subscribers,
+// with and without sharing the serialized frame.
+func BenchmarkSerializeFanout(b *testing.B) {
+	const subscribers = 1000
+	event := &wamp.Event{
+		Subscription: 1234,
+		Publication:  5678,
+		Details:      wamp.Dict{},
+		Arguments:    wamp.List{"hello world", 42},
+		ArgumentsKw:  wamp.Dict{"temperature": 21.5, "unit": "C"},
+	}
+	s := &JSONSerializer{}
+
+	b.Run("Unshared", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for j := 0; j < subscribers; j++ {
+				if _, err := s.Serialize(event); err != nil {
+					panic("serialization error: " + err.Error())
+				}
+			}
+		}
+	})
+
+	b.Run("Shared", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			shared := NewSharedMessage(event)
+			for j := 0; j < subscribers; j++ {
+				if _, err := s.Serialize(shared); err != nil {
+					panic("serialization error: " + err.Error())
+				}
+			}
+		}
+	})
+}
+
+// TestDeserializeCopiesData checks that deserialized messages do not
+// reference the data they are decoded from, which allows peers to reuse
+// their receive buffers.
+func TestDeserializeCopiesData(t *testing.T) {
+	event := &wamp.Event{
+		Subscription: 1234,
+		Publication:  5678,
+		Details:      wamp.Dict{"topic": "some.topic"},
+		Arguments:    wamp.List{"hello world", []byte{1, 2, 3, 4}},
+		ArgumentsKw:  wamp.Dict{"key": "value"},
+	}
+	for _, s := range []Serializer{&JSONSerializer{}, &MessagePackSerializer{}, &CBORSerializer{}, &UBJSONSerializer{}} {
+		b, err := s.Serialize(event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg, err := s.Deserialize(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		before := fmt.Sprintf("%#v", msg)
+		for i := range b {
+			b[i] = 0xff
+		}
+		if after := fmt.Sprintf("%#v", msg); after != before {
+			t.Fatalf("%T: message changed when data overwritten: %s", s, after)
+		}
+	}
+}
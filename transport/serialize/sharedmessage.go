@@ -0,0 +1,79 @@
+package serialize
+
+import (
+	"reflect"
+	"sync"
+
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// SharedMessage is a message that is sent, unchanged, to many peers.  The
+// message is serialized at most once by each kind of Serializer, and the
+// serialized frame is shared by all peers using that kind of Serializer.
+//
+// A SharedMessage must only be sent to peers that accept it, as reported by
+// AcceptsSharedMessage, and the wrapped message must not be modified after it
+// is sent.
+type SharedMessage struct {
+	wamp.Message
+
+	mu     sync.Mutex
+	frames map[reflect.Type]*sharedFrame
+}
+
+// sharedFrame is the message serialized by one kind of Serializer.
+type sharedFrame struct {
+	once sync.Once
+	b    []byte
+	err  error
+}
+
+// NewSharedMessage wraps the message so that it can be sent to many peers.
+func NewSharedMessage(msg wamp.Message) *SharedMessage {
+	return &SharedMessage{Message: msg}
+}
+
+// serialize returns the message serialized by s, serializing the message if
+// it has not already been serialized by the same kind of Serializer.  The
+// returned frame must not be modified.
+func (m *SharedMessage) serialize(s Serializer) ([]byte, error) {
+	key := reflect.TypeOf(s)
+	m.mu.Lock()
+	if m.frames == nil {
+		m.frames = map[reflect.Type]*sharedFrame{}
+	}
+	f, ok := m.frames[key]
+	if !ok {
+		f = &sharedFrame{}
+		m.frames[key] = f
+	}
+	m.mu.Unlock()
+
+	f.once.Do(func() {
+		f.b, f.err = s.Serialize(m.Message)
+	})
+	return f.b, f.err
+}
+
+// SharedMessageAcceptor is implemented by peers that can be sent a
+// SharedMessage.  These are peers that serialize messages using one of the
+// serializers in this package, which serialize the wrapped message.
+type SharedMessageAcceptor interface {
+	AcceptsSharedMessage() bool
+}
+
+// AcceptsSharedMessage returns true if the peer can be sent a SharedMessage.
+// Other peers must be sent the wrapped message.
+func AcceptsSharedMessage(peer wamp.Peer) bool {
+	a, ok := peer.(SharedMessageAcceptor)
+	return ok && a.AcceptsSharedMessage()
+}
+
+// Unshare returns the message wrapped by a SharedMessage, or returns msg if
+// it is not a SharedMessage.
+func Unshare(msg wamp.Message) wamp.Message {
+	if sm, ok := msg.(*SharedMessage); ok {
+		return sm.Message
+	}
+	return msg
+}
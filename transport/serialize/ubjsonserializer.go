@@ -0,0 +1,510 @@
+package serialize
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// UBJSON type markers.  See http://ubjson.org/type-reference/
+const (
+	ubjNull    = 'Z'
+	ubjNoOp    = 'N'
+	ubjTrue    = 'T'
+	ubjFalse   = 'F'
+	ubjInt8    = 'i'
+	ubjUint8   = 'U'
+	ubjInt16   = 'I'
+	ubjInt32   = 'l'
+	ubjInt64   = 'L'
+	ubjFloat32 = 'd'
+	ubjFloat64 = 'D'
+	ubjHighNum = 'H'
+	ubjChar    = 'C'
+	ubjString  = 'S'
+	ubjArray   = '['
+	ubjArrEnd  = ']'
+	ubjObject  = '{'
+	ubjObjEnd  = '}'
+	ubjType    = '$'
+	ubjCount   = '#'
+
+	// Maximum nesting of containers accepted when decoding.
+	ubjMaxDepth = 1000
+)
+
+// UBJSONSerializer is an implementation of Serializer that handles
+// serializing and deserializing UBJSON (Universal Binary JSON) encoded
+// payloads.
+//
+// Binary data, a []byte or BinaryData, is encoded as a strongly typed array
+// of uint8, and decoded as []byte.
+type UBJSONSerializer struct{}
+
+// Serialize encodes a Message into a UBJSON payload.
+func (s *UBJSONSerializer) Serialize(msg wamp.Message) ([]byte, error) {
+	if sm, ok := msg.(*SharedMessage); ok {
+		return sm.serialize(s)
+	}
+	var e ubjEncoder
+	if err := e.encode(reflect.ValueOf(msgToList(msg))); err != nil {
+		return nil, err
+	}
+	return e.b, nil
+}
+
+// Deserialize decodes a UBJSON payload into a Message.
+func (s *UBJSONSerializer) Deserialize(data []byte) (wamp.Message, error) {
+	d := ubjDecoder{b: data}
+	v, err := d.decode(0)
+	if err != nil {
+		return nil, err
+	}
+	if d.pos != len(d.b) {
+		return nil, errors.New("ubjson: extra data after message")
+	}
+	list, ok := v.([]interface{})
+	if !ok || len(list) == 0 {
+		return nil, errors.New("invalid message")
+	}
+	typ, ok := wamp.AsInt64(list[0])
+	if !ok {
+		return nil, errors.New("unsupported message format")
+	}
+	return listToMsg(wamp.MessageType(typ), list)
+}
+
+// ubjEncoder encodes values as UBJSON.
+type ubjEncoder struct {
+	b []byte
+}
+
+func (e *ubjEncoder) encode(v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Invalid, reflect.Func, reflect.Chan:
+		// Values that have no data representation are encoded as null, as
+		// the other serializers do.
+		e.b = append(e.b, ubjNull)
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			e.b = append(e.b, ubjNull)
+			return nil
+		}
+		return e.encode(v.Elem())
+	case reflect.Bool:
+		if v.Bool() {
+			e.b = append(e.b, ubjTrue)
+		} else {
+			e.b = append(e.b, ubjFalse)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.encodeInt(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u := v.Uint()
+		if u > math.MaxInt64 {
+			// Too large for int64, so encode as a high-precision number.
+			e.b = append(e.b, ubjHighNum)
+			e.encodeString(strconv.FormatUint(u, 10))
+			return nil
+		}
+		e.encodeInt(int64(u))
+	case reflect.Float32:
+		e.b = append(e.b, ubjFloat32)
+		e.b = appendUint32(e.b, math.Float32bits(float32(v.Float())))
+	case reflect.Float64:
+		e.b = append(e.b, ubjFloat64)
+		e.b = appendUint64(e.b, math.Float64bits(v.Float()))
+	case reflect.String:
+		e.b = append(e.b, ubjString)
+		e.encodeString(v.String())
+	case reflect.Slice:
+		if v.IsNil() {
+			e.b = append(e.b, ubjNull)
+			return nil
+		}
+		return e.encodeArray(v)
+	case reflect.Array:
+		return e.encodeArray(v)
+	case reflect.Map:
+		if v.IsNil() {
+			e.b = append(e.b, ubjNull)
+			return nil
+		}
+		if v.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("ubjson: unsupported map key type %s", v.Type().Key())
+		}
+		e.b = append(e.b, ubjObject, ubjCount)
+		e.encodeInt(int64(v.Len()))
+		iter := v.MapRange()
+		for iter.Next() {
+			e.encodeString(iter.Key().String())
+			if err := e.encode(iter.Value()); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		return e.encodeStruct(v)
+	default:
+		return fmt.Errorf("ubjson: unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+// encodeInt encodes an integer using the smallest integer type that holds it.
+func (e *ubjEncoder) encodeInt(n int64) {
+	switch {
+	case n >= 0 && n <= math.MaxUint8:
+		e.b = append(e.b, ubjUint8, byte(n))
+	case n >= math.MinInt8 && n <= math.MaxInt8:
+		e.b = append(e.b, ubjInt8, byte(n))
+	case n >= math.MinInt16 && n <= math.MaxInt16:
+		e.b = append(e.b, ubjInt16, byte(n>>8), byte(n))
+	case n >= math.MinInt32 && n <= math.MaxInt32:
+		e.b = append(e.b, ubjInt32)
+		e.b = appendUint32(e.b, uint32(n))
+	default:
+		e.b = append(e.b, ubjInt64)
+		e.b = appendUint64(e.b, uint64(n))
+	}
+}
+
+// encodeString encodes the length and bytes of a string, without a marker.
+func (e *ubjEncoder) encodeString(s string) {
+	e.encodeInt(int64(len(s)))
+	e.b = append(e.b, s...)
+}
+
+// encodeArray encodes a slice or array.  Binary data is encoded as a strongly
+// typed array of uint8.
+func (e *ubjEncoder) encodeArray(v reflect.Value) error {
+	n := v.Len()
+	if v.Type().Elem().Kind() == reflect.Uint8 {
+		e.b = append(e.b, ubjArray, ubjType, ubjUint8, ubjCount)
+		e.encodeInt(int64(n))
+		if v.Kind() == reflect.Slice {
+			e.b = append(e.b, v.Bytes()...)
+		} else {
+			for i := 0; i < n; i++ {
+				e.b = append(e.b, byte(v.Index(i).Uint()))
+			}
+		}
+		return nil
+	}
+	e.b = append(e.b, ubjArray, ubjCount)
+	e.encodeInt(int64(n))
+	for i := 0; i < n; i++ {
+		if err := e.encode(v.Index(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeStruct encodes the exported fields of a struct as an object.  Field
+// names are taken from json tags, if present, and fields tagged "-" are
+// skipped, as with encoding/json.
+func (e *ubjEncoder) encodeStruct(v reflect.Value) error {
+	t := v.Type()
+	e.b = append(e.b, ubjObject)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := f.Name
+		if tag, ok := f.Tag.Lookup("json"); ok {
+			if tag == "-" {
+				continue
+			}
+			opts := strings.Split(tag, ",")
+			if opts[0] != "" {
+				name = opts[0]
+			}
+			if len(opts) > 1 && opts[1] == "omitempty" && v.Field(i).IsZero() {
+				continue
+			}
+		}
+		e.encodeString(name)
+		if err := e.encode(v.Field(i)); err != nil {
+			return err
+		}
+	}
+	e.b = append(e.b, ubjObjEnd)
+	return nil
+}
+
+func appendUint32(b []byte, u uint32) []byte {
+	return append(b, byte(u>>24), byte(u>>16), byte(u>>8), byte(u))
+}
+
+func appendUint64(b []byte, u uint64) []byte {
+	return append(b, byte(u>>56), byte(u>>48), byte(u>>40), byte(u>>32),
+		byte(u>>24), byte(u>>16), byte(u>>8), byte(u))
+}
+
+// ubjDecoder decodes UBJSON values.  Integers are decoded as int64, except
+// uint8 which is decoded as uint64, floats as float64, high-precision numbers
+// as strings, arrays as []interface{}, and objects as map[string]interface{}.
+// A strongly typed array of uint8 is decoded as []byte.
+type ubjDecoder struct {
+	b   []byte
+	pos int
+}
+
+var errUBJSONShort = errors.New("ubjson: unexpected end of data")
+
+// decode decodes the next value.
+func (d *ubjDecoder) decode(depth int) (interface{}, error) {
+	marker, err := d.marker()
+	if err != nil {
+		return nil, err
+	}
+	return d.decodeType(marker, depth)
+}
+
+// marker reads the next type marker, skipping no-op markers.
+func (d *ubjDecoder) marker() (byte, error) {
+	for d.pos < len(d.b) {
+		m := d.b[d.pos]
+		d.pos++
+		if m != ubjNoOp {
+			return m, nil
+		}
+	}
+	return 0, errUBJSONShort
+}
+
+// decodeType decodes a value of the type given by the marker.
+func (d *ubjDecoder) decodeType(marker byte, depth int) (interface{}, error) {
+	switch marker {
+	case ubjNull:
+		return nil, nil
+	case ubjTrue:
+		return true, nil
+	case ubjFalse:
+		return false, nil
+	case ubjInt8, ubjUint8, ubjInt16, ubjInt32, ubjInt64:
+		n, err := d.int(marker)
+		if err != nil {
+			return nil, err
+		}
+		if marker == ubjUint8 {
+			return uint64(n), nil
+		}
+		return n, nil
+	case ubjFloat32:
+		b, err := d.read(4)
+		if err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), nil
+	case ubjFloat64:
+		b, err := d.read(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	case ubjHighNum, ubjString:
+		return d.string()
+	case ubjChar:
+		b, err := d.read(1)
+		if err != nil {
+			return nil, err
+		}
+		return string(b), nil
+	case ubjArray:
+		if depth >= ubjMaxDepth {
+			return nil, errors.New("ubjson: maximum depth exceeded")
+		}
+		return d.array(depth + 1)
+	case ubjObject:
+		if depth >= ubjMaxDepth {
+			return nil, errors.New("ubjson: maximum depth exceeded")
+		}
+		return d.object(depth + 1)
+	}
+	return nil, fmt.Errorf("ubjson: invalid type marker %q", marker)
+}
+
+// read returns the next n bytes.
+func (d *ubjDecoder) read(n int) ([]byte, error) {
+	if n < 0 || len(d.b)-d.pos < n {
+		return nil, errUBJSONShort
+	}
+	b := d.b[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+// int decodes an integer of the type given by the marker.
+func (d *ubjDecoder) int(marker byte) (int64, error) {
+	switch marker {
+	case ubjInt8, ubjUint8:
+		b, err := d.read(1)
+		if err != nil {
+			return 0, err
+		}
+		if marker == ubjInt8 {
+			return int64(int8(b[0])), nil
+		}
+		return int64(b[0]), nil
+	case ubjInt16:
+		b, err := d.read(2)
+		if err != nil {
+			return 0, err
+		}
+		return int64(int16(binary.BigEndian.Uint16(b))), nil
+	case ubjInt32:
+		b, err := d.read(4)
+		if err != nil {
+			return 0, err
+		}
+		return int64(int32(binary.BigEndian.Uint32(b))), nil
+	case ubjInt64:
+		b, err := d.read(8)
+		if err != nil {
+			return 0, err
+		}
+		return int64(binary.BigEndian.Uint64(b)), nil
+	}
+	return 0, fmt.Errorf("ubjson: invalid integer type marker %q", marker)
+}
+
+// length decodes a string length or container count.  The length cannot be
+// more than the size of the data, which limits what is allocated for it.
+func (d *ubjDecoder) length() (int, error) {
+	marker, err := d.marker()
+	if err != nil {
+		return 0, err
+	}
+	n, err := d.int(marker)
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 || n > int64(len(d.b)) {
+		return 0, fmt.Errorf("ubjson: invalid length %d", n)
+	}
+	return int(n), nil
+}
+
+// string decodes the length and bytes of a string.
+func (d *ubjDecoder) string() (string, error) {
+	n, err := d.length()
+	if err != nil {
+		return "", err
+	}
+	b, err := d.read(n)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// containerHeader decodes the optional type and count of a container.  The
+// type is 0 if not given, and the count is -1 if not given.
+func (d *ubjDecoder) containerHeader() (byte, int, error) {
+	var typ byte
+	if d.pos < len(d.b) && d.b[d.pos] == ubjType {
+		d.pos++
+		b, err := d.read(1)
+		if err != nil {
+			return 0, 0, err
+		}
+		typ = b[0]
+		if d.pos >= len(d.b) || d.b[d.pos] != ubjCount {
+			return 0, 0, errors.New("ubjson: container type without count")
+		}
+	}
+	if d.pos < len(d.b) && d.b[d.pos] == ubjCount {
+		d.pos++
+		n, err := d.length()
+		if err != nil {
+			return 0, 0, err
+		}
+		return typ, n, nil
+	}
+	return typ, -1, nil
+}
+
+// element decodes a container element, which has the type given in the
+// container header, if any.
+func (d *ubjDecoder) element(typ byte, depth int) (interface{}, error) {
+	if typ != 0 {
+		return d.decodeType(typ, depth)
+	}
+	return d.decode(depth)
+}
+
+// array decodes an array.
+func (d *ubjDecoder) array(depth int) (interface{}, error) {
+	typ, n, err := d.containerHeader()
+	if err != nil {
+		return nil, err
+	}
+	if typ == ubjUint8 {
+		b, err := d.read(n)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte(nil), b...), nil
+	}
+	if n >= 0 {
+		list := make([]interface{}, n)
+		for i := range list {
+			if list[i], err = d.element(typ, depth); err != nil {
+				return nil, err
+			}
+		}
+		return list, nil
+	}
+	list := []interface{}{}
+	for {
+		marker, err := d.marker()
+		if err != nil {
+			return nil, err
+		}
+		if marker == ubjArrEnd {
+			return list, nil
+		}
+		v, err := d.decodeType(marker, depth)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+	}
+}
+
+// object decodes an object.
+func (d *ubjDecoder) object(depth int) (interface{}, error) {
+	typ, n, err := d.containerHeader()
+	if err != nil {
+		return nil, err
+	}
+	obj := map[string]interface{}{}
+	for i := 0; n < 0 || i < n; i++ {
+		if n < 0 {
+			// Skip no-ops before the key or end of the object.
+			for d.pos < len(d.b) && d.b[d.pos] == ubjNoOp {
+				d.pos++
+			}
+			if d.pos < len(d.b) && d.b[d.pos] == ubjObjEnd {
+				d.pos++
+				break
+			}
+		}
+		key, err := d.string()
+		if err != nil {
+			return nil, err
+		}
+		if obj[key], err = d.element(typ, depth); err != nil {
+			return nil, err
+		}
+	}
+	return obj, nil
+}
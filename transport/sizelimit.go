@@ -0,0 +1,66 @@
+package transport
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// SizeLimitPeer is implemented by peers that can report the received CALL,
+// YIELD, PUBLISH, and ERROR messages whose serialized size exceeds a limit.
+// The messages are still delivered, so that the router can reject them with
+// an error reply.
+type SizeLimitPeer interface {
+	// SetSizeLimit sets the size limit, in bytes.  0 means no limit.
+	SetSizeLimit(n int)
+	// OverSizeLimit returns the serialized size of a received message, and
+	// true, if the message exceeded the size limit.  A message is only
+	// reported once.
+	OverSizeLimit(msg wamp.Message) (int, bool)
+}
+
+// sizeLimit records the sizes of received messages that exceed a limit.
+type sizeLimit struct {
+	limit int64 // accessed atomically
+	mu    sync.Mutex
+	over  map[wamp.Message]int
+}
+
+// SetSizeLimit sets the size limit, in bytes.  0 means no limit.
+func (s *sizeLimit) SetSizeLimit(n int) {
+	atomic.StoreInt64(&s.limit, int64(n))
+}
+
+// OverSizeLimit returns the serialized size of a received message, and true,
+// if the message exceeded the size limit.
+func (s *sizeLimit) OverSizeLimit(msg wamp.Message) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	size, ok := s.over[msg]
+	if ok {
+		delete(s.over, msg)
+	}
+	return size, ok
+}
+
+// check records the size of a received message if it exceeds the limit.  The
+// size is checked before anything is locked, so that this is cheap for
+// messages within the limit.
+func (s *sizeLimit) check(msg wamp.Message, n int) {
+	limit := atomic.LoadInt64(&s.limit)
+	if limit <= 0 || int64(n) <= limit {
+		return
+	}
+	switch msg.(type) {
+	case *wamp.Call, *wamp.Yield, *wamp.Publish, *wamp.Error:
+	default:
+		return
+	}
+	s.mu.Lock()
+	if s.over == nil {
+		s.over = map[wamp.Message]int{}
+	}
+	s.over[msg] = n
+	s.mu.Unlock()
+}
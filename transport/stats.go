@@ -0,0 +1,72 @@
+package transport
+
+import (
+	"sync/atomic"
+
+	"github.com/gammazero/nexus/v3/transport/serialize"
+)
+
+// PeerStats reports the traffic of a peer.  Bytes are counted as the size of
+// serialized messages, so a local peer, which does not serialize messages,
+// counts no bytes.
+type PeerStats struct {
+	// Number of messages received from the other side of the transport.
+	MessagesIn uint64
+	// Number of messages sent to the other side of the transport.
+	MessagesOut uint64
+	// Number of bytes received.
+	BytesIn uint64
+	// Number of bytes sent.
+	BytesOut uint64
+	// Number of messages waiting in the outbound queue.
+	QueueDepth int
+	// Capacity of the outbound queue.
+	QueueSize int
+}
+
+// SerializedPeer is implemented by peers that serialize the messages they send
+// and receive.
+type SerializedPeer interface {
+	// Serialization returns the serialization format used by the peer, or
+	// serialize.AUTO if the peer uses a custom serializer.
+	Serialization() serialize.Serialization
+}
+
+// StatsPeer is implemented by peers that count their traffic.
+type StatsPeer interface {
+	Stats() PeerStats
+}
+
+// trafficCounter counts messages and bytes sent and received.  Counts are
+// accessed atomically.  This must be first in any struct that contains it, to
+// ensure 64-bit alignment.
+type trafficCounter struct {
+	msgsIn   uint64
+	msgsOut  uint64
+	bytesIn  uint64
+	bytesOut uint64
+}
+
+// received counts a message of size n received.
+func (c *trafficCounter) received(n int) {
+	atomic.AddUint64(&c.msgsIn, 1)
+	atomic.AddUint64(&c.bytesIn, uint64(n))
+}
+
+// sent counts a message of size n sent.
+func (c *trafficCounter) sent(n int) {
+	atomic.AddUint64(&c.msgsOut, 1)
+	atomic.AddUint64(&c.bytesOut, uint64(n))
+}
+
+// stats returns the counts, with the depth and size of the outbound queue.
+func (c *trafficCounter) stats(queueDepth, queueSize int) PeerStats {
+	return PeerStats{
+		MessagesIn:  atomic.LoadUint64(&c.msgsIn),
+		MessagesOut: atomic.LoadUint64(&c.msgsOut),
+		BytesIn:     atomic.LoadUint64(&c.bytesIn),
+		BytesOut:    atomic.LoadUint64(&c.bytesOut),
+		QueueDepth:  queueDepth,
+		QueueSize:   queueSize,
+	}
+}
@@ -1,9 +1,13 @@
 package transport
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"net/url"
@@ -43,6 +47,31 @@ type WebsocketConfig struct {
 	// If a "pong" response is not received after 2 intervals have elapsed then
 	// the websocket connection is closed.
 	KeepAlive time.Duration
+
+	// Batched requests a batched websocket subprotocol, which carries
+	// multiple WAMP messages in each websocket message.  Only JSON and
+	// MessagePack serialization have batched modes.  If the server does not
+	// support the batched mode, then the unbatched mode is used.
+	Batched bool `json:"batched"`
+	// BatchWindow is how long to wait for more messages to add to a batch
+	// before sending it, when using a batched subprotocol.  If zero, then a
+	// batch is sent as soon as there are no more messages waiting to be sent.
+	BatchWindow time.Duration `json:"batch_window"`
+	// BatchMaxSize is the size, in bytes, at which a batch is sent without
+	// waiting for more messages.  Default is DefaultBatchMaxSize.
+	BatchMaxSize int `json:"batch_max_size"`
+}
+
+// WebsocketBatchConfig configures the sending of batched websocket messages.
+type WebsocketBatchConfig struct {
+	// Window is how long to wait for more messages to add to a batch before
+	// sending it.  If zero, then a batch is sent as soon as there are no more
+	// messages waiting to be sent.
+	Window time.Duration
+	// MaxSize is the size, in bytes, at which a batch is sent without waiting
+	// for more messages.  A message larger than this is sent in a batch by
+	// itself.  Default is DefaultBatchMaxSize.
+	MaxSize int
 }
 
 // WebsocketConnection is the interface that a websocket connection must implement.
@@ -74,9 +103,20 @@ func (e *WebsocketError) Error() string {
 // websocketPeer implements the Peer interface, connecting the Send and Recv
 // methods to a websocket.
 type websocketPeer struct {
+	// Counts traffic.  This is first to ensure 64-bit alignment.
+	traffic trafficCounter
+
 	conn        WebsocketConnection
 	serializer  serialize.Serializer
 	payloadType int
+	sizeLimit
+
+	// Set if messages are batched.  A batch of text messages are each
+	// terminated by a separator, and a batch of binary messages are each
+	// prefixed by their length.
+	batched  bool
+	batchCfg WebsocketBatchConfig
+	batchBuf []byte
 
 	// Used to signal the websocket is closed explicitly.
 	closed chan struct{}
@@ -99,6 +139,19 @@ const (
 	jsonWebsocketProtocol    = "wamp.2.json"
 	msgpackWebsocketProtocol = "wamp.2.msgpack"
 	cborWebsocketProtocol    = "wamp.2.cbor"
+	ubjsonWebsocketProtocol  = "wamp.2.ubjson"
+
+	// WAMP uses the following websocket subprotocol identifiers for batched
+	// modes:
+	jsonBatchedWebsocketProtocol    = "wamp.2.json.batched"
+	msgpackBatchedWebsocketProtocol = "wamp.2.msgpack.batched"
+
+	// DefaultBatchMaxSize is the default size, in bytes, at which a batch of
+	// messages is sent.
+	DefaultBatchMaxSize = 16 * 1024
+
+	// batchSeparator terminates each message in a batch of JSON messages.
+	batchSeparator = 0x1e
 
 	ctrlTimeout = 5 * time.Second
 )
@@ -115,17 +168,37 @@ func ConnectWebsocketPeer(ctx context.Context, routerURL string, serialization s
 		protocols   []string
 		payloadType int
 		serializer  serialize.Serializer
+		batched     bool
 	)
 
+	if wsCfg != nil {
+		batched = wsCfg.Batched
+	}
+
 	switch serialization {
 	case serialize.AUTO:
-		protocols = []string{jsonWebsocketProtocol, cborWebsocketProtocol, msgpackWebsocketProtocol}
+		if batched {
+			protocols = []string{jsonBatchedWebsocketProtocol, jsonWebsocketProtocol,
+				cborWebsocketProtocol, msgpackBatchedWebsocketProtocol, msgpackWebsocketProtocol}
+		} else {
+			protocols = []string{jsonWebsocketProtocol, cborWebsocketProtocol, msgpackWebsocketProtocol}
+		}
 	case serialize.JSON:
-		protocols = []string{jsonWebsocketProtocol}
+		if batched {
+			protocols = []string{jsonBatchedWebsocketProtocol, jsonWebsocketProtocol}
+		} else {
+			protocols = []string{jsonWebsocketProtocol}
+		}
 	case serialize.MSGPACK:
-		protocols = []string{msgpackWebsocketProtocol}
+		if batched {
+			protocols = []string{msgpackBatchedWebsocketProtocol, msgpackWebsocketProtocol}
+		} else {
+			protocols = []string{msgpackWebsocketProtocol}
+		}
 	case serialize.CBOR:
 		protocols = []string{cborWebsocketProtocol}
+	case serialize.UBJSON:
+		protocols = []string{ubjsonWebsocketProtocol}
 	default:
 		return nil, fmt.Errorf("unsupported serialization: %v", serialization)
 	}
@@ -137,6 +210,7 @@ func ConnectWebsocketPeer(ctx context.Context, routerURL string, serialization s
 	}
 
 	var keepAlive time.Duration = 0
+	var batchCfg WebsocketBatchConfig
 
 	if wsCfg != nil {
 		dialer.NetDial = wsCfg.Dial
@@ -150,6 +224,8 @@ func ConnectWebsocketPeer(ctx context.Context, routerURL string, serialization s
 		dialer.Jar = wsCfg.Jar
 		dialer.EnableCompression = wsCfg.EnableCompression
 		keepAlive = wsCfg.KeepAlive
+		batchCfg.Window = wsCfg.BatchWindow
+		batchCfg.MaxSize = wsCfg.BatchMaxSize
 	}
 
 	conn, rsp, err := dialer.DialContext(ctx, routerURL, nil)
@@ -160,18 +236,33 @@ func ConnectWebsocketPeer(ctx context.Context, routerURL string, serialization s
 		}
 	}
 
+	batched = false
 	switch conn.Subprotocol() {
 	case jsonWebsocketProtocol:
 		payloadType = websocket.TextMessage
 		serializer = &serialize.JSONSerializer{}
+	case jsonBatchedWebsocketProtocol:
+		payloadType = websocket.TextMessage
+		serializer = &serialize.JSONSerializer{}
+		batched = true
 	case cborWebsocketProtocol:
 		payloadType = websocket.BinaryMessage
 		serializer = &serialize.CBORSerializer{}
+	case ubjsonWebsocketProtocol:
+		payloadType = websocket.BinaryMessage
+		serializer = &serialize.UBJSONSerializer{}
 	case msgpackWebsocketProtocol:
 		payloadType = websocket.BinaryMessage
 		serializer = &serialize.MessagePackSerializer{}
+	case msgpackBatchedWebsocketProtocol:
+		payloadType = websocket.BinaryMessage
+		serializer = &serialize.MessagePackSerializer{}
+		batched = true
 	}
 
+	if batched {
+		return NewBatchedWebsocketPeer(conn, serializer, payloadType, logger, keepAlive, 0, batchCfg), nil
+	}
 	return NewWebsocketPeer(conn, serializer, payloadType, logger, keepAlive, 0), nil
 }
 
@@ -183,10 +274,30 @@ func ConnectWebsocketPeer(ctx context.Context, routerURL string, serialization s
 // sending websocket "pings" every keepAlive interval.  If a "pong" response
 // is not received after 2 intervals have elapsed then the websocket is closed.
 func NewWebsocketPeer(conn WebsocketConnection, serializer serialize.Serializer, payloadType int, logger stdlog.StdLog, keepAlive time.Duration, outQueueSize int) wamp.Peer {
+	return newWebsocketPeer(conn, serializer, payloadType, logger, keepAlive, outQueueSize, false, WebsocketBatchConfig{})
+}
+
+// NewBatchedWebsocketPeer creates a websocket peer, from an existing
+// websocket connection, that sends and receives batches of messages in each
+// websocket message.  This is used for the batched websocket subprotocols.
+//
+// Text messages in a batch are each terminated by an ASCII record separator
+// (0x1e), and binary messages in a batch are each prefixed by their length as
+// a 32-bit big-endian integer.
+func NewBatchedWebsocketPeer(conn WebsocketConnection, serializer serialize.Serializer, payloadType int, logger stdlog.StdLog, keepAlive time.Duration, outQueueSize int, batchCfg WebsocketBatchConfig) wamp.Peer {
+	if batchCfg.MaxSize <= 0 {
+		batchCfg.MaxSize = DefaultBatchMaxSize
+	}
+	return newWebsocketPeer(conn, serializer, payloadType, logger, keepAlive, outQueueSize, true, batchCfg)
+}
+
+func newWebsocketPeer(conn WebsocketConnection, serializer serialize.Serializer, payloadType int, logger stdlog.StdLog, keepAlive time.Duration, outQueueSize int, batched bool, batchCfg WebsocketBatchConfig) wamp.Peer {
 	w := &websocketPeer{
 		conn:        conn,
 		serializer:  serializer,
 		payloadType: payloadType,
+		batched:     batched,
+		batchCfg:    batchCfg,
 		closed:      make(chan struct{}),
 		writerDone:  make(chan struct{}),
 
@@ -225,6 +336,10 @@ func (w *websocketPeer) TrySend(msg wamp.Message) error {
 	return wamp.TrySend(w.wr, msg)
 }
 
+func (w *websocketPeer) TrySendDropOldest(msg wamp.Message) (wamp.Message, error) {
+	return wamp.TrySendDropOldest(w.wr, msg)
+}
+
 func (w *websocketPeer) SendCtx(ctx context.Context, msg wamp.Message) error {
 	return wamp.SendCtx(ctx, w.wr, msg)
 }
@@ -235,6 +350,23 @@ func (w *websocketPeer) Send(msg wamp.Message) error {
 
 func (w *websocketPeer) IsLocal() bool { return false }
 
+// Serialization returns the serialization format used by the peer.
+func (w *websocketPeer) Serialization() serialize.Serialization {
+	return serialize.SerializationOf(w.serializer)
+}
+
+// AcceptsSharedMessage returns true if the peer uses one of the serializers
+// in the serialize package, which serialize a serialize.SharedMessage.
+func (w *websocketPeer) AcceptsSharedMessage() bool {
+	return w.Serialization() != serialize.AUTO
+}
+
+// Stats returns the number of messages and bytes sent and received by the
+// peer, and the depth of its outbound queue.
+func (w *websocketPeer) Stats() PeerStats {
+	return w.traffic.stats(len(w.wr), cap(w.wr))
+}
+
 // Close closes the websocket peer.  This closes the local send channel, and
 // sends a close control message to the websocket to tell the other side to
 // close.
@@ -274,20 +406,10 @@ func (w *websocketPeer) sendHandler() {
 		return nil
 	})
 
-sendLoop:
 	for {
 		select {
 		case msg := <-w.wr:
-			b, err := w.serializer.Serialize(msg)
-			if err != nil {
-				w.log.Print(err)
-				continue sendLoop
-			}
-
-			if err = w.conn.WriteMessage(w.payloadType, b); err != nil {
-				if !wamp.IsGoodbyeAck(msg) {
-					w.log.Print(err)
-				}
+			if !w.sendMessage(msg) {
 				return
 			}
 		case m := <-pongs:
@@ -301,6 +423,139 @@ sendLoop:
 	}
 }
 
+// sendMessage serializes the message and writes it to the websocket, or sends
+// it in a batch with any other messages that are waiting to be sent.  Returns
+// false if the websocket cannot be written to.
+func (w *websocketPeer) sendMessage(msg wamp.Message) bool {
+	if w.batched {
+		return w.sendBatch(msg)
+	}
+	b, err := w.serializer.Serialize(msg)
+	if err != nil {
+		w.log.Print(err)
+		return true
+	}
+	if err = w.conn.WriteMessage(w.payloadType, b); err != nil {
+		if !wamp.IsGoodbyeAck(msg) {
+			w.log.Print(err)
+		}
+		return false
+	}
+	w.traffic.sent(len(b))
+	return true
+}
+
+// sendBatch collects the message, and messages that are waiting to be sent or
+// that arrive within the batch window, into a batch and writes the batch to
+// the websocket.  The batch is written early if it reaches its maximum size.
+func (w *websocketPeer) sendBatch(msg wamp.Message) bool {
+	var timeout <-chan time.Time
+	if w.batchCfg.Window > 0 {
+		timer := time.NewTimer(w.batchCfg.Window)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	buf := w.batchBuf[:0]
+	var sizes []int
+	var goodbyeAck bool
+	senderDone := w.ctxSender.Done()
+batchLoop:
+	for {
+		if b, err := w.serializer.Serialize(msg); err != nil {
+			w.log.Print(err)
+		} else {
+			buf = appendBatch(buf, b, w.payloadType)
+			sizes = append(sizes, len(b))
+			goodbyeAck = goodbyeAck || wamp.IsGoodbyeAck(msg)
+		}
+		if len(buf) >= w.batchCfg.MaxSize {
+			break
+		}
+		if timeout == nil {
+			select {
+			case msg = <-w.wr:
+			default:
+				break batchLoop
+			}
+		} else {
+			select {
+			case msg = <-w.wr:
+			case <-timeout:
+				break batchLoop
+			case <-senderDone:
+				break batchLoop
+			}
+		}
+	}
+	// Keep the buffer for the next batch, unless it grew too large to keep.
+	if cap(buf) <= 2*w.batchCfg.MaxSize {
+		w.batchBuf = buf
+	}
+	if len(sizes) == 0 {
+		return true
+	}
+
+	if err := w.conn.WriteMessage(w.payloadType, buf); err != nil {
+		if !goodbyeAck {
+			w.log.Print(err)
+		}
+		return false
+	}
+	for _, n := range sizes {
+		w.traffic.sent(n)
+	}
+	return true
+}
+
+// appendBatch appends a serialized message to a batch.  A text message is
+// terminated by a separator, and a binary message is prefixed by its length.
+func appendBatch(buf, b []byte, payloadType int) []byte {
+	if payloadType == websocket.TextMessage {
+		buf = append(buf, b...)
+		return append(buf, batchSeparator)
+	}
+	var lenBytes [4]byte
+	binary.BigEndian.PutUint32(lenBytes[:], uint32(len(b)))
+	buf = append(buf, lenBytes[:]...)
+	return append(buf, b...)
+}
+
+// splitBatch splits a batch into its serialized messages.
+func splitBatch(b []byte, payloadType int) ([][]byte, error) {
+	var msgs [][]byte
+	if payloadType == websocket.TextMessage {
+		for len(b) != 0 {
+			i := bytes.IndexByte(b, batchSeparator)
+			if i == -1 {
+				// Tolerate a missing separator after the last message.
+				i = len(b)
+			}
+			if i != 0 {
+				msgs = append(msgs, b[:i])
+			}
+			if i == len(b) {
+				break
+			}
+			b = b[i+1:]
+		}
+		return msgs, nil
+	}
+	for len(b) != 0 {
+		if len(b) < 4 {
+			return msgs, errors.New("truncated message length in batch")
+		}
+		n := binary.BigEndian.Uint32(b)
+		b = b[4:]
+		if uint64(n) > uint64(len(b)) {
+			return msgs, errors.New("truncated message in batch")
+		}
+		msgs = append(msgs, b[:n])
+		b = b[n:]
+	}
+	return msgs, nil
+}
+
 func (w *websocketPeer) sendHandlerKeepAlive(keepAlive time.Duration) {
 	defer close(w.writerDone)
 	defer w.cancelSender()
@@ -326,20 +581,10 @@ func (w *websocketPeer) sendHandlerKeepAlive(keepAlive time.Duration) {
 	pingMsg := []byte("keepalive")
 
 	senderDone := w.ctxSender.Done()
-recvLoop:
 	for {
 		select {
 		case msg := <-w.wr:
-			b, err := w.serializer.Serialize(msg)
-			if err != nil {
-				w.log.Print(err)
-				continue recvLoop
-			}
-
-			if err = w.conn.WriteMessage(w.payloadType, b); err != nil {
-				if !wamp.IsGoodbyeAck(msg) {
-					w.log.Print(err)
-				}
+			if !w.sendMessage(msg) {
 				return
 			}
 		case <-ticker.C:
@@ -374,7 +619,7 @@ func (w *websocketPeer) recvHandler() {
 	defer close(w.rd)
 	defer w.conn.Close()
 	for {
-		msgType, b, err := w.conn.ReadMessage()
+		msgType, b, buf, err := w.readMessage()
 		if err != nil {
 			select {
 			case <-w.closed:
@@ -398,32 +643,111 @@ func (w *websocketPeer) recvHandler() {
 		}
 
 		if msgType == websocket.CloseMessage {
+			if buf != nil {
+				putRecvBuffer(buf)
+			}
 			return
 		}
 
+		if w.batched {
+			ok := w.recvBatch(b)
+			if buf != nil {
+				putRecvBuffer(buf)
+			}
+			if !ok {
+				return
+			}
+			continue
+		}
+
 		msg, err := w.serializer.Deserialize(b)
+		if buf != nil {
+			putRecvBuffer(buf)
+		}
 		if err != nil {
 			// TODO: something more than merely logging?
 			w.log.Println("Cannot deserialize peer message:", err)
 			continue
 		}
-		// It is OK for the router to block a client since routing should be
-		// very quick compared to the time to transfer a message over
-		// websocket, and a blocked client will not block other clients.
-		//
-		// Need to wake up on w.closed so this goroutine can exit in the case
-		// that messages are not being read from the peer and prevent this
-		// write from completing.
+		w.traffic.received(len(b))
+		w.check(msg, len(b))
+		if !w.deliver(msg) {
+			return
+		}
+	}
+}
+
+// recvBatch deserializes each message in a batch and pushes it to the read
+// channel.  Returns false if the peer was closed.
+func (w *websocketPeer) recvBatch(b []byte) bool {
+	frames, err := splitBatch(b, w.payloadType)
+	if err != nil {
+		// Deliver the messages before the error.
+		w.log.Println("Cannot split peer message batch:", err)
+	}
+	for _, frame := range frames {
+		msg, err := w.serializer.Deserialize(frame)
+		if err != nil {
+			w.log.Println("Cannot deserialize peer message:", err)
+			continue
+		}
+		w.traffic.received(len(frame))
+		w.check(msg, len(frame))
+		if !w.deliver(msg) {
+			return false
+		}
+	}
+	return true
+}
+
+// deliver pushes a received message to the read channel.  Returns false if
+// the peer was closed.
+func (w *websocketPeer) deliver(msg wamp.Message) bool {
+	// It is OK for the router to block a client since routing should be very
+	// quick compared to the time to transfer a message over websocket, and a
+	// blocked client will not block other clients.
+	//
+	// Need to wake up on w.closed so this goroutine can exit in the case that
+	// messages are not being read from the peer and prevent this write from
+	// completing.
+	select {
+	case w.rd <- msg:
+	case <-w.closed:
+		// If closed, try for one second to send the last message and then
+		// exit recvHandler.
 		select {
 		case w.rd <- msg:
-		case <-w.closed:
-			// If closed, try for one second to send the last message and then
-			// exit recvHandler.
-			select {
-			case w.rd <- msg:
-			case <-time.After(time.Second):
-			}
-			return
+		case <-time.After(time.Second):
 		}
+		return false
+	}
+	return true
+}
+
+// websocketNextReader is implemented by websocket connections, such as
+// *websocket.Conn, that return a reader for each message received.
+type websocketNextReader interface {
+	NextReader() (messageType int, r io.Reader, err error)
+}
+
+// readMessage reads the next message from the websocket.  If the connection
+// returns a reader for the message, then the message is read into a pooled
+// buffer, which is also returned and must be put back in the pool once the
+// message data is no longer used.
+func (w *websocketPeer) readMessage() (int, []byte, *bytes.Buffer, error) {
+	nr, ok := w.conn.(websocketNextReader)
+	if !ok {
+		msgType, b, err := w.conn.ReadMessage()
+		return msgType, b, nil, err
+	}
+	msgType, r, err := nr.NextReader()
+	if err != nil {
+		return msgType, nil, nil, err
+	}
+	buf := getRecvBuffer()
+	if _, err = buf.ReadFrom(r); err != nil {
+		putRecvBuffer(buf)
+		return msgType, nil, nil, err
 	}
+	return msgType, buf.Bytes(), buf, nil
 }
@@ -0,0 +1,49 @@
+package transport
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestSplitBatch(t *testing.T) {
+	msgs := [][]byte{[]byte("first"), []byte("second message"), []byte("3")}
+	for _, payloadType := range []int{websocket.TextMessage, websocket.BinaryMessage} {
+		var batch []byte
+		for _, msg := range msgs {
+			batch = appendBatch(batch, msg, payloadType)
+		}
+		split, err := splitBatch(batch, payloadType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(split) != len(msgs) {
+			t.Fatal("Expected", len(msgs), "messages, got", len(split))
+		}
+		for i := range msgs {
+			if !bytes.Equal(split[i], msgs[i]) {
+				t.Fatalf("Expected message %q, got %q", msgs[i], split[i])
+			}
+		}
+	}
+
+	// The separator after the last text message is optional.
+	split, err := splitBatch([]byte("one\x1etwo"), websocket.TextMessage)
+	if err != nil || len(split) != 2 || string(split[1]) != "two" {
+		t.Fatal("Failed to split batch without final separator:", split, err)
+	}
+
+	// A truncated binary batch returns the messages before the error.
+	batch := appendBatch(nil, []byte("whole"), websocket.BinaryMessage)
+	batch = appendBatch(batch, []byte("truncated"), websocket.BinaryMessage)
+	for _, b := range [][]byte{batch[:len(batch)-1], batch[:len("whole")+6]} {
+		split, err = splitBatch(b, websocket.BinaryMessage)
+		if err == nil {
+			t.Fatal("Expected error splitting truncated batch")
+		}
+		if len(split) != 1 || string(split[0]) != "whole" {
+			t.Fatal("Expected first message before error, got", split)
+		}
+	}
+}
@@ -37,4 +37,51 @@ const (
 	// Options for subscriber filtering.
 	BlacklistKey = "exclude"
 	WhitelistKey = "eligible"
+
+	// Subscribe option and value for at-least-once event delivery
+	// (non-standard).
+	OptDelivery         = "delivery"
+	DeliveryAtLeastOnce = "at_least_once"
+
+	// Event details for at-least-once event delivery (non-standard).
+	DetailDeliverySeq = "delivery_seq"
+	DetailRedelivered = "redelivered"
+
+	// Subscribe option and values for event sequence numbers, which number
+	// the events sent to the subscriber either per topic or per subscription
+	// (non-standard).  The router keeps per-topic numbers for a limited number
+	// of topics, so numbering of a topic that has not been published to
+	// recently may restart at 1.
+	OptSequence          = "sequence"
+	SequenceTopic        = "topic"
+	SequenceSubscription = "subscription"
+
+	// Event details with the event's sequence number per topic or per
+	// subscription (non-standard).
+	DetailTopicSeq        = "topic_seq"
+	DetailSubscriptionSeq = "subscription_seq"
+
+	// Subscribe option with the name of a durable subscription
+	// (non-standard).
+	OptDurable = "durable"
+
+	// Event detail with the sequence number of an event delivered for a
+	// durable subscription (non-standard).
+	DetailDurableSeq = "durable_seq"
+
+	// Hello detail that requests a resumable session (non-standard).
+	DetailResumable = "resumable"
+	// Welcome detail with the token used to resume a session, and hello
+	// detail with the token of the session to resume (non-standard).
+	DetailResumeToken = "resume_token"
+	// Welcome detail that is true when a session was resumed
+	// (non-standard).
+	DetailResumed = "resumed"
+
+	// Event detail with the topic that was published to, when the router
+	// rewrote it to a different topic (non-standard).
+	DetailOriginalTopic = "original_topic"
+	// Invocation detail with the procedure that was called, when the router
+	// rewrote it to a different procedure (non-standard).
+	DetailOriginalProcedure = "original_procedure"
 )
@@ -28,6 +28,15 @@ type Peer interface {
 	IsLocal() bool
 }
 
+// DropOldestSender is implemented by a Peer that can discard the oldest
+// message in its outbound queue to make room for a new message.
+type DropOldestSender interface {
+	// TrySendDropOldest performs a non-blocking send.  If the outbound queue
+	// is full, then the oldest queued EVENT is discarded and returned.
+	// Returns error if still blocked.
+	TrySendDropOldest(Message) (Message, error)
+}
+
 // RecvTimeout receives a message from a peer within the specified time.
 func RecvTimeout(p Peer, t time.Duration) (Message, error) {
 	select {
@@ -62,3 +71,49 @@ func TrySend(wr chan<- Message, msg Message) error {
 	}
 	return nil
 }
+
+// TrySendDropOldest sends a message to the channel.  If the channel is full,
+// then the oldest EVENT in the channel is discarded to make room for the new
+// message.  Other messages are not discarded, since the session may be
+// waiting for them.  Returns the discarded message, if any, and returns an
+// error if the channel still blocks.
+//
+// To discard an event, the queued messages are taken from the channel and
+// queued again without the event, so the caller must not let other
+// goroutines send to the channel at the same time.
+func TrySendDropOldest(ch chan Message, msg Message) (Message, error) {
+	select {
+	case ch <- msg:
+		return nil, nil
+	default:
+	}
+	queued := make([]Message, 0, len(ch))
+	for len(queued) < cap(queued) {
+		select {
+		case m := <-ch:
+			queued = append(queued, m)
+			continue
+		default:
+		}
+		break
+	}
+	var dropped Message
+	for i, m := range queued {
+		if m.MessageType() == EVENT {
+			dropped = m
+			queued = append(queued[:i], queued[i+1:]...)
+			break
+		}
+	}
+	// The queued messages fit, since they were in the channel, and messages
+	// are only removed by the reader.
+	for _, m := range queued {
+		ch <- m
+	}
+	select {
+	case ch <- msg:
+	default:
+		return dropped, errors.New("blocked")
+	}
+	return dropped, nil
+}
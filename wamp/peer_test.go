@@ -75,6 +75,58 @@ func TestTrySend(t *testing.T) {
 	p.Close()
 }
 
+func TestTrySendDropOldest(t *testing.T) {
+	ch := make(chan Message, 1)
+	first, second := &Event{Publication: 1}, &Event{Publication: 2}
+	dropped, err := TrySendDropOldest(ch, first)
+	if err != nil || dropped != nil {
+		t.Fatal("Expected send without drop")
+	}
+	dropped, err = TrySendDropOldest(ch, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dropped != first {
+		t.Fatal("Expected first message to be dropped")
+	}
+	if msg := <-ch; msg != second {
+		t.Fatal("Expected second message in channel")
+	}
+
+	if _, err = TrySendDropOldest(make(chan Message), first); err == nil {
+		t.Fatal("Expected error from unbuffered channel")
+	}
+
+	// Only events are discarded.
+	ch = make(chan Message, 3)
+	result, pub := &Result{Request: 1}, &Publish{Request: 1}
+	ch <- result
+	ch <- first
+	ch <- pub
+	dropped, err = TrySendDropOldest(ch, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dropped != first {
+		t.Fatal("Expected event to be dropped")
+	}
+	for _, expect := range []Message{result, pub, second} {
+		if msg := <-ch; msg != expect {
+			t.Fatal("Expected", expect.MessageType(), "in channel, got",
+				msg.MessageType())
+		}
+	}
+	ch <- result
+	ch <- pub
+	ch <- pub
+	if dropped, err = TrySendDropOldest(ch, second); err == nil || dropped != nil {
+		t.Fatal("Expected error when no event can be dropped")
+	}
+	if len(ch) != 3 || <-ch != result {
+		t.Fatal("Expected queued messages to be kept")
+	}
+}
+
 func TestSendCtx(t *testing.T) {
 	p := newTestPeer()
 	ctx, cancel := context.WithCancel(context.Background())
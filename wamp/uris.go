@@ -52,6 +52,10 @@ const (
 	CloseGoodbyeAndOut = URI("wamp.close.goodbye_and_out")
 	ErrGoodbyeAndOut   = CloseGoodbyeAndOut
 
+	// The Router is closing a session that is not receiving messages as fast
+	// as they are sent to it - used as a GOODBYE reason (non-standard).
+	CloseSlowConsumer = URI("wamp.close.slow_consumer")
+
 	// -- Authorization --
 
 	// A join, call, register, publish or subscribe failed, since the Peer is
@@ -104,6 +108,34 @@ const (
 	// A Peer received invalid WAMP protocol message.
 	ErrProtocolViolation = URI("wamp.error.protocol_violation")
 
+	// -- Quota Errors (non-standard) --
+
+	// A Router rejected a session because the realm has the maximum number of
+	// sessions.
+	ErrSessionLimitExceeded = URI("wamp.error.session_limit_exceeded")
+
+	// A Router rejected a subscription because the session has the maximum
+	// number of subscriptions.
+	ErrSubscriptionLimitExceeded = URI("wamp.error.subscription_limit_exceeded")
+
+	// A Router rejected a registration because the session has the maximum
+	// number of registrations.
+	ErrRegistrationLimitExceeded = URI("wamp.error.registration_limit_exceeded")
+
+	// A Router rejected a call because the caller has the maximum number of
+	// pending calls.
+	ErrPendingCallLimitExceeded = URI("wamp.error.pending_call_limit_exceeded")
+
+	// A Router rejected a message because its payload exceeds the maximum
+	// payload size.
+	ErrPayloadSizeExceeded = URI("wamp.error.payload_size_exceeded")
+
+	// -- Durable Subscription Errors (non-standard) --
+
+	// A Router could not read or update the stored events of a durable
+	// subscription.
+	ErrDurableStoreFailure = URI("wamp.error.durable_store_failure")
+
 	// -- Session Meta Events --
 
 	// Fired when a session joins a realm on the router.
@@ -228,4 +260,50 @@ const (
 	// Remove the Testaments for that Session, either for when it is detached
 	// or destroyed.
 	MetaProcSessionFlushTestaments = URI("wamp.session.flush_testaments")
+
+	// -- Quota Meta Procedures (non-standard) --
+
+	// Retrieves the resource limits of the realm and the number of sessions
+	// currently attached to the realm.
+	MetaProcRealmGetQuotas = URI("wamp.realm.get_quotas")
+
+	// Retrieves the resources, counted against the realm's limits, that are
+	// currently used by a specific session.
+	MetaProcSessionGetQuotaUsage = URI("wamp.session.get_quota_usage")
+
+	// -- Session Stats Meta Procedures (non-standard) --
+
+	// Retrieves the number of messages and bytes sent and received by a
+	// specific session, and the state of its outbound queue.
+	MetaProcSessionGetStats = URI("wamp.session.get_stats")
+
+	// -- Durable Subscription Meta Procedures (non-standard) --
+
+	// Acknowledges the events of the caller's durable subscription, up to and
+	// including the given sequence number, so that they are not redelivered.
+	MetaProcSubAckDurable = URI("wamp.subscription.ack_durable")
+
+	// -- At-Least-Once Delivery Meta Procedures (non-standard) --
+
+	// Acknowledges an event delivered with at-least-once delivery, so that it
+	// is not redelivered.
+	MetaProcSubAck = URI("wamp.subscription.ack")
+
+	// Negatively acknowledges an event delivered with at-least-once delivery,
+	// so that it is redelivered immediately.
+	MetaProcSubNack = URI("wamp.subscription.nack")
+
+	// -- Call Meta Procedures (non-standard) --
+
+	// Retrieves information on all calls currently waiting for a result.
+	MetaProcCallList = URI("wamp.call.list")
+
+	// Retrieves information on a call waiting for a result.
+	MetaProcCallGet = URI("wamp.call.get")
+
+	// Cancels a call waiting for a result.
+	MetaProcCallCancel = URI("wamp.call.cancel")
+
+	// No call with the given ID is waiting for a result.
+	ErrNoSuchCall = URI("wamp.error.no_such_call")
 )